@@ -0,0 +1,800 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"idleshutdown/internal/api"
+	"idleshutdown/internal/audit"
+	"idleshutdown/internal/clock"
+	"idleshutdown/internal/config"
+	"idleshutdown/internal/explain"
+	"idleshutdown/internal/monitor"
+	"idleshutdown/internal/motd"
+	"idleshutdown/internal/notify"
+	"idleshutdown/internal/shutdown"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	if os.Getenv("SIMLOG") != "" {
+		log.SetOutput(os.Stderr)
+	}
+	os.Exit(m.Run())
+}
+
+// recordingRunner records every command the agent runs, with the fake time.
+type recordingRunner struct {
+	clock *clock.Fake
+	calls []string
+	times []time.Time
+}
+
+func (r *recordingRunner) Run(name string, args ...string) ([]byte, error) {
+	r.calls = append(r.calls, strings.Join(append([]string{name}, args...), " "))
+	r.times = append(r.times, r.clock.Now())
+	return nil, nil
+}
+
+// firstCall returns when cmd was first run, or false if it never was.
+func (r *recordingRunner) firstCall(cmd string) (time.Time, bool) {
+	for i, c := range r.calls {
+		if c == cmd {
+			return r.times[i], true
+		}
+	}
+	return time.Time{}, false
+}
+
+func (r *recordingRunner) count(cmd string) int {
+	n := 0
+	for _, c := range r.calls {
+		if c == cmd {
+			n++
+		}
+	}
+	return n
+}
+
+// sessionList is a mutable SessionProvider.
+type sessionList struct {
+	users []string
+}
+
+func (s *sessionList) LoggedInUsers() ([]string, error) { return s.users, nil }
+
+// procSim maintains /proc/stat counters that advance at a chosen usage,
+// next to a quiet /proc/loadavg, /proc/vmstat counters that swap pages at
+// swapRate per second, /proc/net/dev with eth0 receiving netRate bytes per
+// second, /proc/net/tcp with a monitoring scraper and client connections
+// to port 5432 and, when psi is set, /proc/pressure files whose I/O stall
+// advances at ioStall percent.
+type procSim struct {
+	root       string
+	usage      float64
+	busy, idle uint64
+
+	swapRate float64
+	swapped  uint64
+
+	netRate    float64
+	netRxBytes uint64
+
+	clients int
+
+	psi       bool
+	ioStall   float64
+	ioStallUS uint64
+
+	written map[string]string // content last written, by path
+}
+
+// write writes content to the file at name under root unless it holds it
+// already; most files stay the same from one step to the next.
+func (p *procSim) write(name, content string) {
+	path := filepath.Join(p.root, name)
+	if p.written[path] == content {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		panic(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		panic(err)
+	}
+	if p.written == nil {
+		p.written = map[string]string{}
+	}
+	p.written[path] = content
+}
+
+// advance adds d worth of ticks (100 per second) at the current usage.
+func (p *procSim) advance(d time.Duration) {
+	ticks := uint64(d.Seconds() * 100)
+	busy := uint64(float64(ticks) * p.usage / 100)
+	p.busy += busy
+	p.idle += ticks - busy
+
+	p.write("stat", fmt.Sprintf("cpu  %d 0 0 %d 0 0 0 0 0 0\n", p.busy, p.idle))
+	p.write("loadavg", "0.00 0.00 0.00 1/100 1\n")
+
+	p.swapped += uint64(d.Seconds() * p.swapRate)
+	p.write("vmstat", fmt.Sprintf("pgfault 1000\npgmajfault 10\npswpin %d\npswpout 0\n", p.swapped))
+	p.write("meminfo", "MemTotal: 4194304 kB\nMemAvailable: 2097152 kB\nSwapTotal: 0 kB\nSwapFree: 0 kB\n")
+
+	// 10.0.0.5:5432, scraped from 10.9.0.7 and used by 192.168.1.20
+	tcp := "  sl  local_address rem_address   st\n   0: 0500000A:1538 0700090A:C000 01\n"
+	for i := 0; i < p.clients; i++ {
+		tcp += fmt.Sprintf("   %d: 0500000A:1538 1401A8C0:%04X 01\n", i+1, 0xC100+i)
+	}
+	p.write(filepath.Join("net", "tcp"), tcp)
+	p.netRxBytes += uint64(d.Seconds() * p.netRate)
+	p.write(filepath.Join("net", "dev"), "Inter-|   Receive\n face |bytes packets\n"+
+		"    lo: 9000 90 0 0 0 0 0 0 9000 90 0 0 0 0 0 0\n"+
+		fmt.Sprintf("  eth0: %d %d 0 0 0 0 0 0 0 0 0 0 0 0 0 0\n", p.netRxBytes, p.netRxBytes/1500))
+
+	if !p.psi {
+		return
+	}
+	p.ioStallUS += uint64(float64(d.Microseconds()) * p.ioStall / 100)
+	for resource, total := range map[string]uint64{"cpu": 0, "io": p.ioStallUS, "memory": 0} {
+		p.write(filepath.Join("pressure", resource), fmt.Sprintf("some avg10=0.00 avg60=0.00 avg300=0.00 total=%d\n", total))
+	}
+}
+
+// sim drives an agent through simulated time without real sleeps.
+type sim struct {
+	t        *testing.T
+	clock    *clock.Fake
+	proc     *procSim
+	sessions *sessionList
+	runner   *recordingRunner
+	agent    *agent
+	dir      string
+	steps    int
+}
+
+var simStart = time.Date(2026, 2, 16, 0, 0, 0, 0, time.UTC)
+
+// simDefaults shortens recalibration so a full cycle fits in a few
+// simulated days.
+const simDefaults = "[calibration]\ninitial_tracking_hours = 24\n" +
+	"recalibration_interval_days = 2\nrecalibration_tracking_hours = 24\n"
+
+func newSim(t *testing.T, configINI string) *sim {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.ini")
+	if err := os.WriteFile(configPath, []byte(configINI), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defaultsPath := filepath.Join(dir, "default.ini")
+	if err := os.WriteFile(defaultsPath, []byte(simDefaults), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := clock.NewFake(simStart)
+	proc := &procSim{root: dir}
+	proc.advance(time.Hour)
+	sessions := &sessionList{}
+	runner := &recordingRunner{clock: fake}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calibCfg, err := config.LoadDefaults(defaultsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cpuMon := monitor.NewCPUMonitor(samplingInterval)
+	cpuMon.Clock = fake
+	cpuMon.ProcRoot = dir
+	userMon := monitor.NewUserMonitor(samplingInterval)
+	userMon.Clock = fake
+	userMon.Sessions = sessions
+	psiMon := monitor.NewPSIMonitor(samplingInterval)
+	psiMon.Clock = fake
+	psiMon.ProcRoot = dir
+	memMon := monitor.NewMemoryMonitor(samplingInterval)
+	memMon.Clock = fake
+	memMon.ProcRoot = dir
+	memMon.SetLimits(cfg.MemoryLimits())
+	netMon := monitor.NewNetMonitor(samplingInterval)
+	netMon.Clock = fake
+	netMon.ProcRoot = dir
+	netMon.SetLimits(cfg.NetLimits())
+	tcpMon := monitor.NewTCPMonitor(samplingInterval)
+	tcpMon.Clock = fake
+	tcpMon.ProcRoot = dir
+	tcpMon.SetFilter(cfg.TCPFilter())
+	exec := shutdown.NewExecutor(false)
+	exec.Runner = runner
+	exec.Clock = fake
+
+	a := &agent{
+		configPath:   configPath,
+		statusPath:   filepath.Join(dir, "status.ini"),
+		cfg:          cfg,
+		calibCfg:     calibCfg,
+		cpuMon:       cpuMon,
+		userMon:      userMon,
+		psiMon:       psiMon,
+		memMon:       memMon,
+		netMon:       netMon,
+		tcpMon:       tcpMon,
+		shutdownExec: exec,
+		clock:        fake,
+		runner:       runner,
+		notifier:     notify.Discard,
+	}
+	if cfg.AutoMode {
+		if err := a.startAutoMode(filepath.Join(dir, "calibration.state")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return &sim{t: t, clock: fake, proc: proc, sessions: sessions, runner: runner, agent: a, dir: dir}
+}
+
+// run advances simulated time by d, sampling every samplingInterval and
+// running the calibration and evaluation ticks every minute.
+func (s *sim) run(d time.Duration) {
+	for end := s.clock.Now().Add(d); s.clock.Now().Before(end); {
+		s.proc.advance(samplingInterval)
+		s.clock.Advance(samplingInterval)
+		s.agent.cpuMon.Sample()
+		s.agent.userMon.Sample()
+		if s.agent.psiMon.Available() {
+			s.agent.psiMon.Sample()
+		}
+		s.agent.memMon.Sample()
+		s.agent.netMon.Sample()
+		s.agent.tcpMon.Sample()
+
+		s.steps++
+		if s.steps%int(evaluationInterval/samplingInterval) == 0 {
+			if s.agent.calib != nil {
+				s.agent.calibrationTick()
+			}
+			s.agent.evaluationTick()
+		}
+	}
+}
+
+func (s *sim) configText() string {
+	content, err := os.ReadFile(filepath.Join(s.dir, "config.ini"))
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return string(content)
+}
+
+const autoConfig = "[monitoring]\ncpu_check_minutes = 60\nuser_check_minutes = 60\n# cpu_threshold = 25\n"
+
+func TestAutoModeLifecycle(t *testing.T) {
+	s := newSim(t, autoConfig)
+	s.proc.usage = 2
+
+	// Learning phase: the VM is idle, but evaluation is paused for 24h.
+	s.run(23 * time.Hour)
+	if n := s.runner.count("shutdown -h now"); n != 0 {
+		t.Fatalf("shutdown ran %d times during the learning phase", n)
+	}
+	if !strings.Contains(s.configText(), "LEARNING") {
+		t.Error("config.ini should show the learning banner")
+	}
+
+	// Initial calibration after 24h, then shutdown on the next evaluation.
+	s.run(2 * time.Hour)
+	calibrated, ok := s.runner.firstCall("systemctl restart IdleShutdown")
+	if !ok {
+		t.Fatal("initial calibration did not run")
+	}
+	if want := simStart.Add(24 * time.Hour); calibrated.Sub(want) > time.Minute {
+		t.Errorf("initial calibration at %v, want ~%v", calibrated, want)
+	}
+	if got := s.agent.calib.CurrentThreshold(); got != 5 {
+		t.Errorf("calibrated threshold = %d%%, want 5%%", got)
+	}
+	shutdownAt, ok := s.runner.firstCall("shutdown -h now")
+	if !ok {
+		t.Fatal("idle VM was not shut down after calibration")
+	}
+	if shutdownAt.Sub(calibrated) > time.Minute {
+		t.Errorf("shutdown at %v, want right after calibration at %v", shutdownAt, calibrated)
+	}
+	if !strings.Contains(s.configText(), "AUTO-MANAGED") {
+		t.Error("config.ini should show the calibrated banner")
+	}
+
+	// Two days of heavier background load with a user logged in, until
+	// the periodic recalibration picks it up.
+	s.sessions.users = []string{"alice"}
+	s.proc.usage = 9
+	shutdowns := s.runner.count("shutdown -h now")
+	s.run(2 * 24 * time.Hour)
+	if n := s.runner.count("shutdown -h now"); n != shutdowns {
+		t.Errorf("shutdown ran while a user was logged in")
+	}
+	if n := s.runner.count("systemctl restart IdleShutdown"); n != 2 {
+		t.Fatalf("expected initial and periodic calibration, got %d restarts", n)
+	}
+	if got := s.agent.calib.CurrentThreshold(); got != 12 {
+		t.Errorf("recalibrated threshold = %d%%, want 12%%", got)
+	}
+
+	// The user leaves; 9% is now below the recalibrated threshold.
+	s.sessions.users = nil
+	s.run(59 * time.Minute)
+	if n := s.runner.count("shutdown -h now"); n != shutdowns {
+		t.Fatal("shutdown before the user had been gone for 60 minutes")
+	}
+	s.run(2 * time.Minute)
+	if n := s.runner.count("shutdown -h now"); n == shutdowns {
+		t.Error("shutdown did not trigger 60 minutes after the user left")
+	}
+}
+
+func TestManualModeShutdownWaitsForIdleCPU(t *testing.T) {
+	s := newSim(t, "[monitoring]\ncpu_check_minutes = 30\nuser_check_minutes = 30\ncpu_threshold = 20\n")
+	s.proc.usage = 50
+
+	s.run(3 * time.Hour)
+	if n := s.runner.count("shutdown -h now"); n != 0 {
+		t.Fatal("shutdown while CPU was busy")
+	}
+
+	s.proc.usage = 5
+	idleFrom := s.clock.Now()
+	s.run(time.Hour)
+	shutdownAt, ok := s.runner.firstCall("shutdown -h now")
+	if !ok {
+		t.Fatal("no shutdown after CPU went idle")
+	}
+	if elapsed := shutdownAt.Sub(idleFrom); elapsed < 30*time.Minute || elapsed > 32*time.Minute {
+		t.Errorf("shutdown %v after CPU went idle, want ~30m", elapsed)
+	}
+	if s.runner.count("systemctl restart IdleShutdown") != 0 {
+		t.Error("manual mode must not calibrate")
+	}
+}
+
+func TestBannerNoneLeavesConfigUntouched(t *testing.T) {
+	configINI := autoConfig + "\n[agent]\nbanner = none\n"
+	s := newSim(t, configINI)
+	s.proc.usage = 2
+
+	s.run(25 * time.Hour)
+	if _, ok := s.runner.firstCall("systemctl restart IdleShutdown"); !ok {
+		t.Fatal("initial calibration did not run")
+	}
+	if got := s.configText(); got != configINI {
+		t.Errorf("config.ini was modified:\n%s", got)
+	}
+}
+
+func TestBannerFileWritesStatusInsteadOfConfig(t *testing.T) {
+	configINI := autoConfig + "\n[agent]\nbanner = file\n"
+	s := newSim(t, configINI)
+	s.proc.usage = 2
+
+	statusText := func() string {
+		content, err := os.ReadFile(filepath.Join(s.dir, "status.ini"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(content)
+	}
+
+	s.run(time.Hour)
+	if status := statusText(); !strings.Contains(status, "LEARNING") || !strings.Contains(status, "state = learning") {
+		t.Errorf("status.ini during learning:\n%s", status)
+	}
+
+	s.run(24 * time.Hour)
+	if status := statusText(); !strings.Contains(status, "AUTO-MANAGED") || !strings.Contains(status, "cpu_threshold = 5") {
+		t.Errorf("status.ini after calibration:\n%s", status)
+	}
+	if got := s.configText(); got != configINI {
+		t.Errorf("config.ini was modified:\n%s", got)
+	}
+}
+
+func TestMOTDFollowsAgentState(t *testing.T) {
+	s := newSim(t, autoConfig)
+	s.proc.usage = 2
+	path := filepath.Join(s.dir, "motd.d", "idleshutdown")
+	s.agent.motd = motd.NewWriter(path)
+
+	motdText := func() string {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(content)
+	}
+
+	s.run(time.Hour)
+	if text := motdText(); !strings.Contains(text, "learning") || !strings.Contains(text, "23h 0m left") {
+		t.Errorf("MOTD during learning:\n%s", text)
+	}
+
+	s.run(24 * time.Hour)
+	if text := motdText(); !strings.Contains(text, "calibrated threshold 5%") ||
+		!strings.Contains(text, "shut down after 60 min idle") {
+		t.Errorf("MOTD after calibration:\n%s", text)
+	}
+}
+
+func TestWebhookLifecycleEvents(t *testing.T) {
+	var mu sync.Mutex
+	var events []string
+	var pendingFields map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event notify.Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("decode event: %v", err)
+		}
+		mu.Lock()
+		events = append(events, event.Type)
+		if event.Type == notify.ShutdownPending && pendingFields == nil {
+			pendingFields = event.Fields
+		}
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	s := newSim(t, "[monitoring]\ncpu_check_minutes = 60\nuser_check_minutes = 60\nshutdown_grace_minutes = 5\n# cpu_threshold = 25\n")
+	webhook := notify.NewWebhook([]string{srv.URL}, "s3cret", time.Second, 0)
+	s.agent.notifier = webhook
+	s.agent.shutdownExec.Notifier = webhook
+	s.proc.usage = 2
+
+	// Calibration after 24h; the idle VM then waits out the grace period.
+	s.run(24*time.Hour + 3*time.Minute)
+	if n := s.runner.count("shutdown -h now"); n != 0 {
+		t.Fatal("shutdown ran during the grace period")
+	}
+
+	// A user logs in during the grace period and cancels the shutdown.
+	s.sessions.users = []string{"alice"}
+	s.run(2 * time.Minute)
+	s.sessions.users = nil
+	s.run(70 * time.Minute)
+	if n := s.runner.count("shutdown -h now"); n == 0 {
+		t.Fatal("no shutdown after the user left and the grace period passed")
+	}
+
+	// A broken config is reported once.
+	if err := os.WriteFile(filepath.Join(s.dir, "config.ini"), []byte("[monitoring\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s.run(3 * time.Minute)
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{
+		notify.CalibrationApplied, notify.LearningCompleted,
+		notify.ShutdownPending, notify.ShutdownCancelled,
+		notify.ShutdownPending, notify.ShutdownExecuted,
+	}
+	if len(events) < len(want) || strings.Join(events[:len(want)], ",") != strings.Join(want, ",") {
+		t.Errorf("events = %v, want to start with %v", events, want)
+	}
+	// The simulated VM stays up after shutdown, so more shutdown events
+	// follow; the broken config must be reported exactly once.
+	reloadFailures := 0
+	for _, e := range events {
+		if e == notify.ConfigReloadFailed {
+			reloadFailures++
+		}
+	}
+	if reloadFailures != 1 {
+		t.Errorf("%d config_reload_failed events, want 1", reloadFailures)
+	}
+	if pendingFields["cpu_samples"] != float64(120) || pendingFields["user_minutes"] != float64(60) ||
+		pendingFields["shutdown_at"] == nil {
+		t.Errorf("pending event fields = %v", pendingFields)
+	}
+}
+
+func TestWebhookConfigReloaded(t *testing.T) {
+	var mu sync.Mutex
+	var events []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event notify.Event
+		json.NewDecoder(r.Body).Decode(&event)
+		mu.Lock()
+		events = append(events, event.Type)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	const base = "[monitoring]\ncpu_check_minutes = 30\nuser_check_minutes = 30\ncpu_threshold = 20\nshutdown_grace_minutes = 5\n"
+	s := newSim(t, base)
+	s.proc.usage = 50
+	s.run(10 * time.Minute)
+
+	// Webhooks added while the agent runs are used from the next tick.
+	if err := os.WriteFile(filepath.Join(s.dir, "config.ini"), []byte(base+"[webhook]\nurls = "+srv.URL+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s.proc.usage = 2
+	s.run(32 * time.Minute)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) == 0 || events[0] != notify.ShutdownPending {
+		t.Errorf("events = %v, want a shutdown_pending after the reload", events)
+	}
+}
+
+func TestAuditLogRecordsDecisions(t *testing.T) {
+	s := newSim(t, "[monitoring]\ncpu_check_minutes = 30\nuser_check_minutes = 30\ncpu_threshold = 20\nshutdown_grace_minutes = 5\n")
+	path := filepath.Join(s.dir, config.AuditFileName)
+	s.agent.audit = audit.NewLog(path, 1<<20, 2)
+	s.proc.usage = 5
+
+	s.sessions.users = []string{"alice"}
+	s.run(40 * time.Minute)
+	s.sessions.users = nil
+	s.run(40 * time.Minute)
+	if n := s.runner.count("shutdown -h now"); n != 1 {
+		t.Fatalf("shutdown ran %d times, want 1", n)
+	}
+
+	records, err := audit.Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One record per change of state: busy with too few samples, busy
+	// while alice is logged in, the grace period, the shutdown and,
+	// since the fake shutdown leaves the VM running, a new grace period.
+	var states []string
+	var last audit.Record
+	for _, rec := range records {
+		states = append(states, rec.Outcome+" "+rec.DecidedBy)
+		if rec.Outcome == audit.OutcomeShutdown {
+			last = rec
+		}
+	}
+	want := "busy cpu.idle(30m) && users.idle(30m)," +
+		"busy users.idle(30m)," +
+		"pending cpu.idle(30m) && users.idle(30m)," +
+		"shutdown cpu.idle(30m) && users.idle(30m)," +
+		"pending cpu.idle(30m) && users.idle(30m)"
+	if got := strings.Join(states, ","); got != want {
+		t.Errorf("records = %s, want %s", got, want)
+	}
+
+	busy := records[1]
+	if !busy.CPUIdle || busy.UsersIdle || busy.ThresholdSource != "config.ini" || busy.Mode != "manual" {
+		t.Errorf("busy record = %+v", busy)
+	}
+	if busy.CPUSamples != nil || busy.UserSamples != nil {
+		t.Errorf("busy record carries %d cpu and %d user samples", len(busy.CPUSamples), len(busy.UserSamples))
+	}
+	if !last.CPUIdle || !last.UsersIdle || len(last.CPUSamples) != 60 || len(last.UserSamples) != 60 {
+		t.Errorf("shutdown record: idle %v/%v, %d cpu and %d user samples",
+			last.CPUIdle, last.UsersIdle, len(last.CPUSamples), len(last.UserSamples))
+	}
+
+	var out strings.Builder
+	printAuditRecord(&out, busy)
+	if text := out.String(); !strings.Contains(text, "users:     busy over 30 min") {
+		t.Errorf("busy audit output:\n%s", text)
+	}
+	out.Reset()
+	printAuditRecord(&out, last)
+	if text := out.String(); !strings.Contains(text, "cpu:       idle — 60 samples in 30 min, avg 5.00%") {
+		t.Errorf("shutdown audit output:\n%s", text)
+	}
+}
+
+func TestExplainEndpoint(t *testing.T) {
+	s := newSim(t, autoConfig)
+	s.proc.usage = 2
+	handler := api.ExplainHandler(s.agent.explainNow)
+
+	get := func(format string) string {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/explain?format="+format, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /explain: %d %s", rec.Code, rec.Body)
+		}
+		return rec.Body.String()
+	}
+
+	s.run(time.Hour)
+	if text := get("text"); !strings.Contains(text, "learning phase") || !strings.Contains(text, "Learning:   23h 0m left") {
+		t.Errorf("explain during learning:\n%s", text)
+	}
+
+	// After calibration a user keeps the VM up.
+	s.run(23 * time.Hour)
+	s.sessions.users = []string{"alice"}
+	s.run(10 * time.Minute)
+	var report explain.Report
+	if err := json.Unmarshal([]byte(get("json")), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Policy.Learning || report.Policy.CPUThreshold != 5 || len(report.Conditions) != 2 ||
+		!report.Conditions[0].Idle || report.Conditions[1].Idle {
+		t.Errorf("report = %+v", report)
+	}
+	if users := report.Conditions[1]; users.LastBreak == nil || users.LastBreak.Users[0] != "alice" ||
+		!users.IdleAt.Equal(users.LastBreak.Time.Add(time.Hour)) {
+		t.Errorf("user check = %+v", users)
+	}
+	if report.DecidedBy != "users.idle(1h)" {
+		t.Errorf("decided by %s", report.DecidedBy)
+	}
+}
+
+func TestShutdownWhenRule(t *testing.T) {
+	// Logged-in users do not keep this VM up
+	s := newSim(t, "[monitoring]\ncpu_threshold = 20\nshutdown_when = cpu.idle(30m) || users.idle(2h)\n")
+	path := filepath.Join(s.dir, config.AuditFileName)
+	s.agent.audit = audit.NewLog(path, 1<<20, 2)
+	s.sessions.users = []string{"alice"}
+	s.proc.usage = 50
+	s.run(time.Hour)
+	if n := s.runner.count("shutdown -h now"); n != 0 {
+		t.Fatal("shutdown while CPU was busy")
+	}
+
+	s.proc.usage = 5
+	idleFrom := s.clock.Now()
+	s.run(35 * time.Minute)
+	shutdownAt, ok := s.runner.firstCall("shutdown -h now")
+	if !ok {
+		t.Fatal("no shutdown after CPU went idle")
+	}
+	if elapsed := shutdownAt.Sub(idleFrom); elapsed < 30*time.Minute || elapsed > 32*time.Minute {
+		t.Errorf("shutdown %v after CPU went idle, want ~30m", elapsed)
+	}
+
+	records, err := audit.Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := records[0]
+	if first.Rule != "cpu.idle(30m) || users.idle(2h)" || first.DecidedBy != "cpu.idle(30m) || users.idle(2h)" ||
+		first.Reason != "VM busy — rule blocked by cpu.idle(30m) || users.idle(2h)" || first.CPUCheckMinutes != 30 {
+		t.Errorf("first record = %+v", first)
+	}
+	for _, rec := range records {
+		if rec.Outcome == audit.OutcomeShutdown {
+			if rec.DecidedBy != "cpu.idle(30m)" || rec.Reason != "VM idle — rule met by cpu.idle(30m)" {
+				t.Errorf("shutdown record = %+v", rec)
+			}
+			return
+		}
+	}
+	t.Error("no shutdown record")
+}
+
+func TestPSIHoldsShutdown(t *testing.T) {
+	s := newSim(t, "[monitoring]\ncpu_threshold = 20\nshutdown_when = cpu.idle(30m) && psi.idle(30m)\n[psi]\nthreshold = 5\n")
+	s.proc.psi = true
+	s.proc.usage = 2
+	s.proc.ioStall = 30 // a throttled VM waiting on disk, with idle-looking CPU
+	s.run(time.Hour)
+	if n := s.runner.count("shutdown -h now"); n != 0 {
+		t.Fatal("shutdown while tasks were stalled on I/O")
+	}
+
+	s.proc.ioStall = 0
+	s.run(35 * time.Minute)
+	if n := s.runner.count("shutdown -h now"); n == 0 {
+		t.Fatal("no shutdown after the I/O stall ended")
+	}
+}
+
+func TestPSIUnavailableDoesNotHoldShutdown(t *testing.T) {
+	s := newSim(t, "[monitoring]\ncpu_threshold = 20\nshutdown_when = cpu.idle(30m) && psi.idle(30m)\n")
+	s.proc.usage = 2
+	s.run(35 * time.Minute)
+	if s.agent.psiMon.Available() {
+		t.Fatal("PSI available without /proc/pressure")
+	}
+	if n := s.runner.count("shutdown -h now"); n == 0 {
+		t.Fatal("no shutdown on a kernel without PSI")
+	}
+}
+
+func TestMemoryVetoHoldsShutdown(t *testing.T) {
+	s := newSim(t, "[monitoring]\ncpu_threshold = 20\ncpu_check_minutes = 30\nuser_check_minutes = 30\n"+
+		"[memory]\nswap_pages_per_second = 100\nveto_minutes = 15\n")
+	s.proc.usage = 2
+	s.proc.swapRate = 500 // an analytics job paging its working set back in
+	s.run(time.Hour)
+	if n := s.runner.count("shutdown -h now"); n != 0 {
+		t.Fatal("shutdown while swapping above the limit")
+	}
+
+	s.proc.swapRate = 0
+	s.run(20 * time.Minute)
+	if n := s.runner.count("shutdown -h now"); n == 0 {
+		t.Fatal("no shutdown after swapping stopped")
+	}
+}
+
+func TestNetTrafficHoldsShutdown(t *testing.T) {
+	s := newSim(t, "[monitoring]\ncpu_threshold = 20\n"+
+		"shutdown_when = users.idle(30m) || (cpu.idle(2h) && net.idle(2h))\n[net]\nbytes_per_second = 10000\n")
+	s.sessions.users = []string{"alice"}
+	s.proc.usage = 2
+	s.proc.netRate = 5e6 // a notebook kernel downloading a dataset
+	s.run(3 * time.Hour)
+	if n := s.runner.count("shutdown -h now"); n != 0 {
+		t.Fatal("shutdown while downloading with a user logged in")
+	}
+
+	s.proc.netRate = 0
+	s.run(119 * time.Minute)
+	if n := s.runner.count("shutdown -h now"); n != 0 {
+		t.Fatal("shutdown before the network had been quiet for 2 hours")
+	}
+	s.run(2 * time.Minute)
+	if n := s.runner.count("shutdown -h now"); n == 0 {
+		t.Fatal("no shutdown after 2 quiet hours with idle CPU")
+	}
+}
+
+func TestTCPClientsHoldShutdown(t *testing.T) {
+	s := newSim(t, "[monitoring]\ncpu_threshold = 20\ncpu_check_minutes = 30\nuser_check_minutes = 30\n"+
+		"[tcp]\nports = 5432\nexclude_peers = 10.9.0.0/16\n")
+	s.proc.usage = 2
+	s.proc.clients = 2 // a dashboard holding database connections open
+	s.run(time.Hour)
+	if n := s.runner.count("shutdown -h now"); n != 0 {
+		t.Fatal("shutdown while clients were connected")
+	}
+
+	s.proc.clients = 0
+	s.run(6 * time.Minute)
+	if n := s.runner.count("shutdown -h now"); n == 0 {
+		t.Fatal("no shutdown after the clients disconnected; the scraper should not count")
+	}
+}
+
+func TestScoringToleratesCronSpikes(t *testing.T) {
+	// A 30-second job every 5 minutes, on an otherwise idle VM
+	spiky := func(s *sim, d time.Duration) {
+		for end := s.clock.Now().Add(d); s.clock.Now().Before(end); {
+			s.proc.usage = 60
+			s.run(samplingInterval)
+			s.proc.usage = 3
+			s.run(5*time.Minute - samplingInterval)
+		}
+	}
+
+	s := newSim(t, "[monitoring]\ncpu_threshold = 20\n")
+	spiky(s, 2*time.Hour)
+	if n := s.runner.count("shutdown -h now"); n != 0 {
+		t.Fatal("all-samples check shut down despite the spikes")
+	}
+
+	s = newSim(t, "[monitoring]\ncpu_threshold = 20\n[scoring]\nenabled = true\ncpu_spike_seconds = 30\n")
+	start := s.clock.Now()
+	spiky(s, 2*time.Hour)
+	shutdownAt, ok := s.runner.firstCall("shutdown -h now")
+	if !ok {
+		t.Fatal("no shutdown with spikes tolerated")
+	}
+	if elapsed := shutdownAt.Sub(start); elapsed > 62*time.Minute {
+		t.Errorf("shutdown after %v, want once the hour window filled", elapsed)
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"idleshutdown/internal/audit"
+	"idleshutdown/internal/config"
+	"idleshutdown/internal/monitor"
+)
+
+// runAudit implements "idleshutdown audit": it prints the last shutdown
+// decisions from the audit log in the state directory, with the samples
+// behind each.
+func runAudit(args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	configPath := fs.String("config", config.DefaultConfigPath, "Path to configuration file")
+	stateDir := fs.String("state-dir", "", "State directory holding the audit log (default: from config)")
+	n := fs.Int("n", 10, "Number of decisions to show (0 = all)")
+	outcome := fs.String("outcome", "", "Only show decisions with this outcome: busy, pending, cancelled, shutdown or failed")
+	asJSON := fs.Bool("json", false, "Print records as JSON Lines")
+	fs.Parse(args)
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return err
+	}
+	cfg.LogDiagnostics()
+	path := filepath.Join(cfg.ResolveStateDir(*stateDir), config.AuditFileName)
+
+	records, err := audit.Read(path)
+	if err != nil {
+		return err
+	}
+	if *outcome != "" {
+		var filtered []audit.Record
+		for _, rec := range records {
+			if rec.Outcome == *outcome {
+				filtered = append(filtered, rec)
+			}
+		}
+		records = filtered
+	}
+	if *n > 0 && len(records) > *n {
+		records = records[len(records)-*n:]
+	}
+	if len(records) == 0 {
+		fmt.Fprintf(os.Stderr, "No decisions recorded in %s\n", path)
+		return nil
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		for _, rec := range records {
+			if err := encoder.Encode(rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, rec := range records {
+		printAuditRecord(os.Stdout, rec)
+	}
+	return nil
+}
+
+// printAuditRecord writes a human-readable summary of one decision.
+func printAuditRecord(w io.Writer, rec audit.Record) {
+	outcome := rec.Outcome
+	if rec.DryRun {
+		outcome += " (dry run)"
+	}
+	fmt.Fprintf(w, "%s  %-9s  %s\n", rec.Time.UTC().Format("2006-01-02 15:04:05 UTC"), outcome, rec.Reason)
+	if rec.Error != "" {
+		fmt.Fprintf(w, "  error:     %s\n", rec.Error)
+	}
+	fmt.Fprintf(w, "  threshold: %d%% from %s (%s mode)", rec.CPUThreshold, rec.ThresholdSource, rec.Mode)
+	if rec.CPUMetric != "" && rec.CPUMetric != monitor.MetricAggregate {
+		fmt.Fprintf(w, ", on %s", rec.CPUMetric)
+	}
+	fmt.Fprintln(w)
+	if rec.Rule != "" {
+		fmt.Fprintf(w, "  rule:      %s, decided by %s\n", rec.Rule, rec.DecidedBy)
+	}
+	if s := rec.Score; s != nil {
+		fmt.Fprintf(w, "  score:     %.2f (target %.2f) over %d min", s.Value, s.Target, s.Minutes)
+		for _, sig := range s.Signals {
+			fmt.Fprintf(w, "; %s %.2f", sig.Name, sig.Value)
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "  cpu:       %s\n", auditCPUSummary(rec))
+	fmt.Fprintf(w, "  users:     %s\n", auditUserSummary(rec))
+	if p := rec.PSI; p != nil {
+		fmt.Fprintf(w, "  psi:       %s\n", auditPSISummary(*p))
+	}
+	if m := rec.Memory; m != nil {
+		fmt.Fprintf(w, "  memory:    %s\n", auditMemorySummary(*m))
+	}
+	if n := rec.Net; n != nil {
+		fmt.Fprintf(w, "  net:       %s\n", auditNetSummary(*n))
+	}
+	if c := rec.TCP; c != nil {
+		fmt.Fprintf(w, "  tcp:       %s\n", auditTCPSummary(*c))
+	}
+	if rec.GraceMinutes > 0 {
+		fmt.Fprintf(w, "  grace:     %d min\n", rec.GraceMinutes)
+	}
+	fmt.Fprintln(w)
+}
+
+// auditCPUSummary describes the CPU window: idle or not, sample count,
+// average, peak and the first sample at or above the threshold.
+func auditCPUSummary(rec audit.Record) string {
+	state := "busy"
+	if rec.CPUIdle {
+		state = "idle"
+	}
+	if rec.CPUCheckMinutes == 0 {
+		return "not checked by the rule"
+	}
+	if len(rec.CPUSamples) == 0 && !carriesSamples(rec) {
+		return fmt.Sprintf("%s over %d min", state, rec.CPUCheckMinutes)
+	}
+	if len(rec.CPUSamples) == 0 {
+		return fmt.Sprintf("%s — no samples in %d min", state, rec.CPUCheckMinutes)
+	}
+
+	sum, peak := 0.0, 0.0
+	var breaking *audit.CPUSample
+	for i, s := range rec.CPUSamples {
+		sum += s.Usage
+		peak = max(peak, s.Usage)
+		if breaking == nil && s.Usage >= float64(rec.CPUThreshold) {
+			breaking = &rec.CPUSamples[i]
+		}
+	}
+	summary := fmt.Sprintf("%s — %d samples in %d min, avg %.2f%%, peak %.2f%%",
+		state, len(rec.CPUSamples), rec.CPUCheckMinutes, sum/float64(len(rec.CPUSamples)), peak)
+	if breaking != nil {
+		summary += fmt.Sprintf("; %.2f%% ≥ %d%% at %s", breaking.Usage, rec.CPUThreshold, breaking.Time.UTC().Format("15:04"))
+	}
+	return summary
+}
+
+// carriesSamples reports whether rec was written with the samples in its
+// check windows, as records of pending and executed shutdowns are.
+func carriesSamples(rec audit.Record) bool {
+	switch rec.Outcome {
+	case audit.OutcomePending, audit.OutcomeShutdown, audit.OutcomeFailed:
+		return true
+	}
+	return false
+}
+
+// auditPSISummary describes the pressure stall window: idle or not, sample
+// count and peak stall against the threshold.
+func auditPSISummary(p audit.PSICheck) string {
+	if p.Unavailable {
+		return "idle — not available on this kernel"
+	}
+	state := "busy"
+	if p.Idle {
+		state = "idle"
+	}
+	return fmt.Sprintf("%s — %d samples in %d min, peak %.2f%% of %s stall (threshold %g%%)",
+		state, p.Samples, p.Minutes, p.Peak, strings.Join(p.Resources, ", "), p.Threshold)
+}
+
+// auditMemorySummary describes the paging and swap window: idle or not,
+// sample count and the peak rates against their limits.
+func auditMemorySummary(m audit.MemoryCheck) string {
+	state := "busy"
+	if m.Idle {
+		state = "idle"
+	}
+	return fmt.Sprintf("%s — %d samples in %d min, peak swap %s, major faults %s, faults %s",
+		state, m.Samples, m.Minutes, auditRate(m.PeakSwap, m.SwapLimit, "pages"),
+		auditRate(m.PeakMajorFaults, m.MajorFaultsLimit, ""), auditRate(m.PeakPageFaults, m.PageFaultsLimit, ""))
+}
+
+// auditNetSummary describes the network traffic window: idle or not,
+// sample count and the peak rates against their limits.
+func auditNetSummary(n audit.NetCheck) string {
+	state := "busy"
+	if n.Idle {
+		state = "idle"
+	}
+	return fmt.Sprintf("%s — %d samples in %d min, peak %s, %s",
+		state, n.Samples, n.Minutes, auditRate(n.PeakBytes, n.BytesLimit, "bytes"), auditRate(n.PeakPackets, n.PacketsLimit, "packets"))
+}
+
+// auditTCPSummary describes the client connections window: idle or not,
+// sample count and the clients connected.
+func auditTCPSummary(c audit.TCPCheck) string {
+	ports := make([]string, len(c.Ports))
+	for i, p := range c.Ports {
+		ports[i] = strconv.Itoa(int(p))
+	}
+	if c.Idle {
+		return fmt.Sprintf("idle — %d samples in %d min without clients on ports %s",
+			c.Samples, c.Minutes, strings.Join(ports, ", "))
+	}
+	s := fmt.Sprintf("busy — %d samples in %d min on ports %s, up to %d connections",
+		c.Samples, c.Minutes, strings.Join(ports, ", "), c.MaxConnections)
+	if len(c.Peers) > 0 {
+		s += " (" + strings.Join(c.Peers, ", ") + ")"
+	}
+	return s
+}
+
+// auditRate formats a per-second rate with its limit.
+func auditRate(rate, limit float64, unit string) string {
+	s := fmt.Sprintf("%.1f/s", rate)
+	if unit != "" {
+		s = fmt.Sprintf("%.1f %s/s", rate, unit)
+	}
+	if limit > 0 {
+		return fmt.Sprintf("%s (limit %g)", s, limit)
+	}
+	return s
+}
+
+// auditUserSummary describes the user window: idle or not, sample count
+// and who was logged in, when.
+func auditUserSummary(rec audit.Record) string {
+	state := "busy"
+	if rec.UsersIdle {
+		state = "idle"
+	}
+	if rec.UserCheckMinutes == 0 {
+		return "not checked by the rule"
+	}
+	if len(rec.UserSamples) == 0 && !carriesSamples(rec) {
+		return fmt.Sprintf("%s over %d min", state, rec.UserCheckMinutes)
+	}
+	if len(rec.UserSamples) == 0 {
+		return fmt.Sprintf("%s — no samples in %d min", state, rec.UserCheckMinutes)
+	}
+
+	seen := map[string]bool{}
+	var names []string
+	var last time.Time
+	for _, s := range rec.UserSamples {
+		if s.Count > 0 {
+			last = s.Time
+		}
+		for _, name := range s.Names {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	summary := fmt.Sprintf("%s — %d samples in %d min", state, len(rec.UserSamples), rec.UserCheckMinutes)
+	if len(names) > 0 {
+		summary += "; logged in: " + strings.Join(names, ", ")
+	}
+	if !last.IsZero() {
+		summary += "; last seen " + last.UTC().Format("15:04")
+	}
+	return summary
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+
+	"idleshutdown/internal/api"
+	"idleshutdown/internal/config"
+)
+
+// runExplain implements "idleshutdown explain": it asks the running agent
+// why the VM is, or is not, shutting down.
+func runExplain(args []string) error {
+	fs := flag.NewFlagSet("explain", flag.ExitOnError)
+	configPath := fs.String("config", config.DefaultConfigPath, "Path to configuration file")
+	socket := fs.String("socket", "", "Agent API socket (default: from config)")
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	fs.Parse(args)
+
+	if *socket == "" {
+		cfg, err := config.Load(*configPath)
+		if err != nil {
+			return err
+		}
+		cfg.LogDiagnostics()
+		*socket = cfg.APISocket
+	}
+	if *socket == "" {
+		return fmt.Errorf("API is disabled ([api] socket is empty in %s)", *configPath)
+	}
+
+	format := "text"
+	if *asJSON {
+		format = "json"
+	}
+	return api.Get(*socket, "/explain", url.Values{"format": {format}}, os.Stdout)
+}
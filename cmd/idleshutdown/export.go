@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"time"
+
+	"idleshutdown/internal/api"
+	"idleshutdown/internal/config"
+	"idleshutdown/internal/samplefile"
+)
+
+// runExport implements "idleshutdown export": it fetches the sample history
+// from the running agent and writes it as CSV or JSON Lines.
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	configPath := fs.String("config", config.DefaultConfigPath, "Path to configuration file")
+	socket := fs.String("socket", "", "Agent API socket (default: from config)")
+	format := fs.String("format", samplefile.FormatCSV, "Output format: csv or jsonl")
+	since := fs.String("since", "", "Start of range: RFC 3339 time or duration ago (e.g. 24h)")
+	until := fs.String("until", "", "End of range: RFC 3339 time or duration ago")
+	output := fs.String("o", "", "Write to file instead of stdout")
+	fs.Parse(args)
+
+	if *socket == "" {
+		cfg, err := config.Load(*configPath)
+		if err != nil {
+			return err
+		}
+		cfg.LogDiagnostics()
+		*socket = cfg.APISocket
+	}
+	if *socket == "" {
+		return fmt.Errorf("API is disabled ([api] socket is empty in %s)", *configPath)
+	}
+
+	query := url.Values{"format": {*format}}
+	for name, val := range map[string]string{"since": *since, "until": *until} {
+		if val == "" {
+			continue
+		}
+		t, err := parseTimeArg(val, time.Now())
+		if err != nil {
+			return fmt.Errorf("--%s: %w", name, err)
+		}
+		query.Set(name, t.Format(time.RFC3339))
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	return api.Get(*socket, "/samples", query, w)
+}
+
+// parseTimeArg accepts an RFC 3339 timestamp or a duration before now.
+func parseTimeArg(val string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, val); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither an RFC 3339 time nor a duration", val)
+	}
+	return now.Add(-d), nil
+}
@@ -5,16 +5,30 @@ package main
 import (
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
+	"math"
 	"os"
-	"os/exec"
 	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"idleshutdown/internal/api"
+	"idleshutdown/internal/audit"
 	"idleshutdown/internal/calibrator"
+	"idleshutdown/internal/clock"
+	"idleshutdown/internal/command"
 	"idleshutdown/internal/config"
+	"idleshutdown/internal/explain"
+	"idleshutdown/internal/logging"
 	"idleshutdown/internal/monitor"
+	"idleshutdown/internal/motd"
+	"idleshutdown/internal/notify"
+	"idleshutdown/internal/rules"
+	"idleshutdown/internal/scoring"
 	"idleshutdown/internal/shutdown"
 )
 
@@ -28,34 +42,72 @@ const (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "idleshutdown %s: %v\n", os.Args[1], err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	flag.Usage = usage
 	configPath := flag.String("config", config.DefaultConfigPath, "Path to configuration file")
 	defaultsPath := flag.String("defaults", config.DefaultDefaultsPath, "Path to defaults file")
+	stateDirFlag := flag.String("state-dir", "", "State directory (default: state_dir from config, $STATE_DIRECTORY or "+config.DefaultStateDir+")")
 	dryRun := flag.Bool("dry-run", false, "Run in dry-run mode (no actual shutdown)")
+	logFormat := flag.String("log-format", "", "Log format: auto, text, json or journal (default: [logging] format from config)")
+	logLevel := flag.String("log-level", "", "Log level: debug, info, warn or error (default: from config)")
 	flag.Parse()
 
-	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
-
-	log.Println("===========================================")
-	log.Println("  IdleShutdown Agent Starting")
-	log.Println("===========================================")
-	log.Printf("Config path:   %s", *configPath)
-	log.Printf("Defaults path: %s", *defaultsPath)
-	log.Printf("Dry-run mode:  %v", *dryRun)
-
 	// Load configuration
 	cfg, err := config.Load(*configPath)
 	if err != nil {
-		log.Fatalf("Error loading configuration: %v", err)
+		fatal("Error loading configuration", err)
+	}
+
+	// Set up structured logging; flags override config.ini
+	if *logFormat != "" {
+		cfg.LogFormat = *logFormat
+	}
+	if *logLevel != "" {
+		cfg.LogLevel = *logLevel
+	}
+	if err := setupLogging(cfg); err != nil {
+		fatal("Error setting up logging", err)
+	}
+
+	slog.Info("IdleShutdown agent starting", logging.EventKey, logging.Startup,
+		"config", *configPath, "defaults", *defaultsPath, "dry_run", *dryRun)
+	slog.Info("Configuration loaded", logging.EventKey, logging.Startup, "config", cfg.String())
+	cfg.LogDiagnostics()
+
+	// Prepare the state directory, moving state from its old home in /etc
+	stateDir := cfg.ResolveStateDir(*stateDirFlag)
+	slog.Info("State directory", logging.EventKey, logging.Startup, "path", stateDir)
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		fatal("Error creating state directory", err)
+	}
+	statePath := filepath.Join(stateDir, config.StateFileName)
+	if err := calibrator.MigrateStateFile(config.LegacyStatePath, statePath); err != nil {
+		slog.Warn("Could not migrate calibration state", logging.EventKey, logging.Calibration, "error", err)
+	}
+	statusPath := cfg.StatusFile
+	if statusPath == "" {
+		statusPath = filepath.Join(stateDir, config.StatusFileName)
 	}
-	log.Printf("Configuration loaded: %s", cfg)
 
 	// Load calibration defaults
 	calibCfg, err := config.LoadDefaults(*defaultsPath)
 	if err != nil {
-		log.Fatalf("Error loading defaults: %v", err)
+		fatal("Error loading defaults", err)
 	}
-	log.Printf("Calibration defaults loaded: Initial=%v hours, Recalib=%v days, Lookback=%v hours",
-		calibCfg.InitialTrackingHours, calibCfg.RecalibrationIntervalDays, calibCfg.RecalibrationTrackingHours)
+	slog.Info("Calibration defaults loaded", logging.EventKey, logging.Startup,
+		"initial_tracking_hours", calibCfg.InitialTrackingHours,
+		"recalibration_interval_days", calibCfg.RecalibrationIntervalDays,
+		"recalibration_tracking_hours", calibCfg.RecalibrationTrackingHours,
+		"strategy", calibCfg.Strategy)
 
 	// Create stop channel for graceful shutdown
 	stopCh := make(chan struct{})
@@ -66,176 +118,861 @@ func main() {
 
 	// Initialize monitors
 	cpuMonitor := monitor.NewCPUMonitor(samplingInterval)
+	cpuMonitor.SetTolerance(cfg.CPUTolerance())
+	cpuMonitor.SetMetric(cfg.CPUMetric)
+	cpuMonitor.SetAccounting(cfg.CPUAccounting())
+	cpuMonitor.SetCgroups(cfg.CPUCgroupSelection())
 	userMonitor := monitor.NewUserMonitor(samplingInterval)
-
-	log.Println("Starting CPU monitor...")
+	psiMonitor := monitor.NewPSIMonitor(samplingInterval)
+	psiMonitor.SetResources(cfg.PSIResources)
+	memoryMonitor := monitor.NewMemoryMonitor(samplingInterval)
+	memoryMonitor.SetLimits(cfg.MemoryLimits())
+	netMonitor := monitor.NewNetMonitor(samplingInterval)
+	netMonitor.SetLimits(cfg.NetLimits())
+	netMonitor.SetInterfaces(cfg.NetInterfaces)
+	tcpMonitor := monitor.NewTCPMonitor(samplingInterval)
+	tcpMonitor.SetFilter(cfg.TCPFilter())
+
+	slog.Info("Starting monitors", logging.EventKey, logging.Startup, "interval", samplingInterval.String())
 	cpuMonitor.Start(stopCh)
-
-	log.Println("Starting User monitor...")
 	userMonitor.Start(stopCh)
+	psiMonitor.Start(stopCh)
+	memoryMonitor.Start(stopCh)
+	netMonitor.Start(stopCh)
+	tcpMonitor.Start(stopCh)
+
+	// Lifecycle notifications
+	notifier := newNotifier(cfg, logging.Startup)
 
-	// Initialize shutdown executor
 	shutdownExec := shutdown.NewExecutor(*dryRun)
+	shutdownExec.Grace = cfg.ShutdownGrace()
+	shutdownExec.Notifier = notifier
+
+	a := &agent{
+		configPath:   *configPath,
+		statusPath:   statusPath,
+		cfg:          cfg,
+		calibCfg:     calibCfg,
+		cpuMon:       cpuMonitor,
+		userMon:      userMonitor,
+		psiMon:       psiMonitor,
+		memMon:       memoryMonitor,
+		netMon:       netMonitor,
+		tcpMon:       tcpMonitor,
+		shutdownExec: shutdownExec,
+		clock:        clock.Real,
+		runner:       command.Exec,
+		notifier:     notifier,
+		notifierKey:  notifierSettings(cfg),
+		configKey:    cfg.DiagnosticsKey(),
+	}
+	if cfg.MOTDEnabled {
+		a.motd = motd.NewWriter(cfg.MOTDPath)
+		slog.Info("Login message enabled", logging.EventKey, logging.Startup, "path", cfg.MOTDPath)
+	}
+	if cfg.AuditEnabled {
+		auditPath := filepath.Join(stateDir, config.AuditFileName)
+		a.audit = audit.NewLog(auditPath, int64(cfg.AuditMaxSizeMB)<<20, cfg.AuditMaxFiles)
+		slog.Info("Decision audit log enabled", logging.EventKey, logging.Startup, "path", auditPath)
+	}
 
 	// Handle auto/manual mode
-	var calib *calibrator.Calibrator
-
 	if cfg.AutoMode {
-		log.Println("Mode: AUTO — cpu_threshold is absent (commented out)")
-
-		calib = calibrator.New(*configPath, config.DefaultStatePath, calibCfg)
-
-		if calib.IsInLearningPhase() {
-			remaining := calib.LearningTimeRemaining()
-			log.Printf("  📊 Learning phase: %s remaining — shutdown evaluation PAUSED",
-				formatDuration(remaining))
-			log.Printf("  Initial calibration: after %s of data", calibCfg.InitialLookback())
-			calib.WriteLearningBanner()
-		} else {
-			threshold := calib.CurrentThreshold()
-			cfg.CPUThreshold = threshold
-			log.Printf("  Calibrated threshold: %d%%", threshold)
-			log.Printf("  Recalibration: every %s using %s of data",
-				calibCfg.RecalibrationInterval(), calibCfg.RecalibrationLookback())
+		slog.Info("Mode: auto (cpu_threshold is commented out)", logging.EventKey, logging.Startup, "mode", "auto")
+		if err := a.startAutoMode(statePath); err != nil {
+			fatal("Error loading calibration state", err)
 		}
-
-		go runCalibrationLoop(calib, calibCfg, cpuMonitor, stopCh)
+		go runCalibrationLoop(a, stopCh)
 	} else {
-		log.Printf("Mode: MANUAL — cpu_threshold = %d%% (set in config.ini)", cfg.CPUThreshold)
+		slog.Info("Mode: manual (cpu_threshold set in config.ini)", logging.EventKey, logging.Startup,
+			"mode", "manual", "threshold", cfg.CPUThreshold)
 		// Strip any leftover auto-mode banner
-		calibrator.StripBanner(*configPath)
+		switch cfg.Banner {
+		case config.BannerConfig:
+			calibrator.StripBanner(*configPath)
+		case config.BannerFile:
+			calibrator.RemoveStatusFile(statusPath)
+		}
+	}
+	a.updatePolicy()
+
+	// Serve the local API (sample export, explain)
+	if cfg.APISocket != "" {
+		apiServer := api.NewServer(cfg.APISocket, cpuMonitor, userMonitor, psiMonitor, memoryMonitor, netMonitor, tcpMonitor)
+		apiServer.Handle("/explain", api.ExplainHandler(a.explainNow))
+		if err := apiServer.Start(stopCh); err != nil {
+			slog.Warn("Local API disabled", logging.EventKey, logging.API, "error", err)
+		} else {
+			slog.Info("Local API listening", logging.EventKey, logging.API, "socket", cfg.APISocket)
+		}
 	}
 
 	// Main evaluation loop
-	ticker := time.NewTicker(evaluationInterval)
+	ticker := a.clock.NewTicker(evaluationInterval)
 	defer ticker.Stop()
 
-	log.Println("Entering evaluation loop...")
-
 	for {
 		select {
 		case sig := <-sigCh:
-			log.Printf("Received signal %v, shutting down gracefully...", sig)
+			slog.Info("Received signal, stopping", logging.EventKey, logging.Startup, "signal", sig.String())
+			if a.motd != nil {
+				a.motd.Remove()
+			}
 			close(stopCh)
-			log.Println("IdleShutdown Agent stopped.")
+			slog.Info("IdleShutdown agent stopped", logging.EventKey, logging.Startup)
 			return
 
-		case <-ticker.C:
-			// Reload config each tick
-			latestCfg, reloadErr := config.Load(*configPath)
-			if reloadErr != nil {
-				log.Printf("Warning: config reload failed: %v — using last known config", reloadErr)
-			} else {
-				cfg = latestCfg
-			}
+		case <-ticker.C():
+			a.evaluationTick()
+		}
+	}
+}
 
-			// In auto mode during learning phase: skip eval
-			if cfg.AutoMode && calib != nil && calib.IsInLearningPhase() {
-				remaining := calib.LearningTimeRemaining()
-				log.Printf("Learning phase: %s remaining — skipping shutdown evaluation",
-					formatDuration(remaining))
-				continue
-			}
+// subcommands maps command names to their implementations. Without a
+// subcommand the agent itself runs.
+var subcommands = map[string]func(args []string) error{
+	"calibrate": runCalibrate,
+	"backtest":  runBacktest,
+	"export":    runExport,
+	"audit":     runAudit,
+	"explain":   runExplain,
+}
 
-			// In auto mode: use threshold from calibration state
-			if cfg.AutoMode && calib != nil {
-				cfg.CPUThreshold = calib.CurrentThreshold()
-			}
+// usage prints help for the agent flags and the available subcommands.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags]\n", os.Args[0])
+	fmt.Fprintf(out, "       %s calibrate --input samples.csv [flags]\n", os.Args[0])
+	fmt.Fprintf(out, "       %s backtest --input samples.csv [flags]\n", os.Args[0])
+	fmt.Fprintf(out, "       %s export [--format csv|jsonl] [--since 24h] [flags]\n", os.Args[0])
+	fmt.Fprintf(out, "       %s audit [-n 10] [--outcome shutdown] [flags]\n", os.Args[0])
+	fmt.Fprintf(out, "       %s explain [--json] [flags]\n\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+// setupLogging installs the default slog logger in the configured format
+// and level.
+func setupLogging(cfg *config.Config) error {
+	level, err := logging.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		return err
+	}
+	logger, err := logging.New(os.Stderr, cfg.LogFormat, level)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
 
-			evaluateShutdownCondition(cfg, cpuMonitor, userMonitor, shutdownExec)
+// fatal logs a startup error and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, logging.EventKey, logging.Startup, "error", err)
+	os.Exit(1)
+}
+
+// agent holds the running agent's dependencies and live configuration.
+type agent struct {
+	configPath   string
+	statusPath   string // status file for banner = file
+	cfg          *config.Config
+	calibCfg     *config.CalibrationConfig
+	calib        *calibrator.Calibrator // nil in manual mode
+	cpuMon       *monitor.CPUMonitor
+	userMon      *monitor.UserMonitor
+	psiMon       *monitor.PSIMonitor
+	memMon       *monitor.MemoryMonitor
+	netMon       *monitor.NetMonitor
+	tcpMon       *monitor.TCPMonitor
+	shutdownExec *shutdown.Executor
+	clock        clock.Clock
+	runner       command.Runner
+	motd         *motd.Writer // nil when the login message is disabled
+	notifier     notify.Notifier
+	notifierKey  string     // notifierSettings the notifier was built from
+	configKey    string     // DiagnosticsKey of the config as last logged
+	audit        *audit.Log // nil when the audit log is disabled
+	auditKey     string     // auditState of the last record written
+
+	// policy is the shutdown policy as of the last evaluation, read by the
+	// explain endpoint from the API goroutine.
+	policyMu sync.Mutex
+	policy   explain.Policy
+
+	// Notifications for repeated failures are sent once per failure streak.
+	reloadFailing bool
+	calibFailing  bool
+}
+
+// startAutoMode creates the calibrator and reports the calibration status.
+func (a *agent) startAutoMode(statePath string) error {
+	bannerPath := a.configPath
+	if a.cfg.Banner != config.BannerConfig {
+		bannerPath = ""
+	}
+	calib, err := calibrator.New(bannerPath, statePath, a.calibCfg, a.clock)
+	if err != nil {
+		return err
+	}
+	a.calib = calib
+	if a.cfg.Banner == config.BannerFile {
+		a.calib.StatusPath = a.statusPath
+	}
+	a.calib.Metric = a.cfg.CPUMetric
+	a.calib.Accounting = a.cfg.CPUAccounting()
+
+	if a.calib.IsInLearningPhase() {
+		remaining := a.calib.LearningTimeRemaining()
+		slog.Info("Learning phase: shutdown evaluation paused", logging.EventKey, logging.Calibration,
+			"remaining", clock.FormatDuration(remaining), "initial_lookback", a.calibCfg.InitialLookback().String())
+		a.calib.WriteLearningBanner()
+	} else {
+		threshold := a.calib.CurrentThreshold()
+		a.cfg.CPUThreshold = threshold
+		a.applyCalibratedPSIThreshold()
+		slog.Info("Using calibrated threshold", logging.EventKey, logging.Calibration, "threshold", threshold,
+			"recalibration_interval", a.calibCfg.RecalibrationInterval().String(),
+			"recalibration_lookback", a.calibCfg.RecalibrationLookback().String())
+	}
+	return nil
+}
+
+// evaluationTick reloads the config and evaluates the shutdown condition once.
+func (a *agent) evaluationTick() {
+	// Reload config each tick
+	latestCfg, reloadErr := config.Load(a.configPath)
+	if reloadErr != nil {
+		slog.Warn("Config reload failed, using last known config", logging.EventKey, logging.Config, "error", reloadErr)
+		if !a.reloadFailing {
+			a.notify(notify.ConfigReloadFailed, reloadErr.Error(), map[string]any{"config": a.configPath})
 		}
+		a.reloadFailing = true
+	} else {
+		a.cfg = latestCfg
+		if key := latestCfg.DiagnosticsKey(); key != a.configKey {
+			latestCfg.LogDiagnostics()
+			a.configKey = key
+		}
+		a.shutdownExec.Grace = latestCfg.ShutdownGrace()
+		a.cpuMon.SetTolerance(latestCfg.CPUTolerance())
+		a.cpuMon.SetMetric(latestCfg.CPUMetric)
+		a.cpuMon.SetAccounting(latestCfg.CPUAccounting())
+		a.cpuMon.SetCgroups(latestCfg.CPUCgroupSelection())
+		a.psiMon.SetResources(latestCfg.PSIResources)
+		a.memMon.SetLimits(latestCfg.MemoryLimits())
+		a.netMon.SetLimits(latestCfg.NetLimits())
+		a.netMon.SetInterfaces(latestCfg.NetInterfaces)
+		a.tcpMon.SetFilter(latestCfg.TCPFilter())
+		if key := notifierSettings(latestCfg); key != a.notifierKey {
+			a.notifier = newNotifier(latestCfg, logging.Config)
+			a.shutdownExec.Notifier = a.notifier
+			a.notifierKey = key
+		}
+		if a.calib != nil {
+			a.calib.Metric = latestCfg.CPUMetric
+			a.calib.Accounting = latestCfg.CPUAccounting()
+		}
+		a.reloadFailing = false
+	}
+
+	// In auto mode during learning phase: skip eval
+	if a.cfg.AutoMode && a.calib != nil && a.calib.IsInLearningPhase() {
+		remaining := a.calib.LearningTimeRemaining()
+		slog.Info("Learning phase: skipping shutdown evaluation", logging.EventKey, logging.Evaluation,
+			"learning_remaining", clock.FormatDuration(remaining))
+		a.updateMOTD(true, remaining)
+		a.updatePolicy()
+		return
+	}
+
+	// In auto mode: use threshold from calibration state
+	if a.cfg.AutoMode && a.calib != nil {
+		a.cfg.CPUThreshold = a.calib.CurrentThreshold()
+		a.applyCalibratedPSIThreshold()
+	}
+	a.evaluateShutdownCondition()
+	a.updateMOTD(false, 0)
+	a.updatePolicy()
+}
+
+// applyCalibratedPSIThreshold uses the calibrated PSI threshold unless
+// [psi] threshold is set or PSI has not been calibrated yet.
+func (a *agent) applyCalibratedPSIThreshold() {
+	if t := a.calib.State().PSIThreshold; a.cfg.PSIAutoThreshold && t > 0 {
+		a.cfg.PSIThreshold = t
 	}
 }
 
+// currentPolicy describes the shutdown policy in effect.
+func (a *agent) currentPolicy() explain.Policy {
+	p := explain.Policy{
+		Mode:               "manual",
+		ThresholdSource:    "config.ini",
+		CPUThreshold:       a.cfg.CPUThreshold,
+		CPUMetric:          a.cpuMon.Metric(),
+		PSIThreshold:       a.cfg.PSIThreshold,
+		PSIThresholdSource: "config.ini",
+		PSIResources:       a.psiMon.Resources(),
+		MemoryLimits:       a.memMon.Limits(),
+		NetLimits:          a.netMon.Limits(),
+		TCPPorts:           a.tcpMon.Filter().Ports,
+		Rule:               a.cfg.Rule(),
+		Scoring:            a.cfg.Scoring,
+		CPUCheckMinutes:    a.cfg.CPUCheckMinutes,
+		UserCheckMinutes:   a.cfg.UserCheckMinutes,
+		GraceMinutes:       a.cfg.ShutdownGraceMinutes,
+		PendingSince:       a.shutdownExec.PendingSince(),
+	}
+	if a.cfg.AutoMode && a.calib != nil {
+		state := a.calib.State()
+		p.Mode = "auto"
+		if a.calib.IsInLearningPhase() {
+			p.ThresholdSource = "defaults, until the initial calibration"
+			p.Learning = true
+			p.LearningEnds = a.clock.Now().Add(a.calib.LearningTimeRemaining())
+		} else {
+			p.CPUThreshold = a.calib.CurrentThreshold()
+			p.ThresholdSource = fmt.Sprintf("calibration (%s, %s)",
+				state.Strategy, state.LastCalibTime.UTC().Format(time.RFC3339))
+		}
+	}
+	if a.cfg.PSIAutoThreshold {
+		p.PSIThresholdSource = "defaults, until PSI is calibrated"
+		if a.cfg.AutoMode && a.calib != nil && a.calib.State().PSIThreshold > 0 {
+			p.PSIThreshold = a.calib.State().PSIThreshold
+			p.PSIThresholdSource = "calibration"
+		}
+	}
+	return p
+}
+
+// updatePolicy publishes the current policy for the explain endpoint.
+func (a *agent) updatePolicy() {
+	p := a.currentPolicy()
+	a.policyMu.Lock()
+	a.policy = p
+	a.policyMu.Unlock()
+}
+
+// explainNow evaluates the idle conditions against the latest samples under
+// the policy of the last evaluation. It is safe to call from any goroutine.
+func (a *agent) explainNow() explain.Report {
+	a.policyMu.Lock()
+	p := a.policy
+	a.policyMu.Unlock()
+	return explain.Build(a.clock.Now(), p, explain.Monitors{CPU: a.cpuMon, Users: a.userMon, PSI: a.psiMon, Memory: a.memMon, Net: a.netMon, TCP: a.tcpMon})
+}
+
+// updateMOTD refreshes the login message with the policy in effect.
+func (a *agent) updateMOTD(learning bool, learningRemaining time.Duration) {
+	if a.motd == nil {
+		return
+	}
+	var vetoes []string
+	if !learning {
+		env := a.ruleEnv()
+		for _, veto := range a.cfg.Vetoes() {
+			if !env.Idle(veto.Signal, veto.Window) {
+				vetoes = append(vetoes, veto.Signal)
+			}
+		}
+	}
+	var shutdownAt time.Time
+	if a.shutdownExec.Pending() {
+		shutdownAt = a.shutdownExec.PendingSince().Add(a.shutdownExec.Grace)
+	}
+	a.motd.Update(motd.Status{
+		AutoMode:          a.cfg.AutoMode,
+		Learning:          learning,
+		LearningRemaining: learningRemaining,
+		CPUThreshold:      a.cfg.CPUThreshold,
+		CPUCheckMinutes:   a.cfg.CPUCheckMinutes,
+		UserCheckMinutes:  a.cfg.UserCheckMinutes,
+		CPUMetric:         a.cfg.CPUMetric,
+		Rule:              a.motdRule(),
+		GraceMinutes:      a.cfg.ShutdownGraceMinutes,
+		ShutdownAt:        shutdownAt,
+		Vetoes:            vetoes,
+	})
+}
+
+// motdRule returns the rule for the login message, empty for the default
+// condition it words itself.
+func (a *agent) motdRule() string {
+	if a.cfg.DefaultRule() {
+		return ""
+	}
+	return a.cfg.Rule().String()
+}
+
 // runCalibrationLoop runs initial and periodic recalibration.
-func runCalibrationLoop(
-	calib *calibrator.Calibrator,
-	calibCfg *config.CalibrationConfig,
-	cpuMon *monitor.CPUMonitor,
-	stopCh <-chan struct{},
-) {
-	ticker := time.NewTicker(calibrationCheckInterval)
+func runCalibrationLoop(a *agent, stopCh <-chan struct{}) {
+	ticker := a.clock.NewTicker(calibrationCheckInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-stopCh:
 			return
-		case <-ticker.C:
-			samples := cpuMon.GetSamples()
-
-			if calib.ShouldRunInitial() {
-				log.Printf("[Calibrator] %s elapsed — running initial calibration (%d samples)...",
-					calibCfg.InitialLookback(), len(samples))
-				threshold, err := calib.Run(samples, calibCfg.InitialLookback(), samplingInterval)
-				if err != nil {
-					log.Printf("[Calibrator] Initial calibration failed: %v", err)
-					continue
-				}
-				log.Printf("[Calibrator] ✅ Initial calibration complete: cpu_threshold = %.0f%%", threshold)
-				restartService()
-
-			} else if calib.ShouldRunWeekly() {
-				log.Printf("[Calibrator] Weekly recalibration due — %s lookback (%d samples)...",
-					calibCfg.RecalibrationLookback(), len(samples))
-				threshold, err := calib.Run(samples, calibCfg.RecalibrationLookback(), samplingInterval)
-				if err != nil {
-					log.Printf("[Calibrator] Weekly recalibration failed: %v", err)
-					continue
-				}
-				log.Printf("[Calibrator] ✅ Weekly recalibration complete: cpu_threshold = %.0f%%", threshold)
-				restartService()
-
-			} else if calib.IsInLearningPhase() {
-				// Refresh the learning banner with updated remaining time
-				calib.WriteLearningBanner()
-			}
+		case <-ticker.C():
+			a.calibrationTick()
 		}
 	}
 }
 
+// calibrationTick runs a calibration if one is due, or refreshes the
+// learning banner.
+func (a *agent) calibrationTick() {
+	if a.calib.ShouldRunInitial() {
+		samples := a.cpuMon.GetSamples()
+		slog.Info("Running initial calibration", logging.EventKey, logging.Calibration,
+			"lookback", a.calibCfg.InitialLookback().String(), "samples", len(samples))
+		threshold, err := a.calib.Run(samples, a.calibCfg.InitialLookback(), samplingInterval)
+		if err != nil {
+			slog.Error("Initial calibration failed", logging.EventKey, logging.Calibration, "error", err)
+			a.calibrationFailed("initial", err)
+			return
+		}
+		slog.Info("Initial calibration complete", logging.EventKey, logging.Calibration, "threshold", threshold)
+		a.calibratePSI(a.calibCfg.InitialLookback())
+		a.calibrationApplied("initial")
+		a.notify(notify.LearningCompleted,
+			fmt.Sprintf("Learning phase complete, cpu_threshold = %.0f%%", threshold), nil)
+		a.restartService()
+
+	} else if a.calib.ShouldRunWeekly() {
+		samples := a.cpuMon.GetSamples()
+		slog.Info("Running weekly recalibration", logging.EventKey, logging.Calibration,
+			"lookback", a.calibCfg.RecalibrationLookback().String(), "samples", len(samples))
+		threshold, err := a.calib.Run(samples, a.calibCfg.RecalibrationLookback(), samplingInterval)
+		if err != nil {
+			slog.Error("Weekly recalibration failed", logging.EventKey, logging.Calibration, "error", err)
+			a.calibrationFailed("periodic", err)
+			return
+		}
+		slog.Info("Weekly recalibration complete", logging.EventKey, logging.Calibration, "threshold", threshold)
+		a.calibratePSI(a.calibCfg.RecalibrationLookback())
+		a.calibrationApplied("periodic")
+		a.restartService()
+
+	} else if a.calib.IsInLearningPhase() {
+		// Refresh the learning banner with updated remaining time
+		a.calib.WriteLearningBanner()
+	}
+}
+
+// calibratePSI calibrates the psi signal's threshold along with the CPU
+// threshold. A failure is only logged: the signal keeps its previous
+// threshold, and the CPU calibration stands.
+func (a *agent) calibratePSI(lookback time.Duration) {
+	if !a.psiMon.Available() {
+		return
+	}
+	threshold, err := a.calib.RunPSI(a.psiMon.GetSamples(), a.psiMon.Resources(), lookback, samplingInterval)
+	if err != nil {
+		slog.Warn("PSI calibration failed", logging.EventKey, logging.Calibration, "error", err)
+		return
+	}
+	slog.Info("PSI calibration complete", logging.EventKey, logging.Calibration, "psi_threshold", threshold)
+}
+
+// calibrationApplied reports a successful calibration run.
+func (a *agent) calibrationApplied(kind string) {
+	a.calibFailing = false
+	state := a.calib.State()
+	a.notify(notify.CalibrationApplied,
+		fmt.Sprintf("Calibrated cpu_threshold = %.0f%%", state.CurrentThreshold),
+		map[string]any{
+			"kind":          kind,
+			"cpu_threshold": state.CurrentThreshold,
+			"idle_baseline": state.IdleBaseline,
+			"strategy":      state.Strategy,
+		})
+}
+
+// calibrationFailed reports a failed calibration run. Failed runs are
+// retried every minute, so only the first failure in a row is sent.
+func (a *agent) calibrationFailed(kind string, err error) {
+	if !a.calibFailing {
+		a.notify(notify.CalibrationFailed, err.Error(), map[string]any{"kind": kind})
+	}
+	a.calibFailing = true
+}
+
+// newNotifier builds the lifecycle notifier for the [webhook] and [email]
+// sections of cfg, logging which are enabled under event.
+func newNotifier(cfg *config.Config, event string) notify.Notifier {
+	var notifiers []notify.Notifier
+	if len(cfg.WebhookURLs) > 0 {
+		notifiers = append(notifiers,
+			notify.NewWebhook(cfg.WebhookURLs, cfg.WebhookSecret, cfg.WebhookTimeout(), cfg.WebhookRetries))
+		slog.Info("Webhook notifications enabled", logging.EventKey, event,
+			"urls", len(cfg.WebhookURLs), "signed", cfg.WebhookSecret != "")
+	}
+	if cfg.EmailEnabled() {
+		notifiers = append(notifiers, notify.NewSMTP(cfg.SMTPAddr(), cfg.EmailFrom, cfg.OwnerEmails,
+			cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPStartTLS))
+		slog.Info("Email notifications enabled", logging.EventKey, event, "owners", cfg.OwnerEmails, "relay", cfg.SMTPAddr())
+	}
+	if len(notifiers) == 0 && event != logging.Startup {
+		slog.Info("Notifications disabled", logging.EventKey, event)
+	}
+	return notify.Multi(notifiers...)
+}
+
+// notifierSettings identifies the settings newNotifier uses, so that the
+// notifier is rebuilt when a reload changes them; it is empty when no
+// notifications are configured.
+func notifierSettings(cfg *config.Config) string {
+	if len(cfg.WebhookURLs) == 0 && !cfg.EmailEnabled() {
+		return ""
+	}
+	return fmt.Sprintf("%q %q %d %d %q %q %q %q %q %t", cfg.WebhookURLs, cfg.WebhookSecret,
+		cfg.WebhookTimeoutSeconds, cfg.WebhookRetries, cfg.OwnerEmails, cfg.EmailFrom,
+		cfg.SMTPAddr(), cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPStartTLS)
+}
+
+// notify sends a lifecycle event.
+func (a *agent) notify(eventType, message string, fields map[string]any) {
+	a.notifier.Notify(notify.NewEvent(eventType, a.clock.Now(), message, fields))
+}
+
 // restartService restarts the IdleShutdown systemd service.
-func restartService() {
-	log.Println("[Calibrator] Restarting service to apply new threshold...")
-	cmd := exec.Command("systemctl", "restart", "IdleShutdown")
-	if output, err := cmd.CombinedOutput(); err != nil {
-		log.Printf("[Calibrator] Warning: service restart failed: %v — %s", err, string(output))
-	}
-}
-
-// evaluateShutdownCondition checks if both idle conditions are met.
-func evaluateShutdownCondition(
-	cfg *config.Config,
-	cpuMon *monitor.CPUMonitor,
-	userMon *monitor.UserMonitor,
-	shutdownExec *shutdown.Executor,
-) {
-	currentCPU := cpuMon.GetCurrentUsage()
+func (a *agent) restartService() {
+	slog.Info("Restarting service to apply new threshold", logging.EventKey, logging.Calibration)
+	if _, err := a.runner.Run("systemctl", "restart", "IdleShutdown"); err != nil {
+		slog.Warn("Service restart failed", logging.EventKey, logging.Calibration, "error", err)
+	}
+}
+
+// evaluateShutdownCondition checks if both idle conditions are met and
+// records the decision in the audit log.
+func (a *agent) evaluateShutdownCondition() {
+	cfg, cpuMon, userMon, shutdownExec := a.cfg, a.cpuMon, a.userMon, a.shutdownExec
+	currentCPU := cpuMon.Current()
 	currentUsers := userMon.GetCurrentUserCount()
+	currentMem := a.memMon.Current()
+	currentNet := a.netMon.Current()
+
+	slog.Info("Evaluating idle conditions", logging.EventKey, logging.Evaluation,
+		"cpu", math.Round(currentCPU.Usage*100)/100, "max_core", math.Round(currentCPU.MaxCore*100)/100,
+		"load_per_core", math.Round(currentCPU.LoadPerCore*100)/100, "cgroup", math.Round(currentCPU.Cgroup*100)/100, "steal", math.Round(currentCPU.Steal*100)/100,
+		"iowait", math.Round(currentCPU.IOWait*100)/100, "guest", math.Round(currentCPU.Guest*100)/100, "cpu_metric", cpuMon.Metric(),
+		"threshold", cfg.CPUThreshold, "users", currentUsers,
+		"psi", math.Round(a.psiMon.Current().Value(a.psiMon.Resources())*100)/100, "psi_threshold", cfg.PSIThreshold,
+		"page_faults", math.Round(currentMem.PageFaults*100)/100, "major_faults", math.Round(currentMem.MajorFaults*100)/100,
+		"swap", math.Round(currentMem.Swap()*100)/100, "mem_used", currentMem.MemUsed, "swap_used", currentMem.SwapUsed,
+		"net_bytes", math.Round(currentNet.Bytes()*100)/100, "net_packets", math.Round(currentNet.Packets()*100)/100,
+		"tcp_connections", a.tcpMon.Current().Count)
+
+	rule := cfg.Rule()
+	idle, decided := rule.Eval(a.ruleEnv())
+	rec := a.auditRecord(rule, decided)
+
+	if idle {
+		slog.Info("Shutdown triggered", logging.EventKey, logging.Evaluation,
+			"threshold", cfg.CPUThreshold, "rule", rule.String(), "decided_by", decided.String())
+
+		rec.Reason = "VM idle — CPU below threshold and no users logged in"
+		if !cfg.DefaultRule() {
+			rec.Reason = "VM idle — rule met by " + decided.String()
+		}
+		rec.Outcome = audit.OutcomePending
+		if shutdownExec.Due() {
+			rec.Outcome = audit.OutcomeShutdown
+			rec.DryRun = shutdownExec.DryRun
+		}
+		a.addAuditSamples(&rec)
+		// Written first: once the shutdown runs there may be no time left.
+		a.writeAudit(rec)
+
+		if err := shutdownExec.Shutdown(rec.Reason, idleDetails(cfg, rule, decided, cpuMon, userMon, a.memMon, a.netMon)); err != nil {
+			slog.Error("Shutdown command failed", logging.EventKey, logging.Shutdown, "error", err)
+			rec.Outcome = audit.OutcomeFailed
+			rec.Error = err.Error()
+			a.writeAudit(rec)
+		}
+	} else {
+		slog.Debug("Shutdown rule not met", logging.EventKey, logging.Evaluation,
+			"rule", rule.String(), "decided_by", decided.String())
 
-	log.Printf("Evaluating: CPU=%.2f%% (threshold=%d%%), Users=%d",
-		currentCPU, cfg.CPUThreshold, currentUsers)
+		if !cfg.DefaultRule() {
+			rec.Reason = "VM busy — rule blocked by " + decided.String()
+		} else {
+			var busy []string
+			if !rec.CPUIdle {
+				busy = append(busy, fmt.Sprintf("CPU above %d%%", cfg.CPUThreshold))
+			}
+			if !rec.UsersIdle {
+				busy = append(busy, "users logged in")
+			}
+			rec.Reason = "VM busy — " + strings.Join(busy, ", ")
+		}
+		rec.Outcome = audit.OutcomeBusy
+		if shutdownExec.Pending() {
+			rec.Outcome = audit.OutcomeCancelled
+		}
+		a.writeAudit(rec)
 
-	cpuBelowThreshold := cpuMon.IsBelowThreshold(cfg.CPUThreshold, cfg.CPUCheckMinutes)
-	noUsers := userMon.NoUsersLoggedIn(cfg.UserCheckMinutes)
+		shutdownExec.Cancel(rec.Reason)
+	}
+}
 
-	if cpuBelowThreshold && noUsers {
-		log.Printf("🛑 SHUTDOWN TRIGGERED — CPU < %d%% for %d min, 0 users for %d min",
-			cfg.CPUThreshold, cfg.CPUCheckMinutes, cfg.UserCheckMinutes)
+// ruleEnv answers the shutdown rule's idle checks from the monitors.
+func (a *agent) ruleEnv() rules.Env {
+	return rules.EnvFunc(func(signal string, window time.Duration) bool {
+		minutes := int(window / time.Minute)
+		switch signal {
+		case "cpu":
+			return a.cpuMon.IsBelowThreshold(a.cfg.CPUThreshold, minutes)
+		case "users":
+			return a.userMon.NoUsersLoggedIn(minutes)
+		case "psi":
+			return a.psiMon.IsIdle(a.cfg.PSIThreshold, minutes)
+		case "memory":
+			return a.memMon.IsIdle(minutes)
+		case "net":
+			return a.netMon.IsIdle(minutes)
+		case "tcp":
+			return a.tcpMon.IsIdle(minutes)
+		case "score":
+			return a.idleScore(minutes).Idle
+		}
+		return false
+	})
+}
 
-		reason := "VM idle — CPU below threshold and no users logged in"
-		if err := shutdownExec.Shutdown(reason); err != nil {
-			log.Printf("ERROR: shutdown command failed: %v", err)
+// idleScore computes the composite idle score over minutes and logs it
+// like the other idle checks.
+func (a *agent) idleScore(minutes int) scoring.Result {
+	score := scoring.Evaluate(a.clock.Now(), a.cfg.Scoring, a.cfg.CPUThreshold, minutes, a.cpuMon, a.userMon)
+	args := []any{logging.EventKey, logging.Evaluation, "idle", score.Idle,
+		"score", math.Round(score.Value*1000) / 1000, "target", score.Target, "minutes", minutes}
+	for _, s := range score.Signals {
+		args = append(args, s.Name+"_score", math.Round(s.Value*1000)/1000, s.Name+"_tolerated", s.Tolerated)
+	}
+	slog.Info("Idle score", args...)
+	return score
+}
+
+// auditRecord returns the audit record for an evaluation of rule with its
+// inputs filled in; the caller sets the outcome.
+func (a *agent) auditRecord(rule, decided rules.Expr) audit.Record {
+	policy := a.currentPolicy()
+	windows := rules.Windows(rule)
+	rec := audit.Record{
+		Time:             a.clock.Now(),
+		Mode:             policy.Mode,
+		ThresholdSource:  policy.ThresholdSource,
+		CPUThreshold:     a.cfg.CPUThreshold,
+		CPUMetric:        a.cpuMon.Metric(),
+		Rule:             rule.String(),
+		DecidedBy:        decided.String(),
+		CPUCheckMinutes:  int(windows["cpu"] / time.Minute),
+		UserCheckMinutes: int(windows["users"] / time.Minute),
+		GraceMinutes:     a.cfg.ShutdownGraceMinutes,
+	}
+	if rec.CPUCheckMinutes > 0 {
+		rec.CPUIdle = a.cpuMon.Check(a.cfg.CPUThreshold, rec.CPUCheckMinutes).Idle
+	}
+	if rec.UserCheckMinutes > 0 {
+		rec.UsersIdle = a.userMon.Check(rec.UserCheckMinutes).Idle
+	}
+	if minutes := int(windows["score"] / time.Minute); minutes > 0 {
+		score := scoring.Evaluate(rec.Time, a.cfg.Scoring, a.cfg.CPUThreshold, minutes, a.cpuMon, a.userMon)
+		rec.Score = &score
+	}
+	if minutes := int(windows["psi"] / time.Minute); minutes > 0 {
+		rec.PSI = a.psiAudit(minutes)
+	}
+	if minutes := int(windows["memory"] / time.Minute); minutes > 0 {
+		rec.Memory = a.memoryAudit(minutes)
+	}
+	if minutes := int(windows["net"] / time.Minute); minutes > 0 {
+		rec.Net = a.netAudit(minutes)
+	}
+	if minutes := int(windows["tcp"] / time.Minute); minutes > 0 {
+		rec.TCP = a.tcpAudit(minutes)
+	}
+	return rec
+}
+
+// addAuditSamples adds the CPU and user samples in the check windows to
+// rec. Only records of a pending or executed shutdown carry them, the
+// decisions someone may have to account for.
+func (a *agent) addAuditSamples(rec *audit.Record) {
+	if a.audit == nil {
+		return
+	}
+	if rec.CPUCheckMinutes > 0 {
+		for _, s := range a.cpuMon.WindowSamples(rec.CPUCheckMinutes) {
+			usage := s.Value(rec.CPUMetric)
+			rec.CPUSamples = append(rec.CPUSamples, audit.CPUSample{Time: s.Timestamp, Usage: math.Round(usage*100) / 100})
+		}
+	}
+	if rec.UserCheckMinutes > 0 {
+		for _, s := range a.userMon.WindowSamples(rec.UserCheckMinutes) {
+			rec.UserSamples = append(rec.UserSamples, audit.UserSample{Time: s.Timestamp, Count: s.Count, Names: s.Users})
+		}
+	}
+}
+
+// psiAudit summarizes the pressure stall check over minutes for the audit
+// log.
+func (a *agent) psiAudit(minutes int) *audit.PSICheck {
+	resources := a.psiMon.Resources()
+	p := &audit.PSICheck{
+		Minutes:     minutes,
+		Threshold:   a.cfg.PSIThreshold,
+		Resources:   resources,
+		Idle:        a.psiMon.Check(a.cfg.PSIThreshold, minutes).Idle,
+		Unavailable: !a.psiMon.Available(),
+	}
+	for _, s := range a.psiMon.WindowSamples(minutes) {
+		p.Samples++
+		p.Peak = max(p.Peak, math.Round(s.Value(resources)*100)/100)
+	}
+	return p
+}
+
+// memoryAudit summarizes the paging and swap check over minutes for the
+// audit log.
+func (a *agent) memoryAudit(minutes int) *audit.MemoryCheck {
+	limits := a.memMon.Limits()
+	m := &audit.MemoryCheck{
+		Minutes:          minutes,
+		PageFaultsLimit:  limits.PageFaults,
+		MajorFaultsLimit: limits.MajorFaults,
+		SwapLimit:        limits.Swap,
+		Idle:             a.memMon.Check(minutes).Idle,
+	}
+	for _, s := range a.memMon.WindowSamples(minutes) {
+		m.Samples++
+		m.PeakPageFaults = max(m.PeakPageFaults, math.Round(s.PageFaults*100)/100)
+		m.PeakMajorFaults = max(m.PeakMajorFaults, math.Round(s.MajorFaults*100)/100)
+		m.PeakSwap = max(m.PeakSwap, math.Round(s.Swap()*100)/100)
+	}
+	return m
+}
+
+// netAudit summarizes the network traffic check over minutes for the audit
+// log.
+func (a *agent) netAudit(minutes int) *audit.NetCheck {
+	limits := a.netMon.Limits()
+	n := &audit.NetCheck{
+		Minutes:      minutes,
+		BytesLimit:   limits.Bytes,
+		PacketsLimit: limits.Packets,
+		Idle:         a.netMon.Check(minutes).Idle,
+	}
+	for _, s := range a.netMon.WindowSamples(minutes) {
+		n.Samples++
+		n.PeakBytes = max(n.PeakBytes, math.Round(s.Bytes()*100)/100)
+		n.PeakPackets = max(n.PeakPackets, math.Round(s.Packets()*100)/100)
+	}
+	return n
+}
+
+// tcpAudit summarizes the client connections check over minutes for the
+// audit log.
+func (a *agent) tcpAudit(minutes int) *audit.TCPCheck {
+	c := &audit.TCPCheck{
+		Minutes: minutes,
+		Ports:   a.tcpMon.Filter().Ports,
+		Idle:    a.tcpMon.Check(minutes).Idle,
+	}
+	for _, s := range a.tcpMon.WindowSamples(minutes) {
+		c.Samples++
+		c.MaxConnections = max(c.MaxConnections, s.Count)
+		for _, peer := range s.Peers {
+			if !slices.Contains(c.Peers, peer) {
+				c.Peers = append(c.Peers, peer)
+			}
 		}
 	}
+	return c
 }
 
-// formatDuration returns a human-readable duration like "23h 14m".
-func formatDuration(d time.Duration) string {
-	hours := int(d.Hours())
-	mins := int(d.Minutes()) % 60
-	if hours > 0 {
-		return fmt.Sprintf("%dh %dm", hours, mins)
+// writeAudit appends rec to the audit log, if enabled. Evaluations that
+// repeat the last record's outcome for the same reason are skipped, so a VM
+// that stays busy, or a grace period that runs its course, is recorded once
+// rather than every minute. Shutdowns and failures are always recorded.
+func (a *agent) writeAudit(rec audit.Record) {
+	if a.audit == nil {
+		return
+	}
+	key := auditState(rec)
+	if key == a.auditKey && rec.Outcome != audit.OutcomeShutdown && rec.Outcome != audit.OutcomeFailed {
+		return
+	}
+	a.auditKey = key
+	if err := a.audit.Append(rec); err != nil {
+		slog.Warn("Could not write audit record", logging.EventKey, logging.Evaluation, "error", err)
+	}
+}
+
+// auditState identifies the state a record describes: its outcome, the
+// rule and threshold in effect and the part of the rule that decided.
+func auditState(rec audit.Record) string {
+	return fmt.Sprintf("%s %d %q %q", rec.Outcome, rec.CPUThreshold, rec.Rule, rec.DecidedBy)
+}
+
+// idleDetails returns the idle statistics attached to shutdown notifications,
+// over the longest window rule checks each signal over.
+func idleDetails(cfg *config.Config, rule, decided rules.Expr, cpuMon *monitor.CPUMonitor, userMon *monitor.UserMonitor,
+	memMon *monitor.MemoryMonitor, netMon *monitor.NetMonitor) map[string]any {
+	details := map[string]any{
+		"cpu_threshold": cfg.CPUThreshold,
+		"rule":          rule.String(),
+		"decided_by":    decided.String(),
+	}
+	windows := rules.Windows(rule)
+	if minutes := int(windows["score"] / time.Minute); minutes > 0 {
+		score := scoring.Evaluate(cpuMon.Clock.Now(), cfg.Scoring, cfg.CPUThreshold, minutes, cpuMon, userMon)
+		details["score"] = math.Round(score.Value*1000) / 1000
+		details["score_target"] = score.Target
+	}
+	if minutes := int(windows["cpu"] / time.Minute); minutes > 0 {
+		cpuStats := cpuMon.WindowStats(minutes)
+		details["cpu_metric"] = cpuMon.Metric()
+		details["cpu_minutes"] = cpuStats.Minutes
+		details["cpu_samples"] = cpuStats.Samples
+		details["cpu_average"] = math.Round(cpuStats.Average*100) / 100
+		details["cpu_peak"] = math.Round(cpuStats.Peak*100) / 100
+	}
+	if minutes := int(windows["psi"] / time.Minute); minutes > 0 {
+		details["psi_minutes"] = minutes
+		details["psi_threshold"] = cfg.PSIThreshold
+	}
+	if minutes := int(windows["memory"] / time.Minute); minutes > 0 {
+		var peakSwap, peakMajor float64
+		for _, s := range memMon.WindowSamples(minutes) {
+			peakSwap = max(peakSwap, s.Swap())
+			peakMajor = max(peakMajor, s.MajorFaults)
+		}
+		details["memory_minutes"] = minutes
+		details["memory_peak_swap"] = math.Round(peakSwap*100) / 100
+		details["memory_peak_major_faults"] = math.Round(peakMajor*100) / 100
+	}
+	if minutes := int(windows["net"] / time.Minute); minutes > 0 {
+		var peakBytes float64
+		for _, s := range netMon.WindowSamples(minutes) {
+			peakBytes = max(peakBytes, s.Bytes())
+		}
+		details["net_minutes"] = minutes
+		details["net_peak_bytes"] = math.Round(peakBytes*100) / 100
+	}
+	if minutes := int(windows["tcp"] / time.Minute); minutes > 0 {
+		details["tcp_minutes"] = minutes
+		details["tcp_ports"] = cfg.TCPPorts
+	}
+	if minutes := int(windows["users"] / time.Minute); minutes > 0 {
+		userStats := userMon.WindowStats(minutes)
+		details["user_minutes"] = userStats.Minutes
+		details["user_samples"] = userStats.Samples
+		if !userStats.LastSeen.IsZero() {
+			details["users_last_seen"] = userStats.LastSeen.UTC().Format(time.RFC3339)
+		}
 	}
-	return fmt.Sprintf("%dm", mins)
+	return details
 }
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"idleshutdown/internal/calibrator"
+	"idleshutdown/internal/config"
+	"idleshutdown/internal/monitor"
+	"idleshutdown/internal/rules"
+	"idleshutdown/internal/samplefile"
+	"idleshutdown/internal/scoring"
+)
+
+// calibrationFlags holds command-line overrides for default.ini tuning values,
+// shared by the calibrate and backtest commands.
+type calibrationFlags struct {
+	fs           *flag.FlagSet
+	defaultsPath *string
+	strategy     *string
+	percentile   *float64
+	buffer       *float64
+	window       *float64
+	stddevTight  *float64
+	stddevLoose  *float64
+}
+
+func registerCalibrationFlags(fs *flag.FlagSet) *calibrationFlags {
+	return &calibrationFlags{
+		fs:           fs,
+		defaultsPath: fs.String("defaults", config.DefaultDefaultsPath, "Path to defaults file"),
+		strategy:     fs.String("strategy", "", "Override calibration strategy"),
+		percentile:   fs.Float64("idle-percentile", 0, "Override idle_percentile"),
+		buffer:       fs.Float64("buffer", 0, "Override threshold_buffer"),
+		window:       fs.Float64("window-minutes", 0, "Override window_minutes"),
+		stddevTight:  fs.Float64("stddev-tight", 0, "Override stddev_tight"),
+		stddevLoose:  fs.Float64("stddev-loose", 0, "Override stddev_loose"),
+	}
+}
+
+// load reads default.ini and applies any flags given on the command line.
+func (f *calibrationFlags) load() (*config.CalibrationConfig, error) {
+	calibCfg, err := config.LoadDefaults(*f.defaultsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var applyErr error
+	f.fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "strategy":
+			if !config.ValidStrategy(*f.strategy) {
+				applyErr = fmt.Errorf("unknown strategy %q", *f.strategy)
+			}
+			calibCfg.Strategy = *f.strategy
+		case "idle-percentile":
+			calibCfg.IdlePercentile = *f.percentile
+		case "buffer":
+			calibCfg.ThresholdBuffer = *f.buffer
+		case "window-minutes":
+			calibCfg.WindowMinutes = *f.window
+		case "stddev-tight":
+			calibCfg.StddevTight = *f.stddevTight
+		case "stddev-loose":
+			calibCfg.StddevLoose = *f.stddevLoose
+		}
+	})
+	return calibCfg, applyErr
+}
+
+// runCalibrate implements "idleshutdown calibrate": it runs the calibrator
+// over a recorded sample file and prints the threshold it would apply.
+func runCalibrate(args []string) error {
+	fs := flag.NewFlagSet("calibrate", flag.ExitOnError)
+	input := fs.String("input", "", "CSV sample file to calibrate on (required)")
+	lookback := fs.Duration("lookback", 0, "Data window ending at the last sample (default: whole file)")
+	interval := fs.Duration("interval", samplingInterval, "Sampling interval the file was recorded with")
+	metric := fs.String("metric", monitor.MetricAggregate, "CPU metric to calibrate on: aggregate, max_core, load or cgroup")
+	psiResources := fs.String("psi-resources", "cpu,io,memory", "PSI resources to calibrate the psi threshold on")
+	calibFlags := registerCalibrationFlags(fs)
+	fs.Parse(args)
+
+	if *input == "" {
+		return fmt.Errorf("--input is required")
+	}
+	if !monitor.ValidMetric(*metric) {
+		return fmt.Errorf("unknown metric %q", *metric)
+	}
+	resources := strings.Split(*psiResources, ",")
+	for _, r := range resources {
+		if !monitor.ValidPSIResource(r) {
+			return fmt.Errorf("unknown PSI resource %q", r)
+		}
+	}
+
+	calibCfg, err := calibFlags.load()
+	if err != nil {
+		return err
+	}
+
+	samples, err := samplefile.ReadFile(*input)
+	if err != nil {
+		return err
+	}
+	if len(samples.CPU) == 0 {
+		return fmt.Errorf("no cpu samples in %s", *input)
+	}
+
+	first := samples.CPU[0].Timestamp
+	last := samples.CPU[len(samples.CPU)-1].Timestamp
+	if *lookback == 0 {
+		*lookback = last.Sub(first) + *interval
+	}
+
+	calib := calibrator.NewOffline(calibCfg)
+	calib.Metric = *metric
+	threshold, err := calib.RunAt(last, samples.CPU, *lookback, *interval)
+	if err != nil {
+		return err
+	}
+	state := calib.State()
+
+	fmt.Printf("Samples:   %d (%s → %s)\n", len(samples.CPU),
+		first.Format("2006-01-02 15:04"), last.Format("2006-01-02 15:04"))
+	fmt.Printf("Lookback:  %s\n", *lookback)
+	fmt.Printf("Strategy:  %s\n", state.Strategy)
+	fmt.Printf("Metric:    %s\n", state.Metric)
+	fmt.Printf("Baseline:  %.2f%%\n", state.IdleBaseline)
+	fmt.Printf("Threshold: %.0f%%\n", threshold)
+
+	if len(samples.PSI) > 0 {
+		psiThreshold, err := calib.RunPSIAt(last, samples.PSI, resources, *lookback, *interval)
+		if err != nil {
+			fmt.Printf("PSI:       not calibrated: %v\n", err)
+		} else {
+			fmt.Printf("PSI:       %.1f%% of %s stall (baseline %.2f%%)\n",
+				psiThreshold, strings.Join(resources, ", "), calib.State().PSIBaseline)
+		}
+	}
+	return nil
+}
+
+// runBacktest implements "idleshutdown backtest": it replays a recorded
+// sample file through the idle checks and reports when shutdowns would
+// have fired. In auto mode the learning phase and recalibrations are
+// replayed as well.
+func runBacktest(args []string) error {
+	fs := flag.NewFlagSet("backtest", flag.ExitOnError)
+	input := fs.String("input", "", "CSV sample file to replay (required)")
+	configPath := fs.String("config", config.DefaultConfigPath, "Path to configuration file")
+	threshold := fs.Int("threshold", -1, "Fixed cpu_threshold (default: from config, calibrated in auto mode)")
+	cpuMinutes := fs.Int("cpu-minutes", 0, "Override cpu_check_minutes")
+	userMinutes := fs.Int("user-minutes", 0, "Override user_check_minutes")
+	metric := fs.String("metric", "", "Override cpu_metric: aggregate, max_core, load or cgroup")
+	step := fs.Duration("step", evaluationInterval, "Evaluation interval")
+	verbose := fs.Bool("verbose", false, "Show per-evaluation log output")
+	calibFlags := registerCalibrationFlags(fs)
+	fs.Parse(args)
+
+	if *input == "" {
+		return fmt.Errorf("--input is required")
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return err
+	}
+	cfg.LogDiagnostics()
+	calibCfg, err := calibFlags.load()
+	if err != nil {
+		return err
+	}
+	if *threshold >= 0 {
+		cfg.CPUThreshold = *threshold
+		cfg.AutoMode = false
+	}
+	if *cpuMinutes > 0 {
+		cfg.CPUCheckMinutes = *cpuMinutes
+	}
+	if *userMinutes > 0 {
+		cfg.UserCheckMinutes = *userMinutes
+	}
+	if *metric != "" {
+		if !monitor.ValidMetric(*metric) {
+			return fmt.Errorf("unknown metric %q", *metric)
+		}
+		cfg.CPUMetric = *metric
+	}
+
+	samples, err := samplefile.ReadFile(*input)
+	if err != nil {
+		return err
+	}
+	if len(samples.CPU) == 0 {
+		return fmt.Errorf("no cpu samples in %s", *input)
+	}
+
+	cpuMon := monitor.NewCPUMonitor(samplingInterval)
+	cpuMon.SetTolerance(cfg.CPUTolerance())
+	cpuMon.SetMetric(cfg.CPUMetric)
+	cpuMon.AddSamples(samples.CPU)
+	userMon := monitor.NewUserMonitor(samplingInterval)
+	userMon.AddSamples(samples.Users)
+	noUserData := len(samples.Users) == 0
+	psiMon := monitor.NewPSIMonitor(samplingInterval)
+	psiMon.SetResources(cfg.PSIResources)
+	psiMon.AddSamples(samples.PSI)
+	noPSIData := len(samples.PSI) == 0
+	memMon := monitor.NewMemoryMonitor(samplingInterval)
+	memMon.SetLimits(cfg.MemoryLimits())
+	memMon.AddSamples(samples.Memory)
+	noMemoryData := len(samples.Memory) == 0
+	netMon := monitor.NewNetMonitor(samplingInterval)
+	netMon.SetLimits(cfg.NetLimits())
+	netMon.AddSamples(samples.Net)
+	noNetData := len(samples.Net) == 0
+	tcpMon := monitor.NewTCPMonitor(samplingInterval)
+	tcpMon.SetFilter(cfg.TCPFilter())
+	tcpMon.AddSamples(samples.TCP)
+	noTCPData := len(samples.TCP) == 0
+
+	fmt.Printf("Replaying %d cpu and %d user samples: %s\n", len(samples.CPU), len(samples.Users), cfg)
+	if noUserData {
+		fmt.Println("No user samples in file — treating the user condition as always met")
+		cfg.Scoring.Users.Weight = 0
+	}
+	if _, checksPSI := rules.Windows(cfg.Rule())["psi"]; checksPSI && noPSIData {
+		fmt.Println("No PSI samples in file — treating the psi condition as always met")
+	}
+	if _, checksMemory := rules.Windows(cfg.Rule())["memory"]; checksMemory && noMemoryData {
+		fmt.Println("No memory samples in file — treating the memory condition as always met")
+	}
+	if _, checksNet := rules.Windows(cfg.Rule())["net"]; checksNet && noNetData {
+		fmt.Println("No network samples in file — treating the net condition as always met")
+	}
+	if _, checksTCP := rules.Windows(cfg.Rule())["tcp"]; checksTCP && noTCPData {
+		fmt.Println("No TCP samples in file — treating the tcp condition as always met")
+	}
+
+	if !*verbose {
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(os.Stderr)
+	}
+
+	start := samples.CPU[0].Timestamp
+	end := samples.CPU[len(samples.CPU)-1].Timestamp
+
+	var calib *calibrator.Calibrator
+	var nextCalib time.Time
+	calibFailed := false
+	if cfg.AutoMode {
+		calib = calibrator.NewOffline(calibCfg)
+		calib.Metric = cfg.CPUMetric
+		nextCalib = start.Add(calibCfg.InitialLookback())
+	}
+
+	rule := cfg.Rule()
+	evaluations, shutdowns := 0, 0
+	firing := false
+	for now := start.Add(*step); !now.After(end); now = now.Add(*step) {
+		if calib != nil && !now.Before(nextCalib) {
+			lookback := calibCfg.RecalibrationLookback()
+			if calib.IsInLearningPhase() {
+				lookback = calibCfg.InitialLookback()
+			}
+			// Like the live agent, a failed run is retried every evaluation.
+			thr, err := calib.RunAt(now, samples.CPU, lookback, samplingInterval)
+			if err != nil {
+				if !calibFailed {
+					fmt.Printf("%s  CALIBRATE  failed: %v\n", now.Format("2006-01-02 15:04"), err)
+				}
+				calibFailed = true
+			} else {
+				state := calib.State()
+				fmt.Printf("%s  CALIBRATE  threshold=%.0f%% (baseline %.2f%%, %s)\n",
+					now.Format("2006-01-02 15:04"), thr, state.IdleBaseline, state.Strategy)
+				nextCalib = now.Add(calibCfg.RecalibrationInterval())
+				calibFailed = false
+
+				if !noPSIData {
+					if psiThr, err := calib.RunPSIAt(now, samples.PSI, cfg.PSIResources, lookback, samplingInterval); err != nil {
+						fmt.Printf("%s  CALIBRATE  psi failed: %v\n", now.Format("2006-01-02 15:04"), err)
+					} else {
+						fmt.Printf("%s  CALIBRATE  psi_threshold=%.1f%%\n", now.Format("2006-01-02 15:04"), psiThr)
+					}
+				}
+			}
+		}
+
+		if calib != nil {
+			if calib.IsInLearningPhase() {
+				continue
+			}
+			cfg.CPUThreshold = calib.CurrentThreshold()
+			if t := calib.State().PSIThreshold; cfg.PSIAutoThreshold && t > 0 {
+				cfg.PSIThreshold = t
+			}
+		}
+
+		evaluations++
+		idle, decided := rule.Eval(rules.EnvFunc(func(signal string, window time.Duration) bool {
+			minutes := int(window / time.Minute)
+			switch signal {
+			case "cpu":
+				return cpuMon.IsBelowThresholdAt(now, cfg.CPUThreshold, minutes)
+			case "users":
+				return noUserData || userMon.NoUsersLoggedInAt(now, minutes)
+			case "psi":
+				return noPSIData || psiMon.IsIdleAt(now, cfg.PSIThreshold, minutes)
+			case "memory":
+				return noMemoryData || memMon.IsIdleAt(now, minutes)
+			case "net":
+				return noNetData || netMon.IsIdleAt(now, minutes)
+			case "tcp":
+				return noTCPData || tcpMon.IsIdleAt(now, minutes)
+			case "score":
+				return scoring.Evaluate(now, cfg.Scoring, cfg.CPUThreshold, minutes, cpuMon, userMon).Idle
+			}
+			return false
+		}))
+
+		if idle {
+			if !firing {
+				shutdowns++
+				if !cfg.DefaultRule() {
+					fmt.Printf("%s  SHUTDOWN   %s (CPU < %d%%)\n", now.Format("2006-01-02 15:04"), decided, cfg.CPUThreshold)
+				} else {
+					fmt.Printf("%s  SHUTDOWN   CPU < %d%% for %d min, 0 users for %d min\n",
+						now.Format("2006-01-02 15:04"), cfg.CPUThreshold, cfg.CPUCheckMinutes, cfg.UserCheckMinutes)
+				}
+			}
+			firing = true
+		} else {
+			firing = false
+		}
+	}
+
+	fmt.Printf("Evaluations: %d, shutdowns: %d\n", evaluations, shutdowns)
+	return nil
+}
@@ -0,0 +1,196 @@
+// Package api serves agent data over a local Unix socket so that CLI
+// subcommands can query the running agent.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"idleshutdown/internal/explain"
+	"idleshutdown/internal/logging"
+	"idleshutdown/internal/monitor"
+	"idleshutdown/internal/samplefile"
+)
+
+// socketMode restricts the API to root and the socket's group.
+const socketMode = 0660
+
+// Server is the local HTTP API of the agent.
+type Server struct {
+	socketPath string
+	mux        *http.ServeMux
+	cpuMon     *monitor.CPUMonitor
+	userMon    *monitor.UserMonitor
+	psiMon     *monitor.PSIMonitor
+	memMon     *monitor.MemoryMonitor
+	netMon     *monitor.NetMonitor
+	tcpMon     *monitor.TCPMonitor
+}
+
+// NewServer creates an API server exposing the given monitors' samples.
+func NewServer(socketPath string, cpuMon *monitor.CPUMonitor, userMon *monitor.UserMonitor, psiMon *monitor.PSIMonitor,
+	memMon *monitor.MemoryMonitor, netMon *monitor.NetMonitor, tcpMon *monitor.TCPMonitor) *Server {
+	s := &Server{
+		socketPath: socketPath,
+		mux:        http.NewServeMux(),
+		cpuMon:     cpuMon,
+		userMon:    userMon,
+		psiMon:     psiMon,
+		memMon:     memMon,
+		netMon:     netMon,
+		tcpMon:     tcpMon,
+	}
+	s.mux.HandleFunc("/samples", s.handleSamples)
+	return s
+}
+
+// Handle registers an additional endpoint.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// Start listens on the Unix socket and serves requests in a background
+// goroutine until stopCh is closed.
+func (s *Server) Start(stopCh <-chan struct{}) error {
+	if err := os.MkdirAll(filepath.Dir(s.socketPath), 0755); err != nil {
+		return fmt.Errorf("create socket directory: %w", err)
+	}
+	// Remove a stale socket left behind by a previous run
+	if err := os.Remove(s.socketPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove stale socket: %w", err)
+	}
+
+	listener, err := net.Listen("unix", s.socketPath)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %w", s.socketPath, err)
+	}
+	if err := os.Chmod(s.socketPath, socketMode); err != nil {
+		listener.Close()
+		return fmt.Errorf("chmod socket: %w", err)
+	}
+
+	server := &http.Server{Handler: s.mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("API server stopped", logging.EventKey, logging.API, "error", err)
+		}
+	}()
+	go func() {
+		<-stopCh
+		server.Close()
+	}()
+
+	return nil
+}
+
+// handleSamples serves GET /samples?format=csv|jsonl&since=RFC3339&until=RFC3339.
+func (s *Server) handleSamples(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	format := query.Get("format")
+	if format == "" {
+		format = samplefile.FormatCSV
+	}
+	since, err := parseTimeParam(query, "since")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	until, err := parseTimeParam(query, "until")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	samples := (&samplefile.Samples{
+		CPU:    s.cpuMon.GetSamples(),
+		Users:  s.userMon.GetSamples(),
+		PSI:    s.psiMon.GetSamples(),
+		Memory: s.memMon.GetSamples(),
+		Net:    s.netMon.GetSamples(),
+		TCP:    s.tcpMon.GetSamples(),
+	}).Between(since, until)
+
+	switch format {
+	case samplefile.FormatCSV:
+		w.Header().Set("Content-Type", "text/csv")
+	case samplefile.FormatJSONL:
+		w.Header().Set("Content-Type", "application/jsonl")
+	default:
+		http.Error(w, fmt.Sprintf("unknown format %q", format), http.StatusBadRequest)
+		return
+	}
+	if err := samples.Write(w, format); err != nil {
+		slog.Warn("Writing samples failed", logging.EventKey, logging.API, "error", err)
+	}
+}
+
+// ExplainHandler serves GET /explain?format=text|json with the report
+// returned by build.
+func ExplainHandler(build func() explain.Report) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := build()
+		switch format := r.URL.Query().Get("format"); format {
+		case "", "json":
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(report); err != nil {
+				slog.Warn("Writing explanation failed", logging.EventKey, logging.API, "error", err)
+			}
+		case "text":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			report.Write(w)
+		default:
+			http.Error(w, fmt.Sprintf("unknown format %q", format), http.StatusBadRequest)
+		}
+	})
+}
+
+func parseTimeParam(query url.Values, name string) (time.Time, error) {
+	val := query.Get(name)
+	if val == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, val)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return t, nil
+}
+
+// Get requests path from the agent listening on socketPath and copies the
+// response body to w.
+func Get(socketPath, path string, query url.Values, w io.Writer) error {
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+
+	u := url.URL{Scheme: "http", Host: "idleshutdown", Path: path, RawQuery: query.Encode()}
+	resp, err := client.Get(u.String())
+	if err != nil {
+		return fmt.Errorf("query agent at %s: %w", socketPath, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("agent returned %s: %s", resp.Status, body)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"bytes"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"idleshutdown/internal/monitor"
+	"idleshutdown/internal/samplefile"
+)
+
+var testStart = time.Date(2026, 2, 19, 2, 0, 0, 0, time.UTC)
+
+// startServer serves the samples of monitors holding two CPU and user
+// samples a minute apart, and returns the socket path.
+func startServer(t *testing.T) string {
+	cpuMon := monitor.NewCPUMonitor(time.Minute)
+	cpuMon.AddSamples([]monitor.CPUSample{
+		{Timestamp: testStart, Usage: 3.5},
+		{Timestamp: testStart.Add(time.Minute), Usage: 42},
+	})
+	userMon := monitor.NewUserMonitor(time.Minute)
+	userMon.AddSamples([]monitor.UserSample{
+		{Timestamp: testStart, Count: 0},
+		{Timestamp: testStart.Add(time.Minute), Count: 2},
+	})
+
+	socketPath := filepath.Join(t.TempDir(), "api.sock")
+	s := NewServer(socketPath, cpuMon, userMon, monitor.NewPSIMonitor(time.Minute), monitor.NewMemoryMonitor(time.Minute),
+		monitor.NewNetMonitor(time.Minute), monitor.NewTCPMonitor(time.Minute))
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+	if err := s.Start(stopCh); err != nil {
+		t.Fatal(err)
+	}
+	return socketPath
+}
+
+func TestSamplesCSV(t *testing.T) {
+	socketPath := startServer(t)
+
+	var out bytes.Buffer
+	query := url.Values{"since": {testStart.Add(30 * time.Second).Format(time.RFC3339)}}
+	if err := Get(socketPath, "/samples", query, &out); err != nil {
+		t.Fatal(err)
+	}
+	samples, err := samplefile.ReadCSV(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples.CPU) != 1 || samples.CPU[0].Usage != 42 || len(samples.Users) != 1 || samples.Users[0].Count != 2 {
+		t.Errorf("samples since the second minute = %+v", samples)
+	}
+}
+
+func TestSamplesJSONL(t *testing.T) {
+	socketPath := startServer(t)
+
+	var out bytes.Buffer
+	if err := Get(socketPath, "/samples", url.Values{"format": {samplefile.FormatJSONL}}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), `{"timestamp":"2026-02-19T02:00:00Z","metric":"cpu","value":3.5}`) {
+		t.Errorf("output does not start with the first cpu sample:\n%s", out.String())
+	}
+	samples, err := samplefile.ReadJSONL(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples.CPU) != 2 || len(samples.Users) != 2 {
+		t.Errorf("got %d cpu and %d user samples, want 2 each", len(samples.CPU), len(samples.Users))
+	}
+}
+
+func TestSamplesUnknownFormat(t *testing.T) {
+	socketPath := startServer(t)
+
+	var out bytes.Buffer
+	err := Get(socketPath, "/samples", url.Values{"format": {"xml"}}, &out)
+	if err == nil || !strings.Contains(err.Error(), "400") || !strings.Contains(err.Error(), `unknown format "xml"`) {
+		t.Errorf("err = %v, want a 400 naming the format", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("output = %q, want none", out.String())
+	}
+}
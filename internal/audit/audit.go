@@ -0,0 +1,280 @@
+// Package audit keeps an append-only log of shutdown decisions, one JSON
+// object per line, with the samples each shutdown was based on. It lives in
+// the state directory so the evidence outlives the VM it shut down.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"idleshutdown/internal/scoring"
+)
+
+// Decision outcomes.
+const (
+	// OutcomeBusy means the VM was not idle and nothing was pending.
+	OutcomeBusy = "busy"
+	// OutcomePending means the VM was idle and the grace period is running.
+	OutcomePending = "pending"
+	// OutcomeCancelled means the VM became busy during the grace period.
+	OutcomeCancelled = "cancelled"
+	// OutcomeShutdown means the shutdown was executed (or dry-run).
+	OutcomeShutdown = "shutdown"
+	// OutcomeFailed means the shutdown command failed.
+	OutcomeFailed = "failed"
+)
+
+// Record is one shutdown decision with its full input.
+type Record struct {
+	Time    time.Time `json:"time"`
+	Outcome string    `json:"outcome"`
+	Reason  string    `json:"reason"`
+	Error   string    `json:"error,omitempty"`
+	DryRun  bool      `json:"dry_run,omitempty"`
+
+	// Mode is auto or manual; ThresholdSource says where CPUThreshold
+	// came from (config.ini, or the calibration that set it).
+	Mode            string `json:"mode"`
+	ThresholdSource string `json:"threshold_source"`
+	CPUThreshold    int    `json:"cpu_threshold"`
+	// CPUMetric is the CPU reading compared with CPUThreshold, and the one
+	// CPUSamples hold; empty in records written before it was selectable,
+	// which compared the aggregate usage.
+	CPUMetric string `json:"cpu_metric,omitempty"`
+
+	// Rule is the shutdown rule evaluated and DecidedBy the part of it
+	// that decided the outcome: what held for a shutdown, what blocked
+	// otherwise.
+	Rule      string `json:"rule"`
+	DecidedBy string `json:"decided_by"`
+
+	// CPUCheckMinutes and UserCheckMinutes are the longest windows the
+	// rule checks each signal over, 0 if it does not check it; the
+	// samples and idle flags cover those windows.
+	CPUCheckMinutes  int  `json:"cpu_check_minutes"`
+	UserCheckMinutes int  `json:"user_check_minutes"`
+	GraceMinutes     int  `json:"grace_minutes"`
+	CPUIdle          bool `json:"cpu_idle"`
+	UsersIdle        bool `json:"users_idle"`
+
+	// CPUSamples and UserSamples are the samples in those windows, kept
+	// for pending and executed shutdowns only.
+	CPUSamples  []CPUSample  `json:"cpu_samples,omitempty"`
+	UserSamples []UserSample `json:"user_samples,omitempty"`
+
+	// Score is the idle score over the longest score.idle window, if the
+	// rule checks one.
+	Score *scoring.Result `json:"score,omitempty"`
+
+	// PSI is the pressure stall check over the longest psi.idle window, if
+	// the rule checks one.
+	PSI *PSICheck `json:"psi,omitempty"`
+
+	// Memory is the paging and swap check over the longest memory.idle
+	// window, if the rule checks one.
+	Memory *MemoryCheck `json:"memory,omitempty"`
+
+	// Net is the network traffic check over the longest net.idle window,
+	// if the rule checks one.
+	Net *NetCheck `json:"net,omitempty"`
+
+	// TCP is the client connections check over the longest tcp.idle
+	// window, if the rule checks one.
+	TCP *TCPCheck `json:"tcp,omitempty"`
+}
+
+// PSICheck summarizes the pressure stall check of a decision.
+type PSICheck struct {
+	Minutes   int      `json:"minutes"`
+	Threshold float64  `json:"threshold"`
+	Resources []string `json:"resources"`
+	Idle      bool     `json:"idle"`
+	// Unavailable is set when the kernel has no PSI, which counts as idle.
+	Unavailable bool `json:"unavailable,omitempty"`
+	Samples     int  `json:"samples"`
+	// Peak is the highest stall of the resources in the window.
+	Peak float64 `json:"peak"`
+}
+
+// MemoryCheck summarizes the paging and swap check of a decision. Rates
+// are per second; a zero limit is not checked.
+type MemoryCheck struct {
+	Minutes          int     `json:"minutes"`
+	PageFaultsLimit  float64 `json:"page_faults_limit"`
+	MajorFaultsLimit float64 `json:"major_faults_limit"`
+	SwapLimit        float64 `json:"swap_limit"`
+	Idle             bool    `json:"idle"`
+	Samples          int     `json:"samples"`
+	// The highest rates in the window.
+	PeakPageFaults  float64 `json:"peak_page_faults"`
+	PeakMajorFaults float64 `json:"peak_major_faults"`
+	PeakSwap        float64 `json:"peak_swap"`
+}
+
+// NetCheck summarizes the network traffic check of a decision. Rates are
+// per second; a zero limit is not checked.
+type NetCheck struct {
+	Minutes      int     `json:"minutes"`
+	BytesLimit   float64 `json:"bytes_limit"`
+	PacketsLimit float64 `json:"packets_limit"`
+	Idle         bool    `json:"idle"`
+	Samples      int     `json:"samples"`
+	// The highest rates in the window.
+	PeakBytes   float64 `json:"peak_bytes"`
+	PeakPackets float64 `json:"peak_packets"`
+}
+
+// TCPCheck summarizes the client connections check of a decision.
+type TCPCheck struct {
+	Minutes int      `json:"minutes"`
+	Ports   []uint16 `json:"ports"`
+	Idle    bool     `json:"idle"`
+	Samples int      `json:"samples"`
+	// MaxConnections is the most qualifying connections in one sample and
+	// Peers the clients connected in the window.
+	MaxConnections int      `json:"max_connections"`
+	Peers          []string `json:"peers,omitempty"`
+}
+
+// CPUSample is a CPU reading in the check window.
+type CPUSample struct {
+	Time  time.Time `json:"t"`
+	Usage float64   `json:"cpu"`
+}
+
+// UserSample is a logged-in users reading in the check window.
+type UserSample struct {
+	Time  time.Time `json:"t"`
+	Count int       `json:"users"`
+	Names []string  `json:"names,omitempty"`
+}
+
+// Log appends records to a file, rotating it once it exceeds MaxBytes.
+// Rotated files are named <path>.1 (newest) to <path>.<MaxFiles>.
+type Log struct {
+	Path     string
+	MaxBytes int64
+	MaxFiles int
+
+	mu sync.Mutex
+}
+
+// NewLog returns a log writing to path.
+func NewLog(path string, maxBytes int64, maxFiles int) *Log {
+	return &Log{Path: path, MaxBytes: maxBytes, MaxFiles: maxFiles}
+}
+
+// Append writes rec as one line and syncs it to disk, since a shutdown may
+// follow right after.
+func (l *Log) Append(rec Record) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("encode audit record: %w", err)
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if info, err := os.Stat(l.Path); err == nil && l.MaxBytes > 0 && info.Size()+int64(len(line)) > l.MaxBytes {
+		if err := l.rotate(); err != nil {
+			return fmt.Errorf("rotate audit log: %w", err)
+		}
+	}
+
+	file, err := os.OpenFile(l.Path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	// Terminate a line torn by a crash so it doesn't swallow this record
+	if info, err := file.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			line = append([]byte{'\n'}, line...)
+		}
+	}
+	if _, err := file.Write(line); err != nil {
+		file.Close()
+		return fmt.Errorf("write audit log: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("sync audit log: %w", err)
+	}
+	return file.Close()
+}
+
+// rotate shifts <path>.N to <path>.N+1, dropping the oldest, and moves the
+// current file to <path>.1.
+func (l *Log) rotate() error {
+	if l.MaxFiles < 1 {
+		return os.Remove(l.Path)
+	}
+	os.Remove(rotatedName(l.Path, l.MaxFiles))
+	for i := l.MaxFiles - 1; i >= 1; i-- {
+		if err := os.Rename(rotatedName(l.Path, i), rotatedName(l.Path, i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return os.Rename(l.Path, rotatedName(l.Path, 1))
+}
+
+func rotatedName(path string, n int) string {
+	return path + "." + strconv.Itoa(n)
+}
+
+// Read returns all records in the log at path and its rotated files, oldest
+// first. Lines that fail to parse, such as one torn by a power loss, are
+// skipped.
+func Read(path string) ([]Record, error) {
+	files := []string{path}
+	for i := 1; ; i++ {
+		name := rotatedName(path, i)
+		if _, err := os.Stat(name); err != nil {
+			break
+		}
+		files = append([]string{name}, files...)
+	}
+
+	var records []Record
+	for _, name := range files {
+		recs, err := readFile(name)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, recs...)
+	}
+	return records, nil
+}
+
+func readFile(path string) ([]Record, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open audit log: %w", err)
+	}
+	defer file.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	return records, nil
+}
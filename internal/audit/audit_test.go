@@ -0,0 +1,100 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testStart = time.Date(2026, 2, 19, 2, 13, 0, 0, time.UTC)
+
+func record(i int, outcome string) Record {
+	return Record{
+		Time:         testStart.Add(time.Duration(i) * time.Minute),
+		Outcome:      outcome,
+		Mode:         "manual",
+		CPUThreshold: 25,
+		CPUSamples:   []CPUSample{{Time: testStart, Usage: 3.2}},
+		UserSamples:  []UserSample{{Time: testStart, Count: 1, Names: []string{"alice"}}},
+	}
+}
+
+func TestAppendAndRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	log := NewLog(path, 0, 0)
+	for i, outcome := range []string{OutcomeBusy, OutcomePending, OutcomeShutdown} {
+		if err := log.Append(record(i, outcome)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[2].Outcome != OutcomeShutdown || !records[2].Time.Equal(testStart.Add(2*time.Minute)) {
+		t.Fatalf("records = %+v", records)
+	}
+	if got := records[0].UserSamples[0].Names; len(got) != 1 || got[0] != "alice" {
+		t.Errorf("user names = %v", got)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("audit log mode = %v, %v", info.Mode(), err)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	log := NewLog(path, 1, 2) // every record rotates the previous one out
+	for i := 0; i < 5; i++ {
+		if err := log.Append(record(i, OutcomeBusy)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected at most 2 rotated files, stat .3: %v", err)
+	}
+	records, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("got %d records, want 3 (current + 2 rotated)", len(records))
+	}
+	for i, rec := range records {
+		if want := testStart.Add(time.Duration(i+2) * time.Minute); !rec.Time.Equal(want) {
+			t.Errorf("record %d at %v, want %v", i, rec.Time, want)
+		}
+	}
+}
+
+func TestReadSkipsTornLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	if err := NewLog(path, 0, 0).Append(record(0, OutcomeShutdown)); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"time":"2026-02-19T02:14:00Z","outc`)
+	file.Close()
+
+	if err := NewLog(path, 0, 0).Append(record(2, OutcomeBusy)); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Outcome != OutcomeShutdown || records[1].Outcome != OutcomeBusy {
+		t.Errorf("records = %+v", records)
+	}
+
+	if records, err := Read(filepath.Join(t.TempDir(), "missing.jsonl")); err != nil || len(records) != 0 {
+		t.Errorf("missing log = %v, %v", records, err)
+	}
+}
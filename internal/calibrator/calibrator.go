@@ -3,30 +3,20 @@
 package calibrator
 
 import (
-	"bufio"
 	"fmt"
-	"log"
+	"log/slog"
 	"math"
 	"os"
-	"strconv"
 	"strings"
 	"time"
 
+	"idleshutdown/internal/clock"
 	"idleshutdown/internal/config"
+	"idleshutdown/internal/logging"
 	"idleshutdown/internal/monitor"
 )
 
 const (
-	// ThresholdBuffer is added to idle baseline when setting cpu_threshold.
-	ThresholdBuffer = 3.0
-	// MinThreshold ensures cpu_threshold never goes dangerously low.
-	MinThreshold = 5.0
-	// stddevTight is the first-pass standard deviation target.
-	stddevTight = 1.0
-	// stddevLoose is the fallback if no tight windows are found.
-	stddevLoose = 2.0
-	// windowDuration is the sliding window size for idle detection.
-	windowDuration = 30 * time.Minute
 	// minWindowSamples is the minimum samples needed in a sliding window.
 	minWindowSamples = 5
 	// minCalibSamples is the minimum total samples for a calibration run.
@@ -39,11 +29,43 @@ const (
 
 // State tracks calibration history persisted to disk.
 type State struct {
-	InitialDone      bool
-	LastCalibTime    time.Time
-	StartTime        time.Time
-	CurrentThreshold float64
-	IdleBaseline     float64
+	InitialDone      bool      `json:"initial_done"`
+	LastCalibTime    time.Time `json:"last_calib_time"`
+	StartTime        time.Time `json:"start_time"`
+	CurrentThreshold float64   `json:"current_threshold"`
+	IdleBaseline     float64   `json:"idle_baseline"`
+	// Strategy is the calibration strategy that produced CurrentThreshold.
+	Strategy string `json:"strategy"`
+	// Metric is the CPU metric CurrentThreshold applies to; empty in
+	// states written before metrics were selectable, which used
+	// monitor.MetricAggregate.
+	Metric string `json:"metric,omitempty"`
+	// Accounting describes how steal, I/O wait and guest time counted in
+	// the samples (see monitor.CPUAccounting); empty in states written
+	// before it was configurable, which used the default.
+	Accounting string `json:"accounting,omitempty"`
+
+	// PSIThreshold is the calibrated stall threshold of the psi signal and
+	// PSIBaseline the idle baseline it was derived from; 0 until PSI has
+	// been calibrated.
+	PSIThreshold float64 `json:"psi_threshold,omitempty"`
+	PSIBaseline  float64 `json:"psi_baseline,omitempty"`
+}
+
+// metric returns the CPU metric of the state.
+func (s State) metric() string {
+	if s.Metric == "" {
+		return monitor.MetricAggregate
+	}
+	return s.Metric
+}
+
+// accounting returns the CPU time accounting of the state.
+func (s State) accounting() string {
+	if s.Accounting == "" {
+		return monitor.DefaultCPUAccounting().String()
+	}
+	return s.Accounting
 }
 
 // Calibrator manages automatic CPU threshold detection.
@@ -51,24 +73,47 @@ type Calibrator struct {
 	configPath string
 	statePath  string
 	calibCfg   *config.CalibrationConfig
+	clock      clock.Clock
 	state      State
+
+	// StatusPath, when set, receives the banner as a standalone status file
+	// and config.ini is never modified.
+	StatusPath string
+
+	// Metric is the CPU metric calibrated on (see monitor.MetricAggregate);
+	// empty means the aggregate.
+	Metric string
+	// Accounting is the monitor.CPUAccounting the samples are taken with;
+	// empty means the default.
+	Accounting monitor.CPUAccounting
 }
 
-// New creates a new Calibrator with configurable timings.
-func New(configPath, statePath string, calibCfg *config.CalibrationConfig) *Calibrator {
+// New creates a new Calibrator with configurable timings. Status banners
+// are written into the config.ini at configPath; an empty configPath
+// disables them. It fails if the state file exists but cannot be read.
+func New(configPath, statePath string, calibCfg *config.CalibrationConfig, clk clock.Clock) (*Calibrator, error) {
 	c := &Calibrator{
 		configPath: configPath,
 		statePath:  statePath,
 		calibCfg:   calibCfg,
+		clock:      clk,
+	}
+	if err := c.loadState(); err != nil {
+		return nil, err
 	}
-	c.loadState()
 	if c.state.StartTime.IsZero() {
-		c.state.StartTime = time.Now()
+		c.state.StartTime = clk.Now()
 		if err := c.saveState(); err != nil {
-			log.Printf("[Calibrator] Warning: could not persist initial state: %v", err)
+			slog.Warn("Could not persist initial calibration state", logging.EventKey, logging.Calibration, "error", err)
 		}
 	}
-	return c
+	return c, nil
+}
+
+// NewOffline creates a Calibrator that keeps its state in memory only and
+// never touches config.ini. Used by the calibrate and backtest commands.
+func NewOffline(calibCfg *config.CalibrationConfig) *Calibrator {
+	return &Calibrator{calibCfg: calibCfg, clock: clock.Real}
 }
 
 // IsInLearningPhase returns true if we're still collecting initial data.
@@ -78,7 +123,7 @@ func (c *Calibrator) IsInLearningPhase() bool {
 
 // LearningTimeRemaining returns how much time is left in the learning phase.
 func (c *Calibrator) LearningTimeRemaining() time.Duration {
-	elapsed := time.Since(c.state.StartTime)
+	elapsed := c.clock.Since(c.state.StartTime)
 	remaining := c.calibCfg.InitialLookback() - elapsed
 	if remaining < 0 {
 		return 0
@@ -86,6 +131,11 @@ func (c *Calibrator) LearningTimeRemaining() time.Duration {
 	return remaining
 }
 
+// State returns a copy of the current calibration state.
+func (c *Calibrator) State() State {
+	return c.state
+}
+
 // CurrentThreshold returns the last calibrated threshold, or 0 if not yet calibrated.
 func (c *Calibrator) CurrentThreshold() int {
 	return int(math.Round(c.state.CurrentThreshold))
@@ -93,56 +143,77 @@ func (c *Calibrator) CurrentThreshold() int {
 
 // ShouldRunInitial returns true if the initial tracking time has elapsed.
 func (c *Calibrator) ShouldRunInitial() bool {
-	return !c.state.InitialDone && time.Since(c.state.StartTime) >= c.calibCfg.InitialLookback()
+	return !c.state.InitialDone && c.clock.Since(c.state.StartTime) >= c.calibCfg.InitialLookback()
 }
 
-// ShouldRunWeekly returns true if the recalibration interval has elapsed.
+// ShouldRunWeekly returns true if the recalibration interval has elapsed,
+// or if the threshold was calibrated on a different CPU metric or
+// accounting.
 func (c *Calibrator) ShouldRunWeekly() bool {
-	return c.state.InitialDone && time.Since(c.state.LastCalibTime) >= c.calibCfg.RecalibrationInterval()
+	if !c.state.InitialDone {
+		return false
+	}
+	return c.clock.Since(c.state.LastCalibTime) >= c.calibCfg.RecalibrationInterval() ||
+		c.state.metric() != c.metric() || c.state.accounting() != c.Accounting.String()
+}
+
+// metric returns the CPU metric to calibrate on.
+func (c *Calibrator) metric() string {
+	if c.Metric == "" {
+		return monitor.MetricAggregate
+	}
+	return c.Metric
 }
 
 // Run performs calibration and returns the new threshold.
 func (c *Calibrator) Run(samples []monitor.CPUSample, lookback time.Duration, samplingInterval time.Duration) (float64, error) {
-	cutoff := time.Now().Add(-lookback)
+	return c.RunAt(c.clock.Now(), samples, lookback, samplingInterval)
+}
+
+// RunAt performs calibration as if the current time were now, considering
+// only samples within lookback before now. Used for offline analysis.
+func (c *Calibrator) RunAt(now time.Time, samples []monitor.CPUSample, lookback time.Duration, samplingInterval time.Duration) (float64, error) {
+	// The strategies look at Usage, so hand them the calibrated metric
+	metric := c.metric()
+	cutoff := now.Add(-lookback)
 	var window []monitor.CPUSample
 	for _, s := range samples {
-		if s.Timestamp.After(cutoff) {
-			window = append(window, s)
+		if s.Timestamp.After(cutoff) && !s.Timestamp.After(now) {
+			window = append(window, monitor.CPUSample{Timestamp: s.Timestamp, Usage: s.Value(metric)})
 		}
 	}
 
-	// Dynamic sample requirement: expect samples every samplingInterval.
-	// We require at least 50% of expected samples to ensure data quality,
-	// but never fewer than 5 samples (2.5 minutes of data).
-	expectedSamples := int(lookback / samplingInterval)
-	minSamples := int(float64(expectedSamples) * 0.5)
-	if minSamples < 5 {
-		minSamples = 5
-	}
-
-	if len(window) < minSamples {
-		return 0, fmt.Errorf("insufficient samples (%d, need %d for %s lookback)", len(window), minSamples, lookback)
+	coverage, err := c.checkCoverage(len(window), lookback, samplingInterval)
+	if err != nil {
+		slog.Warn("Rejected new threshold", logging.EventKey, logging.Calibration, "error", err)
+		return 0, err
 	}
 
-	log.Printf("[Calibrator] Calibrating on %d samples from last %s", len(window), lookback)
+	slog.Info("Calibrating", logging.EventKey, logging.Calibration,
+		"samples", len(window), "lookback", lookback.String(), "coverage", math.Round(coverage), "metric", metric,
+		"accounting", c.Accounting.String())
 
-	idleBaseline, err := findIdleBaseline(window)
+	strategy := c.calibCfg.Strategy
+	idleBaseline, err := findIdleBaseline(window, c.calibCfg)
 	if err != nil {
-		return 0, fmt.Errorf("calibration failed: %w", err)
+		return 0, fmt.Errorf("calibration failed (%s): %w", strategy, err)
 	}
 
-	newThreshold := math.Max(idleBaseline+ThresholdBuffer, MinThreshold)
-	rounded := math.Round(newThreshold)
+	rounded := c.applyGuardrails(math.Round(idleBaseline + c.calibCfg.ThresholdBuffer))
 
-	log.Printf("[Calibrator] Idle baseline=%.2f%%, New threshold=%.0f%%", idleBaseline, rounded)
+	slog.Info("Calibration result", logging.EventKey, logging.Calibration,
+		"strategy", strategy, "idle_baseline", math.Round(idleBaseline*100)/100, "threshold", rounded)
 
 	// Update state
 	c.state.InitialDone = true
-	c.state.LastCalibTime = time.Now()
+	c.state.LastCalibTime = now
 	c.state.CurrentThreshold = rounded
 	c.state.IdleBaseline = idleBaseline
+	c.state.Strategy = strategy
+	c.state.Metric = metric
+	c.state.Accounting = c.Accounting.String()
 	if err := c.saveState(); err != nil {
-		log.Printf("[Calibrator] Warning: could not persist state: %v", err)
+		slog.Warn("Could not persist calibration state", logging.EventKey, logging.Calibration, "error", err)
 	}
 
 	// Write banner to config.ini
@@ -151,7 +222,90 @@ func (c *Calibrator) Run(samples []monitor.CPUSample, lookback time.Duration, sa
 	return rounded, nil
 }
 
-// WriteLearningBanner writes a learning-phase banner into config.ini.
+// checkCoverage applies the confidence rule to the number of samples in a
+// lookback: expect a sample every samplingInterval and refuse to calibrate
+// when too few of them are present, but never accept fewer than 5 samples
+// (2.5 minutes of data). It returns the coverage in percent.
+func (c *Calibrator) checkCoverage(samples int, lookback, samplingInterval time.Duration) (float64, error) {
+	expectedSamples := int(lookback / samplingInterval)
+	coverage := 100.0
+	if expectedSamples > 0 {
+		coverage = float64(samples) / float64(expectedSamples) * 100
+	}
+	if coverage < c.calibCfg.MinSampleCoverage || samples < 5 {
+		return coverage, fmt.Errorf("insufficient sample coverage: %d of %d expected samples (%.0f%%, need %.0f%%) in %s lookback",
+			samples, expectedSamples, coverage, c.calibCfg.MinSampleCoverage, lookback)
+	}
+	return coverage, nil
+}
+
+// RunPSI calibrates the stall threshold of the psi signal on the highest
+// stall of resources in each sample and returns it.
+func (c *Calibrator) RunPSI(samples []monitor.PSISample, resources []string, lookback, samplingInterval time.Duration) (float64, error) {
+	return c.RunPSIAt(c.clock.Now(), samples, resources, lookback, samplingInterval)
+}
+
+// RunPSIAt is RunPSI as if the current time were now. It finds the idle
+// baseline with the configured strategy, like RunAt, and adds the same
+// buffer; the CPU threshold guardrails do not apply.
+func (c *Calibrator) RunPSIAt(now time.Time, samples []monitor.PSISample, resources []string, lookback, samplingInterval time.Duration) (float64, error) {
+	cutoff := now.Add(-lookback)
+	var window []monitor.CPUSample
+	for _, s := range samples {
+		if s.Timestamp.After(cutoff) && !s.Timestamp.After(now) {
+			window = append(window, monitor.CPUSample{Timestamp: s.Timestamp, Usage: s.Value(resources)})
+		}
+	}
+
+	if _, err := c.checkCoverage(len(window), lookback, samplingInterval); err != nil {
+		return 0, fmt.Errorf("PSI: %w", err)
+	}
+
+	idleBaseline, err := findIdleBaseline(window, c.calibCfg)
+	if err != nil {
+		return 0, fmt.Errorf("PSI calibration failed (%s): %w", c.calibCfg.Strategy, err)
+	}
+	threshold := math.Min(math.Round((idleBaseline+c.calibCfg.ThresholdBuffer)*10)/10, 100)
+
+	slog.Info("PSI calibration result", logging.EventKey, logging.Calibration,
+		"strategy", c.calibCfg.Strategy, "resources", strings.Join(resources, ","),
+		"idle_baseline", math.Round(idleBaseline*100)/100, "threshold", threshold)
+
+	c.state.PSIThreshold = threshold
+	c.state.PSIBaseline = idleBaseline
+	if err := c.saveState(); err != nil {
+		slog.Warn("Could not persist calibration state", logging.EventKey, logging.Calibration, "error", err)
+	}
+	c.WriteCalibratedBanner()
+
+	return threshold, nil
+}
+
+// applyGuardrails limits how far a proposed threshold may move from the
+// currently active threshold, then clamps it to the configured min/max. The
+// range is applied last so it holds even when an admin narrowed it around
+// a previous threshold that now lies outside.
+func (c *Calibrator) applyGuardrails(proposed float64) float64 {
+	threshold := proposed
+	if maxChange := c.calibCfg.MaxChangePerCalibration; c.state.InitialDone && maxChange > 0 {
+		previous := c.state.CurrentThreshold
+		threshold = math.Min(math.Max(proposed, previous-maxChange), previous+maxChange)
+		if threshold != proposed {
+			slog.Info("Threshold change limited", logging.EventKey, logging.Calibration, "previous", previous, "proposed", proposed,
+				"max_change", maxChange, "threshold", threshold)
+		}
+	}
+
+	clamped := math.Min(math.Max(threshold, c.calibCfg.MinThreshold), c.calibCfg.MaxThreshold)
+	if clamped != threshold {
+		slog.Info("Proposed threshold clamped", logging.EventKey, logging.Calibration, "proposed", threshold, "threshold", clamped,
+			"min_threshold", c.calibCfg.MinThreshold, "max_threshold", c.calibCfg.MaxThreshold)
+	}
+	return clamped
+}
+
+// WriteLearningBanner writes a learning-phase banner into config.ini or the
+// status file.
 func (c *Calibrator) WriteLearningBanner() {
 	remaining := c.LearningTimeRemaining()
 	
@@ -170,10 +324,11 @@ func (c *Calibrator) WriteLearningBanner() {
 		"# │  To set manually, uncomment cpu_threshold below          │",
 		bannerEnd,
 	}
-	c.writeBannerToConfig(banner)
+	c.writeBanner(banner)
 }
 
-// WriteCalibratedBanner writes the auto-managed banner with calibration metadata.
+// WriteCalibratedBanner writes the auto-managed banner with calibration metadata
+// into config.ini or the status file.
 func (c *Calibrator) WriteCalibratedBanner() {
 	nextCalib := c.state.LastCalibTime.Add(c.calibCfg.RecalibrationInterval())
 
@@ -182,11 +337,13 @@ func (c *Calibrator) WriteCalibratedBanner() {
 		"# │  ⚡ AUTO-MANAGED — to set manually, uncomment below      │",
 		fmt.Sprintf("# │  Last calibrated : %-38s│", c.state.LastCalibTime.Format("2006-01-02 15:04 UTC")),
 		fmt.Sprintf("# │  Idle baseline   : %-38s│", fmt.Sprintf("%.1f%%", c.state.IdleBaseline)),
+		fmt.Sprintf("# │  Strategy        : %-38s│", c.state.Strategy),
+		fmt.Sprintf("# │  CPU metric      : %-38s│", c.state.metric()),
 		fmt.Sprintf("# │  Current value   : %-38s│", fmt.Sprintf("%.0f%% (active)", c.state.CurrentThreshold)),
 		fmt.Sprintf("# │  Next calibration: %-38s│", "~"+nextCalib.Format("2006-01-02")),
 		bannerEnd,
 	}
-	c.writeBannerToConfig(banner)
+	c.writeBanner(banner)
 }
 
 // StripBanner removes any existing banner from config.ini (used when switching to manual).
@@ -225,18 +382,22 @@ func StripBanner(configPath string) {
 	// Write back using LF only
 	cleaned := strings.Join(result, "\n")
 	if err := os.WriteFile(configPath, []byte(cleaned), 0644); err != nil {
-		log.Printf("[Calibrator] Warning: could not strip banner: %v", err)
+		slog.Warn("Could not strip banner", logging.EventKey, logging.Calibration, "config", configPath, "error", err)
 	} else {
-		log.Println("[Calibrator] Stripped auto-mode banner (manual mode active)")
+		slog.Info("Stripped auto-mode banner (manual mode active)", logging.EventKey, logging.Calibration, "config", configPath)
 	}
 }
 
 // writeBannerToConfig replaces any existing banner in config.ini or inserts one
 // before the cpu_threshold line.
 func (c *Calibrator) writeBannerToConfig(banner []string) {
+	if c.configPath == "" {
+		return
+	}
+
 	content, err := os.ReadFile(c.configPath)
 	if err != nil {
-		log.Printf("[Calibrator] Warning: could not read config for banner: %v", err)
+		slog.Warn("Could not read config for banner", logging.EventKey, logging.Calibration, "error", err)
 		return
 	}
 
@@ -287,7 +448,7 @@ func (c *Calibrator) writeBannerToConfig(banner []string) {
 	// Write back using LF only
 	output := strings.Join(result, "\n")
 	if err := os.WriteFile(c.configPath, []byte(output), 0644); err != nil {
-		log.Printf("[Calibrator] Warning: could not write banner: %v", err)
+		slog.Warn("Could not write banner", logging.EventKey, logging.Calibration, "error", err)
 	}
 }
 
@@ -303,41 +464,6 @@ func padTo(width int, content string) string {
 
 // --- Statistical helpers ---
 
-func findIdleBaseline(samples []monitor.CPUSample) (float64, error) {
-	for _, maxStddev := range []float64{stddevTight, stddevLoose} {
-		if baseline, found := slidingWindowMin(samples, maxStddev); found {
-			log.Printf("[Calibrator] Found idle baseline=%.2f%% with stddev < %.1f%%", baseline, maxStddev)
-			return baseline, nil
-		}
-		log.Printf("[Calibrator] No stable windows with stddev < %.1f%%, loosening...", maxStddev)
-	}
-	return 0, fmt.Errorf("no stable idle windows found (stddev always > %.1f%%)", stddevLoose)
-}
-
-func slidingWindowMin(samples []monitor.CPUSample, maxStddev float64) (float64, bool) {
-	minAvg := math.MaxFloat64
-	found := false
-
-	for i := range samples {
-		end := samples[i].Timestamp.Add(windowDuration)
-		var winValues []float64
-		for j := i; j < len(samples) && samples[j].Timestamp.Before(end); j++ {
-			winValues = append(winValues, samples[j].Usage)
-		}
-		if len(winValues) < minWindowSamples {
-			continue
-		}
-
-		avg := mean(winValues)
-		if stddev(winValues, avg) < maxStddev && avg < minAvg {
-			minAvg = avg
-			found = true
-		}
-	}
-
-	return minAvg, found
-}
-
 func mean(vals []float64) float64 {
 	sum := 0.0
 	for _, v := range vals {
@@ -354,65 +480,3 @@ func stddev(vals []float64, avg float64) float64 {
 	}
 	return math.Sqrt(sum / float64(len(vals)))
 }
-
-// --- State persistence ---
-
-func (c *Calibrator) loadState() {
-	file, err := os.Open(c.statePath)
-	if err != nil {
-		return
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		parts := strings.SplitN(scanner.Text(), "=", 2)
-		if len(parts) != 2 {
-			continue
-		}
-		key, val := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
-
-		switch key {
-		case "initial_done":
-			c.state.InitialDone = val == "true"
-		case "last_calib_time":
-			if t, err := time.Parse(time.RFC3339, val); err == nil {
-				c.state.LastCalibTime = t
-			}
-		case "start_time":
-			if t, err := time.Parse(time.RFC3339, val); err == nil {
-				c.state.StartTime = t
-			}
-		case "current_threshold":
-			if v, err := strconv.ParseFloat(val, 64); err == nil {
-				c.state.CurrentThreshold = v
-			}
-		case "idle_baseline":
-			if v, err := strconv.ParseFloat(val, 64); err == nil {
-				c.state.IdleBaseline = v
-			}
-		}
-	}
-}
-
-func (c *Calibrator) saveState() error {
-	file, err := os.Create(c.statePath)
-	if err != nil {
-		return fmt.Errorf("create state file: %w", err)
-	}
-	defer file.Close()
-
-	lines := []string{
-		fmt.Sprintf("initial_done=%s", strconv.FormatBool(c.state.InitialDone)),
-		fmt.Sprintf("last_calib_time=%s", c.state.LastCalibTime.Format(time.RFC3339)),
-		fmt.Sprintf("start_time=%s", c.state.StartTime.Format(time.RFC3339)),
-		fmt.Sprintf("current_threshold=%.0f", c.state.CurrentThreshold),
-		fmt.Sprintf("idle_baseline=%.2f", c.state.IdleBaseline),
-	}
-	for _, l := range lines {
-		if _, err := fmt.Fprintln(file, l); err != nil {
-			return fmt.Errorf("write state: %w", err)
-		}
-	}
-	return nil
-}
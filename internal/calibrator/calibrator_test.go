@@ -0,0 +1,289 @@
+package calibrator
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"idleshutdown/internal/clock"
+	"idleshutdown/internal/config"
+	"idleshutdown/internal/monitor"
+)
+
+const testInterval = 30 * time.Second
+
+var testStart = time.Date(2026, 2, 16, 0, 0, 0, 0, time.UTC)
+
+func testCalibConfig() *config.CalibrationConfig {
+	return &config.CalibrationConfig{
+		InitialTrackingHours:       24,
+		RecalibrationIntervalDays:  7,
+		RecalibrationTrackingHours: 72,
+		Strategy:                   config.StrategyMinWindow,
+		IdlePercentile:             config.DefaultIdlePercentile,
+		MinThreshold:               config.DefaultMinThreshold,
+		MaxThreshold:               config.DefaultMaxThreshold,
+		MaxChangePerCalibration:    config.DefaultMaxChangePerCalibration,
+		MinSampleCoverage:          config.DefaultMinSampleCoverage,
+		ThresholdBuffer:            config.DefaultThresholdBuffer,
+		WindowMinutes:              config.DefaultWindowMinutes,
+		StddevTight:                config.DefaultStddevTight,
+		StddevLoose:                config.DefaultStddevLoose,
+	}
+}
+
+// genSamples returns samples every testInterval over [from, from+d) with
+// usage given by level(t) plus a little noise.
+func genSamples(from time.Time, d time.Duration, level func(time.Time) float64) []monitor.CPUSample {
+	rng := rand.New(rand.NewSource(1))
+	var samples []monitor.CPUSample
+	for ts := from; ts.Before(from.Add(d)); ts = ts.Add(testInterval) {
+		samples = append(samples, monitor.CPUSample{Timestamp: ts, Usage: level(ts) + rng.Float64()*0.5})
+	}
+	return samples
+}
+
+func constant(v float64) func(time.Time) float64 {
+	return func(time.Time) float64 { return v }
+}
+
+func newTestCalibrator(t *testing.T, fake *clock.Fake) (*Calibrator, string) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.ini")
+	if err := os.WriteFile(configPath, []byte("[monitoring]\ncpu_check_minutes = 60\n# cpu_threshold = 25\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return mustNew(t, configPath, filepath.Join(dir, "calibration.state"), fake), dir
+}
+
+func mustNew(t *testing.T, configPath, statePath string, fake *clock.Fake) *Calibrator {
+	t.Helper()
+	c, err := New(configPath, statePath, testCalibConfig(), fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestLearningPhase(t *testing.T) {
+	fake := clock.NewFake(testStart)
+	c, _ := newTestCalibrator(t, fake)
+
+	if !c.IsInLearningPhase() || c.ShouldRunInitial() {
+		t.Fatal("new calibrator should be learning and not yet due")
+	}
+	fake.Advance(23 * time.Hour)
+	if got := c.LearningTimeRemaining(); got != time.Hour {
+		t.Errorf("remaining = %v, want 1h", got)
+	}
+	fake.Advance(time.Hour)
+	if !c.ShouldRunInitial() {
+		t.Error("initial calibration should be due after 24h")
+	}
+}
+
+func TestRunInitialAndWeekly(t *testing.T) {
+	fake := clock.NewFake(testStart)
+	c, dir := newTestCalibrator(t, fake)
+
+	samples := genSamples(testStart, 24*time.Hour, constant(2))
+	fake.Advance(24 * time.Hour)
+	threshold, err := c.Run(samples, 24*time.Hour, testInterval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if threshold != 5 {
+		t.Errorf("threshold = %.0f, want 5 (baseline ~2%% + 3)", threshold)
+	}
+	if c.IsInLearningPhase() || c.ShouldRunWeekly() {
+		t.Error("calibrated state should leave learning and not be due for recalibration")
+	}
+
+	banner, _ := os.ReadFile(filepath.Join(dir, "config.ini"))
+	if !strings.Contains(string(banner), "AUTO-MANAGED") || !strings.Contains(string(banner), "min_window") {
+		t.Errorf("config.ini missing calibrated banner:\n%s", banner)
+	}
+
+	fake.Advance(7 * 24 * time.Hour)
+	if !c.ShouldRunWeekly() {
+		t.Fatal("weekly recalibration should be due after 7 days")
+	}
+	samples = genSamples(fake.Now().Add(-72*time.Hour), 72*time.Hour, constant(9))
+	threshold, err = c.Run(samples, 72*time.Hour, testInterval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if threshold != 12 {
+		t.Errorf("threshold = %.0f, want 12", threshold)
+	}
+}
+
+func TestRunOnSelectedMetric(t *testing.T) {
+	fake := clock.NewFake(testStart.Add(24 * time.Hour))
+	c, _ := newTestCalibrator(t, fake)
+
+	// One core at ~10% on a VM idling at ~2% overall
+	samples := genSamples(testStart, 24*time.Hour, constant(2))
+	for i := range samples {
+		samples[i].MaxCore = samples[i].Usage + 8
+	}
+	if _, err := c.Run(samples, 24*time.Hour, testInterval); err != nil {
+		t.Fatal(err)
+	}
+	if c.ShouldRunWeekly() {
+		t.Fatal("recalibration should not be due right after calibrating")
+	}
+
+	c.Metric = monitor.MetricMaxCore
+	if !c.ShouldRunWeekly() {
+		t.Fatal("changing the metric should make a recalibration due")
+	}
+	threshold, err := c.Run(samples, 24*time.Hour, testInterval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if threshold != 13 || c.State().Metric != monitor.MetricMaxCore {
+		t.Errorf("threshold = %.0f on %q, want 13 (busiest core ~10%% + 3) on max_core", threshold, c.State().Metric)
+	}
+
+	c.Accounting = monitor.CPUAccounting{Steal: monitor.AccountExcluded}
+	if !c.ShouldRunWeekly() {
+		t.Error("changing the CPU time accounting should make a recalibration due")
+	}
+}
+
+func TestRunMaxChangePerCalibration(t *testing.T) {
+	fake := clock.NewFake(testStart.Add(24 * time.Hour))
+	c, _ := newTestCalibrator(t, fake)
+
+	if _, err := c.Run(genSamples(testStart, 24*time.Hour, constant(20)), 24*time.Hour, testInterval); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.CurrentThreshold(); got != 23 {
+		t.Fatalf("initial threshold = %d, want 23", got)
+	}
+
+	fake.Advance(24 * time.Hour)
+	quiet := genSamples(fake.Now().Add(-24*time.Hour), 24*time.Hour, constant(0))
+	threshold, err := c.Run(quiet, 24*time.Hour, testInterval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if threshold != 13 {
+		t.Errorf("threshold = %.0f, want 13 (23 limited by 10 points)", threshold)
+	}
+}
+
+func TestRunClampsLimitedChangeToNarrowedRange(t *testing.T) {
+	fake := clock.NewFake(testStart.Add(24 * time.Hour))
+	c, _ := newTestCalibrator(t, fake)
+
+	if _, err := c.Run(genSamples(testStart, 24*time.Hour, constant(20)), 24*time.Hour, testInterval); err != nil {
+		t.Fatal(err)
+	}
+
+	// The admin narrows the range below the active threshold of 23: the
+	// step limit alone would only bring it down to 13.
+	c.calibCfg.MaxThreshold = 10
+	fake.Advance(24 * time.Hour)
+	threshold, err := c.Run(genSamples(fake.Now().Add(-24*time.Hour), 24*time.Hour, constant(5)), 24*time.Hour, testInterval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if threshold != 10 {
+		t.Errorf("threshold = %.0f, want 10 (max_threshold applied after the step limit)", threshold)
+	}
+}
+
+func TestRunClampsToMaxThreshold(t *testing.T) {
+	fake := clock.NewFake(testStart.Add(24 * time.Hour))
+	c, _ := newTestCalibrator(t, fake)
+
+	threshold, err := c.Run(genSamples(testStart, 24*time.Hour, constant(70)), 24*time.Hour, testInterval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if threshold != config.DefaultMaxThreshold {
+		t.Errorf("threshold = %.0f, want %.0f", threshold, config.DefaultMaxThreshold)
+	}
+}
+
+func TestRunRejectsPoorCoverage(t *testing.T) {
+	fake := clock.NewFake(testStart.Add(24 * time.Hour))
+	c, _ := newTestCalibrator(t, fake)
+
+	// Only the last 6 hours of a 24h lookback were recorded
+	samples := genSamples(testStart.Add(18*time.Hour), 6*time.Hour, constant(2))
+	if _, err := c.Run(samples, 24*time.Hour, testInterval); err == nil {
+		t.Fatal("expected rejection for 25% sample coverage")
+	}
+	if !c.IsInLearningPhase() {
+		t.Error("rejected calibration must not change state")
+	}
+}
+
+func TestRunPSI(t *testing.T) {
+	fake := clock.NewFake(testStart.Add(24 * time.Hour))
+	c, dir := newTestCalibrator(t, fake)
+
+	// I/O stalls around 1%, memory never; CPU stalls are not selected
+	var samples []monitor.PSISample
+	for _, s := range genSamples(testStart, 24*time.Hour, constant(1)) {
+		samples = append(samples, monitor.PSISample{Timestamp: s.Timestamp,
+			CPU: monitor.PSIPressure{Stall: 40}, IO: monitor.PSIPressure{Stall: s.Usage}})
+	}
+	threshold, err := c.RunPSI(samples, []string{monitor.PSIIO, monitor.PSIMemory}, 24*time.Hour, testInterval)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if threshold < 4 || threshold > 4.5 {
+		t.Errorf("PSI threshold = %.1f, want about 1%% + 3 points", threshold)
+	}
+	if !c.IsInLearningPhase() {
+		t.Error("PSI calibration must leave the CPU calibration alone")
+	}
+
+	restarted := mustNew(t, filepath.Join(dir, "config.ini"), filepath.Join(dir, "calibration.state"), fake)
+	if got := restarted.State().PSIThreshold; got != threshold {
+		t.Errorf("PSI threshold after restart = %.1f, want %.1f", got, threshold)
+	}
+
+	if _, err := c.RunPSI(samples[:100], nil, 24*time.Hour, testInterval); err == nil {
+		t.Error("expected rejection for poor PSI sample coverage")
+	}
+}
+
+func TestStatusFileCreatesDirectory(t *testing.T) {
+	fake := clock.NewFake(testStart)
+	c, dir := newTestCalibrator(t, fake)
+	c.StatusPath = filepath.Join(dir, "status", "idleshutdown.status")
+
+	c.WriteLearningBanner()
+	data, err := os.ReadFile(c.StatusPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "state = learning") {
+		t.Errorf("status file:\n%s", data)
+	}
+}
+
+func TestStatePersistsAcrossRestart(t *testing.T) {
+	fake := clock.NewFake(testStart.Add(24 * time.Hour))
+	c, dir := newTestCalibrator(t, fake)
+	if _, err := c.Run(genSamples(testStart, 24*time.Hour, constant(4)), 24*time.Hour, testInterval); err != nil {
+		t.Fatal(err)
+	}
+
+	fake.Advance(time.Hour)
+	restarted := mustNew(t, filepath.Join(dir, "config.ini"), filepath.Join(dir, "calibration.state"), fake)
+	got, want := restarted.State(), c.State()
+	if got.InitialDone != want.InitialDone || got.CurrentThreshold != want.CurrentThreshold ||
+		!got.StartTime.Equal(want.StartTime) || !got.LastCalibTime.Equal(want.LastCalibTime) ||
+		got.Strategy != want.Strategy {
+		t.Errorf("state after restart = %+v, want %+v", got, want)
+	}
+}
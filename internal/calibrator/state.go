@@ -0,0 +1,247 @@
+package calibrator
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"idleshutdown/internal/config"
+	"idleshutdown/internal/fileutil"
+	"idleshutdown/internal/logging"
+)
+
+// stateVersion is the current calibration.state schema version. Version 1
+// is the original key=value format, which is migrated on load.
+const stateVersion = 2
+
+// stateFile is the on-disk JSON envelope of the calibration state.
+type stateFile struct {
+	Version int   `json:"version"`
+	State   State `json:"state"`
+	// Checksum is the SHA-256 of the JSON-encoded State, to detect
+	// corruption and hand edits that break the format.
+	Checksum string `json:"checksum"`
+}
+
+// MigrateStateFile moves the state file from legacyPath (its location
+// before the state directory existed) to statePath. It does nothing when
+// there is no legacy file or statePath already exists.
+func MigrateStateFile(legacyPath, statePath string) error {
+	if legacyPath == statePath {
+		return nil
+	}
+	if _, err := os.Stat(statePath); err == nil {
+		return nil
+	}
+	data, err := os.ReadFile(legacyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read legacy state: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(statePath), 0755); err != nil {
+		return fmt.Errorf("create state directory: %w", err)
+	}
+	if err := fileutil.WriteAtomic(statePath, data, 0644); err != nil {
+		return fmt.Errorf("write state file: %w", err)
+	}
+	slog.Info("Moved calibration state", logging.EventKey, logging.Calibration, "from", legacyPath, "to", statePath)
+
+	// The copy is authoritative from now on; a leftover legacy file (e.g. on
+	// a read-only /etc) is ignored because statePath exists.
+	if err := os.Remove(legacyPath); err != nil {
+		slog.Warn("Could not remove legacy state", logging.EventKey, logging.Calibration, "error", err)
+	}
+	return nil
+}
+
+// loadState reads the state file. A missing file leaves the zero state
+// (fresh start); a corrupt one is quarantined and logged, then also
+// starts fresh. A file that cannot be read is an error, so that it is not
+// overwritten by a fresh state.
+func (c *Calibrator) loadState() error {
+	data, err := os.ReadFile(c.statePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read calibration state: %w", err)
+	}
+
+	state, version, err := decodeState(data)
+	if err != nil {
+		slog.Error("Calibration state is corrupt", logging.EventKey, logging.Calibration, "path", c.statePath, "error", err)
+		c.quarantineState()
+		return nil
+	}
+	c.state = state
+
+	if version < stateVersion {
+		if err := c.saveState(); err != nil {
+			slog.Warn("Could not migrate calibration state", logging.EventKey, logging.Calibration, "version", stateVersion, "error", err)
+			return nil
+		}
+		slog.Info("Migrated calibration state", logging.EventKey, logging.Calibration, "from_version", version, "to_version", stateVersion)
+	}
+	return nil
+}
+
+// quarantineState moves a corrupt state file aside so it can be inspected
+// and is not overwritten.
+func (c *Calibrator) quarantineState() {
+	dest := fmt.Sprintf("%s.corrupt-%s", c.statePath, c.clock.Now().UTC().Format("20060102T150405Z"))
+	if err := os.Rename(c.statePath, dest); err != nil {
+		slog.Warn("Could not quarantine corrupt state", logging.EventKey, logging.Calibration, "error", err)
+		return
+	}
+	slog.Warn("Corrupt state quarantined, learning phase restarts", logging.EventKey, logging.Calibration, "moved_to", dest)
+}
+
+func (c *Calibrator) saveState() error {
+	if c.statePath == "" {
+		return nil
+	}
+
+	data, err := encodeState(c.state)
+	if err != nil {
+		return err
+	}
+	if err := fileutil.WriteAtomic(c.statePath, data, 0644); err != nil {
+		return fmt.Errorf("write state file: %w", err)
+	}
+	return nil
+}
+
+func encodeState(state State) ([]byte, error) {
+	sum, err := stateChecksum(state)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(stateFile{Version: stateVersion, State: state, Checksum: sum}, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("encode state: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// decodeState parses either format and returns the state together with
+// the schema version it was stored in.
+func decodeState(data []byte) (State, int, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return State{}, 0, errors.New("file is empty")
+	}
+	if trimmed[0] != '{' {
+		state, err := decodeLegacyState(trimmed)
+		return state, 1, err
+	}
+
+	var file stateFile
+	if err := json.Unmarshal(trimmed, &file); err != nil {
+		return State{}, 0, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if file.Version < 2 || file.Version > stateVersion {
+		return State{}, 0, fmt.Errorf("unsupported state version %d", file.Version)
+	}
+	sum, err := stateChecksum(file.State)
+	if err != nil {
+		return State{}, 0, err
+	}
+	if sum != file.Checksum {
+		return State{}, 0, fmt.Errorf("checksum mismatch (stored %s, computed %s)", file.Checksum, sum)
+	}
+	if err := validateState(file.State); err != nil {
+		return State{}, 0, err
+	}
+	return file.State, file.Version, nil
+}
+
+// decodeLegacyState parses the version 1 key=value format. Unlike the
+// original reader, missing or unparsable keys are errors rather than
+// silently becoming zero values.
+func decodeLegacyState(data []byte) (State, error) {
+	var state State
+	seen := make(map[string]bool)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key, val := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+
+		var err error
+		switch key {
+		case "initial_done":
+			state.InitialDone, err = strconv.ParseBool(val)
+		case "last_calib_time":
+			state.LastCalibTime, err = time.Parse(time.RFC3339, val)
+		case "start_time":
+			state.StartTime, err = time.Parse(time.RFC3339, val)
+		case "current_threshold":
+			state.CurrentThreshold, err = strconv.ParseFloat(val, 64)
+		case "idle_baseline":
+			state.IdleBaseline, err = strconv.ParseFloat(val, 64)
+		case "strategy":
+			state.Strategy = val
+		default:
+			continue
+		}
+		if err != nil {
+			return State{}, fmt.Errorf("invalid %s: %w", key, err)
+		}
+		seen[key] = true
+	}
+
+	for _, key := range []string{"initial_done", "start_time"} {
+		if !seen[key] {
+			return State{}, fmt.Errorf("missing key %q", key)
+		}
+	}
+	if state.InitialDone && !seen["current_threshold"] {
+		return State{}, errors.New(`missing key "current_threshold"`)
+	}
+	if state.InitialDone && state.Strategy == "" {
+		// State written before strategies existed was always min_window.
+		state.Strategy = config.StrategyMinWindow
+	}
+	return state, validateState(state)
+}
+
+func validateState(state State) error {
+	if state.StartTime.IsZero() {
+		return errors.New("start_time is not set")
+	}
+	if state.InitialDone {
+		if state.LastCalibTime.IsZero() {
+			return errors.New("calibrated state has no last_calib_time")
+		}
+		// 0 is valid: min_threshold and threshold_buffer may both be 0.
+		if state.CurrentThreshold < 0 || state.CurrentThreshold > 100 {
+			return fmt.Errorf("current_threshold %.2f out of range", state.CurrentThreshold)
+		}
+	}
+	return nil
+}
+
+func stateChecksum(state State) (string, error) {
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return "", fmt.Errorf("encode state: %w", err)
+	}
+	sum := sha256.Sum256(payload)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,165 @@
+package calibrator
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"idleshutdown/internal/clock"
+	"idleshutdown/internal/config"
+)
+
+func newStateCalibrator(t *testing.T, fake *clock.Fake, contents string) (*Calibrator, string) {
+	dir := t.TempDir()
+	statePath := filepath.Join(dir, "calibration.state")
+	if err := os.WriteFile(statePath, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return mustNew(t, filepath.Join(dir, "config.ini"), statePath, fake), statePath
+}
+
+func TestStateFileIsVersionedJSON(t *testing.T) {
+	fake := clock.NewFake(testStart)
+	c, dir := newTestCalibrator(t, fake)
+	if _, err := c.Run(genSamples(testStart.Add(-24*time.Hour), 24*time.Hour, constant(4)), 24*time.Hour, testInterval); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "calibration.state"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, version, err := decodeState(data)
+	if err != nil {
+		t.Fatalf("decodeState: %v\n%s", err, data)
+	}
+	if version != stateVersion || int(state.CurrentThreshold) != c.CurrentThreshold() {
+		t.Errorf("decoded version %d threshold %.0f, want %d and %d", version, state.CurrentThreshold, stateVersion, c.CurrentThreshold())
+	}
+}
+
+func TestLegacyStateIsMigrated(t *testing.T) {
+	fake := clock.NewFake(testStart.Add(48 * time.Hour))
+	c, statePath := newStateCalibrator(t, fake, "initial_done=true\n"+
+		"last_calib_time=2026-02-17T00:00:00Z\n"+
+		"start_time=2026-02-16T00:00:00Z\n"+
+		"current_threshold=12.00\n"+
+		"idle_baseline=8.50\n")
+
+	state := c.State()
+	if !state.InitialDone || state.CurrentThreshold != 12 || !state.StartTime.Equal(testStart) {
+		t.Errorf("legacy state = %+v", state)
+	}
+	if state.Strategy != config.StrategyMinWindow {
+		t.Errorf("legacy strategy = %q, want %q", state.Strategy, config.StrategyMinWindow)
+	}
+
+	data, err := os.ReadFile(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, version, err := decodeState(data); err != nil || version != stateVersion {
+		t.Errorf("state not rewritten as version %d: version %d, err %v", stateVersion, version, err)
+	}
+}
+
+func TestCorruptStateIsQuarantined(t *testing.T) {
+	valid, err := encodeState(State{
+		InitialDone:      true,
+		LastCalibTime:    testStart.Add(24 * time.Hour),
+		StartTime:        testStart,
+		CurrentThreshold: 12,
+		IdleBaseline:     8.5,
+		Strategy:         config.StrategyMinWindow,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]string{
+		"truncated json":      string(valid[:len(valid)/2]),
+		"checksum":            strings.Replace(string(valid), `"current_threshold": 12`, `"current_threshold": 40`, 1),
+		"future version":      strings.Replace(string(valid), `"version": 2`, `"version": 9`, 1),
+		"truncated legacy":    "initial_done=true\nlast_calib_time=2026-02-17T00:00:00Z\n",
+		"legacy no threshold": "initial_done=true\nlast_calib_time=2026-02-17T00:00:00Z\nstart_time=2026-02-16T00:00:00Z\n",
+		"empty":               "",
+	}
+	for name, contents := range cases {
+		t.Run(name, func(t *testing.T) {
+			now := testStart.Add(48 * time.Hour)
+			c, statePath := newStateCalibrator(t, clock.NewFake(now), contents)
+
+			if !c.IsInLearningPhase() || !c.State().StartTime.Equal(now) {
+				t.Errorf("corrupt state should restart learning, got %+v", c.State())
+			}
+			quarantined := statePath + ".corrupt-" + now.Format("20060102T150405Z")
+			if got, err := os.ReadFile(quarantined); err != nil || string(got) != contents {
+				t.Errorf("quarantined file = %q, %v; want original contents", got, err)
+			}
+		})
+	}
+}
+
+func TestZeroThresholdStateIsValid(t *testing.T) {
+	// min_threshold and threshold_buffer may both be 0.
+	data, err := encodeState(State{
+		InitialDone:   true,
+		LastCalibTime: testStart.Add(24 * time.Hour),
+		StartTime:     testStart,
+		Strategy:      config.StrategyMinWindow,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _ := newStateCalibrator(t, clock.NewFake(testStart.Add(48*time.Hour)), string(data))
+	if c.IsInLearningPhase() || c.CurrentThreshold() != 0 {
+		t.Errorf("zero threshold state = %+v, want it kept", c.State())
+	}
+}
+
+func TestUnreadableStateIsNotOverwritten(t *testing.T) {
+	// A directory in place of the file cannot be read, even by root.
+	statePath := filepath.Join(t.TempDir(), "calibration.state")
+	if err := os.Mkdir(statePath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New("", statePath, testCalibConfig(), clock.NewFake(testStart)); err == nil {
+		t.Fatal("expected an error for an unreadable state file")
+	}
+	if info, err := os.Stat(statePath); err != nil || !info.IsDir() {
+		t.Errorf("state path was replaced: %v, %v", info, err)
+	}
+}
+
+func TestMigrateStateFile(t *testing.T) {
+	dir := t.TempDir()
+	legacy := filepath.Join(dir, "etc", "calibration.state")
+	statePath := filepath.Join(dir, "var", "calibration.state")
+	if err := os.MkdirAll(filepath.Dir(legacy), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(legacy, []byte("initial_done=false\nstart_time=2026-02-16T00:00:00Z\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := MigrateStateFile(legacy, statePath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Errorf("legacy file still present: %v", err)
+	}
+	c := mustNew(t, "", statePath, clock.NewFake(testStart.Add(time.Hour)))
+	if !c.State().StartTime.Equal(testStart) {
+		t.Errorf("migrated start time = %v, want %v", c.State().StartTime, testStart)
+	}
+
+	// A second run finds nothing to move and leaves the new file alone
+	if err := MigrateStateFile(legacy, statePath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(statePath); err != nil {
+		t.Error(err)
+	}
+}
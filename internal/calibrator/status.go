@@ -0,0 +1,90 @@
+package calibrator
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"idleshutdown/internal/fileutil"
+	"idleshutdown/internal/logging"
+)
+
+// writeBanner sends the banner to the status file when one is configured,
+// otherwise into config.ini.
+func (c *Calibrator) writeBanner(banner []string) {
+	if c.StatusPath != "" {
+		c.writeStatusFile(banner)
+		return
+	}
+	c.writeBannerToConfig(banner)
+}
+
+// writeStatusFile replaces the status file with the banner followed by a
+// [status] section for scripts and monitoring.
+func (c *Calibrator) writeStatusFile(banner []string) {
+	lines := []string{
+		"# IdleShutdown status — generated by the agent, edits are overwritten.",
+		"# cpu_threshold referred to below is in config.ini.",
+	}
+	lines = append(lines, banner...)
+	lines = append(lines, "", "[status]")
+	for _, field := range c.statusFields() {
+		lines = append(lines, fmt.Sprintf("%s = %s", field[0], field[1]))
+	}
+
+	data := []byte(strings.Join(lines, "\n") + "\n")
+	if err := os.MkdirAll(filepath.Dir(c.StatusPath), 0755); err != nil {
+		slog.Warn("Could not create status file directory", logging.EventKey, logging.Calibration, "error", err)
+		return
+	}
+	if err := fileutil.WriteAtomic(c.StatusPath, data, 0644); err != nil {
+		slog.Warn("Could not write status file", logging.EventKey, logging.Calibration, "error", err)
+	}
+}
+
+// statusFields returns the key/value pairs of the [status] section in order.
+func (c *Calibrator) statusFields() [][2]string {
+	if c.IsInLearningPhase() {
+		return [][2]string{
+			{"state", "learning"},
+			{"learning_started", c.state.StartTime.UTC().Format(time.RFC3339)},
+			{"learning_remaining", c.LearningTimeRemaining().Round(time.Second).String()},
+		}
+	}
+
+	nextCalib := c.state.LastCalibTime.Add(c.calibCfg.RecalibrationInterval())
+	fields := [][2]string{
+		{"state", "calibrated"},
+		{"cpu_threshold", fmt.Sprintf("%.0f", c.state.CurrentThreshold)},
+		{"idle_baseline", fmt.Sprintf("%.2f", c.state.IdleBaseline)},
+		{"strategy", c.state.Strategy},
+		{"cpu_metric", c.state.metric()},
+		{"cpu_accounting", c.state.accounting()},
+	}
+	if c.state.PSIThreshold > 0 {
+		fields = append(fields,
+			[2]string{"psi_threshold", fmt.Sprintf("%.1f", c.state.PSIThreshold)},
+			[2]string{"psi_baseline", fmt.Sprintf("%.2f", c.state.PSIBaseline)})
+	}
+	return append(fields,
+		[2]string{"last_calibrated", c.state.LastCalibTime.UTC().Format(time.RFC3339)},
+		[2]string{"next_calibration", nextCalib.UTC().Format(time.RFC3339)})
+}
+
+// RemoveStatusFile deletes a leftover auto-mode status file (used when
+// switching to manual), the status-file counterpart of StripBanner.
+func RemoveStatusFile(statusPath string) {
+	err := os.Remove(statusPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		slog.Warn("Could not remove status file", logging.EventKey, logging.Calibration, "error", err)
+		return
+	}
+	slog.Info("Removed auto-mode status file (manual mode active)", logging.EventKey, logging.Calibration, "path", statusPath)
+}
@@ -0,0 +1,159 @@
+package calibrator
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"time"
+
+	"idleshutdown/internal/config"
+	"idleshutdown/internal/logging"
+	"idleshutdown/internal/monitor"
+)
+
+// madScale converts a median absolute deviation into a stddev-comparable value
+// for normally distributed data.
+const madScale = 1.4826
+
+// windowEstimator returns the level (typical value) and spread of a window.
+type windowEstimator func(vals []float64) (level, spread float64)
+
+// findIdleBaseline derives the idle baseline from samples using the
+// strategy, window size and spread tiers configured in cfg.
+func findIdleBaseline(samples []monitor.CPUSample, cfg *config.CalibrationConfig) (float64, error) {
+	atPercentile := func(levels []float64) float64 {
+		return percentileOf(levels, cfg.IdlePercentile)
+	}
+
+	switch cfg.Strategy {
+	case config.StrategyPercentile:
+		return tieredBaseline(samples, cfg, meanStddev, atPercentile)
+	case config.StrategyRobust:
+		return tieredBaseline(samples, cfg, medianMAD, atPercentile)
+	case config.StrategyHourly:
+		return hourlyBaseline(samples, cfg)
+	default:
+		return tieredBaseline(samples, cfg, meanStddev, minOf)
+	}
+}
+
+// tieredBaseline collects stable windows with the tight spread limit, falling
+// back to the loose limit, and reduces their levels with pick.
+func tieredBaseline(samples []monitor.CPUSample, cfg *config.CalibrationConfig,
+	est windowEstimator, pick func([]float64) float64) (float64, error) {
+	for _, maxSpread := range []float64{cfg.StddevTight, cfg.StddevLoose} {
+		if levels := stableWindowLevels(samples, cfg.Window(), 0, maxSpread, est); len(levels) > 0 {
+			baseline := pick(levels)
+			slog.Info("Found idle baseline", logging.EventKey, logging.Calibration,
+				"idle_baseline", math.Round(baseline*100)/100, "windows", len(levels), "max_spread", maxSpread)
+			return baseline, nil
+		}
+		slog.Info("No stable windows, loosening spread limit", logging.EventKey, logging.Calibration, "max_spread", maxSpread)
+	}
+	return 0, fmt.Errorf("no stable idle windows found (spread always > %.1f%%)", cfg.StddevLoose)
+}
+
+// hourlyBaseline finds the quietest stable window for each hour of the day and
+// returns the highest of those, so daytime background load stays below threshold.
+// cfg.IdlePercentile does not apply; LoadDefaults warns when it is tuned.
+func hourlyBaseline(samples []monitor.CPUSample, cfg *config.CalibrationConfig) (float64, error) {
+	// Each hour's samples end abruptly every day, so only windows covering at
+	// least half the window duration count; short tail windows are noise.
+	minSpan := cfg.Window() / 2
+
+	var byHour [24][]monitor.CPUSample
+	for _, s := range samples {
+		h := s.Timestamp.Hour()
+		byHour[h] = append(byHour[h], s)
+	}
+
+	baseline := 0.0
+	hours := 0
+	for h, hourSamples := range byHour {
+		if len(hourSamples) < minWindowSamples {
+			continue
+		}
+		for _, maxSpread := range []float64{cfg.StddevTight, cfg.StddevLoose} {
+			if levels := stableWindowLevels(hourSamples, cfg.Window(), minSpan, maxSpread, meanStddev); len(levels) > 0 {
+				hourBaseline := minOf(levels)
+				slog.Debug("Hourly idle baseline", logging.EventKey, logging.Calibration,
+					"hour", h, "idle_baseline", math.Round(hourBaseline*100)/100, "max_spread", maxSpread)
+				baseline = math.Max(baseline, hourBaseline)
+				hours++
+				break
+			}
+		}
+	}
+
+	if hours == 0 {
+		return 0, fmt.Errorf("no stable idle windows found in any hour (spread always > %.1f%%)", cfg.StddevLoose)
+	}
+	slog.Info("Found idle baseline", logging.EventKey, logging.Calibration, "idle_baseline", math.Round(baseline*100)/100, "hours", hours)
+	return baseline, nil
+}
+
+// stableWindowLevels slides a window over samples and returns the level of
+// every window whose spread is below maxSpread. Windows whose samples span
+// less than minSpan are skipped.
+func stableWindowLevels(samples []monitor.CPUSample, window, minSpan time.Duration,
+	maxSpread float64, est windowEstimator) []float64 {
+	var levels []float64
+
+	for i := range samples {
+		end := samples[i].Timestamp.Add(window)
+		var winValues []float64
+		j := i
+		for ; j < len(samples) && samples[j].Timestamp.Before(end); j++ {
+			winValues = append(winValues, samples[j].Usage)
+		}
+		if len(winValues) < minWindowSamples || samples[j-1].Timestamp.Sub(samples[i].Timestamp) < minSpan {
+			continue
+		}
+
+		if level, spread := est(winValues); spread < maxSpread {
+			levels = append(levels, level)
+		}
+	}
+
+	return levels
+}
+
+func meanStddev(vals []float64) (float64, float64) {
+	avg := mean(vals)
+	return avg, stddev(vals, avg)
+}
+
+func medianMAD(vals []float64) (float64, float64) {
+	med := median(vals)
+	deviations := make([]float64, len(vals))
+	for i, v := range vals {
+		deviations[i] = math.Abs(v - med)
+	}
+	return med, median(deviations) * madScale
+}
+
+func minOf(vals []float64) float64 {
+	m := math.MaxFloat64
+	for _, v := range vals {
+		m = math.Min(m, v)
+	}
+	return m
+}
+
+func median(vals []float64) float64 {
+	return percentileOf(vals, 50)
+}
+
+// percentileOf returns the p-th percentile (0-100) of vals using linear
+// interpolation between closest ranks.
+func percentileOf(vals []float64, p float64) float64 {
+	sorted := append([]float64(nil), vals...)
+	sort.Float64s(sorted)
+
+	rank := p / 100 * float64(len(sorted)-1)
+	lo := int(math.Floor(rank))
+	hi := int(math.Ceil(rank))
+	frac := rank - float64(lo)
+	return sorted[lo] + (sorted[hi]-sorted[lo])*frac
+}
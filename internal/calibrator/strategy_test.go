@@ -0,0 +1,73 @@
+package calibrator
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"idleshutdown/internal/config"
+)
+
+// dayNight is 2% at night (00-07h) and 8% background load during the day.
+func dayNight(ts time.Time) float64 {
+	if ts.Hour() < 7 {
+		return 2
+	}
+	return 8
+}
+
+func TestStrategies(t *testing.T) {
+	samples := genSamples(testStart, 72*time.Hour, dayNight)
+
+	tests := []struct {
+		strategy string
+		want     float64
+	}{
+		{config.StrategyMinWindow, 2},
+		{config.StrategyPercentile, 8},
+		{config.StrategyHourly, 8},
+		{config.StrategyRobust, 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			cfg := testCalibConfig()
+			cfg.Strategy = tt.strategy
+			baseline, err := findIdleBaseline(samples, cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(baseline-tt.want) > 0.5 {
+				t.Errorf("baseline = %.2f, want ~%.0f", baseline, tt.want)
+			}
+		})
+	}
+}
+
+func TestRobustToleratesSpikes(t *testing.T) {
+	// 4% load with a spike to 60% every 5 minutes
+	samples := genSamples(testStart, 6*time.Hour, func(ts time.Time) float64 {
+		if ts.Minute()%5 == 0 && ts.Second() == 0 {
+			return 60
+		}
+		return 4
+	})
+
+	cfg := testCalibConfig()
+	cfg.Strategy = config.StrategyRobust
+	baseline, err := findIdleBaseline(samples, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(baseline-4.25) > 0.5 {
+		t.Errorf("baseline = %.2f, want ~4", baseline)
+	}
+}
+
+func TestPercentileOf(t *testing.T) {
+	vals := []float64{5, 1, 3, 2, 4}
+	for p, want := range map[float64]float64{0: 1, 50: 3, 100: 5, 25: 2} {
+		if got := percentileOf(vals, p); got != want {
+			t.Errorf("percentileOf(%v) = %v, want %v", p, got, want)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatDuration(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		30 * time.Second:              "<1m",
+		45 * time.Minute:              "45m",
+		3 * time.Hour:                 "3h 0m",
+		23*time.Hour + 14*time.Minute: "23h 14m",
+	} {
+		if got := FormatDuration(d); got != want {
+			t.Errorf("FormatDuration(%v) = %q, want %q", d, got, want)
+		}
+	}
+}
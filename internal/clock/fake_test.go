@@ -0,0 +1,51 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeTickerFiresOnAdvance(t *testing.T) {
+	start := time.Date(2026, 2, 19, 0, 0, 0, 0, time.UTC)
+	f := NewFake(start)
+	ticker := f.NewTicker(time.Minute)
+
+	f.Advance(30 * time.Second)
+	select {
+	case <-ticker.C():
+		t.Fatal("ticker fired before its period elapsed")
+	default:
+	}
+
+	f.Advance(30 * time.Second)
+	select {
+	case tick := <-ticker.C():
+		if want := start.Add(time.Minute); !tick.Equal(want) {
+			t.Errorf("tick = %v, want %v", tick, want)
+		}
+	default:
+		t.Fatal("ticker did not fire after one period")
+	}
+}
+
+func TestFakeTickerDropsMissedTicks(t *testing.T) {
+	f := NewFake(time.Unix(0, 0))
+	ticker := f.NewTicker(time.Second)
+
+	f.Advance(10 * time.Second)
+	<-ticker.C()
+	select {
+	case <-ticker.C():
+		t.Fatal("missed ticks should be dropped, like time.Ticker")
+	default:
+	}
+}
+
+func TestFakeSleepAdvances(t *testing.T) {
+	start := time.Unix(1000, 0)
+	f := NewFake(start)
+	f.Sleep(100 * time.Millisecond)
+	if got := f.Since(start); got != 100*time.Millisecond {
+		t.Errorf("Since = %v, want 100ms", got)
+	}
+}
@@ -0,0 +1,37 @@
+// Package command runs external programs behind an interface so callers
+// can be tested without executing real system commands.
+package command
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Runner executes external commands.
+type Runner interface {
+	// Run executes name with args and returns its standard output. On
+	// failure the error includes anything the command wrote to stderr.
+	Run(name string, args ...string) ([]byte, error)
+}
+
+// Exec is the Runner backed by os/exec.
+var Exec Runner = execRunner{}
+
+type execRunner struct{}
+
+func (execRunner) Run(name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return stdout.Bytes(), fmt.Errorf("%w — %s", err, msg)
+		}
+		return stdout.Bytes(), err
+	}
+	return stdout.Bytes(), nil
+}
@@ -2,12 +2,21 @@
 package config
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
+	"net/netip"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"gopkg.in/ini.v1"
+
+	"idleshutdown/internal/logging"
+	"idleshutdown/internal/monitor"
+	"idleshutdown/internal/rules"
 )
 
 // Default configuration values
@@ -17,9 +26,105 @@ const (
 	DefaultCPUThreshold     = 25
 	DefaultConfigPath       = "/etc/idleshutdown/config.ini"
 	DefaultDefaultsPath     = "/etc/idleshutdown/default.ini"
-	DefaultStatePath        = "/etc/idleshutdown/calibration.state"
+	DefaultStateDir         = "/var/lib/idleshutdown"
+	DefaultAPISocket        = "/run/idleshutdown/api.sock"
+	DefaultMOTDPath         = "/run/motd.d/idleshutdown"
+	DefaultIdlePercentile   = 50.0
+
+	// Notification delivery defaults.
+	DefaultWebhookTimeoutSeconds = 10
+	DefaultWebhookRetries        = 3
+	DefaultSMTPPort              = 587
+	// MinOwnerGraceMinutes is the shortest shutdown grace period when
+	// owners are emailed, so the pending email arrives before the shutdown.
+	MinOwnerGraceMinutes = 10
+
+	// Audit log rotation defaults.
+	DefaultAuditMaxSizeMB = 10
+	DefaultAuditMaxFiles  = 5
+
+	// DefaultPSIThreshold is the stall percentage below which the psi
+	// signal is idle, until calibrated.
+	DefaultPSIThreshold = 10.0
+
+	// Memory activity limits: major faults and pages swapped per second.
+	DefaultMajorFaultsPerSecond = 50.0
+	DefaultSwapPagesPerSecond   = 100.0
+
+	// DefaultNetBytesPerSecond is the traffic, received and sent, at or
+	// above which the net signal is busy. Background traffic of an idle VM
+	// (DHCP, NTP, metadata, an idle SSH session) stays well below it.
+	DefaultNetBytesPerSecond = 10000.0
+
+	// DefaultTCPIdleMinutes is how long the selected ports must be free of
+	// client connections before a shutdown.
+	DefaultTCPIdleMinutes = 5
+
+	// Idle score defaults.
+	DefaultScoreTarget          = 0.9
+	DefaultScoreHalfLifeMinutes = 15.0
+
+	// Calibration guardrail defaults.
+	DefaultMinThreshold            = 5.0
+	DefaultMaxThreshold            = 50.0
+	DefaultMaxChangePerCalibration = 10.0
+	DefaultMinSampleCoverage       = 50.0
+
+	// Baseline analysis defaults.
+	DefaultThresholdBuffer = 3.0
+	DefaultWindowMinutes   = 30.0
+	DefaultStddevTight     = 1.0
+	DefaultStddevLoose     = 2.0
 )
 
+// StateFileName is the calibration state file inside the state directory.
+const StateFileName = "calibration.state"
+
+// LegacyStatePath is where calibration.state lived before the state
+// directory existed; it is migrated on startup.
+const LegacyStatePath = "/etc/idleshutdown/calibration.state"
+
+// StatusFileName is the default status file inside the state directory,
+// used with banner = file.
+const StatusFileName = "status.ini"
+
+// AuditFileName is the decision audit log inside the state directory.
+const AuditFileName = "audit.jsonl"
+
+// Banner modes selectable via the "banner" key in config.ini.
+const (
+	// BannerConfig writes the auto-mode status banner into config.ini.
+	BannerConfig = "config"
+	// BannerFile writes the banner to a separate status file and never
+	// modifies config.ini.
+	BannerFile = "file"
+	// BannerNone never modifies config.ini and writes no status.
+	BannerNone = "none"
+)
+
+// Calibration strategies selectable via the "strategy" key in default.ini.
+const (
+	// StrategyMinWindow picks the quietest stable window (original behaviour).
+	StrategyMinWindow = "min_window"
+	// StrategyPercentile takes a percentile of all stable window averages.
+	StrategyPercentile = "percentile"
+	// StrategyHourly finds the quietest window per hour of day and uses the
+	// highest of those, so the threshold covers every hour's background load.
+	StrategyHourly = "hourly"
+	// StrategyRobust uses window medians and MAD instead of mean and stddev,
+	// so isolated spikes do not disqualify otherwise idle windows.
+	StrategyRobust = "robust"
+)
+
+// ValidStrategy reports whether name is a known calibration strategy.
+func ValidStrategy(name string) bool {
+	switch name {
+	case StrategyMinWindow, StrategyPercentile, StrategyHourly, StrategyRobust:
+		return true
+	}
+	return false
+}
+
 // Config holds the agent configuration parameters.
 type Config struct {
 	CPUCheckMinutes  int
@@ -30,9 +135,193 @@ type Config struct {
 	// In auto mode it comes from calibration.state (set by calibrator).
 	CPUThreshold int
 
+	// CPUMetric selects which CPU reading is compared with CPUThreshold:
+	// monitor.MetricAggregate, MetricMaxCore, MetricLoad or MetricCgroup.
+	CPUMetric string
+
+	// CPUCgroups and CPUCgroupsExclude select the cgroups MetricCgroup
+	// measures (see monitor.CgroupSelection).
+	CPUCgroups        []string
+	CPUCgroupsExclude []string
+
+	// CPUSteal, CPUIOWait and CPUGuest say how steal, I/O wait and guest
+	// time count towards CPU usage: monitor.AccountBusy, AccountIdle or
+	// AccountExcluded (see monitor.CPUAccounting).
+	CPUSteal  string
+	CPUIOWait string
+	CPUGuest  string
+
 	// AutoMode is true when cpu_threshold is commented out or absent in config.ini,
 	// meaning the agent self-calibrates the threshold.
 	AutoMode bool
+
+	// CPUSpikePercent, CPUSpikeMaxConsecutive and CPURollingAverageMinutes
+	// let the CPU idle check tolerate short bursts above the threshold
+	// (see monitor.CPUTolerance); all 0 tolerates none.
+	CPUSpikePercent          float64
+	CPUSpikeMaxConsecutive   int
+	CPURollingAverageMinutes int
+
+	// ShutdownGraceMinutes delays the shutdown after the idle condition is
+	// met; it is cancelled if the VM becomes busy in the meantime.
+	ShutdownGraceMinutes int
+
+	// ShutdownWhen is the shutdown_when rule as written in config.ini and
+	// ShutdownRule its parsed form; both are empty when unset (see Rule).
+	ShutdownWhen string
+	ShutdownRule rules.Expr
+
+	// APISocket is the Unix socket the local API listens on; empty disables it.
+	APISocket string
+
+	// StateDir holds calibration state and other mutable data; empty means
+	// the systemd or built-in default (see ResolveStateDir).
+	StateDir string
+	// Banner selects where the status banner is written: config.ini, a
+	// separate status file, or nowhere.
+	Banner string
+	// StatusFile is the status file for banner = file; empty means
+	// status.ini in the state directory.
+	StatusFile string
+
+	// MOTDEnabled turns on the login message announcing the shutdown
+	// policy, written to MOTDPath.
+	MOTDEnabled bool
+	MOTDPath    string
+
+	// WebhookURLs receive lifecycle events as JSON; empty disables webhooks.
+	WebhookURLs []string
+	// WebhookSecret signs each request with HMAC-SHA256 when set.
+	WebhookSecret         string
+	WebhookTimeoutSeconds int
+	WebhookRetries        int
+
+	// OwnerEmails are notified about pending and executed shutdowns through
+	// the SMTP relay at SMTPHost:SMTPPort; either empty disables email.
+	OwnerEmails  []string
+	EmailFrom    string
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	// SMTPStartTLS requires the relay to support STARTTLS.
+	SMTPStartTLS bool
+
+	// LogFormat is the log output format (auto, text, json or journal);
+	// LogLevel the minimum level logged.
+	LogFormat string
+	LogLevel  string
+
+	// AuditEnabled records every shutdown decision in the audit log in the
+	// state directory, rotated at AuditMaxSizeMB with AuditMaxFiles kept.
+	AuditEnabled   bool
+	AuditMaxSizeMB int
+	AuditMaxFiles  int
+
+	// Scoring configures the composite idle score behind score.idle.
+	Scoring ScoringConfig
+
+	// PSIThreshold is the stall percentage of PSIResources below which
+	// the psi signal is idle (see monitor.PSIMonitor). PSIAutoThreshold is
+	// true when [psi] threshold is absent; in auto mode the calibrated one
+	// then replaces the default.
+	PSIThreshold     float64
+	PSIAutoThreshold bool
+	PSIResources     []string
+
+	// PageFaultsPerSecond, MajorFaultsPerSecond and SwapPagesPerSecond are
+	// the paging and swap rates at or above which the memory signal is
+	// busy; 0 disables a limit. MemoryVetoMinutes > 0 adds
+	// memory.idle(MemoryVetoMinutes) to the shutdown rule, so activity
+	// above the limits vetoes a shutdown whatever the rule.
+	PageFaultsPerSecond  float64
+	MajorFaultsPerSecond float64
+	SwapPagesPerSecond   float64
+	MemoryVetoMinutes    int
+
+	// NetInterfaces are the interfaces whose traffic the net signal
+	// measures; empty means every interface but loopback.
+	// NetBytesPerSecond and NetPacketsPerSecond are the rates at or above
+	// which it is busy; 0 disables a limit.
+	NetInterfaces       []string
+	NetBytesPerSecond   float64
+	NetPacketsPerSecond float64
+
+	// TCPPorts are the local ports whose established connections keep the
+	// VM busy, except from peers in TCPExcludePeers. With ports set,
+	// tcp.idle(TCPIdleMinutes) is added to the shutdown rule.
+	TCPPorts        []uint16
+	TCPExcludePeers []netip.Prefix
+	TCPIdleMinutes  int
+
+	// Diagnostics are the problems Load found in the file. Load does not
+	// log them itself, since the agent reloads the config every
+	// evaluation; see LogDiagnostics.
+	Diagnostics []Diagnostic
+}
+
+// Diagnostic is a message about the config file, with slog attributes.
+type Diagnostic struct {
+	Level slog.Level
+	Msg   string
+	Args  []any
+}
+
+// LogDiagnostics logs the problems Load found.
+func (c *Config) LogDiagnostics() {
+	for _, d := range c.Diagnostics {
+		slog.Log(context.Background(), d.Level, d.Msg, append([]any{logging.EventKey, logging.Config}, d.Args...)...)
+	}
+}
+
+// DiagnosticsKey identifies the diagnostics, so a caller reloading the
+// config can log them again only when they change.
+func (c *Config) DiagnosticsKey() string {
+	return fmt.Sprint(c.Diagnostics)
+}
+
+func (c *Config) note(level slog.Level, msg string, args ...any) {
+	c.Diagnostics = append(c.Diagnostics, Diagnostic{Level: level, Msg: msg, Args: args})
+}
+
+func (c *Config) warn(msg string, args ...any) {
+	c.note(slog.LevelWarn, msg, args...)
+}
+
+// ScoringConfig configures the composite idle score: each signal's
+// idleness over the window, from 0 to 1, averaged by weight.
+type ScoringConfig struct {
+	// Enabled makes score.idle(WindowMinutes) the default rule.
+	Enabled bool
+	// WindowMinutes is the window of that default rule; 0 means the
+	// longer of cpu_check_minutes and user_check_minutes.
+	WindowMinutes int
+	// Target is the score at or above which the VM counts as idle.
+	Target float64
+
+	CPU   SignalScoring
+	Users SignalScoring
+}
+
+// SignalScoring configures one signal's contribution to the idle score.
+type SignalScoring struct {
+	// Weight is the signal's share of the score; 0 leaves it out.
+	Weight float64
+	// Method is monitor.ScoreFraction or monitor.ScoreEWMA, the latter
+	// halving a sample's weight every HalfLifeMinutes.
+	Method          string
+	HalfLifeMinutes float64
+	// SpikeSeconds forgives runs of busy samples up to this long.
+	SpikeSeconds int
+}
+
+// Options returns the monitor options for scoring the signal.
+func (s SignalScoring) Options() monitor.ScoreOptions {
+	return monitor.ScoreOptions{
+		Method:         s.Method,
+		HalfLife:       time.Duration(s.HalfLifeMinutes * float64(time.Minute)),
+		SpikeTolerance: time.Duration(s.SpikeSeconds) * time.Second,
+	}
 }
 
 // CalibrationConfig holds the calibration timing parameters from default.ini.
@@ -41,6 +330,36 @@ type CalibrationConfig struct {
 	InitialTrackingHours       float64
 	RecalibrationIntervalDays  float64
 	RecalibrationTrackingHours float64
+
+	// Strategy selects how the idle baseline is derived from samples.
+	Strategy string
+	// IdlePercentile is the percentile (0-100) used by the percentile and
+	// robust strategies.
+	IdlePercentile float64
+
+	// MinThreshold and MaxThreshold clamp every calibrated threshold.
+	MinThreshold float64
+	MaxThreshold float64
+	// MaxChangePerCalibration limits how far (in percentage points) a single
+	// recalibration may move the threshold. 0 disables the limit.
+	MaxChangePerCalibration float64
+	// MinSampleCoverage is the percentage of expected samples that must be
+	// present in the lookback for a calibration result to be applied.
+	MinSampleCoverage float64
+
+	// ThresholdBuffer is added to the idle baseline to form the threshold.
+	ThresholdBuffer float64
+	// WindowMinutes is the sliding window size used to find stable periods.
+	WindowMinutes float64
+	// StddevTight is the first-pass spread limit for a stable window;
+	// StddevLoose is the fallback when no tight windows are found.
+	StddevTight float64
+	StddevLoose float64
+}
+
+// Window returns the sliding window duration used for baseline analysis.
+func (c *CalibrationConfig) Window() time.Duration {
+	return time.Duration(c.WindowMinutes * float64(time.Minute))
 }
 
 // InitialLookback returns the initial tracking duration.
@@ -65,11 +384,49 @@ func Load(path string) (*Config, error) {
 		CPUCheckMinutes:  DefaultCPUCheckMinutes,
 		UserCheckMinutes: DefaultUserCheckMinutes,
 		CPUThreshold:     DefaultCPUThreshold,
+		CPUMetric:        monitor.MetricAggregate,
+		CPUSteal:         monitor.DefaultCPUAccounting().Steal,
+		CPUIOWait:        monitor.DefaultCPUAccounting().IOWait,
+		CPUGuest:         monitor.DefaultCPUAccounting().Guest,
 		AutoMode:         true, // Default: auto mode (threshold absent)
+		APISocket:        DefaultAPISocket,
+		Banner:           BannerConfig,
+		MOTDEnabled:      true,
+		MOTDPath:         DefaultMOTDPath,
+
+		WebhookTimeoutSeconds: DefaultWebhookTimeoutSeconds,
+		WebhookRetries:        DefaultWebhookRetries,
+
+		SMTPPort:     DefaultSMTPPort,
+		SMTPStartTLS: true,
+
+		LogFormat: logging.FormatAuto,
+		LogLevel:  "info",
+
+		AuditEnabled:   true,
+		AuditMaxSizeMB: DefaultAuditMaxSizeMB,
+		AuditMaxFiles:  DefaultAuditMaxFiles,
+
+		Scoring: ScoringConfig{
+			Target: DefaultScoreTarget,
+			CPU:    defaultSignalScoring(),
+			Users:  defaultSignalScoring(),
+		},
+
+		PSIThreshold:     DefaultPSIThreshold,
+		PSIAutoThreshold: true,
+		PSIResources:     monitor.PSIResources,
+
+		MajorFaultsPerSecond: DefaultMajorFaultsPerSecond,
+		SwapPagesPerSecond:   DefaultSwapPagesPerSecond,
+
+		NetBytesPerSecond: DefaultNetBytesPerSecond,
+
+		TCPIdleMinutes: DefaultTCPIdleMinutes,
 	}
 
 	if _, err := os.Stat(path); os.IsNotExist(err) {
-		log.Printf("Config file not found at %s, using defaults (auto mode)", path)
+		cfg.note(slog.LevelInfo, "Config file not found, using defaults (auto mode)", "path", path)
 		return cfg, nil
 	}
 
@@ -101,19 +458,562 @@ func Load(path string) (*Config, error) {
 		}
 	}
 
+	if key, err := section.GetKey("cpu_metric"); err == nil {
+		if val := strings.ToLower(strings.TrimSpace(key.String())); monitor.ValidMetric(val) {
+			cfg.CPUMetric = val
+		} else {
+			cfg.warn("Unknown CPU metric", "value", val, "using", cfg.CPUMetric)
+		}
+	}
+
+	if key, err := section.GetKey("cpu_cgroups"); err == nil {
+		cfg.CPUCgroups = splitList(key.String())
+	}
+	if key, err := section.GetKey("cpu_cgroups_exclude"); err == nil {
+		cfg.CPUCgroupsExclude = splitList(key.String())
+	}
+	if cfg.CPUMetric != monitor.MetricCgroup && !cfg.CPUCgroupSelection().IsZero() {
+		cfg.warn("cpu_cgroups is set but cpu_metric is not cgroup, the selection is not used", "cpu_metric", cfg.CPUMetric)
+	}
+
+	cfg.loadCPUAccount(section, "cpu_steal", &cfg.CPUSteal)
+	cfg.loadCPUAccount(section, "cpu_iowait", &cfg.CPUIOWait)
+	cfg.loadCPUAccount(section, "cpu_guest", &cfg.CPUGuest)
+
+	if key, err := section.GetKey("cpu_spike_percent"); err == nil {
+		if val, err := key.Float64(); err == nil && val >= 0 && val < 100 {
+			cfg.CPUSpikePercent = val
+		}
+	}
+
+	if key, err := section.GetKey("cpu_spike_max_consecutive"); err == nil {
+		if val, err := key.Int(); err == nil && val >= 0 {
+			cfg.CPUSpikeMaxConsecutive = val
+		}
+	}
+
+	if key, err := section.GetKey("cpu_rolling_average_minutes"); err == nil {
+		if val, err := key.Int(); err == nil && val >= 0 {
+			cfg.CPURollingAverageMinutes = val
+		}
+	}
+
+	if key, err := section.GetKey("shutdown_grace_minutes"); err == nil {
+		if val, err := key.Int(); err == nil && val >= 0 {
+			cfg.ShutdownGraceMinutes = val
+		}
+	}
+
+	// Unlike most keys, an invalid rule is an error rather than a warning:
+	// falling back to the default could shut down a VM the rule would
+	// have kept running.
+	if key, err := section.GetKey("shutdown_when"); err == nil {
+		if val := strings.TrimSpace(key.String()); val != "" {
+			rule, err := rules.Parse(val)
+			if err != nil {
+				return nil, err
+			}
+			cfg.ShutdownWhen = val
+			cfg.ShutdownRule = rule
+		}
+	}
+
+	if key, err := iniFile.Section("api").GetKey("socket"); err == nil {
+		cfg.APISocket = strings.TrimSpace(key.String())
+	}
+
+	agentSection := iniFile.Section("agent")
+
+	if key, err := agentSection.GetKey("state_dir"); err == nil {
+		cfg.StateDir = strings.TrimSpace(key.String())
+	}
+
+	if key, err := agentSection.GetKey("banner"); err == nil {
+		switch val := strings.ToLower(strings.TrimSpace(key.String())); val {
+		case BannerConfig, BannerFile, BannerNone:
+			cfg.Banner = val
+		default:
+			cfg.warn("Unknown banner mode", "value", val, "using", cfg.Banner)
+		}
+	}
+
+	if key, err := agentSection.GetKey("status_file"); err == nil {
+		cfg.StatusFile = strings.TrimSpace(key.String())
+	}
+
+	motdSection := iniFile.Section("motd")
+
+	if key, err := motdSection.GetKey("enabled"); err == nil {
+		if val, err := key.Bool(); err == nil {
+			cfg.MOTDEnabled = val
+		}
+	}
+
+	if key, err := motdSection.GetKey("path"); err == nil {
+		if val := strings.TrimSpace(key.String()); val != "" {
+			cfg.MOTDPath = val
+		}
+	}
+
+	webhookSection := iniFile.Section("webhook")
+
+	if key, err := webhookSection.GetKey("urls"); err == nil {
+		cfg.WebhookURLs = splitList(key.String())
+	}
+
+	if key, err := webhookSection.GetKey("secret"); err == nil {
+		cfg.WebhookSecret = strings.TrimSpace(key.String())
+	}
+
+	if key, err := webhookSection.GetKey("timeout_seconds"); err == nil {
+		if val, err := key.Int(); err == nil && val > 0 {
+			cfg.WebhookTimeoutSeconds = val
+		}
+	}
+
+	if key, err := webhookSection.GetKey("retries"); err == nil {
+		if val, err := key.Int(); err == nil && val >= 0 {
+			cfg.WebhookRetries = val
+		}
+	}
+
+	emailSection := iniFile.Section("email")
+
+	if key, err := emailSection.GetKey("owners"); err == nil {
+		cfg.OwnerEmails = splitList(key.String())
+	}
+
+	if key, err := emailSection.GetKey("from"); err == nil {
+		cfg.EmailFrom = strings.TrimSpace(key.String())
+	}
+
+	if key, err := emailSection.GetKey("smtp_host"); err == nil {
+		cfg.SMTPHost = strings.TrimSpace(key.String())
+	}
+
+	if key, err := emailSection.GetKey("smtp_port"); err == nil {
+		if val, err := key.Int(); err == nil && val > 0 && val <= 65535 {
+			cfg.SMTPPort = val
+		}
+	}
+
+	if key, err := emailSection.GetKey("username"); err == nil {
+		cfg.SMTPUsername = strings.TrimSpace(key.String())
+	}
+
+	if key, err := emailSection.GetKey("password"); err == nil {
+		cfg.SMTPPassword = key.String()
+	}
+
+	if key, err := emailSection.GetKey("starttls"); err == nil {
+		if val, err := key.Bool(); err == nil {
+			cfg.SMTPStartTLS = val
+		}
+	}
+
+	if cfg.EmailEnabled() && cfg.ShutdownGraceMinutes < MinOwnerGraceMinutes {
+		cfg.warn("shutdown_grace_minutes is too short to warn owners by email before the shutdown",
+			"value", cfg.ShutdownGraceMinutes, "using", MinOwnerGraceMinutes)
+		cfg.ShutdownGraceMinutes = MinOwnerGraceMinutes
+	}
+
+	loggingSection := iniFile.Section("logging")
+
+	if key, err := loggingSection.GetKey("format"); err == nil {
+		if val := strings.ToLower(strings.TrimSpace(key.String())); logging.ValidFormat(val) {
+			cfg.LogFormat = val
+		} else {
+			cfg.warn("Unknown log format", "value", val, "using", cfg.LogFormat)
+		}
+	}
+
+	if key, err := loggingSection.GetKey("level"); err == nil {
+		val := strings.ToLower(strings.TrimSpace(key.String()))
+		if _, err := logging.ParseLevel(val); err == nil {
+			cfg.LogLevel = val
+		} else {
+			cfg.warn("Unknown log level", "value", val, "using", cfg.LogLevel)
+		}
+	}
+
+	auditSection := iniFile.Section("audit")
+
+	if key, err := auditSection.GetKey("enabled"); err == nil {
+		if val, err := key.Bool(); err == nil {
+			cfg.AuditEnabled = val
+		}
+	}
+
+	if key, err := auditSection.GetKey("max_size_mb"); err == nil {
+		if val, err := key.Int(); err == nil && val > 0 {
+			cfg.AuditMaxSizeMB = val
+		}
+	}
+
+	if key, err := auditSection.GetKey("max_files"); err == nil {
+		if val, err := key.Int(); err == nil && val >= 0 {
+			cfg.AuditMaxFiles = val
+		}
+	}
+
+	scoringSection := iniFile.Section("scoring")
+
+	if key, err := scoringSection.GetKey("enabled"); err == nil {
+		if val, err := key.Bool(); err == nil {
+			cfg.Scoring.Enabled = val
+		}
+	}
+
+	if key, err := scoringSection.GetKey("window_minutes"); err == nil {
+		if val, err := key.Int(); err == nil && val > 0 {
+			cfg.Scoring.WindowMinutes = val
+		}
+	}
+
+	if key, err := scoringSection.GetKey("target"); err == nil {
+		if val, err := key.Float64(); err == nil && val > 0 && val <= 1 {
+			cfg.Scoring.Target = val
+		} else {
+			cfg.warn("Invalid score target, must be in (0, 1]", "value", key.String(), "using", cfg.Scoring.Target)
+		}
+	}
+
+	cfg.loadSignalScoring(scoringSection, "cpu", &cfg.Scoring.CPU)
+	cfg.loadSignalScoring(scoringSection, "users", &cfg.Scoring.Users)
+
+	psiSection := iniFile.Section("psi")
+
+	if key, err := psiSection.GetKey("threshold"); err == nil {
+		if val, err := key.Float64(); err == nil && val > 0 && val <= 100 {
+			cfg.PSIThreshold = val
+			cfg.PSIAutoThreshold = false
+		} else {
+			cfg.warn("Invalid PSI threshold, must be in (0, 100]", "value", key.String(), "using", cfg.PSIThreshold)
+		}
+	}
+
+	if key, err := psiSection.GetKey("resources"); err == nil {
+		var resources []string
+		for _, val := range splitList(strings.ToLower(key.String())) {
+			if monitor.ValidPSIResource(val) {
+				resources = append(resources, val)
+			} else {
+				cfg.warn("Unknown PSI resource", "value", val)
+			}
+		}
+		if len(resources) > 0 {
+			cfg.PSIResources = resources
+		}
+	}
+
+	memorySection := iniFile.Section("memory")
+
+	cfg.loadRate(memorySection, "page_faults_per_second", &cfg.PageFaultsPerSecond)
+	cfg.loadRate(memorySection, "major_faults_per_second", &cfg.MajorFaultsPerSecond)
+	cfg.loadRate(memorySection, "swap_pages_per_second", &cfg.SwapPagesPerSecond)
+
+	if key, err := memorySection.GetKey("veto_minutes"); err == nil {
+		if val, err := key.Int(); err == nil && val >= 0 {
+			cfg.MemoryVetoMinutes = val
+		} else {
+			cfg.warn("Invalid memory veto_minutes, must be 0 or more", "value", key.String(), "using", cfg.MemoryVetoMinutes)
+		}
+	}
+
+	netSection := iniFile.Section("net")
+
+	if key, err := netSection.GetKey("interfaces"); err == nil {
+		cfg.NetInterfaces = splitList(key.String())
+	}
+
+	cfg.loadRate(netSection, "bytes_per_second", &cfg.NetBytesPerSecond)
+	cfg.loadRate(netSection, "packets_per_second", &cfg.NetPacketsPerSecond)
+
+	tcpSection := iniFile.Section("tcp")
+
+	if key, err := tcpSection.GetKey("ports"); err == nil {
+		for _, val := range splitList(key.String()) {
+			if port, err := strconv.ParseUint(val, 10, 16); err == nil && port > 0 {
+				cfg.TCPPorts = append(cfg.TCPPorts, uint16(port))
+			} else {
+				cfg.warn("Invalid TCP port", "value", val)
+			}
+		}
+	}
+
+	if key, err := tcpSection.GetKey("exclude_peers"); err == nil {
+		for _, val := range splitList(key.String()) {
+			if prefix, err := parsePeer(val); err == nil {
+				cfg.TCPExcludePeers = append(cfg.TCPExcludePeers, prefix)
+			} else {
+				cfg.warn("Invalid TCP peer, want an address or CIDR", "value", val)
+			}
+		}
+	}
+
+	if key, err := tcpSection.GetKey("idle_minutes"); err == nil {
+		if val, err := key.Int(); err == nil && val > 0 {
+			cfg.TCPIdleMinutes = val
+		} else {
+			cfg.warn("Invalid TCP idle_minutes, must be at least 1", "value", key.String(), "using", cfg.TCPIdleMinutes)
+		}
+	}
+
 	return cfg, nil
 }
 
+// parsePeer parses a CIDR, or a single address as a prefix of its full
+// length.
+func parsePeer(val string) (netip.Prefix, error) {
+	if strings.Contains(val, "/") {
+		prefix, err := netip.ParsePrefix(val)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(val)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// loadRate reads a memory or network activity limit from key into rate,
+// keeping the default if it is invalid.
+func (c *Config) loadRate(section *ini.Section, key string, rate *float64) {
+	k, err := section.GetKey(key)
+	if err != nil {
+		return
+	}
+	if val, err := k.Float64(); err == nil && val >= 0 {
+		*rate = val
+	} else {
+		c.warn("Invalid activity limit, must be 0 or more", "section", section.Name(), "key", key, "value", k.String(), "using", *rate)
+	}
+}
+
+func defaultSignalScoring() SignalScoring {
+	return SignalScoring{Weight: 1, Method: monitor.ScoreFraction, HalfLifeMinutes: DefaultScoreHalfLifeMinutes}
+}
+
+// loadSignalScoring reads the <signal>_* keys of [scoring] into s.
+func (c *Config) loadSignalScoring(section *ini.Section, signal string, s *SignalScoring) {
+	if key, err := section.GetKey(signal + "_weight"); err == nil {
+		if val, err := key.Float64(); err == nil && val >= 0 {
+			s.Weight = val
+		}
+	}
+
+	if key, err := section.GetKey(signal + "_method"); err == nil {
+		switch val := strings.ToLower(strings.TrimSpace(key.String())); val {
+		case monitor.ScoreFraction, monitor.ScoreEWMA:
+			s.Method = val
+		default:
+			c.warn("Unknown scoring method", "signal", signal, "value", val, "using", s.Method)
+		}
+	}
+
+	if key, err := section.GetKey(signal + "_half_life_minutes"); err == nil {
+		if val, err := key.Float64(); err == nil && val > 0 {
+			s.HalfLifeMinutes = val
+		}
+	}
+
+	if key, err := section.GetKey(signal + "_spike_seconds"); err == nil {
+		if val, err := key.Int(); err == nil && val >= 0 {
+			s.SpikeSeconds = val
+		}
+	}
+}
+
+// splitList splits a comma-separated value, dropping empty items.
+func splitList(val string) []string {
+	var items []string
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// EmailEnabled reports whether owners are emailed, which needs both
+// owners and a relay.
+func (c *Config) EmailEnabled() bool {
+	return len(c.OwnerEmails) > 0 && c.SMTPHost != ""
+}
+
+// SMTPAddr returns the relay address as host:port.
+func (c *Config) SMTPAddr() string {
+	return net.JoinHostPort(c.SMTPHost, strconv.Itoa(c.SMTPPort))
+}
+
+// Rule returns the shutdown rule: shutdown_when if set, otherwise the idle
+// score over its window if scoring is enabled, otherwise CPU idle for
+// cpu_check_minutes and no users for user_check_minutes. With a memory
+// veto, the rule also requires memory.idle(veto_minutes), and with TCP
+// ports tcp.idle(idle_minutes), unless it requires that signal already.
+func (c *Config) Rule() rules.Expr {
+	rule := c.baseRule()
+	for _, veto := range c.Vetoes() {
+		rule = withVeto(rule, veto)
+	}
+	return rule
+}
+
+// Vetoes returns the idle checks Rule adds to the shutdown rule:
+// memory.idle(veto_minutes) with a memory veto and tcp.idle(idle_minutes)
+// with TCP ports.
+func (c *Config) Vetoes() []rules.Atom {
+	var vetoes []rules.Atom
+	if c.MemoryVetoMinutes > 0 {
+		vetoes = append(vetoes, rules.Atom{Signal: "memory", Window: time.Duration(c.MemoryVetoMinutes) * time.Minute})
+	}
+	if len(c.TCPPorts) > 0 {
+		vetoes = append(vetoes, rules.Atom{Signal: "tcp", Window: time.Duration(c.TCPIdleMinutes) * time.Minute})
+	}
+	return vetoes
+}
+
+// withVeto returns rule and veto, or rule if one of its top-level
+// conjuncts checks veto's signal already, in which case its window wins. A
+// check under || or ! does not count: the rule could be met without it.
+func withVeto(rule rules.Expr, veto rules.Atom) rules.Expr {
+	conjuncts := []rules.Expr{rule}
+	if and, ok := rule.(rules.And); ok {
+		conjuncts = and
+	}
+	for _, x := range conjuncts {
+		if atom, ok := x.(rules.Atom); ok && atom.Signal == veto.Signal {
+			return rule
+		}
+	}
+	if and, ok := rule.(rules.And); ok {
+		return append(and[:len(and):len(and)], veto)
+	}
+	return rules.And{rule, veto}
+}
+
+// baseRule is Rule without the memory and TCP vetoes.
+func (c *Config) baseRule() rules.Expr {
+	switch {
+	case c.ShutdownRule != nil:
+		return c.ShutdownRule
+	case c.Scoring.Enabled:
+		minutes := c.Scoring.WindowMinutes
+		if minutes == 0 {
+			minutes = max(c.CPUCheckMinutes, c.UserCheckMinutes)
+		}
+		return rules.Atom{Signal: "score", Window: time.Duration(minutes) * time.Minute}
+	}
+	return rules.Default(c.CPUCheckMinutes, c.UserCheckMinutes)
+}
+
+// loadCPUAccount reads how a class of CPU time counts from key into how,
+// keeping the default if it is invalid.
+func (c *Config) loadCPUAccount(section *ini.Section, key string, how *string) {
+	k, err := section.GetKey(key)
+	if err != nil {
+		return
+	}
+	if val := strings.ToLower(strings.TrimSpace(k.String())); monitor.ValidAccount(val) {
+		*how = val
+	} else {
+		c.warn("Invalid CPU time accounting, want busy, idle or excluded", "key", key, "value", val, "using", *how)
+	}
+}
+
+// CPUCgroupSelection returns the cgroups MetricCgroup measures.
+func (c *Config) CPUCgroupSelection() monitor.CgroupSelection {
+	return monitor.CgroupSelection{Include: c.CPUCgroups, Exclude: c.CPUCgroupsExclude}
+}
+
+// CPUAccounting returns how steal, I/O wait and guest time count towards
+// CPU usage.
+func (c *Config) CPUAccounting() monitor.CPUAccounting {
+	return monitor.CPUAccounting{Steal: c.CPUSteal, IOWait: c.CPUIOWait, Guest: c.CPUGuest}
+}
+
+// CPUTolerance returns the spike tolerance of the CPU idle check.
+func (c *Config) CPUTolerance() monitor.CPUTolerance {
+	return monitor.CPUTolerance{
+		Percent:        c.CPUSpikePercent,
+		MaxConsecutive: c.CPUSpikeMaxConsecutive,
+		RollingAverage: time.Duration(c.CPURollingAverageMinutes) * time.Minute,
+	}
+}
+
+// DefaultRule reports whether Rule is the built-in CPU and users condition.
+func (c *Config) DefaultRule() bool {
+	return c.ShutdownRule == nil && !c.Scoring.Enabled && c.MemoryVetoMinutes <= 0 && len(c.TCPPorts) == 0
+}
+
+// TCPFilter returns the connections the tcp signal counts.
+func (c *Config) TCPFilter() monitor.TCPFilter {
+	return monitor.TCPFilter{Ports: c.TCPPorts, ExcludePeers: c.TCPExcludePeers}
+}
+
+// MemoryLimits returns the activity rates the memory signal checks.
+func (c *Config) MemoryLimits() monitor.MemoryLimits {
+	return monitor.MemoryLimits{
+		PageFaults:  c.PageFaultsPerSecond,
+		MajorFaults: c.MajorFaultsPerSecond,
+		Swap:        c.SwapPagesPerSecond,
+	}
+}
+
+// NetLimits returns the traffic rates the net signal checks.
+func (c *Config) NetLimits() monitor.NetLimits {
+	return monitor.NetLimits{Bytes: c.NetBytesPerSecond, Packets: c.NetPacketsPerSecond}
+}
+
+// ShutdownGrace returns the shutdown grace period.
+func (c *Config) ShutdownGrace() time.Duration {
+	return time.Duration(c.ShutdownGraceMinutes) * time.Minute
+}
+
+// WebhookTimeout returns the time allowed for delivering one event to one
+// webhook URL, retries included.
+func (c *Config) WebhookTimeout() time.Duration {
+	return time.Duration(c.WebhookTimeoutSeconds) * time.Second
+}
+
+// ResolveStateDir returns the state directory to use. An explicit override
+// (the --state-dir flag) wins, then state_dir from config.ini, then the
+// directory systemd created for StateDirectory= ($STATE_DIRECTORY), then
+// DefaultStateDir.
+func (c *Config) ResolveStateDir(override string) string {
+	if override != "" {
+		return override
+	}
+	if c.StateDir != "" {
+		return c.StateDir
+	}
+	// systemd passes a colon-separated list when several are configured
+	if dirs := os.Getenv("STATE_DIRECTORY"); dirs != "" {
+		return strings.Split(dirs, ":")[0]
+	}
+	return DefaultStateDir
+}
+
 // LoadDefaults reads calibration timing parameters from default.ini.
 func LoadDefaults(path string) (*CalibrationConfig, error) {
 	defaults := &CalibrationConfig{
 		InitialTrackingHours:       24.0,
 		RecalibrationIntervalDays:  7.0,
 		RecalibrationTrackingHours: 72.0,
+		Strategy:                   StrategyMinWindow,
+		IdlePercentile:             DefaultIdlePercentile,
+		MinThreshold:               DefaultMinThreshold,
+		MaxThreshold:               DefaultMaxThreshold,
+		MaxChangePerCalibration:    DefaultMaxChangePerCalibration,
+		MinSampleCoverage:          DefaultMinSampleCoverage,
+		ThresholdBuffer:            DefaultThresholdBuffer,
+		WindowMinutes:              DefaultWindowMinutes,
+		StddevTight:                DefaultStddevTight,
+		StddevLoose:                DefaultStddevLoose,
 	}
 
 	if _, err := os.Stat(path); os.IsNotExist(err) {
-		log.Printf("Defaults file not found at %s, using built-in defaults", path)
+		slog.Info("Defaults file not found, using built-in defaults", logging.EventKey, logging.Config, "path", path)
 		return defaults, nil
 	}
 
@@ -142,6 +1042,85 @@ func LoadDefaults(path string) (*CalibrationConfig, error) {
 		}
 	}
 
+	if key, err := section.GetKey("strategy"); err == nil {
+		if val := strings.ToLower(strings.TrimSpace(key.String())); ValidStrategy(val) {
+			defaults.Strategy = val
+		} else {
+			slog.Warn("Unknown calibration strategy", logging.EventKey, logging.Config, "value", val, "using", defaults.Strategy)
+		}
+	}
+
+	if key, err := section.GetKey("idle_percentile"); err == nil {
+		if val, err := key.Float64(); err == nil && val >= 0 && val <= 100 {
+			defaults.IdlePercentile = val
+		}
+	}
+	// hourly always takes each hour's quietest window; a tuned percentile
+	// would otherwise be silently ignored.
+	if defaults.Strategy == StrategyHourly && defaults.IdlePercentile != DefaultIdlePercentile {
+		slog.Warn("idle_percentile is ignored by the hourly strategy", logging.EventKey, logging.Config,
+			"idle_percentile", defaults.IdlePercentile)
+	}
+
+	if key, err := section.GetKey("min_threshold"); err == nil {
+		if val, err := key.Float64(); err == nil && val >= 0 && val <= 100 {
+			defaults.MinThreshold = val
+		}
+	}
+
+	if key, err := section.GetKey("max_threshold"); err == nil {
+		if val, err := key.Float64(); err == nil && val > 0 && val <= 100 {
+			defaults.MaxThreshold = val
+		}
+	}
+
+	if defaults.MinThreshold > defaults.MaxThreshold {
+		slog.Warn("min_threshold exceeds max_threshold, using built-in limits", logging.EventKey, logging.Config,
+			"min_threshold", defaults.MinThreshold, "max_threshold", defaults.MaxThreshold)
+		defaults.MinThreshold = DefaultMinThreshold
+		defaults.MaxThreshold = DefaultMaxThreshold
+	}
+
+	if key, err := section.GetKey("max_change_per_calibration"); err == nil {
+		if val, err := key.Float64(); err == nil && val >= 0 {
+			defaults.MaxChangePerCalibration = val
+		}
+	}
+
+	if key, err := section.GetKey("min_sample_coverage"); err == nil {
+		if val, err := key.Float64(); err == nil && val >= 0 && val <= 100 {
+			defaults.MinSampleCoverage = val
+		}
+	}
+
+	if key, err := section.GetKey("threshold_buffer"); err == nil {
+		if val, err := key.Float64(); err == nil && val >= 0 {
+			defaults.ThresholdBuffer = val
+		}
+	}
+
+	if key, err := section.GetKey("window_minutes"); err == nil {
+		if val, err := key.Float64(); err == nil && val > 0 {
+			defaults.WindowMinutes = val
+		}
+	}
+
+	if key, err := section.GetKey("stddev_tight"); err == nil {
+		if val, err := key.Float64(); err == nil && val > 0 {
+			defaults.StddevTight = val
+		}
+	}
+
+	if key, err := section.GetKey("stddev_loose"); err == nil {
+		if val, err := key.Float64(); err == nil && val > 0 {
+			defaults.StddevLoose = val
+		}
+	}
+
+	if defaults.StddevLoose < defaults.StddevTight {
+		defaults.StddevLoose = defaults.StddevTight
+	}
+
 	return defaults, nil
 }
 
@@ -151,6 +1130,6 @@ func (c *Config) String() string {
 	if c.AutoMode {
 		mode = "AUTO"
 	}
-	return fmt.Sprintf("Config{CPUCheck: %dmin, UserCheck: %dmin, Threshold: %d%%, Mode: %s}",
-		c.CPUCheckMinutes, c.UserCheckMinutes, c.CPUThreshold, mode)
+	return fmt.Sprintf("Config{CPUCheck: %dmin, UserCheck: %dmin, Threshold: %d%%, Metric: %s (%s), Mode: %s, Rule: %s}",
+		c.CPUCheckMinutes, c.UserCheckMinutes, c.CPUThreshold, c.CPUMetric, c.CPUAccounting(), mode, c.Rule())
 }
@@ -0,0 +1,508 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"idleshutdown/internal/monitor"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadModes(t *testing.T) {
+	auto, err := Load(writeFile(t, "config.ini", "[monitoring]\ncpu_check_minutes = 45\n# cpu_threshold = 25\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !auto.AutoMode || auto.CPUCheckMinutes != 45 || auto.UserCheckMinutes != DefaultUserCheckMinutes {
+		t.Errorf("auto config = %s", auto)
+	}
+
+	manual, err := Load(writeFile(t, "config.ini", "[monitoring]\r\ncpu_threshold = 30\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manual.AutoMode || manual.CPUThreshold != 30 {
+		t.Errorf("manual config = %s", manual)
+	}
+}
+
+func TestLoadMissingFileUsesDefaults(t *testing.T) {
+	cfg, err := Load(filepath.Join(t.TempDir(), "missing.ini"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.AutoMode || cfg.CPUThreshold != DefaultCPUThreshold || cfg.APISocket != DefaultAPISocket {
+		t.Errorf("defaults = %s", cfg)
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	calibCfg, err := LoadDefaults(writeFile(t, "default.ini", `[calibration]
+initial_tracking_hours = 0.5
+strategy = Hourly
+min_threshold = 60
+max_threshold = 40
+window_minutes = 20
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calibCfg.InitialTrackingHours != 0.5 || calibCfg.Strategy != StrategyHourly || calibCfg.WindowMinutes != 20 {
+		t.Errorf("defaults = %+v", calibCfg)
+	}
+	if calibCfg.MinThreshold != DefaultMinThreshold || calibCfg.MaxThreshold != DefaultMaxThreshold {
+		t.Errorf("inverted limits should fall back to built-ins, got %.0f-%.0f",
+			calibCfg.MinThreshold, calibCfg.MaxThreshold)
+	}
+
+	unknown, err := LoadDefaults(writeFile(t, "default.ini", "[calibration]\nstrategy = magic\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unknown.Strategy != StrategyMinWindow {
+		t.Errorf("unknown strategy should fall back to %s, got %s", StrategyMinWindow, unknown.Strategy)
+	}
+}
+
+func TestLoadAgentSection(t *testing.T) {
+	cfg, err := Load(writeFile(t, "config.ini", "[agent]\nstate_dir = /srv/idle\nbanner = None\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.StateDir != "/srv/idle" || cfg.Banner != BannerNone {
+		t.Errorf("state_dir = %q, banner = %q", cfg.StateDir, cfg.Banner)
+	}
+
+	cfg, err = Load(writeFile(t, "config.ini", "[agent]\nbanner = file\nstatus_file = /run/idle/status.ini\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Banner != BannerFile || cfg.StatusFile != "/run/idle/status.ini" {
+		t.Errorf("banner = %q, status_file = %q", cfg.Banner, cfg.StatusFile)
+	}
+}
+
+func TestLoadWebhookSection(t *testing.T) {
+	cfg, err := Load(writeFile(t, "config.ini", `[monitoring]
+shutdown_grace_minutes = 5
+
+[webhook]
+urls = https://hooks.example.com/a, ,https://hooks.example.com/b
+secret = s3cret
+timeout_seconds = 3
+retries = 0
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.WebhookURLs) != 2 || cfg.WebhookURLs[1] != "https://hooks.example.com/b" {
+		t.Errorf("urls = %q", cfg.WebhookURLs)
+	}
+	if cfg.WebhookSecret != "s3cret" || cfg.WebhookTimeoutSeconds != 3 || cfg.WebhookRetries != 0 {
+		t.Errorf("webhook = %+v", cfg)
+	}
+	if cfg.ShutdownGraceMinutes != 5 {
+		t.Errorf("grace = %d", cfg.ShutdownGraceMinutes)
+	}
+}
+
+func TestLoadEmailSection(t *testing.T) {
+	cfg, err := Load(writeFile(t, "config.ini", `[email]
+owners = alice@example.com, bob@example.com
+smtp_host = relay.example.com
+starttls = false
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.OwnerEmails) != 2 || cfg.SMTPAddr() != "relay.example.com:587" || cfg.SMTPStartTLS {
+		t.Errorf("email = %q via %s, starttls %v", cfg.OwnerEmails, cfg.SMTPAddr(), cfg.SMTPStartTLS)
+	}
+	// Owners are warned before the shutdown even with the default grace.
+	if !cfg.EmailEnabled() || cfg.ShutdownGraceMinutes != MinOwnerGraceMinutes {
+		t.Errorf("grace = %d, want the %d-minute minimum for emailed owners", cfg.ShutdownGraceMinutes, MinOwnerGraceMinutes)
+	}
+
+	cfg, err = Load(writeFile(t, "config.ini", "[monitoring]\nshutdown_grace_minutes = 30\n[email]\nowners = alice@example.com\nsmtp_host = relay\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ShutdownGraceMinutes != 30 {
+		t.Errorf("grace = %d, want 30 as configured", cfg.ShutdownGraceMinutes)
+	}
+}
+
+func TestLoadCollectsDiagnostics(t *testing.T) {
+	content := "[monitoring]\ncpu_metric = iowait\ncpu_cgroups = user.slice\n[email]\nowners = alice@example.com\nsmtp_host = relay\n"
+	cfg, err := Load(writeFile(t, "config.ini", content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msgs []string
+	for _, d := range cfg.Diagnostics {
+		msgs = append(msgs, d.Msg)
+	}
+	want := []string{
+		"Unknown CPU metric",
+		"cpu_cgroups is set but cpu_metric is not cgroup, the selection is not used",
+		"shutdown_grace_minutes is too short to warn owners by email before the shutdown",
+	}
+	if !slices.Equal(msgs, want) {
+		t.Errorf("diagnostics = %q, want %q", msgs, want)
+	}
+
+	// A reload of the same file yields the same key, so the agent does not
+	// log the warnings again every evaluation.
+	again, err := Load(writeFile(t, "config.ini", content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.DiagnosticsKey() != cfg.DiagnosticsKey() {
+		t.Errorf("key changed on reload: %q, then %q", cfg.DiagnosticsKey(), again.DiagnosticsKey())
+	}
+	clean, err := Load(writeFile(t, "config.ini", "[monitoring]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(clean.Diagnostics) != 0 || clean.DiagnosticsKey() == cfg.DiagnosticsKey() {
+		t.Errorf("clean config diagnostics = %v", clean.Diagnostics)
+	}
+}
+
+func TestLoadLoggingSection(t *testing.T) {
+	cfg, err := Load(writeFile(t, "config.ini", "[logging]\nformat = JSON\nlevel = debug\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.LogFormat != "json" || cfg.LogLevel != "debug" {
+		t.Errorf("logging = %q %q, want json debug", cfg.LogFormat, cfg.LogLevel)
+	}
+
+	cfg, err = Load(writeFile(t, "config.ini", "[logging]\nformat = xml\nlevel = loud\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.LogFormat != "auto" || cfg.LogLevel != "info" {
+		t.Errorf("invalid logging = %q %q, want defaults auto info", cfg.LogFormat, cfg.LogLevel)
+	}
+}
+
+func TestLoadAuditSection(t *testing.T) {
+	cfg, err := Load(writeFile(t, "config.ini", "[audit]\nmax_size_mb = 2\nmax_files = 0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.AuditEnabled || cfg.AuditMaxSizeMB != 2 || cfg.AuditMaxFiles != 0 {
+		t.Errorf("audit = %v %d MB x %d", cfg.AuditEnabled, cfg.AuditMaxSizeMB, cfg.AuditMaxFiles)
+	}
+
+	cfg, err = Load(writeFile(t, "config.ini", "[audit]\nenabled = false\nmax_size_mb = -1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.AuditEnabled || cfg.AuditMaxSizeMB != DefaultAuditMaxSizeMB {
+		t.Errorf("audit = %v %d MB", cfg.AuditEnabled, cfg.AuditMaxSizeMB)
+	}
+}
+
+func TestLoadCPUSpikeTolerance(t *testing.T) {
+	cfg, err := Load(writeFile(t, "config.ini",
+		"[monitoring]\ncpu_spike_percent = 5\ncpu_spike_max_consecutive = 2\ncpu_rolling_average_minutes = 3\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := monitor.CPUTolerance{Percent: 5, MaxConsecutive: 2, RollingAverage: 3 * time.Minute}
+	if got := cfg.CPUTolerance(); got != want {
+		t.Errorf("tolerance = %+v, want %+v", got, want)
+	}
+
+	cfg, err = Load(writeFile(t, "config.ini", "[monitoring]\ncpu_spike_percent = 100\ncpu_spike_max_consecutive = -1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.CPUTolerance(); got != (monitor.CPUTolerance{}) {
+		t.Errorf("invalid tolerance = %+v, want none", got)
+	}
+}
+
+func TestLoadCPUMetric(t *testing.T) {
+	for content, want := range map[string]string{
+		"[monitoring]\n":                        monitor.MetricAggregate,
+		"[monitoring]\ncpu_metric = Max_Core\n": monitor.MetricMaxCore,
+		"[monitoring]\ncpu_metric = load\n":     monitor.MetricLoad,
+		"[monitoring]\ncpu_metric = iowait\n":   monitor.MetricAggregate,
+	} {
+		cfg, err := Load(writeFile(t, "config.ini", content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.CPUMetric != want {
+			t.Errorf("%q: metric = %q, want %q", content, cfg.CPUMetric, want)
+		}
+	}
+}
+
+func TestLoadCPUCgroups(t *testing.T) {
+	cfg, err := Load(writeFile(t, "config.ini",
+		"[monitoring]\ncpu_metric = cgroup\ncpu_cgroups = user.slice, app.service\ncpu_cgroups_exclude = system.slice/cron.service\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sel := cfg.CPUCgroupSelection()
+	if cfg.CPUMetric != monitor.MetricCgroup || sel.String() != "user.slice, app.service minus system.slice/cron.service" {
+		t.Errorf("metric %q, cgroups %q", cfg.CPUMetric, sel)
+	}
+}
+
+func TestLoadCPUAccounting(t *testing.T) {
+	cfg, err := Load(writeFile(t, "config.ini", "[monitoring]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.CPUAccounting(); got != monitor.DefaultCPUAccounting() {
+		t.Errorf("default accounting = %+v", got)
+	}
+
+	cfg, err = Load(writeFile(t, "config.ini", "[monitoring]\ncpu_steal = Excluded\ncpu_iowait = busy\ncpu_guest = sometimes\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := monitor.CPUAccounting{Steal: monitor.AccountExcluded, IOWait: monitor.AccountBusy, Guest: monitor.AccountBusy}
+	if got := cfg.CPUAccounting(); got != want {
+		t.Errorf("accounting = %+v, want %+v", got, want)
+	}
+}
+
+func TestLoadShutdownWhen(t *testing.T) {
+	cfg, err := Load(writeFile(t, "config.ini", "[monitoring]\ncpu_check_minutes = 30\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Rule().String(); cfg.ShutdownRule != nil || got != "cpu.idle(30m) && users.idle(1h)" {
+		t.Errorf("default rule = %s", got)
+	}
+
+	cfg, err = Load(writeFile(t, "config.ini", "[monitoring]\nshutdown_when = users.idle(30m) || (cpu.idle(2h) && users.idle(5m))\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Rule().String(); got != "users.idle(30m) || (cpu.idle(2h) && users.idle(5m))" {
+		t.Errorf("rule = %s", got)
+	}
+
+	if _, err := Load(writeFile(t, "config.ini", "[monitoring]\nshutdown_when = cpu.idle(2h) && disk.idle(2h)\n")); err == nil {
+		t.Error("invalid shutdown_when loaded without error")
+	}
+}
+
+func TestLoadScoringSection(t *testing.T) {
+	cfg, err := Load(writeFile(t, "config.ini", "[monitoring]\nuser_check_minutes = 90\n"+
+		"[scoring]\nenabled = true\ntarget = 0.8\ncpu_weight = 2\ncpu_method = EWMA\ncpu_half_life_minutes = 5\n"+
+		"cpu_spike_seconds = 60\nusers_weight = 0\nusers_method = median\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := cfg.Scoring
+	if !s.Enabled || s.Target != 0.8 || s.CPU.Weight != 2 || s.CPU.Method != "ewma" || s.Users.Weight != 0 || s.Users.Method != "fraction" {
+		t.Errorf("scoring = %+v", s)
+	}
+	if opts := s.CPU.Options(); opts.HalfLife != 5*time.Minute || opts.SpikeTolerance != time.Minute {
+		t.Errorf("cpu options = %+v", opts)
+	}
+	if got := cfg.Rule().String(); got != "score.idle(1h30m)" || cfg.DefaultRule() {
+		t.Errorf("rule = %s", got)
+	}
+
+	cfg, err = Load(writeFile(t, "config.ini", "[scoring]\ntarget = 1.5\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Scoring.Target != DefaultScoreTarget || cfg.Scoring.Enabled || !cfg.DefaultRule() {
+		t.Errorf("scoring = %+v", cfg.Scoring)
+	}
+}
+
+func TestLoadPSISection(t *testing.T) {
+	cfg, err := Load(writeFile(t, "config.ini", "[monitoring]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.PSIAutoThreshold || cfg.PSIThreshold != DefaultPSIThreshold || len(cfg.PSIResources) != 3 {
+		t.Errorf("default psi = %v %v %v", cfg.PSIAutoThreshold, cfg.PSIThreshold, cfg.PSIResources)
+	}
+
+	cfg, err = Load(writeFile(t, "config.ini", "[psi]\nthreshold = 2.5\nresources = IO, swap, memory\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.PSIAutoThreshold || cfg.PSIThreshold != 2.5 || strings.Join(cfg.PSIResources, ",") != "io,memory" {
+		t.Errorf("psi = %v %v %v", cfg.PSIAutoThreshold, cfg.PSIThreshold, cfg.PSIResources)
+	}
+
+	cfg, err = Load(writeFile(t, "config.ini", "[psi]\nthreshold = 0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.PSIAutoThreshold || cfg.PSIThreshold != DefaultPSIThreshold {
+		t.Errorf("invalid threshold: %v %v", cfg.PSIAutoThreshold, cfg.PSIThreshold)
+	}
+}
+
+func TestLoadNetSection(t *testing.T) {
+	cfg, err := Load(writeFile(t, "config.ini", "[monitoring]\ncpu_check_minutes = 30\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (monitor.NetLimits{Bytes: DefaultNetBytesPerSecond}); cfg.NetLimits() != want || cfg.NetInterfaces != nil {
+		t.Errorf("default net = %+v, interfaces %v", cfg.NetLimits(), cfg.NetInterfaces)
+	}
+
+	cfg, err = Load(writeFile(t, "config.ini",
+		"[net]\ninterfaces = eth0, ens5\nbytes_per_second = -5\npackets_per_second = 50\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (monitor.NetLimits{Bytes: DefaultNetBytesPerSecond, Packets: 50}); cfg.NetLimits() != want {
+		t.Errorf("net limits = %+v, want %+v", cfg.NetLimits(), want)
+	}
+	if !slices.Equal(cfg.NetInterfaces, []string{"eth0", "ens5"}) {
+		t.Errorf("interfaces = %v", cfg.NetInterfaces)
+	}
+
+	cfg, err = Load(writeFile(t, "config.ini",
+		"[monitoring]\nshutdown_when = users.idle(30m) || (cpu.idle(2h) && net.idle(2h))\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Rule().String(); got != "users.idle(30m) || (cpu.idle(2h) && net.idle(2h))" {
+		t.Errorf("rule = %q", got)
+	}
+}
+
+func TestLoadMemorySection(t *testing.T) {
+	cfg, err := Load(writeFile(t, "config.ini", "[monitoring]\ncpu_check_minutes = 30\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := monitor.MemoryLimits{MajorFaults: DefaultMajorFaultsPerSecond, Swap: DefaultSwapPagesPerSecond}
+	if cfg.MemoryLimits() != want || cfg.MemoryVetoMinutes != 0 || !cfg.DefaultRule() {
+		t.Errorf("default memory = %+v, veto %d", cfg.MemoryLimits(), cfg.MemoryVetoMinutes)
+	}
+
+	cfg, err = Load(writeFile(t, "config.ini",
+		"[monitoring]\ncpu_check_minutes = 30\n[memory]\npage_faults_per_second = 5000\nswap_pages_per_second = -1\nveto_minutes = 15\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = monitor.MemoryLimits{PageFaults: 5000, MajorFaults: DefaultMajorFaultsPerSecond, Swap: DefaultSwapPagesPerSecond}
+	if cfg.MemoryLimits() != want {
+		t.Errorf("memory limits = %+v, want %+v", cfg.MemoryLimits(), want)
+	}
+	if got := cfg.Rule().String(); got != "cpu.idle(30m) && users.idle(1h) && memory.idle(15m)" || cfg.DefaultRule() {
+		t.Errorf("rule with veto = %q, default %v", got, cfg.DefaultRule())
+	}
+
+	cfg, err = Load(writeFile(t, "config.ini",
+		"[monitoring]\nshutdown_when = users.idle(30m) || cpu.idle(2h)\n[memory]\nveto_minutes = 10\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Rule().String(); got != "(users.idle(30m) || cpu.idle(2h)) && memory.idle(10m)" {
+		t.Errorf("custom rule with veto = %q", got)
+	}
+}
+
+func TestLoadTCPSection(t *testing.T) {
+	cfg, err := Load(writeFile(t, "config.ini",
+		"[monitoring]\ncpu_check_minutes = 30\n[tcp]\nports = 5432, 8888, http, 443\n"+
+			"exclude_peers = 10.9.0.0/16, 192.0.2.7, fd00::/8, scraper\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := cfg.TCPFilter()
+	if fmt.Sprint(filter.Ports) != "[5432 8888 443]" || fmt.Sprint(filter.ExcludePeers) != "[10.9.0.0/16 192.0.2.7/32 fd00::/8]" {
+		t.Errorf("tcp filter = %v", filter)
+	}
+	if got := cfg.Rule().String(); got != "cpu.idle(30m) && users.idle(1h) && tcp.idle(5m)" || cfg.DefaultRule() {
+		t.Errorf("rule with ports = %q, default %v", got, cfg.DefaultRule())
+	}
+
+	// A rule that requires no connections itself is left alone
+	cfg, err = Load(writeFile(t, "config.ini",
+		"[monitoring]\nshutdown_when = cpu.idle(2h) && tcp.idle(1h)\n[tcp]\nports = 443\nidle_minutes = 10\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Rule().String(); got != "cpu.idle(2h) && tcp.idle(1h)" || cfg.TCPIdleMinutes != 10 {
+		t.Errorf("rule requiring tcp = %q, idle minutes %d", got, cfg.TCPIdleMinutes)
+	}
+
+	// One that can be met without it still gets the veto
+	cfg, err = Load(writeFile(t, "config.ini",
+		"[monitoring]\nshutdown_when = tcp.idle(1h) || users.idle(2h)\n[tcp]\nports = 443\nidle_minutes = 10\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Rule().String(); got != "(tcp.idle(1h) || users.idle(2h)) && tcp.idle(10m)" {
+		t.Errorf("rule checking tcp under || = %q", got)
+	}
+}
+
+func TestLoadMOTDSection(t *testing.T) {
+	cfg, err := Load(writeFile(t, "config.ini", "[monitoring]\ncpu_threshold = 20\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.MOTDEnabled || cfg.MOTDPath != DefaultMOTDPath {
+		t.Errorf("default motd = %v %q", cfg.MOTDEnabled, cfg.MOTDPath)
+	}
+
+	cfg, err = Load(writeFile(t, "config.ini", "[motd]\nenabled = false\npath = /etc/motd.d/idle\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MOTDEnabled || cfg.MOTDPath != "/etc/motd.d/idle" {
+		t.Errorf("motd = %v %q", cfg.MOTDEnabled, cfg.MOTDPath)
+	}
+
+	cfg, err = Load(writeFile(t, "config.ini", "[agent]\nbanner = sometimes\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Banner != BannerConfig {
+		t.Errorf("unknown banner mode should keep %q, got %q", BannerConfig, cfg.Banner)
+	}
+}
+
+func TestResolveStateDir(t *testing.T) {
+	cfg := &Config{}
+	t.Setenv("STATE_DIRECTORY", "")
+	if got := cfg.ResolveStateDir(""); got != DefaultStateDir {
+		t.Errorf("default = %q", got)
+	}
+
+	t.Setenv("STATE_DIRECTORY", "/var/lib/idleshutdown-unit:/var/lib/other")
+	if got := cfg.ResolveStateDir(""); got != "/var/lib/idleshutdown-unit" {
+		t.Errorf("systemd = %q", got)
+	}
+
+	cfg.StateDir = "/srv/idle"
+	if got := cfg.ResolveStateDir(""); got != "/srv/idle" {
+		t.Errorf("config = %q", got)
+	}
+	if got := cfg.ResolveStateDir("/tmp/state"); got != "/tmp/state" {
+		t.Errorf("override = %q", got)
+	}
+}
@@ -0,0 +1,503 @@
+// Package explain reports why the agent is, or is not, shutting the VM
+// down: each idle condition with the samples that broke it, gaps in the
+// data, the learning phase and when the shutdown would happen if nothing
+// changes.
+package explain
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"idleshutdown/internal/clock"
+	"idleshutdown/internal/config"
+	"idleshutdown/internal/monitor"
+	"idleshutdown/internal/rules"
+	"idleshutdown/internal/scoring"
+)
+
+// Policy is the shutdown policy in effect, as of the last evaluation.
+type Policy struct {
+	Mode            string `json:"mode"`
+	ThresholdSource string `json:"threshold_source"`
+	CPUThreshold    int    `json:"cpu_threshold"`
+	// CPUMetric is the CPU reading compared with CPUThreshold (see
+	// monitor.MetricAggregate); empty means the aggregate.
+	CPUMetric string `json:"cpu_metric,omitempty"`
+	// PSIThreshold is the stall percentage of PSIResources below which
+	// psi.idle atoms hold, taken from PSIThresholdSource.
+	PSIThreshold       float64  `json:"psi_threshold,omitempty"`
+	PSIThresholdSource string   `json:"psi_threshold_source,omitempty"`
+	PSIResources       []string `json:"psi_resources,omitempty"`
+	// MemoryLimits are the paging and swap rates at or above which
+	// memory.idle atoms fail.
+	MemoryLimits monitor.MemoryLimits `json:"memory_limits"`
+	// NetLimits are the traffic rates at or above which net.idle atoms
+	// fail.
+	NetLimits monitor.NetLimits `json:"net_limits"`
+	// TCPPorts are the local ports whose client connections make tcp.idle
+	// atoms fail.
+	TCPPorts []uint16 `json:"tcp_ports,omitempty"`
+
+	// Rule is the shutdown rule; nil means the default of CPU idle for
+	// CPUCheckMinutes and no users for UserCheckMinutes. Scoring
+	// configures its score.idle atoms.
+	Rule             rules.Expr           `json:"-"`
+	Scoring          config.ScoringConfig `json:"-"`
+	CPUCheckMinutes  int                  `json:"cpu_check_minutes"`
+	UserCheckMinutes int                  `json:"user_check_minutes"`
+	GraceMinutes     int                  `json:"grace_minutes"`
+
+	// Learning is true while shutdown evaluation is paused until
+	// LearningEnds.
+	Learning     bool      `json:"learning"`
+	LearningEnds time.Time `json:"learning_ends,omitempty"`
+
+	// PendingSince is when the pending shutdown's grace period started;
+	// zero if none is pending.
+	PendingSince time.Time `json:"pending_since,omitempty"`
+}
+
+// rule returns the rule in effect.
+func (p Policy) rule() rules.Expr {
+	if p.Rule != nil {
+		return p.Rule
+	}
+	return rules.Default(p.CPUCheckMinutes, p.UserCheckMinutes)
+}
+
+// Condition is one idle check of the rule with its evidence. Score atoms
+// fill in Score and only the Minutes and Idle of the window check.
+type Condition struct {
+	Atom   string          `json:"atom"`
+	Signal string          `json:"signal"`
+	Score  *scoring.Result `json:"score,omitempty"`
+	// Unavailable is set when the signal cannot be measured on this
+	// host, such as PSI on an older kernel, and counts as idle.
+	Unavailable bool `json:"unavailable,omitempty"`
+	monitor.WindowCheck
+}
+
+// Monitors are the sources of the idle checks. PSI may be nil, in which
+// case psi atoms count as unavailable; Memory, Net and TCP are needed only
+// for rules with memory, net and tcp atoms.
+type Monitors struct {
+	CPU    *monitor.CPUMonitor
+	Users  *monitor.UserMonitor
+	PSI    *monitor.PSIMonitor
+	Memory *monitor.MemoryMonitor
+	Net    *monitor.NetMonitor
+	TCP    *monitor.TCPMonitor
+}
+
+// Report is the explanation at one point in time.
+type Report struct {
+	Time   time.Time `json:"time"`
+	Policy Policy    `json:"policy"`
+
+	// Rule is the rule evaluated and DecidedBy the part of it that decided
+	// the verdict.
+	Rule       string      `json:"rule"`
+	DecidedBy  string      `json:"decided_by"`
+	Conditions []Condition `json:"conditions"`
+
+	// ShuttingDown is true when the next evaluation shuts the VM down or
+	// a grace period is running.
+	ShuttingDown bool `json:"shutting_down"`
+	// ShutdownAt estimates when the VM shuts down if no further sample
+	// breaks idleness; zero if that cannot be estimated because the rule
+	// waits for activity or a score.
+	ShutdownAt time.Time `json:"shutdown_at"`
+	Verdict    string    `json:"verdict"`
+}
+
+// Build evaluates the policy's rule at now.
+func Build(now time.Time, policy Policy, mons Monitors) Report {
+	rule := policy.rule()
+	r := Report{Time: now, Policy: policy, Rule: rule.String()}
+
+	checks := map[string]Condition{}
+	for _, atom := range rules.Atoms(rule) {
+		key := atom.String()
+		if _, ok := checks[key]; ok {
+			continue
+		}
+		c := Condition{Atom: key, Signal: atom.Signal}
+		switch atom.Signal {
+		case "cpu":
+			c.WindowCheck = mons.CPU.CheckAt(now, policy.CPUThreshold, atom.Minutes())
+		case "users":
+			c.WindowCheck = mons.Users.CheckAt(now, atom.Minutes())
+		case "psi":
+			c.Unavailable = mons.PSI == nil || !mons.PSI.Available()
+			c.WindowCheck = monitor.WindowCheck{Minutes: atom.Minutes(), Idle: true, IdleAt: now}
+			if !c.Unavailable {
+				c.WindowCheck = mons.PSI.CheckAt(now, policy.PSIThreshold, atom.Minutes())
+			}
+		case "memory":
+			c.WindowCheck = mons.Memory.CheckAt(now, atom.Minutes())
+		case "net":
+			c.WindowCheck = mons.Net.CheckAt(now, atom.Minutes())
+		case "tcp":
+			c.WindowCheck = mons.TCP.CheckAt(now, atom.Minutes())
+		case "score":
+			score := scoring.Evaluate(now, policy.Scoring, policy.CPUThreshold, atom.Minutes(), mons.CPU, mons.Users)
+			c.Score = &score
+			c.WindowCheck = monitor.WindowCheck{Minutes: score.Minutes, Idle: score.Idle, IdleAt: now}
+		}
+		checks[key] = c
+		r.Conditions = append(r.Conditions, c)
+	}
+
+	met, decided := rule.Eval(checkEnv(checks))
+	r.DecidedBy = decided.String()
+	// Windows are named in the verdict unless the rule is the default,
+	// where each signal has only one.
+	named := rule.String() != rules.Default(policy.CPUCheckMinutes, policy.UserCheckMinutes).String()
+	why := describe(decided, met, checks, named)
+
+	grace := time.Duration(policy.GraceMinutes) * time.Minute
+	idleAt, predictable := estimateIdleAt(rule, now, checks)
+	if policy.Learning && policy.LearningEnds.After(idleAt) {
+		idleAt = policy.LearningEnds
+	}
+
+	switch {
+	case policy.Learning:
+		if predictable {
+			r.ShutdownAt = idleAt.Add(grace)
+		}
+		r.Verdict = fmt.Sprintf("Not shutting down: learning phase, shutdown evaluation paused for %s",
+			clock.FormatDuration(policy.LearningEnds.Sub(now)))
+	case met:
+		r.ShuttingDown = true
+		r.ShutdownAt = now
+		if !policy.PendingSince.IsZero() {
+			r.ShutdownAt = policy.PendingSince.Add(grace)
+		} else if grace > 0 {
+			r.ShutdownAt = now.Add(grace)
+		}
+		r.Verdict = "Shutting down: " + why
+		if r.ShutdownAt.After(now) {
+			r.Verdict += fmt.Sprintf(", grace period ends in %s", clock.FormatDuration(r.ShutdownAt.Sub(now)))
+		}
+	default:
+		if predictable {
+			r.ShutdownAt = idleAt.Add(grace)
+		}
+		r.Verdict = "Not shutting down: " + why
+	}
+	return r
+}
+
+// checkEnv answers atoms from the checks already made, keyed by atom.
+func checkEnv(checks map[string]Condition) rules.Env {
+	return rules.EnvFunc(func(signal string, window time.Duration) bool {
+		return checks[rules.Atom{Signal: signal, Window: window}.String()].Idle
+	})
+}
+
+// estimateIdleAt returns when x becomes true if no further sample breaks
+// idleness. A negation that is not yet true waits for activity, which
+// cannot be predicted, and neither can a score that has not reached its
+// target.
+func estimateIdleAt(x rules.Expr, now time.Time, checks map[string]Condition) (time.Time, bool) {
+	switch x := x.(type) {
+	case rules.Atom:
+		c := checks[x.String()]
+		return c.IdleAt, c.Score == nil || c.Idle
+	case rules.Not:
+		val, _ := x.Eval(checkEnv(checks))
+		return now, val
+	case rules.And:
+		var latest time.Time
+		for _, y := range x {
+			at, ok := estimateIdleAt(y, now, checks)
+			if !ok {
+				return time.Time{}, false
+			}
+			if at.After(latest) {
+				latest = at
+			}
+		}
+		return latest, true
+	case rules.Or:
+		var earliest time.Time
+		found := false
+		for _, y := range x {
+			if at, ok := estimateIdleAt(y, now, checks); ok && (!found || at.Before(earliest)) {
+				earliest, found = at, true
+			}
+		}
+		return earliest, found
+	}
+	return time.Time{}, false
+}
+
+// describe words the deciding expression x for the verdict: the state of
+// each check in it, joined by "and" if x holds and by commas if every part
+// blocks.
+func describe(x rules.Expr, met bool, checks map[string]Condition, named bool) string {
+	var parts []rules.Expr
+	switch x := x.(type) {
+	case rules.Atom:
+		return conditionPhrase(x, checks[x.String()], named)
+	case rules.Not:
+		return describe(x.X, !met, checks, named)
+	case rules.And:
+		parts = x
+	case rules.Or:
+		parts = x
+	}
+	words := make([]string, len(parts))
+	for i, y := range parts {
+		words[i] = describe(y, met, checks, named)
+	}
+	if met {
+		return strings.Join(words, " and ")
+	}
+	return strings.Join(words, ", ")
+}
+
+// conditionPhrase words the state of one idle check.
+func conditionPhrase(atom rules.Atom, check Condition, named bool) string {
+	if s := check.Score; s != nil {
+		op := "<"
+		if s.Idle {
+			op = "≥"
+		}
+		phrase := fmt.Sprintf("idle score %.2f %s %.2f", s.Value, op, s.Target)
+		if named {
+			phrase += " (" + atom.String() + ")"
+		}
+		return phrase
+	}
+
+	what, idle, busy := "CPU", "CPU below threshold", "CPU above threshold"
+	switch atom.Signal {
+	case "users":
+		what, idle, busy = "users", "no users logged in", "users logged in"
+	case "psi":
+		what, idle, busy = "PSI", "pressure stall below threshold", "pressure stall above threshold"
+	case "memory":
+		what, idle, busy = "memory", "paging and swapping below limits", "paging or swapping above limits"
+	case "net":
+		what, idle, busy = "network", "network traffic below limits", "network traffic above limits"
+	case "tcp":
+		what, idle, busy = "TCP", "no client connections", "clients connected"
+	}
+	phrase := busy
+	switch {
+	case check.Unavailable:
+		phrase = "no PSI on this kernel"
+	case check.Idle:
+		phrase = idle
+	case check.LastBreak == nil:
+		phrase = fmt.Sprintf("too few %s samples (%d of %d)", what, check.Samples, check.MinSamples)
+	}
+	if named {
+		phrase += " (" + atom.String() + ")"
+	}
+	return phrase
+}
+
+// Write prints the report for humans.
+func (r Report) Write(w io.Writer) {
+	p := r.Policy
+	fmt.Fprintf(w, "%s\n\n", r.Verdict)
+	fmt.Fprintf(w, "Time:       %s\n", r.Time.UTC().Format("2006-01-02 15:04:05 UTC"))
+	fmt.Fprintf(w, "Mode:       %s, cpu_threshold %d%% from %s", p.Mode, p.CPUThreshold, p.ThresholdSource)
+	if p.CPUMetric != "" && p.CPUMetric != monitor.MetricAggregate {
+		fmt.Fprintf(w, ", on %s", p.CPUMetric)
+	}
+	fmt.Fprintln(w)
+	if p.Learning {
+		fmt.Fprintf(w, "Learning:   %s left, until %s; the threshold above is a placeholder\n",
+			clock.FormatDuration(p.LearningEnds.Sub(r.Time)), formatClock(p.LearningEnds, r.Time))
+	}
+	fmt.Fprintf(w, "Rule:       %s\n", r.Rule)
+	if !p.PendingSince.IsZero() {
+		fmt.Fprintf(w, "Pending:    since %s, %d min grace period\n", formatClock(p.PendingSince, r.Time), p.GraceMinutes)
+	}
+	for _, c := range r.Conditions {
+		if c.Signal == "psi" {
+			fmt.Fprintf(w, "PSI:        threshold %g%% of %s stall from %s\n",
+				p.PSIThreshold, strings.Join(p.PSIResources, ", "), p.PSIThresholdSource)
+			break
+		}
+	}
+	for _, c := range r.Conditions {
+		if c.Signal == "memory" {
+			fmt.Fprintf(w, "Memory:     limits %s\n", p.MemoryLimits)
+			break
+		}
+	}
+	for _, c := range r.Conditions {
+		if c.Signal == "net" {
+			fmt.Fprintf(w, "Network:    limits %s\n", p.NetLimits)
+			break
+		}
+	}
+	fmt.Fprintln(w)
+
+	for _, c := range r.Conditions {
+		if c.Score != nil {
+			writeScore(w, *c.Score, p.CPUThreshold)
+			continue
+		}
+		if c.Signal == "cpu" {
+			what := metricName(p.CPUMetric)
+			writeCondition(w, fmt.Sprintf("%s below %d%% for %d min", what, p.CPUThreshold, c.Minutes), c.WindowCheck, r.Time,
+				func(b *monitor.Breach) string {
+					return fmt.Sprintf("%s %.1f%% ≥ %d%% at %s", what, b.Value, p.CPUThreshold, formatClock(b.Time, r.Time))
+				})
+			continue
+		}
+		if c.Signal == "psi" {
+			title := fmt.Sprintf("Pressure stall below %g%% for %d min", p.PSIThreshold, c.Minutes)
+			if c.Unavailable {
+				fmt.Fprintf(w, "%s: met, PSI is not available on this kernel\n\n", title)
+				continue
+			}
+			writeCondition(w, title, c.WindowCheck, r.Time,
+				func(b *monitor.Breach) string {
+					return fmt.Sprintf("Stall %.1f%% ≥ %g%% at %s", b.Value, p.PSIThreshold, formatClock(b.Time, r.Time))
+				})
+			continue
+		}
+		if c.Signal == "memory" {
+			writeCondition(w, fmt.Sprintf("Paging and swapping below limits for %d min", c.Minutes), c.WindowCheck, r.Time,
+				func(b *monitor.Breach) string {
+					name, unit := memoryMetricName(b.Metric)
+					return fmt.Sprintf("%s %.1f %s ≥ %g at %s", name, b.Value, unit,
+						p.MemoryLimits.Limit(b.Metric), formatClock(b.Time, r.Time))
+				})
+			continue
+		}
+		if c.Signal == "net" {
+			writeCondition(w, fmt.Sprintf("Network traffic below limits for %d min", c.Minutes), c.WindowCheck, r.Time,
+				func(b *monitor.Breach) string {
+					return fmt.Sprintf("Traffic %.1f %s/s ≥ %g at %s", b.Value, b.Metric,
+						p.NetLimits.Limit(b.Metric), formatClock(b.Time, r.Time))
+				})
+			continue
+		}
+		if c.Signal == "tcp" {
+			writeCondition(w, fmt.Sprintf("No connections on ports %s for %d min", formatPorts(p.TCPPorts), c.Minutes), c.WindowCheck, r.Time,
+				func(b *monitor.Breach) string {
+					return fmt.Sprintf("%d connected (%s) at %s", int(b.Value), strings.Join(b.Users, ", "), formatClock(b.Time, r.Time))
+				})
+			continue
+		}
+		writeCondition(w, fmt.Sprintf("No users for %d min", c.Minutes), c.WindowCheck, r.Time,
+			func(b *monitor.Breach) string {
+				return fmt.Sprintf("%d logged in (%s) at %s", int(b.Value), strings.Join(b.Users, ", "), formatClock(b.Time, r.Time))
+			})
+	}
+
+	switch {
+	case r.ShuttingDown:
+	case r.ShutdownAt.IsZero():
+		fmt.Fprintf(w, "Shutdown:   cannot be estimated, it depends on future activity (%s)\n", r.DecidedBy)
+	default:
+		fmt.Fprintf(w, "Shutdown:   %s at the earliest (in %s), if nothing breaks idleness meanwhile\n",
+			formatClock(r.ShutdownAt, r.Time), clock.FormatDuration(r.ShutdownAt.Sub(r.Time)))
+	}
+}
+
+// metricName names a CPU metric in the condition lines.
+func metricName(metric string) string {
+	switch metric {
+	case monitor.MetricMaxCore:
+		return "Busiest core"
+	case monitor.MetricLoad:
+		return "Load per core"
+	case monitor.MetricCgroup:
+		return "Cgroup CPU"
+	}
+	return "CPU"
+}
+
+// formatPorts lists ports as "5432, 443".
+func formatPorts(ports []uint16) string {
+	s := make([]string, len(ports))
+	for i, p := range ports {
+		s[i] = fmt.Sprint(p)
+	}
+	return strings.Join(s, ", ")
+}
+
+// memoryMetricName names a memory activity metric and its unit in the
+// condition lines.
+func memoryMetricName(metric string) (name, unit string) {
+	switch metric {
+	case monitor.MemorySwap:
+		return "Swap", "pages/s"
+	case monitor.MemoryMajorFaults:
+		return "Major faults", "faults/s"
+	}
+	return "Page faults", "faults/s"
+}
+
+// writeScore prints the idle score with each signal's part of it.
+func writeScore(w io.Writer, score scoring.Result, threshold int) {
+	status := "met"
+	if !score.Idle {
+		status = "NOT met"
+	}
+	fmt.Fprintf(w, "Idle score ≥ %.2f over %d min: %s (%.2f)\n", score.Target, score.Minutes, status, score.Value)
+	for _, s := range score.Signals {
+		what := "without users"
+		if s.Name == "cpu" {
+			what = fmt.Sprintf("below %d%%", threshold)
+		}
+		fmt.Fprintf(w, "  %-10s %.2f, weight %g, %s: %d of %d samples %s",
+			s.Name+":", s.Value, s.Weight, s.Method, s.IdleSamples, s.Samples, what)
+		if s.Tolerated > 0 {
+			fmt.Fprintf(w, " (%d in tolerated spikes)", s.Tolerated)
+		}
+		if s.Samples < s.MinSamples {
+			fmt.Fprintf(w, ", %d needed", s.MinSamples)
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintln(w)
+}
+
+// writeCondition prints one idle condition with its evidence.
+func writeCondition(w io.Writer, title string, check monitor.WindowCheck, now time.Time, describe func(*monitor.Breach) string) {
+	status := "met"
+	if !check.Idle {
+		status = "NOT met"
+	}
+	fmt.Fprintf(w, "%s: %s\n", title, status)
+	fmt.Fprintf(w, "  samples:   %d in window (%d needed)\n", check.Samples, check.MinSamples)
+	if check.Tolerated > 0 {
+		fmt.Fprintf(w, "  tolerated: %d samples in short spikes\n", check.Tolerated)
+	}
+	if check.FirstBreak != nil {
+		fmt.Fprintf(w, "  first:     %s\n", describe(check.FirstBreak))
+		if check.Breaks > 1 {
+			fmt.Fprintf(w, "  last:      %s (%d samples broke idleness)\n", describe(check.LastBreak), check.Breaks)
+		}
+	}
+	for _, gap := range check.Gaps {
+		fmt.Fprintf(w, "  gap:       no samples %s – %s (%s)\n",
+			formatClock(gap.From, now), formatClock(gap.To, now), clock.FormatDuration(gap.To.Sub(gap.From)))
+	}
+	if !check.Idle {
+		fmt.Fprintf(w, "  met in:    %s (%s)\n", clock.FormatDuration(check.IdleAt.Sub(now)), formatClock(check.IdleAt, now))
+	}
+	fmt.Fprintln(w)
+}
+
+// formatClock shows t as a UTC time of day, with the date if it is not on
+// the same day as now.
+func formatClock(t, now time.Time) string {
+	t, now = t.UTC(), now.UTC()
+	if t.YearDay() == now.YearDay() && t.Year() == now.Year() {
+		return t.Format("15:04")
+	}
+	return t.Format("2006-01-02 15:04")
+}
@@ -0,0 +1,386 @@
+package explain
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"idleshutdown/internal/clock"
+	"idleshutdown/internal/config"
+	"idleshutdown/internal/monitor"
+	"idleshutdown/internal/rules"
+)
+
+var testNow = time.Date(2026, 2, 19, 14, 30, 0, 0, time.UTC)
+
+// monitors returns monitors holding an hour of samples every 30s up to
+// testNow, with cpu(i) and users(i) giving the values of sample i.
+func monitors(cpu func(i int) float64, users func(i int) []string) (*monitor.CPUMonitor, *monitor.UserMonitor) {
+	cpuMon := monitor.NewCPUMonitor(30 * time.Second)
+	cpuMon.Clock = clock.NewFake(testNow)
+	userMon := monitor.NewUserMonitor(30 * time.Second)
+	userMon.Clock = clock.NewFake(testNow)
+
+	var cpuSamples []monitor.CPUSample
+	var userSamples []monitor.UserSample
+	for i := 0; i < 120; i++ {
+		ts := testNow.Add(-time.Duration(119-i) * 30 * time.Second)
+		cpuSamples = append(cpuSamples, monitor.CPUSample{Timestamp: ts, Usage: cpu(i)})
+		names := users(i)
+		userSamples = append(userSamples, monitor.UserSample{Timestamp: ts, Count: len(names), Users: names})
+	}
+	cpuMon.AddSamples(cpuSamples)
+	userMon.AddSamples(userSamples)
+	return cpuMon, userMon
+}
+
+var manual = Policy{
+	Mode: "manual", ThresholdSource: "config.ini", CPUThreshold: 25,
+	CPUCheckMinutes: 60, UserCheckMinutes: 60,
+}
+
+func TestBuildBusy(t *testing.T) {
+	cpuMon, userMon := monitors(
+		func(i int) float64 {
+			if i == 63 { // 14:02
+				return 31.2
+			}
+			return 3
+		},
+		func(i int) []string {
+			if i < 20 {
+				return []string{"alice"}
+			}
+			return nil
+		})
+
+	r := Build(testNow, manual, Monitors{CPU: cpuMon, Users: userMon})
+	if r.ShuttingDown || len(r.Conditions) != 2 || r.Conditions[0].Idle || r.Conditions[1].Idle {
+		t.Fatalf("report = %+v", r)
+	}
+	if r.Verdict != "Not shutting down: CPU above threshold, users logged in" {
+		t.Errorf("verdict = %q", r.Verdict)
+	}
+	// The CPU spike leaves the window last, an hour after 14:02
+	if want := time.Date(2026, 2, 19, 15, 2, 0, 0, time.UTC); !r.ShutdownAt.Equal(want) {
+		t.Errorf("shutdown at %v, want %v", r.ShutdownAt, want)
+	}
+
+	var out strings.Builder
+	r.Write(&out)
+	text := out.String()
+	for _, want := range []string{
+		"CPU below 25% for 60 min: NOT met",
+		"first:     CPU 31.2% ≥ 25% at 14:02",
+		"first:     1 logged in (alice) at 13:30",
+		"last:      1 logged in (alice) at 13:40 (20 samples broke idleness)",
+		"met in:    32m (15:02)",
+		"Shutdown:   15:02 at the earliest (in 32m)",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("output missing %q:\n%s", want, text)
+		}
+	}
+}
+
+func TestBuildMaxCore(t *testing.T) {
+	// Samples without per-core data fall back to the aggregate
+	cpuMon, userMon := monitors(func(i int) float64 {
+		if i == 63 {
+			return 31.2
+		}
+		return 3
+	}, func(int) []string { return nil })
+	cpuMon.SetMetric(monitor.MetricMaxCore)
+	policy := manual
+	policy.CPUMetric = monitor.MetricMaxCore
+
+	var out strings.Builder
+	Build(testNow, policy, Monitors{CPU: cpuMon, Users: userMon}).Write(&out)
+	text := out.String()
+	for _, want := range []string{
+		"cpu_threshold 25% from config.ini, on max_core",
+		"Busiest core below 25% for 60 min: NOT met",
+		"first:     Busiest core 31.2% ≥ 25% at 14:02",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("output missing %q:\n%s", want, text)
+		}
+	}
+}
+
+func TestBuildIdleWithGrace(t *testing.T) {
+	cpuMon, userMon := monitors(func(int) float64 { return 3 }, func(int) []string { return nil })
+	policy := manual
+	policy.GraceMinutes = 5
+	policy.PendingSince = testNow.Add(-2 * time.Minute)
+
+	r := Build(testNow, policy, Monitors{CPU: cpuMon, Users: userMon})
+	if !r.ShuttingDown || !r.ShutdownAt.Equal(testNow.Add(3*time.Minute)) {
+		t.Errorf("report = %+v", r)
+	}
+	if !strings.Contains(r.Verdict, "grace period ends in 3m") {
+		t.Errorf("verdict = %q", r.Verdict)
+	}
+}
+
+func TestBuildLearningAndGaps(t *testing.T) {
+	cpuMon, userMon := monitors(func(int) float64 { return 3 }, func(int) []string { return nil })
+	// Samples stop 10 minutes before the check
+	now := testNow.Add(10 * time.Minute)
+	policy := manual
+	policy.Mode = "auto"
+	policy.Learning = true
+	policy.LearningEnds = now.Add(3 * time.Hour)
+
+	r := Build(now, policy, Monitors{CPU: cpuMon, Users: userMon})
+	if r.ShuttingDown || !strings.HasPrefix(r.Verdict, "Not shutting down: learning phase") {
+		t.Errorf("verdict = %q", r.Verdict)
+	}
+	if !r.ShutdownAt.Equal(policy.LearningEnds) {
+		t.Errorf("shutdown at %v, want the end of learning", r.ShutdownAt)
+	}
+	if gaps := r.Conditions[0].Gaps; len(gaps) != 1 || !gaps[0].From.Equal(testNow) || !gaps[0].To.Equal(now) {
+		t.Errorf("gaps = %+v", gaps)
+	}
+
+	var out strings.Builder
+	r.Write(&out)
+	if text := out.String(); !strings.Contains(text, "gap:       no samples 14:30 – 14:40 (10m)") ||
+		!strings.Contains(text, "Learning:   3h 0m left") {
+		t.Errorf("output:\n%s", text)
+	}
+}
+
+func TestBuildCustomRule(t *testing.T) {
+	// Busy as in TestBuildBusy, but the rule only needs half an hour
+	// without users
+	cpuMon, userMon := monitors(
+		func(i int) float64 {
+			if i == 63 {
+				return 31.2
+			}
+			return 3
+		},
+		func(i int) []string {
+			if i < 20 {
+				return []string{"alice"}
+			}
+			return nil
+		})
+	policy := manual
+	policy.Rule = rules.Or{rules.Atom{Signal: "users", Window: 30 * time.Minute}, rules.Default(60, 60)}
+
+	r := Build(testNow, policy, Monitors{CPU: cpuMon, Users: userMon})
+	if !r.ShuttingDown || r.DecidedBy != "users.idle(30m)" || len(r.Conditions) != 3 {
+		t.Errorf("report = %+v", r)
+	}
+	if r.Verdict != "Shutting down: no users logged in (users.idle(30m))" {
+		t.Errorf("verdict = %q", r.Verdict)
+	}
+
+	// A negated check waits for activity, so no shutdown time is estimated
+	cpuMon, userMon = monitors(func(int) float64 { return 3 }, func(int) []string { return nil })
+	policy.Rule = rules.And{rules.Atom{Signal: "cpu", Window: time.Hour}, rules.Not{X: rules.Atom{Signal: "users", Window: time.Hour}}}
+
+	r = Build(testNow, policy, Monitors{CPU: cpuMon, Users: userMon})
+	if r.ShuttingDown || !r.ShutdownAt.IsZero() || r.Verdict != "Not shutting down: no users logged in (users.idle(1h))" {
+		t.Errorf("report = %+v", r)
+	}
+	var out strings.Builder
+	r.Write(&out)
+	if text := out.String(); !strings.Contains(text, "Rule:       cpu.idle(1h) && !users.idle(1h)") ||
+		!strings.Contains(text, "Shutdown:   cannot be estimated, it depends on future activity (!users.idle(1h))") {
+		t.Errorf("output:\n%s", text)
+	}
+}
+
+func TestBuildScore(t *testing.T) {
+	// Busy as in TestBuildBusy, which the score tolerates
+	cpuMon, userMon := monitors(
+		func(i int) float64 {
+			if i == 63 {
+				return 31.2
+			}
+			return 3
+		},
+		func(i int) []string {
+			if i < 20 {
+				return []string{"alice"}
+			}
+			return nil
+		})
+	policy := manual
+	policy.Rule = rules.Atom{Signal: "score", Window: time.Hour}
+	policy.Scoring = config.ScoringConfig{
+		Target: 0.9,
+		CPU:    config.SignalScoring{Weight: 1, Method: monitor.ScoreFraction},
+		Users:  config.SignalScoring{Weight: 1, Method: monitor.ScoreFraction},
+	}
+
+	r := Build(testNow, policy, Monitors{CPU: cpuMon, Users: userMon})
+	if !r.ShuttingDown || r.Verdict != "Shutting down: idle score 0.91 ≥ 0.90 (score.idle(1h))" {
+		t.Errorf("report = %+v", r)
+	}
+	var out strings.Builder
+	r.Write(&out)
+	if text := out.String(); !strings.Contains(text, "Idle score ≥ 0.90 over 60 min: met (0.91)") ||
+		!strings.Contains(text, "users:     0.83, weight 1, fraction: 100 of 120 samples without users") {
+		t.Errorf("output:\n%s", text)
+	}
+
+	policy.Scoring.Target = 0.95
+	if r := Build(testNow, policy, Monitors{CPU: cpuMon, Users: userMon}); r.ShuttingDown || !r.ShutdownAt.IsZero() {
+		t.Errorf("report = %+v", r)
+	}
+}
+
+func TestBuildPSI(t *testing.T) {
+	cpuMon, userMon := monitors(func(int) float64 { return 3 }, func(int) []string { return nil })
+	psiMon := monitor.NewPSIMonitor(30 * time.Second)
+	psiMon.Clock = clock.NewFake(testNow)
+	var samples []monitor.PSISample
+	for i := 0; i < 120; i++ {
+		s := monitor.PSISample{Timestamp: testNow.Add(-time.Duration(119-i) * 30 * time.Second)}
+		if i == 100 { // 14:20
+			s.IO.Stall = 12.5
+		}
+		samples = append(samples, s)
+	}
+	psiMon.AddSamples(samples)
+
+	policy := manual
+	policy.Rule = rules.And{rules.Atom{Signal: "cpu", Window: time.Hour}, rules.Atom{Signal: "psi", Window: 30 * time.Minute}}
+	policy.PSIThreshold, policy.PSIThresholdSource, policy.PSIResources = 5, "config.ini", monitor.PSIResources
+
+	r := Build(testNow, policy, Monitors{CPU: cpuMon, Users: userMon, PSI: psiMon})
+	if r.ShuttingDown || r.Verdict != "Not shutting down: pressure stall above threshold (psi.idle(30m))" {
+		t.Errorf("report = %+v", r)
+	}
+	var out strings.Builder
+	r.Write(&out)
+	for _, want := range []string{
+		"PSI:        threshold 5% of cpu, io, memory stall from config.ini",
+		"Pressure stall below 5% for 30 min: NOT met",
+		"first:     Stall 12.5% ≥ 5% at 14:20",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, out.String())
+		}
+	}
+
+	// Without PSI the check does not hold the shutdown back
+	r = Build(testNow, policy, Monitors{CPU: cpuMon, Users: userMon})
+	if !r.ShuttingDown || !r.Conditions[1].Unavailable {
+		t.Errorf("report without PSI = %+v", r)
+	}
+}
+
+func TestBuildMemory(t *testing.T) {
+	cpuMon, userMon := monitors(func(int) float64 { return 3 }, func(int) []string { return nil })
+	memMon := monitor.NewMemoryMonitor(30 * time.Second)
+	memMon.Clock = clock.NewFake(testNow)
+	memMon.SetLimits(monitor.MemoryLimits{MajorFaults: 50, Swap: 100})
+	var samples []monitor.MemorySample
+	for i := 0; i < 120; i++ {
+		s := monitor.MemorySample{Timestamp: testNow.Add(-time.Duration(119-i) * 30 * time.Second), PageFaults: 900}
+		if i == 100 { // 14:20
+			s.SwapIn, s.SwapOut = 150, 30
+		}
+		samples = append(samples, s)
+	}
+	memMon.AddSamples(samples)
+
+	policy := manual
+	policy.Rule = rules.And{rules.Atom{Signal: "cpu", Window: time.Hour}, rules.Atom{Signal: "memory", Window: 30 * time.Minute}}
+	policy.MemoryLimits = memMon.Limits()
+
+	r := Build(testNow, policy, Monitors{CPU: cpuMon, Users: userMon, Memory: memMon})
+	if r.ShuttingDown || r.Verdict != "Not shutting down: paging or swapping above limits (memory.idle(30m))" {
+		t.Errorf("report = %+v", r)
+	}
+	var out strings.Builder
+	r.Write(&out)
+	for _, want := range []string{
+		"Memory:     limits swap 100 pages/s, major faults 50/s",
+		"Paging and swapping below limits for 30 min: NOT met",
+		"first:     Swap 180.0 pages/s ≥ 100 at 14:20",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestBuildNet(t *testing.T) {
+	cpuMon, userMon := monitors(func(int) float64 { return 3 }, func(int) []string { return []string{"alice"} })
+	netMon := monitor.NewNetMonitor(30 * time.Second)
+	netMon.Clock = clock.NewFake(testNow)
+	netMon.SetLimits(monitor.NetLimits{Bytes: 10000})
+	var samples []monitor.NetSample
+	for i := 0; i < 120; i++ {
+		s := monitor.NetSample{Timestamp: testNow.Add(-time.Duration(119-i) * 30 * time.Second), RxBytes: 300, TxBytes: 200}
+		if i == 100 { // 14:20
+			s.RxBytes = 2_500_000
+		}
+		samples = append(samples, s)
+	}
+	netMon.AddSamples(samples)
+
+	policy := manual
+	policy.Rule = rules.Or{
+		rules.Atom{Signal: "users", Window: 30 * time.Minute},
+		rules.And{rules.Atom{Signal: "cpu", Window: time.Hour}, rules.Atom{Signal: "net", Window: time.Hour}},
+	}
+	policy.NetLimits = netMon.Limits()
+
+	r := Build(testNow, policy, Monitors{CPU: cpuMon, Users: userMon, Net: netMon})
+	if r.ShuttingDown || r.DecidedBy != "users.idle(30m) || net.idle(1h)" {
+		t.Errorf("report = %+v", r)
+	}
+	var out strings.Builder
+	r.Write(&out)
+	for _, want := range []string{
+		"Network:    limits 10000 bytes/s",
+		"Network traffic below limits for 60 min: NOT met",
+		"first:     Traffic 2500200.0 bytes/s ≥ 10000 at 14:20",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestBuildTCP(t *testing.T) {
+	cpuMon, userMon := monitors(func(int) float64 { return 3 }, func(int) []string { return nil })
+	tcpMon := monitor.NewTCPMonitor(30 * time.Second)
+	tcpMon.Clock = clock.NewFake(testNow)
+	var samples []monitor.TCPSample
+	for i := 0; i < 120; i++ {
+		s := monitor.TCPSample{Timestamp: testNow.Add(-time.Duration(119-i) * 30 * time.Second)}
+		if i == 118 { // 14:29
+			s.Count, s.Peers = 2, []string{"192.168.1.20 → :5432"}
+		}
+		samples = append(samples, s)
+	}
+	tcpMon.AddSamples(samples)
+
+	policy := manual
+	policy.Rule = rules.And{rules.Atom{Signal: "cpu", Window: time.Hour}, rules.Atom{Signal: "tcp", Window: 5 * time.Minute}}
+	policy.TCPPorts = []uint16{5432, 443}
+
+	r := Build(testNow, policy, Monitors{CPU: cpuMon, Users: userMon, TCP: tcpMon})
+	if r.ShuttingDown || r.Verdict != "Not shutting down: clients connected (tcp.idle(5m))" {
+		t.Errorf("report = %+v", r)
+	}
+	var out strings.Builder
+	r.Write(&out)
+	for _, want := range []string{
+		"No connections on ports 5432, 443 for 5 min: NOT met",
+		"first:     2 connected (192.168.1.20 → :5432) at 14:29",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+// JournalSocket is the socket journald receives native-protocol datagrams on.
+var JournalSocket = "/run/systemd/journal/socket"
+
+// JournalIdentifier is sent as SYSLOG_IDENTIFIER, matching the unit's
+// SyslogIdentifier= so journalctl -t finds both native and stream records.
+const JournalIdentifier = "IdleShutdown"
+
+// fieldPrefix is prepended to record attributes to form journal field names.
+const fieldPrefix = "IDLESHUTDOWN_"
+
+// maxTruncatedValue is the length field values are cut to when a record
+// is too large for one datagram.
+const maxTruncatedValue = 4096
+
+// MessageIDs maps each event name to the MESSAGE_ID its records carry, so
+// `journalctl MESSAGE_ID=<id>` selects one kind of record. The IDs are part
+// of the log interface and must never change.
+var MessageIDs = map[string]string{
+	Startup:     "f1747b39628a4cc1939644013a5d5a3f",
+	Config:      "de7fc43ddbfe44a587b246ab82e7964f",
+	Evaluation:  "9a5d5a1c534e43b193502002e9f35d83",
+	CPUCheck:    "f531fc9f3e8e4275913332e00cffda41",
+	UserCheck:   "4fc4ced937cb490c8a34569156ca368d",
+	PSICheck:    "5c0e1b7a9d3f4e62a8b1c47d2e9f6a13",
+	MemoryCheck: "a3d96f0e2b7c4185b94e6c0d71f28a5e",
+	NetCheck:    "0b8e4d2f71a94c6e9d53a1f7c8e26b40",
+	TCPCheck:    "6e1f4c2a8b9d47d3a05f3e7c92b8d614",
+	Sampling:    "e7f2cd576543477d85a2ae342a92902a",
+	Calibration: "f14f59a9328345e4b1c64195994d1571",
+	Shutdown:    "d361d0f7cb2043e09dc7f33739d47b7c",
+	Notify:      "b3482dc96e1c483396b0d8c8a4f6ebbf",
+	API:         "98f139dbd9734ca8b7656588ad6fa5bf",
+}
+
+// JournalHandler is a slog.Handler that sends each record to journald as
+// one datagram in the native protocol, with PRIORITY, SYSLOG_IDENTIFIER,
+// MESSAGE_ID for the record's event and every attribute as an
+// IDLESHUTDOWN_<KEY> field.
+//
+// When journald restarts, the handler reconnects on the next record; a
+// record that cannot be sent even then is written to stderr instead. A
+// record too large for one datagram is sent with its field values
+// truncated and IDLESHUTDOWN_TRUNCATED=true.
+type JournalHandler struct {
+	conn     *journalConn
+	fallback slog.Handler
+	level    slog.Leveler
+	fields   []journalField // fields from WithAttrs
+	group    string         // field name prefix from WithGroup
+	event    string         // event set through WithAttrs
+}
+
+// journalField is one field of a journal record.
+type journalField struct {
+	name, value string
+}
+
+// journalConn is the connection to the journal socket, shared by a handler
+// and those derived from it.
+type journalConn struct {
+	path string
+	mu   sync.Mutex
+	conn *net.UnixConn // nil after a failed reconnect
+}
+
+// NewJournalHandler connects to the journal socket at path.
+func NewJournalHandler(path string, opts *slog.HandlerOptions) (*JournalHandler, error) {
+	conn, err := dialJournal(path)
+	if err != nil {
+		return nil, err
+	}
+	h := &JournalHandler{
+		conn:     &journalConn{path: path, conn: conn},
+		fallback: slog.NewTextHandler(os.Stderr, opts),
+		level:    slog.LevelInfo,
+	}
+	if opts != nil && opts.Level != nil {
+		h.level = opts.Level
+	}
+	return h, nil
+}
+
+func dialJournal(path string) (*net.UnixConn, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("connect to journal: %w", err)
+	}
+	return conn, nil
+}
+
+// write sends one datagram, reconnecting once if the socket has gone away,
+// e.g. because journald was restarted.
+func (c *journalConn) write(data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		_, err := c.conn.Write(data)
+		if err == nil || errors.Is(err, syscall.EMSGSIZE) {
+			return err
+		}
+		c.conn.Close()
+		c.conn = nil
+	}
+
+	conn, err := dialJournal(c.path)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	_, err = c.conn.Write(data)
+	return err
+}
+
+// Enabled reports whether records at level are sent.
+func (h *JournalHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+// Handle sends the record to the journal, or to stderr if the journal
+// cannot be reached.
+func (h *JournalHandler) Handle(ctx context.Context, r slog.Record) error {
+	fields := []journalField{
+		{"MESSAGE", r.Message},
+		{"PRIORITY", journalPriority(r.Level)},
+		{"SYSLOG_IDENTIFIER", JournalIdentifier},
+	}
+	fields = append(fields, h.fields...)
+
+	event := h.event
+	r.Attrs(func(a slog.Attr) bool {
+		if h.group == "" && a.Key == EventKey {
+			event = a.Value.String()
+		}
+		fields = appendAttr(fields, h.group, a)
+		return true
+	})
+	if id, ok := MessageIDs[event]; ok {
+		fields = append(fields, journalField{"MESSAGE_ID", id})
+	}
+
+	err := h.conn.write(encodeFields(fields, 0))
+	if errors.Is(err, syscall.EMSGSIZE) {
+		fields = append(fields, journalField{fieldPrefix + "TRUNCATED", "true"})
+		err = h.conn.write(encodeFields(fields, maxTruncatedValue))
+	}
+	if err != nil {
+		return h.fallback.Handle(ctx, r)
+	}
+	return nil
+}
+
+// WithAttrs returns a handler that adds attrs to every record.
+func (h *JournalHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := *h
+	next.fallback = h.fallback.WithAttrs(attrs)
+	next.fields = append([]journalField{}, h.fields...)
+	for _, a := range attrs {
+		if h.group == "" && a.Key == EventKey {
+			next.event = a.Value.String()
+		}
+		next.fields = appendAttr(next.fields, h.group, a)
+	}
+	return &next
+}
+
+// WithGroup returns a handler that prefixes field names with name.
+func (h *JournalHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	next := *h
+	next.fallback = h.fallback.WithGroup(name)
+	next.group = h.group + name + "_"
+	return &next
+}
+
+// appendAttr appends a as a journal field, flattening groups.
+func appendAttr(fields []journalField, group string, a slog.Attr) []journalField {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		prefix := group
+		if a.Key != "" {
+			prefix += a.Key + "_"
+		}
+		for _, ga := range v.Group() {
+			fields = appendAttr(fields, prefix, ga)
+		}
+		return fields
+	}
+	if a.Key == "" {
+		return fields
+	}
+
+	var val string
+	switch v.Kind() {
+	case slog.KindTime:
+		val = v.Time().UTC().Format(time.RFC3339)
+	case slog.KindAny:
+		val = fmt.Sprint(v.Any())
+	default:
+		val = v.String()
+	}
+	return append(fields, journalField{fieldName(group + a.Key), val})
+}
+
+// encodeFields encodes a record in the native protocol, cutting values
+// longer than limit bytes when limit is positive.
+func encodeFields(fields []journalField, limit int) []byte {
+	var buf bytes.Buffer
+	for _, f := range fields {
+		value := f.value
+		if limit > 0 && len(value) > limit {
+			cut := limit
+			for cut > 0 && !utf8.RuneStart(value[cut]) {
+				cut--
+			}
+			value = value[:cut] + "…"
+		}
+		writeField(&buf, f.name, value)
+	}
+	return buf.Bytes()
+}
+
+// fieldName turns an attribute key into a valid journal field name:
+// upper case letters, digits and underscores, with the IDLESHUTDOWN_ prefix.
+func fieldName(key string) string {
+	var b strings.Builder
+	b.WriteString(fieldPrefix)
+	for _, r := range strings.ToUpper(key) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// writeField appends one field in the native protocol. Values containing
+// a newline use the binary form: name, newline, little-endian length, value.
+func writeField(buf *bytes.Buffer, name, value string) {
+	buf.WriteString(name)
+	if strings.ContainsRune(value, '\n') {
+		buf.WriteByte('\n')
+		binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	} else {
+		buf.WriteByte('=')
+	}
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+// journalPriority maps a slog level to a syslog priority.
+func journalPriority(level slog.Level) string {
+	switch {
+	case level >= slog.LevelError:
+		return "3" // err
+	case level >= slog.LevelWarn:
+		return "4" // warning
+	case level >= slog.LevelInfo:
+		return "6" // info
+	default:
+		return "7" // debug
+	}
+}
+
+// connectedToJournal reports whether f is the stream systemd connected to
+// the journal, as advertised in $JOURNAL_STREAM ("<device>:<inode>").
+func connectedToJournal(f *os.File) bool {
+	stream := os.Getenv("JOURNAL_STREAM")
+	if stream == "" {
+		return false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+	return stream == fmt.Sprintf("%d:%d", st.Dev, st.Ino)
+}
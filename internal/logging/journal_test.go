@@ -0,0 +1,229 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// journalStandIn listens on a datagram socket the way journald does and
+// returns the fields of each datagram received.
+func journalStandIn(t *testing.T) (string, func() map[string]string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "journal.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	next := func() map[string]string {
+		t.Helper()
+		buf := make([]byte, 65536)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fields, err := parseJournalDatagram(buf[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fields
+	}
+	return path, next
+}
+
+// parseJournalDatagram decodes the native protocol, both KEY=value lines
+// and the binary KEY\n<len><value>\n form.
+func parseJournalDatagram(data []byte) (map[string]string, error) {
+	fields := map[string]string{}
+	for len(data) > 0 {
+		nl := bytes.IndexByte(data, '\n')
+		if nl < 0 {
+			return nil, errors.New("unterminated field")
+		}
+		line := data[:nl]
+		if eq := bytes.IndexByte(line, '='); eq >= 0 {
+			fields[string(line[:eq])] = string(line[eq+1:])
+			data = data[nl+1:]
+			continue
+		}
+		rest := data[nl+1:]
+		if len(rest) < 8 {
+			return nil, errors.New("short binary field")
+		}
+		size := binary.LittleEndian.Uint64(rest)
+		if uint64(len(rest)-8) < size+1 {
+			return nil, errors.New("truncated binary field")
+		}
+		fields[string(line)] = string(rest[8 : 8+size])
+		data = rest[8+size+1:]
+	}
+	return fields, nil
+}
+
+func TestJournalHandlerFields(t *testing.T) {
+	path, next := journalStandIn(t)
+	h, err := NewJournalHandler(path, &slog.HandlerOptions{Level: slog.LevelInfo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := slog.New(h)
+
+	logger.Info("Evaluating idle conditions", EventKey, Evaluation,
+		"cpu", 3.21, "threshold", 5, "users", 0)
+	fields := next()
+	want := map[string]string{
+		"MESSAGE":                "Evaluating idle conditions",
+		"PRIORITY":               "6",
+		"SYSLOG_IDENTIFIER":      JournalIdentifier,
+		"MESSAGE_ID":             MessageIDs[Evaluation],
+		"IDLESHUTDOWN_EVENT":     Evaluation,
+		"IDLESHUTDOWN_CPU":       "3.21",
+		"IDLESHUTDOWN_THRESHOLD": "5",
+		"IDLESHUTDOWN_USERS":     "0",
+	}
+	for key, val := range want {
+		if fields[key] != val {
+			t.Errorf("%s = %q, want %q", key, fields[key], val)
+		}
+	}
+
+	logger.Debug("hidden", EventKey, Evaluation)
+	logger.Error("Shutdown command failed\nexit status 1", EventKey, Shutdown,
+		"error", errors.New("exit status 1"))
+	fields = next()
+	if fields["PRIORITY"] != "3" || fields["MESSAGE_ID"] != MessageIDs[Shutdown] {
+		t.Errorf("error record = %v", fields)
+	}
+	if fields["MESSAGE"] != "Shutdown command failed\nexit status 1" || fields["IDLESHUTDOWN_ERROR"] != "exit status 1" {
+		t.Errorf("multi-line message = %q, error = %q", fields["MESSAGE"], fields["IDLESHUTDOWN_ERROR"])
+	}
+}
+
+func TestJournalHandlerWithAttrsAndGroups(t *testing.T) {
+	path, next := journalStandIn(t)
+	h, err := NewJournalHandler(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := slog.New(h).With(EventKey, Calibration)
+
+	logger.Warn("Calibration rejected", slog.Group("window", "avg-cpu", 2.5))
+	fields := next()
+	if fields["MESSAGE_ID"] != MessageIDs[Calibration] || fields["PRIORITY"] != "4" {
+		t.Errorf("record = %v", fields)
+	}
+	if fields["IDLESHUTDOWN_WINDOW_AVG_CPU"] != "2.5" {
+		t.Errorf("group field = %q", fields["IDLESHUTDOWN_WINDOW_AVG_CPU"])
+	}
+}
+
+func TestNewJournalFormat(t *testing.T) {
+	path, next := journalStandIn(t)
+	old := JournalSocket
+	JournalSocket = path
+	defer func() { JournalSocket = old }()
+
+	logger, err := New(nil, FormatJournal, slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("IdleShutdown agent starting", EventKey, Startup)
+	if fields := next(); fields["MESSAGE_ID"] != MessageIDs[Startup] {
+		t.Errorf("record = %v", fields)
+	}
+
+	JournalSocket = filepath.Join(t.TempDir(), "missing.sock")
+	if _, err := New(nil, FormatJournal, slog.LevelInfo); err == nil {
+		t.Error("expected error without a journal socket")
+	}
+}
+
+func TestAutoFormatWithoutJournal(t *testing.T) {
+	t.Setenv("JOURNAL_STREAM", "")
+	var buf bytes.Buffer
+	logger, err := New(&buf, FormatAuto, slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("hello", EventKey, Startup)
+	if !bytes.Contains(buf.Bytes(), []byte("event=startup")) {
+		t.Errorf("auto format output = %q, want text", buf.String())
+	}
+}
+
+func TestJournalHandlerReconnects(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.sock")
+	listen := func() *net.UnixConn {
+		conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+	journald := listen()
+	h, err := NewJournalHandler(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stderr bytes.Buffer
+	h.fallback = slog.NewTextHandler(&stderr, nil)
+	logger := slog.New(h).With(EventKey, Evaluation)
+
+	// journald is restarted: the old socket goes away, a new one appears.
+	journald.Close()
+	os.Remove(path)
+	journald = listen()
+	defer journald.Close()
+
+	logger.Info("after restart")
+	buf := make([]byte, 65536)
+	journald.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := journald.Read(buf)
+	if err != nil {
+		t.Fatalf("no record after journald restarted: %v", err)
+	}
+	if fields, _ := parseJournalDatagram(buf[:n]); fields["MESSAGE"] != "after restart" {
+		t.Errorf("record = %v", fields)
+	}
+
+	// While journald is down, records go to stderr.
+	journald.Close()
+	os.Remove(path)
+	logger.Warn("journal down", "cpu", 3)
+	if out := stderr.String(); !strings.Contains(out, `msg="journal down"`) || !strings.Contains(out, "event=evaluation") {
+		t.Errorf("stderr = %q, want the record with its attributes", out)
+	}
+}
+
+func TestJournalHandlerTruncatesOversizedRecords(t *testing.T) {
+	path, next := journalStandIn(t)
+	h, err := NewJournalHandler(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stderr bytes.Buffer
+	h.fallback = slog.NewTextHandler(&stderr, nil)
+
+	slog.New(h).Info("Audit", EventKey, Shutdown, "samples", strings.Repeat("é", 1<<20))
+	fields := next()
+	if fields["MESSAGE"] != "Audit" || fields["IDLESHUTDOWN_TRUNCATED"] != "true" {
+		t.Errorf("record = %v", fields)
+	}
+	if got := fields["IDLESHUTDOWN_SAMPLES"]; len(got) > maxTruncatedValue+len("…") || !utf8.ValidString(got) {
+		t.Errorf("samples field has %d bytes, valid UTF-8 %v", len(got), utf8.ValidString(got))
+	}
+	if stderr.Len() > 0 {
+		t.Errorf("truncated record also went to stderr: %q", stderr.String()[:100])
+	}
+}
@@ -0,0 +1,102 @@
+// Package logging configures the agent's structured logger (log/slog) and
+// defines the stable event names every record carries in its "event"
+// attribute, so log pipelines can filter without parsing messages.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Event names, logged under the "event" key.
+const (
+	// Startup covers agent start, stop and configuration summaries.
+	Startup = "startup"
+	// Config covers config.ini and default.ini loading problems.
+	Config = "config"
+	// Evaluation is one run of the shutdown decision.
+	Evaluation = "evaluation"
+	// CPUCheck is the CPU part of an evaluation.
+	CPUCheck = "cpu_check"
+	// UserCheck is the logged-in users part of an evaluation.
+	UserCheck = "user_check"
+	// PSICheck is the pressure stall part of an evaluation.
+	PSICheck = "psi_check"
+	// MemoryCheck is the paging and swap part of an evaluation.
+	MemoryCheck = "memory_check"
+	// NetCheck is the network traffic part of an evaluation.
+	NetCheck = "net_check"
+	// TCPCheck is the client connections part of an evaluation.
+	TCPCheck = "tcp_check"
+	// Sampling covers failures to read CPU or session data.
+	Sampling = "sampling"
+	// Calibration covers learning, calibration runs and calibration state.
+	Calibration = "calibration"
+	// Shutdown covers pending, cancelled and executed shutdowns.
+	Shutdown = "shutdown"
+	// Notify covers delivery of webhook and email notifications.
+	Notify = "notify"
+	// API covers the local API server.
+	API = "api"
+)
+
+// Output formats. FormatAuto picks FormatJournal when stderr is connected
+// to the journal by systemd, and FormatText otherwise.
+const (
+	FormatAuto    = "auto"
+	FormatText    = "text"
+	FormatJSON    = "json"
+	FormatJournal = "journal"
+)
+
+// EventKey is the attribute holding the event name.
+const EventKey = "event"
+
+// New returns a logger writing records to w in the given format at level
+// and above. FormatJournal sends records to JournalSocket instead of w.
+func New(w io.Writer, format string, level slog.Level) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	if format == FormatAuto {
+		format = FormatText
+		if f, ok := w.(*os.File); ok && connectedToJournal(f) {
+			format = FormatJournal
+		}
+	}
+
+	switch format {
+	case FormatText:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case FormatJournal:
+		h, err := NewJournalHandler(JournalSocket, opts)
+		if err != nil {
+			return nil, err
+		}
+		return slog.New(h), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q (want %s, %s, %s or %s)",
+			format, FormatAuto, FormatText, FormatJSON, FormatJournal)
+	}
+}
+
+// ValidFormat reports whether format is a known output format.
+func ValidFormat(format string) bool {
+	switch format {
+	case FormatAuto, FormatText, FormatJSON, FormatJournal:
+		return true
+	}
+	return false
+}
+
+// ParseLevel parses debug, info, warn or error.
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", s)
+	}
+	return level, nil
+}
@@ -0,0 +1,52 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestJSONRecordsCarryEvent(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, FormatJSON, slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("CPU check passed", EventKey, CPUCheck, "samples", 120)
+	logger.Debug("hidden", EventKey, CPUCheck)
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("not a single JSON record: %v\n%s", err, buf.String())
+	}
+	if record["event"] != CPUCheck || record["samples"] != float64(120) || record["msg"] != "CPU check passed" {
+		t.Errorf("record = %v", record)
+	}
+}
+
+func TestTextFormat(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, FormatText, slog.LevelDebug)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Debug("evaluating", EventKey, Evaluation)
+	if !strings.Contains(buf.String(), "event=evaluation") {
+		t.Errorf("text output = %q", buf.String())
+	}
+
+	if _, err := New(&buf, "xml", slog.LevelInfo); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	if level, err := ParseLevel("debug"); err != nil || level != slog.LevelDebug {
+		t.Errorf("debug = %v, %v", level, err)
+	}
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Error("expected error for unknown level")
+	}
+}
@@ -0,0 +1,126 @@
+package monitor
+
+import "fmt"
+
+// How a class of CPU time counts towards usage.
+const (
+	// AccountBusy counts the time as busy.
+	AccountBusy = "busy"
+	// AccountIdle counts the time as idle.
+	AccountIdle = "idle"
+	// AccountExcluded leaves the time out of usage altogether, as if the
+	// VM had not been given it.
+	AccountExcluded = "excluded"
+)
+
+// ValidAccount reports whether name is a known way to count CPU time.
+func ValidAccount(name string) bool {
+	switch name {
+	case AccountBusy, AccountIdle, AccountExcluded:
+		return true
+	}
+	return false
+}
+
+// CPUAccounting says how the CPU time classes that do not clearly belong
+// to this VM's own work count towards usage. Empty fields take the
+// defaults of DefaultCPUAccounting.
+type CPUAccounting struct {
+	// Steal is time the hypervisor gave to other VMs while this one
+	// wanted to run; a noisy neighbour raises it.
+	Steal string
+	// IOWait is idle time while disk I/O was outstanding.
+	IOWait string
+	// Guest is time spent running nested guests, which the kernel also
+	// counts as user time.
+	Guest string
+}
+
+// DefaultCPUAccounting is how usage has always been counted: steal and
+// guest time busy, I/O wait idle.
+func DefaultCPUAccounting() CPUAccounting {
+	return CPUAccounting{Steal: AccountBusy, IOWait: AccountIdle, Guest: AccountBusy}
+}
+
+// withDefaults fills in empty fields from DefaultCPUAccounting.
+func (a CPUAccounting) withDefaults() CPUAccounting {
+	d := DefaultCPUAccounting()
+	if a.Steal == "" {
+		a.Steal = d.Steal
+	}
+	if a.IOWait == "" {
+		a.IOWait = d.IOWait
+	}
+	if a.Guest == "" {
+		a.Guest = d.Guest
+	}
+	return a
+}
+
+// String describes the accounting for logs and the calibration state,
+// e.g. "steal busy, iowait idle, guest busy".
+func (a CPUAccounting) String() string {
+	a = a.withDefaults()
+	return fmt.Sprintf("steal %s, iowait %s, guest %s", a.Steal, a.IOWait, a.Guest)
+}
+
+// SetAccounting sets how steal, I/O wait and guest time count towards
+// usage in samples taken from now on.
+func (m *CPUMonitor) SetAccounting(a CPUAccounting) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.accounting = a.withDefaults()
+}
+
+// Accounting returns how steal, I/O wait and guest time count towards
+// usage.
+func (m *CPUMonitor) Accounting() CPUAccounting {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.accounting.withDefaults()
+}
+
+// ticks returns the busy and total ticks of s, counting steal, I/O wait
+// and guest time as a says.
+func (s cpuStats) ticks(a CPUAccounting) (busy, total uint64) {
+	// user and nice include guest and guest_nice
+	guest := s.guest + s.guestNice
+	own := s.user + s.nice + s.system + s.irq + s.softirq
+	guest = min(guest, own)
+	busy, idle := own-guest, s.idle
+	for _, class := range []struct {
+		ticks uint64
+		how   string
+	}{{s.steal, a.Steal}, {s.iowait, a.IOWait}, {guest, a.Guest}} {
+		switch class.how {
+		case AccountBusy:
+			busy += class.ticks
+		case AccountIdle:
+			idle += class.ticks
+		}
+	}
+	return busy, busy + idle
+}
+
+// allTicks returns every tick in s, however it is accounted.
+func (s cpuStats) allTicks() uint64 {
+	return s.user + s.nice + s.system + s.idle + s.iowait + s.irq + s.softirq + s.steal
+}
+
+// components returns steal, I/O wait and guest time between two readings,
+// each as a percentage of all time. A counter that went backwards counts
+// as 0.
+func components(before, after cpuStats) (steal, iowait, guest float64) {
+	if after.allTicks() <= before.allTicks() {
+		return 0, 0, 0
+	}
+	all := float64(after.allTicks() - before.allTicks())
+	percent := func(b, a uint64) float64 {
+		if a <= b {
+			return 0
+		}
+		return float64(a-b) / all * 100
+	}
+	return percent(before.steal, after.steal), percent(before.iowait, after.iowait),
+		percent(before.guest+before.guestNice, after.guest+after.guestNice)
+}
@@ -0,0 +1,259 @@
+package monitor
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"idleshutdown/internal/logging"
+)
+
+// DefaultCgroupRoot is where the cgroup v2 hierarchy is mounted.
+const DefaultCgroupRoot = "/sys/fs/cgroup"
+
+// CgroupSelection picks the cgroups whose CPU time MetricCgroup measures.
+// Entries are systemd unit or slice names such as app.service or
+// user.slice, found anywhere in the hierarchy, or paths relative to its
+// root such as system.slice/cron.service.
+type CgroupSelection struct {
+	// Include lists the cgroups to measure; empty means the whole system.
+	Include []string
+	// Exclude lists cgroups whose CPU time is subtracted from the
+	// included cgroup they are nested in, or from the whole system when
+	// Include is empty, e.g. system.slice to ignore monitoring agents.
+	Exclude []string
+}
+
+// IsZero reports whether nothing is selected, in which case MetricCgroup
+// equals MetricAggregate.
+func (c CgroupSelection) IsZero() bool {
+	return len(c.Include) == 0 && len(c.Exclude) == 0
+}
+
+// String describes the selection for logs, e.g.
+// "user.slice, app.service minus system.slice".
+func (c CgroupSelection) String() string {
+	include := "everything"
+	if len(c.Include) > 0 {
+		include = strings.Join(c.Include, ", ")
+	}
+	if len(c.Exclude) == 0 {
+		return include
+	}
+	return include + " minus " + strings.Join(c.Exclude, ", ")
+}
+
+// SetCgroups selects the cgroups MetricCgroup measures.
+func (m *CPUMonitor) SetCgroups(c CgroupSelection) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cgroups = c
+}
+
+// Cgroups returns the cgroups MetricCgroup measures.
+func (m *CPUMonitor) Cgroups() CgroupSelection {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.cgroups
+}
+
+// cgroupUsage returns the usage of the selected cgroups over elapsed as a
+// percentage of all cores, given the aggregate usage over the same time.
+// An included cgroup nested in another included one is counted once, and
+// an excluded cgroup is only subtracted when it lies inside an included
+// one (or anywhere, when nothing is included), so excluding system.slice
+// does not take away from an included app.service that lives in it.
+// It remembers each cgroup's counter for the next call; a cgroup seen for
+// the first time contributes nothing yet, and one whose counter went
+// backwards, e.g. because its unit restarted, contributes what it used
+// since.
+func (m *CPUMonitor) cgroupUsage(sel CgroupSelection, aggregate float64, elapsed time.Duration, cores int) float64 {
+	if sel.IsZero() {
+		return aggregate
+	}
+	names := append(append([]string{}, sel.Include...), sel.Exclude...)
+	paths := findCgroups(m.CgroupRoot, names)
+	m.warnMissingCgroups(names, paths)
+
+	prev := m.prevCgroups
+	m.prevCgroups = make(map[string]uint64, len(paths))
+	used := func(list []string) time.Duration {
+		var total time.Duration
+		for _, path := range list {
+			usec, err := readCgroupUsage(path)
+			if err != nil {
+				slog.Warn("Reading cgroup CPU usage failed", logging.EventKey, logging.Sampling, "cgroup", path, "error", err)
+				continue
+			}
+			m.prevCgroups[path] = usec
+			before, seen := prev[path]
+			switch {
+			case !seen:
+			case usec < before:
+				total += time.Duration(usec) * time.Microsecond
+			default:
+				total += time.Duration(usec-before) * time.Microsecond
+			}
+		}
+		return total
+	}
+
+	include := outermostCgroups(resolvedCgroups(sel.Include, paths))
+	var exclude []string
+	for _, path := range outermostCgroups(resolvedCgroups(sel.Exclude, paths)) {
+		if len(sel.Include) == 0 || insideAnyCgroup(path, include) {
+			exclude = append(exclude, path)
+		}
+	}
+
+	included, excluded := used(include), used(exclude)
+	capacity := float64(elapsed) * float64(max(cores, 1))
+	if capacity <= 0 {
+		return 0
+	}
+	usage := aggregate
+	if len(sel.Include) > 0 {
+		usage = float64(included) / capacity * 100
+	}
+	usage -= float64(excluded) / capacity * 100
+	return min(max(usage, 0), 100)
+}
+
+// resolvedCgroups returns the directories of the names that were found,
+// without duplicates.
+func resolvedCgroups(names []string, paths map[string]string) []string {
+	var list []string
+	seen := map[string]bool{}
+	for _, name := range names {
+		if path, ok := paths[name]; ok && !seen[path] {
+			seen[path] = true
+			list = append(list, path)
+		}
+	}
+	return list
+}
+
+// outermostCgroups drops the cgroups nested in another one of the list,
+// whose CPU time the outer one already counts.
+func outermostCgroups(list []string) []string {
+	var outer []string
+	for i, path := range list {
+		nested := false
+		for j, other := range list {
+			if i != j && path != other && insideCgroup(path, other) {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			outer = append(outer, path)
+		}
+	}
+	return outer
+}
+
+// insideAnyCgroup reports whether path is one of parents or below one.
+func insideAnyCgroup(path string, parents []string) bool {
+	for _, parent := range parents {
+		if insideCgroup(path, parent) {
+			return true
+		}
+	}
+	return false
+}
+
+// insideCgroup reports whether path is parent or below it.
+func insideCgroup(path, parent string) bool {
+	return path == parent || strings.HasPrefix(path, parent+string(filepath.Separator))
+}
+
+// warnMissingCgroups logs the selected cgroups that do not exist, each
+// time that set changes.
+func (m *CPUMonitor) warnMissingCgroups(names []string, found map[string]string) {
+	var missing []string
+	for _, name := range names {
+		if _, ok := found[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	if key := strings.Join(missing, ","); key != m.missingCgroups {
+		m.missingCgroups = key
+		if len(missing) > 0 {
+			slog.Warn("Selected cgroups not found; they count as idle until they appear", logging.EventKey, logging.Sampling,
+				"cgroups", missing, "root", m.CgroupRoot)
+		}
+	}
+}
+
+// findCgroups resolves names to cgroup directories under root. A name
+// containing a slash is a path relative to root; any other is looked up
+// as a directory of that name anywhere in the hierarchy, which is where
+// systemd puts the cgroup of a unit or slice; the walk continues below a
+// match, since a selected unit may sit inside a selected slice. Names not
+// found are left out.
+func findCgroups(root string, names []string) map[string]string {
+	paths := map[string]string{}
+	wanted := map[string]bool{}
+	for _, name := range names {
+		if strings.Contains(name, "/") {
+			path := filepath.Join(root, filepath.Clean("/"+name))
+			if info, err := os.Stat(path); err == nil && info.IsDir() {
+				paths[name] = path
+			}
+			continue
+		}
+		wanted[name] = true
+	}
+	if len(wanted) == 0 {
+		return paths
+	}
+
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if name := d.Name(); wanted[name] && path != root {
+			delete(wanted, name)
+			paths[name] = path
+			if len(wanted) == 0 {
+				return fs.SkipAll
+			}
+		}
+		return nil
+	})
+	return paths
+}
+
+// readCgroupUsage returns usage_usec from the cpu.stat of the cgroup
+// directory path.
+func readCgroupUsage(path string) (uint64, error) {
+	file, err := os.Open(filepath.Join(path, "cpu.stat"))
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "usage_usec" {
+			usec, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("parse usage_usec: %w", err)
+			}
+			return usec, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no usage_usec in %s", filepath.Join(path, "cpu.stat"))
+}
@@ -0,0 +1,129 @@
+package monitor
+
+import (
+	"sort"
+	"time"
+)
+
+// WindowCheck is the detailed result of an idle check over a window: the
+// same decision IsBelowThreshold and NoUsersLoggedIn make, with the
+// evidence behind it.
+type WindowCheck struct {
+	Minutes    int  `json:"minutes"`
+	Samples    int  `json:"samples"`
+	MinSamples int  `json:"min_samples"`
+	Idle       bool `json:"idle"`
+
+	// FirstBreak and LastBreak are the oldest and newest samples in the
+	// window that broke idleness; nil if none did.
+	FirstBreak *Breach `json:"first_break,omitempty"`
+	LastBreak  *Breach `json:"last_break,omitempty"`
+	Breaks     int     `json:"breaks"`
+	// Tolerated counts samples that broke idleness but were forgiven as
+	// short spikes; they are not among the breaks.
+	Tolerated int `json:"tolerated,omitempty"`
+
+	// Gaps are stretches of the window without samples, longer than two
+	// sampling intervals.
+	Gaps []Gap `json:"gaps,omitempty"`
+
+	// IdleAt estimates when the check passes if no further sample breaks
+	// idleness: once the last breach has left the window and enough
+	// samples have accumulated. It equals the check time when Idle.
+	IdleAt time.Time `json:"idle_at"`
+}
+
+// Breach is a sample that broke idleness.
+type Breach struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+	Users []string  `json:"users,omitempty"`
+	// Metric names the reading Value is, for checks over several
+	// readings such as the memory check.
+	Metric string `json:"metric,omitempty"`
+}
+
+// Gap is a stretch of time without samples.
+type Gap struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// checkPoint is one sample as seen by checkWindow.
+type checkPoint struct {
+	time   time.Time
+	value  float64
+	users  []string
+	metric string
+	breaks bool
+}
+
+// minWindowSamples is the number of samples a window of minutes needs
+// before it can count as idle: one per two minutes.
+func minWindowSamples(minutes int) int {
+	return max(minutes/2, 1)
+}
+
+// windowCutoff returns the exclusive start of the window of minutes ending
+// at now.
+func windowCutoff(now time.Time, minutes int) time.Time {
+	return now.Add(-time.Duration(minutes) * time.Minute)
+}
+
+// windowStart returns the index of the first of n chronological samples
+// taken after since, where at returns the time of sample i. Checks build
+// their points from there rather than from the whole retained history.
+func windowStart(n int, since time.Time, at func(i int) time.Time) int {
+	return sort.Search(n, func(i int) bool { return at(i).After(since) })
+}
+
+// checkWindow evaluates the points in the window (now-minutes, now],
+// which must be in chronological order.
+func checkWindow(now time.Time, minutes int, interval time.Duration, points []checkPoint) WindowCheck {
+	window := time.Duration(minutes) * time.Minute
+	cutoff := now.Add(-window)
+	check := WindowCheck{Minutes: minutes, MinSamples: minWindowSamples(minutes)}
+
+	prev := cutoff
+	afterLastBreak := 0
+	for _, p := range points {
+		if !p.time.After(cutoff) || p.time.After(now) {
+			continue
+		}
+		check.Samples++
+		if interval > 0 && p.time.Sub(prev) > 2*interval {
+			check.Gaps = append(check.Gaps, Gap{From: prev, To: p.time})
+		}
+		prev = p.time
+
+		if p.breaks {
+			breach := &Breach{Time: p.time, Value: p.value, Users: p.users, Metric: p.metric}
+			if check.FirstBreak == nil {
+				check.FirstBreak = breach
+			}
+			check.LastBreak = breach
+			check.Breaks++
+			afterLastBreak = 0
+		} else {
+			afterLastBreak++
+		}
+	}
+	if interval > 0 && now.Sub(prev) > 2*interval {
+		check.Gaps = append(check.Gaps, Gap{From: prev, To: now})
+	}
+
+	check.Idle = check.Samples >= check.MinSamples && check.LastBreak == nil
+	check.IdleAt = now
+	if check.Idle {
+		return check
+	}
+	if check.LastBreak != nil {
+		check.IdleAt = check.LastBreak.Time.Add(window)
+	}
+	if missing := check.MinSamples - afterLastBreak; missing > 0 && interval > 0 {
+		if enough := now.Add(time.Duration(missing) * interval); enough.After(check.IdleAt) {
+			check.IdleAt = enough
+		}
+	}
+	return check
+}
@@ -4,69 +4,211 @@ package monitor
 import (
 	"bufio"
 	"fmt"
-	"log"
+	"log/slog"
+	"math"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"idleshutdown/internal/clock"
+	"idleshutdown/internal/logging"
 )
 
-// maxSampleRetention is how far back CPU samples are kept in memory.
-// This is 72h to support weekly calibration lookback.
+// maxSampleRetention is how far back samples are kept in memory.
+// This is 72h to support weekly calibration lookback, and applies to user
+// samples too so that exports can be backtested over their whole range.
 const maxSampleRetention = 72 * time.Hour
 
+// DefaultProcRoot is where procfs is mounted.
+const DefaultProcRoot = "/proc"
+
 // CPUMonitor tracks CPU usage over time using a rolling window.
 type CPUMonitor struct {
-	mu       sync.RWMutex
-	samples  []cpuSample
-	interval time.Duration
+	mu         sync.RWMutex
+	samples    []cpuSample
+	interval   time.Duration
+	tolerance  CPUTolerance
+	metric     string
+	accounting CPUAccounting
+	cgroups    CgroupSelection
+
+	// prev is the /proc/stat reading of the previous Sample, taken at
+	// prevAt, and prevCgroups the cgroup counters by path; each sample
+	// covers the time since. Only Sample uses them and missingCgroups.
+	prev           *procStat
+	prevAt         time.Time
+	prevCgroups    map[string]uint64
+	missingCgroups string
+
+	// Clock, ProcRoot and CgroupRoot may be replaced before Start, e.g. in
+	// tests.
+	Clock      clock.Clock
+	ProcRoot   string
+	CgroupRoot string
+}
+
+// CPU metrics the idle check can compare with the threshold, all in percent.
+const (
+	// MetricAggregate is the usage of all cores together.
+	MetricAggregate = "aggregate"
+	// MetricMaxCore is the usage of the busiest core, which catches a
+	// single-threaded job that barely moves the aggregate on a large VM.
+	MetricMaxCore = "max_core"
+	// MetricLoad is the 1-minute load average per core, which also counts
+	// tasks waiting for a CPU or for disk I/O.
+	MetricLoad = "load"
+	// MetricCgroup is the usage of selected cgroups, such as a service and
+	// the user slice, as a share of all cores (see CgroupSelection).
+	MetricCgroup = "cgroup"
+)
+
+// ValidMetric reports whether name is a known CPU metric.
+func ValidMetric(name string) bool {
+	switch name {
+	case MetricAggregate, MetricMaxCore, MetricLoad, MetricCgroup:
+		return true
+	}
+	return false
 }
 
 // CPUSample is the exported form of a CPU usage reading.
 type CPUSample struct {
 	Timestamp time.Time
 	Usage     float64
+	// MaxCore is the usage of the busiest core, LoadPerCore the 1-minute
+	// load average divided by the number of cores and Cgroup the usage of
+	// the selected cgroups, as a percentage. They are 0 in samples
+	// recorded before they were measured.
+	MaxCore     float64
+	LoadPerCore float64
+	Cgroup      float64
+	// Steal, IOWait and Guest are the shares of all CPU time spent on
+	// each, as a percentage, whichever way CPUAccounting counts them in
+	// Usage.
+	Steal  float64
+	IOWait float64
+	Guest  float64
+}
+
+// Value returns the reading of the sample for metric.
+func (s CPUSample) Value(metric string) float64 {
+	return cpuSample{usage: s.Usage, maxCore: s.MaxCore, loadPerCore: s.LoadPerCore, cgroup: s.Cgroup}.value(metric)
 }
 
 // cpuSample represents a single CPU usage measurement (internal).
 type cpuSample struct {
-	timestamp time.Time
-	usage     float64
+	timestamp   time.Time
+	usage       float64
+	maxCore     float64
+	loadPerCore float64
+	cgroup      float64
+	steal       float64
+	iowait      float64
+	guest       float64
+}
+
+// value returns the reading for metric. The busiest core is never below
+// the aggregate, which stands in for it in samples without per-core data.
+func (s cpuSample) value(metric string) float64 {
+	switch metric {
+	case MetricMaxCore:
+		return max(s.maxCore, s.usage)
+	case MetricLoad:
+		return s.loadPerCore
+	case MetricCgroup:
+		return s.cgroup
+	}
+	return s.usage
+}
+
+func (s cpuSample) export() CPUSample {
+	return CPUSample{Timestamp: s.timestamp, Usage: s.usage, MaxCore: s.maxCore, LoadPerCore: s.loadPerCore,
+		Cgroup: s.cgroup, Steal: s.steal, IOWait: s.iowait, Guest: s.guest}
 }
 
 // cpuStats holds raw CPU counters from /proc/stat.
 type cpuStats struct {
-	user    uint64
-	nice    uint64
-	system  uint64
-	idle    uint64
-	iowait  uint64
-	irq     uint64
-	softirq uint64
-	steal   uint64
+	user      uint64
+	nice      uint64
+	system    uint64
+	idle      uint64
+	iowait    uint64
+	irq       uint64
+	softirq   uint64
+	steal     uint64
+	guest     uint64
+	guestNice uint64
+}
+
+// procStat holds the aggregate and per-core counters of one /proc/stat read.
+type procStat struct {
+	total cpuStats
+	cores []cpuStats
+}
+
+// busyPercent returns the share of busy time between two readings, with
+// steal, I/O wait and guest time counted as the CPUAccounting a says. It
+// fails if the counters went backwards, i.e. wrapped or were reset; a busy
+// count that alone went backwards, as steal time does on some hypervisors,
+// counts as idle.
+func busyPercent(before, after cpuStats, a CPUAccounting) (float64, bool) {
+	if after.allTicks() < before.allTicks() {
+		return 0, false
+	}
+	busy1, total1 := before.ticks(a)
+	busy2, total2 := after.ticks(a)
+	if total2 <= total1 || busy2 <= busy1 {
+		return 0, true
+	}
+	busyDelta := min(busy2-busy1, total2-total1)
+	return float64(busyDelta) / float64(total2-total1) * 100, true
 }
 
 // NewCPUMonitor creates a new CPU monitor with the specified sampling interval.
 func NewCPUMonitor(samplingInterval time.Duration) *CPUMonitor {
 	return &CPUMonitor{
-		samples:  make([]cpuSample, 0, 256),
-		interval: samplingInterval,
+		samples:    make([]cpuSample, 0, 256),
+		interval:   samplingInterval,
+		Clock:      clock.Real,
+		ProcRoot:   DefaultProcRoot,
+		CgroupRoot: DefaultCgroupRoot,
 	}
 }
 
+// SetMetric selects the CPU metric the idle check, scores and window
+// statistics use; see MetricAggregate and friends. An empty metric means
+// MetricAggregate.
+func (m *CPUMonitor) SetMetric(metric string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.metric = metric
+}
+
+// Metric returns the CPU metric the idle check uses.
+func (m *CPUMonitor) Metric() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.metric == "" {
+		return MetricAggregate
+	}
+	return m.metric
+}
+
 // Start begins CPU monitoring in a background goroutine.
 func (m *CPUMonitor) Start(stopCh <-chan struct{}) {
 	go func() {
-		ticker := time.NewTicker(m.interval)
+		ticker := m.Clock.NewTicker(m.interval)
 		defer ticker.Stop()
 
-		m.takeSample() // initial reading
+		m.Sample() // primes the counters; the first sample follows a tick later
 
 		for {
 			select {
-			case <-ticker.C:
-				m.takeSample()
+			case <-ticker.C():
+				m.Sample()
 			case <-stopCh:
 				return
 			}
@@ -74,19 +216,25 @@ func (m *CPUMonitor) Start(stopCh <-chan struct{}) {
 	}()
 }
 
-// takeSample reads current CPU usage and appends it to the rolling buffer.
-func (m *CPUMonitor) takeSample() {
-	usage, err := m.getCurrentCPUUsage()
+// Sample reads the CPU counters and appends the usage since the previous
+// call to the rolling buffer. The first call, and the first after a gap
+// such as a suspend, only records the counters.
+func (m *CPUMonitor) Sample() {
+	now := m.Clock.Now()
+	sample, ok, err := m.measure(now)
 	if err != nil {
-		log.Printf("Error reading CPU usage: %v", err)
+		slog.Error("Reading CPU usage failed", logging.EventKey, logging.Sampling, "error", err)
+		return
+	}
+	if !ok {
 		return
 	}
 
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	now := time.Now()
-	m.samples = append(m.samples, cpuSample{timestamp: now, usage: usage})
+	sample.timestamp = now
+	m.samples = append(m.samples, sample)
 
 	// Prune samples older than retention window
 	cutoff := now.Add(-maxSampleRetention)
@@ -99,135 +247,275 @@ func (m *CPUMonitor) takeSample() {
 	}
 }
 
-// getCurrentCPUUsage calculates current CPU usage percentage by comparing
-// two readings of /proc/stat separated by a short interval.
-func (m *CPUMonitor) getCurrentCPUUsage() (float64, error) {
-	stats1, err := readCPUStats()
+// measure reads /proc/stat and the load average at now and returns the
+// aggregate and per-core usage since the previous reading. ok is false
+// when there is no usable previous reading: on the first call, after a
+// gap of more than two intervals, or when the counters went backwards.
+func (m *CPUMonitor) measure(now time.Time) (sample cpuSample, ok bool, err error) {
+	stats, err := readCPUStats(m.ProcRoot)
 	if err != nil {
-		return 0, err
+		return cpuSample{}, false, err
 	}
-
-	time.Sleep(100 * time.Millisecond)
-
-	stats2, err := readCPUStats()
+	load, err := readLoadAverage(m.ProcRoot)
 	if err != nil {
-		return 0, err
+		return cpuSample{}, false, err
 	}
 
-	// Calculate deltas — include all non-idle activity
-	idle1 := stats1.idle + stats1.iowait
-	idle2 := stats2.idle + stats2.iowait
-
-	total1 := stats1.user + stats1.nice + stats1.system + idle1 +
-		stats1.irq + stats1.softirq + stats1.steal
-	total2 := stats2.user + stats2.nice + stats2.system + idle2 +
-		stats2.irq + stats2.softirq + stats2.steal
-
-	totalDelta := float64(total2 - total1)
-	idleDelta := float64(idle2 - idle1)
-
-	if totalDelta == 0 {
-		return 0, nil
+	accounting, cgroups := m.Accounting(), m.Cgroups()
+	prev, prevAt := m.prev, m.prevAt
+	m.prev, m.prevAt = stats, now
+	if prev == nil {
+		m.cgroupUsage(cgroups, 0, 0, 0) // primes the cgroup counters
+		return cpuSample{}, false, nil
+	}
+	// Wall-clock time, which unlike the monotonic clock includes time
+	// spent suspended. The counters stood still meanwhile, so a sample
+	// spanning the gap would be stretched over time it did not measure.
+	if gap := now.Round(0).Sub(prevAt.Round(0)); m.interval > 0 && gap > 2*m.interval {
+		slog.Info("CPU sampling resumed after a gap, restarting from current counters", logging.EventKey, logging.Sampling,
+			"gap", gap.Round(time.Second).String())
+		m.cgroupUsage(cgroups, 0, 0, 0)
+		return cpuSample{}, false, nil
+	}
+	usage, ok := busyPercent(prev.total, stats.total, accounting)
+	if !ok {
+		slog.Warn("CPU counters went backwards, restarting from current counters", logging.EventKey, logging.Sampling)
+		m.cgroupUsage(cgroups, 0, 0, 0)
+		return cpuSample{}, false, nil
 	}
 
-	return ((totalDelta - idleDelta) / totalDelta) * 100, nil
+	sample = cpuSample{usage: usage, maxCore: usage}
+	sample.steal, sample.iowait, sample.guest = components(prev.total, stats.total)
+	if len(prev.cores) == len(stats.cores) {
+		for i := range stats.cores {
+			if core, ok := busyPercent(prev.cores[i], stats.cores[i], accounting); ok {
+				sample.maxCore = max(sample.maxCore, core)
+			}
+		}
+	}
+	sample.loadPerCore = load / float64(max(len(stats.cores), 1)) * 100
+	sample.cgroup = m.cgroupUsage(cgroups, usage, now.Sub(prevAt), len(stats.cores))
+	return sample, true, nil
 }
 
-// readCPUStats reads CPU statistics from /proc/stat.
-func readCPUStats() (*cpuStats, error) {
-	file, err := os.Open("/proc/stat")
+// readCPUStats reads the aggregate and per-core CPU statistics from
+// /proc/stat under procRoot.
+func readCPUStats(procRoot string) (*procStat, error) {
+	file, err := os.Open(filepath.Join(procRoot, "stat"))
 	if err != nil {
 		return nil, fmt.Errorf("open /proc/stat: %w", err)
 	}
 	defer file.Close()
 
+	var stat procStat
+	found := false
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
-		line := scanner.Text()
-		if !strings.HasPrefix(line, "cpu ") {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], "cpu") {
 			continue
 		}
-
-		fields := strings.Fields(line)
-		if len(fields) < 5 {
-			return nil, fmt.Errorf("unexpected /proc/stat format: only %d fields", len(fields))
+		stats, err := parseCPULine(fields)
+		if err != nil {
+			return nil, err
 		}
-
-		parse := func(idx int) uint64 {
-			if idx >= len(fields) {
-				return 0
-			}
-			v, err := strconv.ParseUint(fields[idx], 10, 64)
-			if err != nil {
-				log.Printf("Warning: failed to parse /proc/stat field[%d]=%q: %v", idx, fields[idx], err)
-				return 0
-			}
-			return v
+		if fields[0] == "cpu" {
+			stat.total = stats
+			found = true
+		} else {
+			stat.cores = append(stat.cores, stats)
 		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read /proc/stat: %w", err)
+	}
+	if !found {
+		return nil, fmt.Errorf("cpu line not found in /proc/stat")
+	}
+	return &stat, nil
+}
 
-		return &cpuStats{
-			user:    parse(1),
-			nice:    parse(2),
-			system:  parse(3),
-			idle:    parse(4),
-			iowait:  parse(5),
-			irq:     parse(6),
-			softirq: parse(7),
-			steal:   parse(8),
-		}, nil
+// parseCPULine parses the counters of a cpu or cpuN line of /proc/stat.
+func parseCPULine(fields []string) (cpuStats, error) {
+	if len(fields) < 5 {
+		return cpuStats{}, fmt.Errorf("unexpected /proc/stat format: only %d fields in %s line", len(fields), fields[0])
+	}
+
+	parse := func(idx int) uint64 {
+		if idx >= len(fields) {
+			return 0
+		}
+		v, err := strconv.ParseUint(fields[idx], 10, 64)
+		if err != nil {
+			slog.Warn("Unparsable /proc/stat field", logging.EventKey, logging.Sampling,
+				"index", idx, "value", fields[idx], "error", err)
+			return 0
+		}
+		return v
 	}
 
-	return nil, fmt.Errorf("cpu line not found in /proc/stat")
+	return cpuStats{
+		user:      parse(1),
+		nice:      parse(2),
+		system:    parse(3),
+		idle:      parse(4),
+		iowait:    parse(5),
+		irq:       parse(6),
+		softirq:   parse(7),
+		steal:     parse(8),
+		guest:     parse(9),
+		guestNice: parse(10),
+	}, nil
+}
+
+// readLoadAverage reads the 1-minute load average from /proc/loadavg under
+// procRoot.
+func readLoadAverage(procRoot string) (float64, error) {
+	data, err := os.ReadFile(filepath.Join(procRoot, "loadavg"))
+	if err != nil {
+		return 0, fmt.Errorf("read /proc/loadavg: %w", err)
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("unexpected /proc/loadavg format: %q", data)
+	}
+	load, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse /proc/loadavg: %w", err)
+	}
+	return load, nil
 }
 
 // IsBelowThreshold checks if CPU usage has been below the threshold
 // for the specified duration.
 func (m *CPUMonitor) IsBelowThreshold(threshold int, minutes int) bool {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
-	cutoff := time.Now().Add(-time.Duration(minutes) * time.Minute)
+	return m.IsBelowThresholdAt(m.Clock.Now(), threshold, minutes)
+}
 
-	var samplesInWindow []cpuSample
-	for _, s := range m.samples {
-		if s.timestamp.After(cutoff) {
-			samplesInWindow = append(samplesInWindow, s)
-		}
-	}
+// IsBelowThresholdAt is IsBelowThreshold evaluated as if the current time
+// were now. Samples after now are ignored, which lets recorded samples be
+// replayed offline.
+func (m *CPUMonitor) IsBelowThresholdAt(now time.Time, threshold int, minutes int) bool {
+	check := m.CheckAt(now, threshold, minutes)
 
 	// Require at least 1 sample per 2 minutes of the window
-	minSamples := minutes / 2
-	if minSamples < 1 {
-		minSamples = 1
-	}
-	if len(samplesInWindow) < minSamples {
-		log.Printf("CPU check: insufficient samples (%d/%d) for %d-min window",
-			len(samplesInWindow), minSamples, minutes)
+	if check.Samples < check.MinSamples {
+		slog.Info("CPU check: insufficient samples", logging.EventKey, logging.CPUCheck,
+			"idle", false, "samples", check.Samples, "min_samples", check.MinSamples, "minutes", minutes)
 		return false
 	}
 
-	for _, s := range samplesInWindow {
-		if s.usage >= float64(threshold) {
-			log.Printf("CPU check: %.2f%% >= %d%% at %s — not idle",
-				s.usage, threshold, s.timestamp.Format(time.RFC3339))
-			return false
-		}
+	if b := check.FirstBreak; b != nil {
+		slog.Info("CPU check: not idle", logging.EventKey, logging.CPUCheck,
+			"idle", false, "usage", round2(b.Value), "threshold", threshold, "at", b.Time, "minutes", minutes,
+			"tolerated_spikes", check.Tolerated)
+		return false
 	}
 
-	log.Printf("CPU check: all %d samples below %d%% for last %d min ✓",
-		len(samplesInWindow), threshold, minutes)
+	slog.Info("CPU check: idle", logging.EventKey, logging.CPUCheck,
+		"idle", true, "samples", check.Samples, "threshold", threshold, "minutes", minutes,
+		"tolerated_spikes", check.Tolerated)
 	return true
 }
 
-// GetCurrentUsage returns the most recent CPU usage reading.
+// Check returns the detailed result of the IsBelowThreshold check.
+func (m *CPUMonitor) Check(threshold int, minutes int) WindowCheck {
+	return m.CheckAt(m.Clock.Now(), threshold, minutes)
+}
+
+// CheckAt is Check evaluated as if the current time were now.
+func (m *CPUMonitor) CheckAt(now time.Time, threshold int, minutes int) WindowCheck {
+	tolerance := m.Tolerance()
+	// A rolling average reaches back before the window
+	points := m.checkPoints(windowCutoff(now, minutes).Add(-tolerance.RollingAverage), threshold)
+	if tolerance.RollingAverage > 0 {
+		rollingAverage(points, tolerance.RollingAverage, threshold)
+	}
+	tolerated := tolerateSpikes(now, minutes, points, tolerance)
+
+	check := checkWindow(now, minutes, m.interval, points)
+	check.Tolerated = tolerated
+	return check
+}
+
+// Score returns how idle the CPU was over the last minutes, a sample
+// counting as idle when below threshold.
+func (m *CPUMonitor) Score(threshold int, minutes int, opts ScoreOptions) Score {
+	return m.ScoreAt(m.Clock.Now(), threshold, minutes, opts)
+}
+
+// ScoreAt is Score evaluated as if the current time were now.
+func (m *CPUMonitor) ScoreAt(now time.Time, threshold int, minutes int, opts ScoreOptions) Score {
+	return scoreWindow(now, minutes, m.interval, m.checkPoints(windowCutoff(now, minutes), threshold), opts)
+}
+
+// checkPoints returns the readings of the selected metric in the samples
+// taken after since, breaking idleness at or above threshold.
+func (m *CPUMonitor) checkPoints(since time.Time, threshold int) []checkPoint {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	samples := m.samples[windowStart(len(m.samples), since, func(i int) time.Time { return m.samples[i].timestamp }):]
+	points := make([]checkPoint, len(samples))
+	for i, s := range samples {
+		v := s.value(m.metric)
+		points[i] = checkPoint{time: s.timestamp, value: v, breaks: v >= float64(threshold)}
+	}
+	return points
+}
+
+// CPUWindowStats summarizes the CPU samples in an idle check window.
+type CPUWindowStats struct {
+	Minutes int
+	Samples int
+	Average float64
+	Peak    float64
+}
+
+// WindowStats returns statistics of the selected metric over the last
+// minutes, the same window IsBelowThreshold checks.
+func (m *CPUMonitor) WindowStats(minutes int) CPUWindowStats {
+	return m.WindowStatsAt(m.Clock.Now(), minutes)
+}
+
+// WindowStatsAt is WindowStats evaluated as if the current time were now.
+func (m *CPUMonitor) WindowStatsAt(now time.Time, minutes int) CPUWindowStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	cutoff := now.Add(-time.Duration(minutes) * time.Minute)
+	stats := CPUWindowStats{Minutes: minutes}
+	sum := 0.0
+	for _, s := range m.samples {
+		if s.timestamp.After(cutoff) && !s.timestamp.After(now) {
+			v := s.value(m.metric)
+			stats.Samples++
+			sum += v
+			if v > stats.Peak {
+				stats.Peak = v
+			}
+		}
+	}
+	if stats.Samples > 0 {
+		stats.Average = sum / float64(stats.Samples)
+	}
+	return stats
+}
+
+// GetCurrentUsage returns the most recent aggregate CPU usage reading.
 func (m *CPUMonitor) GetCurrentUsage() float64 {
+	return m.Current().Usage
+}
+
+// Current returns the most recent sample, or the zero sample if there is
+// none yet.
+func (m *CPUMonitor) Current() CPUSample {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
 	if len(m.samples) == 0 {
-		return 0
+		return CPUSample{}
 	}
-	return m.samples[len(m.samples)-1].usage
+	return m.samples[len(m.samples)-1].export()
 }
 
 // GetSamples returns a snapshot of all retained CPU samples as exported types.
@@ -238,7 +526,45 @@ func (m *CPUMonitor) GetSamples() []CPUSample {
 
 	result := make([]CPUSample, len(m.samples))
 	for i, s := range m.samples {
-		result[i] = CPUSample{Timestamp: s.timestamp, Usage: s.usage}
+		result[i] = s.export()
+	}
+	return result
+}
+
+// WindowSamples returns the samples in the last minutes, the window
+// IsBelowThreshold checks.
+func (m *CPUMonitor) WindowSamples(minutes int) []CPUSample {
+	return m.WindowSamplesAt(m.Clock.Now(), minutes)
+}
+
+// WindowSamplesAt is WindowSamples evaluated as if the current time were now.
+func (m *CPUMonitor) WindowSamplesAt(now time.Time, minutes int) []CPUSample {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	cutoff := now.Add(-time.Duration(minutes) * time.Minute)
+	var result []CPUSample
+	for _, s := range m.samples {
+		if s.timestamp.After(cutoff) && !s.timestamp.After(now) {
+			result = append(result, s.export())
+		}
 	}
 	return result
 }
+
+// AddSamples appends previously recorded samples, e.g. loaded from a sample
+// file for offline replay. Samples must be in chronological order.
+func (m *CPUMonitor) AddSamples(samples []CPUSample) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range samples {
+		m.samples = append(m.samples, cpuSample{timestamp: s.Timestamp, usage: s.Usage, maxCore: s.MaxCore, loadPerCore: s.LoadPerCore,
+			cgroup: s.Cgroup, steal: s.Steal, iowait: s.IOWait, guest: s.Guest})
+	}
+}
+
+// round2 rounds a percentage to two decimals for logging.
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,465 @@
+package monitor
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"idleshutdown/internal/clock"
+)
+
+var testStart = time.Date(2026, 2, 19, 0, 0, 0, 0, time.UTC)
+
+// fakeProc writes /proc/stat and /proc/loadavg files under a temporary
+// root.
+type fakeProc struct {
+	t          *testing.T
+	root       string
+	busy, idle uint64
+}
+
+func newFakeProc(t *testing.T) *fakeProc {
+	p := &fakeProc{t: t, root: t.TempDir(), busy: 1000, idle: 9000}
+	p.write()
+	return p
+}
+
+// advance adds ticks with the given busy percentage and rewrites the file.
+func (p *fakeProc) advance(ticks uint64, usage float64) {
+	busy := uint64(float64(ticks) * usage / 100)
+	p.busy += busy
+	p.idle += ticks - busy
+	p.write()
+}
+
+func (p *fakeProc) write() {
+	content := fmt.Sprintf("cpu  %d 0 0 %d 0 0 0 0 0 0\ncpu0 %d 0 0 %d 0 0 0 0 0 0\nintr 0\n",
+		p.busy, p.idle, p.busy, p.idle)
+	writeFile(p.t, filepath.Join(p.root, "stat"), content)
+	writeFile(p.t, filepath.Join(p.root, "loadavg"), "0.10 0.05 0.01 1/200 4242\n")
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSampleReadsProcStat(t *testing.T) {
+	proc := newFakeProc(t)
+	fake := clock.NewFake(testStart)
+	m := NewCPUMonitor(30 * time.Second)
+	m.ProcRoot = proc.root
+	m.Clock = fake
+
+	m.Sample()
+	if n := len(m.GetSamples()); n != 0 {
+		t.Fatalf("first reading recorded %d samples, want none", n)
+	}
+
+	// A 20s burst between two readings shows in the sample covering it
+	proc.advance(2000, 100)
+	proc.advance(1000, 0)
+	fake.Advance(30 * time.Second)
+	m.Sample()
+
+	if got := m.GetCurrentUsage(); math.Abs(got-66.67) > 0.01 {
+		t.Errorf("usage = %.2f, want 66.67", got)
+	}
+}
+
+func TestSampleRestartsAfterGapOrReset(t *testing.T) {
+	proc := newFakeProc(t)
+	fake := clock.NewFake(testStart)
+	m := NewCPUMonitor(30 * time.Second)
+	m.ProcRoot = proc.root
+	m.Clock = fake
+	m.Sample()
+
+	// Resumed after a 10 minute suspend: the counters barely moved, so
+	// the delta is dropped rather than spread over the gap
+	proc.advance(100, 90)
+	fake.Advance(10 * time.Minute)
+	m.Sample()
+	if n := len(m.GetSamples()); n != 0 {
+		t.Fatalf("sample across the gap recorded, got %d samples", n)
+	}
+	proc.advance(3000, 10)
+	fake.Advance(30 * time.Second)
+	m.Sample()
+	if got := m.GetCurrentUsage(); got != 10 {
+		t.Errorf("usage after the gap = %.2f, want 10", got)
+	}
+
+	// Counters reset, e.g. after a wrap
+	proc.busy, proc.idle = 10, 90
+	proc.write()
+	fake.Advance(30 * time.Second)
+	m.Sample()
+	proc.advance(3000, 50)
+	fake.Advance(30 * time.Second)
+	m.Sample()
+	if samples := m.GetSamples(); len(samples) != 2 || samples[1].Usage != 50 {
+		t.Errorf("samples after reset = %+v, want the reset skipped, then 50%%", samples)
+	}
+}
+
+func TestSampleReadsCoresAndLoad(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "stat"),
+		"cpu  2000 0 0 18000 0 0 0 0\ncpu0 1000 0 0 9000 0 0 0 0\ncpu1 1000 0 0 9000 0 0 0 0\n")
+	writeFile(t, filepath.Join(root, "loadavg"), "1.50 0.80 0.40 2/300 1234\n")
+
+	fake := clock.NewFake(testStart)
+	m := NewCPUMonitor(30 * time.Second)
+	m.ProcRoot = root
+	m.Clock = fake
+	m.Sample()
+
+	// cpu0 80% busy, cpu1 idle: 40% overall
+	writeFile(t, filepath.Join(root, "stat"),
+		"cpu  2800 0 0 19200 0 0 0 0\ncpu0 1800 0 0 9200 0 0 0 0\ncpu1 1000 0 0 10000 0 0 0 0\n")
+	fake.Advance(30 * time.Second)
+	m.Sample()
+
+	got := m.Current()
+	if got.Usage != 40 || got.MaxCore != 80 || got.LoadPerCore != 75 {
+		t.Fatalf("sample = %+v, want usage 40, max core 80, load per core 75", got)
+	}
+	if !m.IsBelowThreshold(50, 1) {
+		t.Error("aggregate 40% should be below 50%")
+	}
+	m.SetMetric(MetricMaxCore)
+	if check := m.Check(50, 1); check.Idle || check.FirstBreak.Value != 80 {
+		t.Errorf("max_core check = %+v, want the 80%% core to break idleness", check)
+	}
+	m.SetMetric(MetricLoad)
+	if stats := m.WindowStats(1); stats.Peak != 75 {
+		t.Errorf("load stats = %+v, want peak 75", stats)
+	}
+}
+
+func TestSampleAccounting(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "stat"), "cpu  1000 0 0 9000 0 0 0 0 0 0\n")
+	writeFile(t, filepath.Join(root, "loadavg"), "0.00 0.00 0.00 1/100 1\n")
+	fake := clock.NewFake(testStart)
+	m := NewCPUMonitor(30 * time.Second)
+	m.ProcRoot = root
+	m.Clock = fake
+	m.Sample()
+
+	// 600 user ticks of which 200 guest, 2000 idle, 400 iowait, 1000 steal
+	writeFile(t, filepath.Join(root, "stat"), "cpu  1600 0 0 11000 400 0 0 1000 200 0\n")
+	fake.Advance(30 * time.Second)
+	m.Sample()
+
+	got := m.Current()
+	if got.Usage != 40 || got.Steal != 25 || got.IOWait != 10 || got.Guest != 5 {
+		t.Errorf("sample = %+v, want usage 40 (steal busy), steal 25, iowait 10, guest 5", got)
+	}
+
+	before, _ := parseCPULine(strings.Fields("cpu 1000 0 0 9000 0 0 0 0 0 0"))
+	after, _ := parseCPULine(strings.Fields("cpu 1600 0 0 11000 400 0 0 1000 200 0"))
+	for _, tc := range []struct {
+		accounting CPUAccounting
+		want       float64
+	}{
+		{CPUAccounting{}, 40},
+		{CPUAccounting{Steal: AccountIdle}, 15},
+		{CPUAccounting{Steal: AccountExcluded}, 20},
+		{CPUAccounting{Steal: AccountExcluded, IOWait: AccountBusy}, 1000.0 / 30},
+		{CPUAccounting{Steal: AccountExcluded, Guest: AccountIdle}, 400.0 / 30},
+	} {
+		usage, ok := busyPercent(before, after, tc.accounting.withDefaults())
+		if !ok || math.Abs(usage-tc.want) > 0.01 {
+			t.Errorf("%s: usage = %.2f, want %.2f", tc.accounting, usage, tc.want)
+		}
+	}
+}
+
+func TestSampleCgroups(t *testing.T) {
+	cgroups := t.TempDir()
+	setUsage := func(path string, usec int) {
+		dir := filepath.Join(cgroups, path)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, filepath.Join(dir, "cpu.stat"), fmt.Sprintf("usage_usec %d\nuser_usec 0\nsystem_usec 0\n", usec))
+	}
+
+	for _, tc := range []struct {
+		name string
+		sel  CgroupSelection
+		want float64
+	}{
+		// 3s + 1.5s of the 30s interval on one core
+		{"include", CgroupSelection{Include: []string{"user.slice", "app.service", "ghost.service"}}, 15},
+		// 40% overall minus cron's 3s
+		{"exclude", CgroupSelection{Exclude: []string{"system.slice/cron.service"}}, 30},
+		// app.service is not taken away by excluding the slice it lives in
+		{"include and exclude", CgroupSelection{Include: []string{"user.slice", "app.service"}, Exclude: []string{"system.slice"}}, 15},
+		// user.slice's 3s once, without the 1.2s of the session nested in it
+		{"nested", CgroupSelection{Include: []string{"user.slice", "session-1.scope"}}, 10},
+		// system.slice less cron.service inside it: 1.5s
+		{"exclude inside include", CgroupSelection{Include: []string{"system.slice"}, Exclude: []string{"cron.service", "user.slice"}}, 5},
+		{"none", CgroupSelection{}, 40},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			proc := newFakeProc(t)
+			fake := clock.NewFake(testStart)
+			m := NewCPUMonitor(30 * time.Second)
+			m.ProcRoot, m.CgroupRoot, m.Clock = proc.root, cgroups, fake
+			m.SetCgroups(tc.sel)
+			setUsage("user.slice", 1_000_000)
+			setUsage("user.slice/session-1.scope", 800_000)
+			setUsage("system.slice", 7_000_000)
+			setUsage("system.slice/app.service", 2_000_000)
+			setUsage("system.slice/cron.service", 5_000_000)
+			m.Sample()
+
+			setUsage("user.slice", 4_000_000)
+			setUsage("user.slice/session-1.scope", 2_000_000)
+			setUsage("system.slice", 11_500_000)
+			setUsage("system.slice/app.service", 3_500_000)
+			setUsage("system.slice/cron.service", 8_000_000)
+			proc.advance(3000, 40)
+			fake.Advance(30 * time.Second)
+			m.Sample()
+
+			if got := m.Current(); math.Abs(got.Cgroup-tc.want) > 0.01 || got.Usage != 40 {
+				t.Errorf("sample = %+v, want cgroup usage %.0f", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMaxCoreFallsBackToAggregate(t *testing.T) {
+	// Samples recorded before per-core data existed
+	s := CPUSample{Usage: 30}
+	if got := s.Value(MetricMaxCore); got != 30 {
+		t.Errorf("max_core = %v, want the aggregate 30", got)
+	}
+	if got := s.Value(MetricLoad); got != 0 {
+		t.Errorf("load = %v, want 0", got)
+	}
+}
+
+func TestReadCPUStatsMissingFile(t *testing.T) {
+	if _, err := readCPUStats(t.TempDir()); err == nil {
+		t.Fatal("expected error for missing stat file")
+	}
+}
+
+func cpuMonitorWithSamples(now time.Time, usages ...float64) *CPUMonitor {
+	m := NewCPUMonitor(30 * time.Second)
+	m.Clock = clock.NewFake(now)
+	var samples []CPUSample
+	for i, u := range usages {
+		ts := now.Add(-time.Duration(len(usages)-1-i) * 30 * time.Second)
+		samples = append(samples, CPUSample{Timestamp: ts, Usage: u})
+	}
+	m.AddSamples(samples)
+	return m
+}
+
+func repeat(v float64, n int) []float64 {
+	vals := make([]float64, n)
+	for i := range vals {
+		vals[i] = v
+	}
+	return vals
+}
+
+func TestIsBelowThreshold(t *testing.T) {
+	now := testStart.Add(2 * time.Hour)
+
+	idle := cpuMonitorWithSamples(now, repeat(3, 120)...)
+	if !idle.IsBelowThreshold(5, 60) {
+		t.Error("expected idle when all samples are below threshold")
+	}
+
+	spike := cpuMonitorWithSamples(now, append(repeat(3, 100), 50, 3, 3)...)
+	if spike.IsBelowThreshold(5, 60) {
+		t.Error("expected busy when a sample in the window is above threshold")
+	}
+
+	old := cpuMonitorWithSamples(now, append([]float64{90}, repeat(3, 119)...)...)
+	if !old.IsBelowThreshold(5, 30) {
+		t.Error("samples older than the window should be ignored")
+	}
+
+	sparse := cpuMonitorWithSamples(now, repeat(3, 10)...)
+	if sparse.IsBelowThreshold(5, 60) {
+		t.Error("expected not idle with insufficient samples")
+	}
+}
+
+func TestIsBelowThresholdAtIgnoresFutureSamples(t *testing.T) {
+	now := testStart.Add(2 * time.Hour)
+	m := cpuMonitorWithSamples(now, append(repeat(3, 120), 80)...)
+
+	if m.IsBelowThreshold(5, 60) {
+		t.Error("expected busy at the latest sample")
+	}
+	if !m.IsBelowThresholdAt(now.Add(-30*time.Second), 5, 60) {
+		t.Error("samples after the evaluation time should be ignored")
+	}
+}
+
+func TestWindowStats(t *testing.T) {
+	now := testStart.Add(2 * time.Hour)
+	m := cpuMonitorWithSamples(now, append([]float64{90}, append(repeat(2, 118), 8)...)...)
+
+	stats := m.WindowStats(30)
+	if stats.Samples != 60 || stats.Peak != 8 || stats.Minutes != 30 {
+		t.Errorf("stats = %+v, want 60 samples peaking at 8%%", stats)
+	}
+	if want := (2*59 + 8) / 60.0; stats.Average != want {
+		t.Errorf("average = %v, want %v", stats.Average, want)
+	}
+}
+
+func TestCheckReportsBreachesGapsAndIdleAt(t *testing.T) {
+	now := testStart.Add(2 * time.Hour)
+	usages := repeat(3, 120)
+	usages[70], usages[100] = 31.2, 40 // 25 and 10 minutes before now
+	m := cpuMonitorWithSamples(now, usages...)
+
+	check := m.Check(25, 60)
+	if check.Idle || check.Breaks != 2 || check.Samples != 120 {
+		t.Fatalf("check = %+v", check)
+	}
+	if b := check.FirstBreak; b.Value != 31.2 || !b.Time.Equal(now.Add(-49*30*time.Second)) {
+		t.Errorf("first break = %+v", b)
+	}
+	if want := check.LastBreak.Time.Add(time.Hour); !check.IdleAt.Equal(want) {
+		t.Errorf("idle at %v, want an hour after the last break (%v)", check.IdleAt, want)
+	}
+	if len(check.Gaps) != 0 {
+		t.Errorf("gaps = %v", check.Gaps)
+	}
+
+	// Ten minutes of samples: not enough, and a gap before them
+	sparse := cpuMonitorWithSamples(now, repeat(3, 20)...)
+	check = sparse.Check(25, 60)
+	if check.Idle || check.FirstBreak != nil || len(check.Gaps) != 1 {
+		t.Fatalf("sparse check = %+v", check)
+	}
+	if want := now.Add(10 * 30 * time.Second); !check.IdleAt.Equal(want) {
+		t.Errorf("sparse idle at %v, want %v (10 more samples)", check.IdleAt, want)
+	}
+
+	if check := cpuMonitorWithSamples(now, repeat(3, 120)...).Check(25, 60); !check.Idle || !check.IdleAt.Equal(now) {
+		t.Errorf("idle check = %+v", check)
+	}
+}
+
+func TestCheckToleratesSpikes(t *testing.T) {
+	now := testStart.Add(2 * time.Hour)
+	usages := repeat(3, 120)
+	usages[20], usages[50], usages[80] = 50, 50, 50 // single-sample spikes
+	usages[100], usages[101] = 50, 50               // and a double one
+	m := cpuMonitorWithSamples(now, usages...)
+
+	tests := []struct {
+		tolerance CPUTolerance
+		idle      bool
+		tolerated int
+	}{
+		{CPUTolerance{}, false, 0},
+		{CPUTolerance{MaxConsecutive: 1}, false, 3},
+		{CPUTolerance{MaxConsecutive: 2}, true, 5},
+		{CPUTolerance{Percent: 3}, false, 0},
+		{CPUTolerance{Percent: 5}, true, 5},
+		{CPUTolerance{Percent: 3, MaxConsecutive: 1}, false, 3},
+		// Averaged over 2 minutes, a single 50% sample among 3% ones stays
+		// below 25%, two in a row do not
+		{CPUTolerance{RollingAverage: 2 * time.Minute}, false, 0},
+	}
+	for _, tt := range tests {
+		m.SetTolerance(tt.tolerance)
+		check := m.Check(25, 60)
+		if check.Idle != tt.idle || check.Tolerated != tt.tolerated {
+			t.Errorf("tolerance %v: idle %v, %d tolerated, want %v, %d", tt.tolerance, check.Idle, check.Tolerated, tt.idle, tt.tolerated)
+		}
+	}
+
+	// With the rolling average only the double spike breaks
+	m.SetTolerance(CPUTolerance{RollingAverage: 2 * time.Minute})
+	check := m.Check(25, 60)
+	if check.FirstBreak == nil || !check.FirstBreak.Time.Equal(now.Add(-18*30*time.Second)) || check.Breaks != 3 {
+		t.Errorf("rolling average check = %+v, first break %+v", check, check.FirstBreak)
+	}
+}
+
+func TestScore(t *testing.T) {
+	now := testStart.Add(2 * time.Hour)
+	fraction := ScoreOptions{Method: ScoreFraction}
+
+	if s := cpuMonitorWithSamples(now, repeat(3, 120)...).Score(5, 60, fraction); s.Value != 1 || s.IdleSamples != 120 {
+		t.Errorf("idle score = %+v", s)
+	}
+
+	usages := repeat(3, 120)
+	usages[40] = 50                                 // a 30s spike
+	usages[80], usages[81], usages[82] = 50, 50, 50 // and a 90s one
+	m := cpuMonitorWithSamples(now, usages...)
+	if s := m.Score(5, 60, fraction); s.IdleSamples != 116 || s.Value != 116.0/120 {
+		t.Errorf("fraction score = %+v", s)
+	}
+	tolerant := ScoreOptions{Method: ScoreFraction, SpikeTolerance: time.Minute}
+	if s := m.Score(5, 60, tolerant); s.Tolerated != 1 || s.Value != 117.0/120 {
+		t.Errorf("score with spike tolerance = %+v", s)
+	}
+
+	// EWMA: the same spike counts for more when it is recent
+	ewma := ScoreOptions{Method: ScoreEWMA, HalfLife: 10 * time.Minute}
+	early, late := repeat(3, 120), repeat(3, 120)
+	early[10], late[110] = 50, 50
+	if e, l := cpuMonitorWithSamples(now, early...).Score(5, 60, ewma), cpuMonitorWithSamples(now, late...).Score(5, 60, ewma); e.Value <= l.Value || l.Value >= 119.0/120 {
+		t.Errorf("ewma early spike %v, late spike %v", e.Value, l.Value)
+	}
+
+	// Too few samples scale the score down
+	if s := cpuMonitorWithSamples(now, repeat(3, 20)...).Score(5, 60, fraction); s.Value != 20.0/30 {
+		t.Errorf("sparse score = %+v", s)
+	}
+}
+
+func TestWindowSamples(t *testing.T) {
+	now := testStart.Add(2 * time.Hour)
+	m := cpuMonitorWithSamples(now, append(repeat(2, 119), 8)...)
+
+	samples := m.WindowSamples(30)
+	if len(samples) != 60 || samples[59].Usage != 8 || !samples[59].Timestamp.Equal(now) {
+		t.Errorf("window = %d samples ending %v, want 60 ending at 8%% now", len(samples), samples[len(samples)-1])
+	}
+	if got := m.WindowSamplesAt(now.Add(-15*time.Minute), 30); len(got) != 60 || got[59].Usage != 2 {
+		t.Errorf("window 15m ago = %d samples", len(got))
+	}
+}
+
+func TestSamplePrunesOldSamples(t *testing.T) {
+	proc := newFakeProc(t)
+	fake := clock.NewFake(testStart)
+	m := NewCPUMonitor(30 * time.Second)
+	m.ProcRoot = proc.root
+	m.Clock = fake
+	m.Sample() // primes the counters
+	m.AddSamples([]CPUSample{{Timestamp: testStart.Add(-maxSampleRetention), Usage: 1}})
+
+	proc.advance(3000, 5)
+	fake.Advance(30 * time.Second)
+	m.Sample()
+
+	samples := m.GetSamples()
+	if len(samples) != 1 || !samples[0].Timestamp.Equal(fake.Now()) {
+		t.Errorf("expected only the fresh sample to remain, got %v", samples)
+	}
+}
@@ -0,0 +1,416 @@
+package monitor
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"idleshutdown/internal/clock"
+	"idleshutdown/internal/logging"
+)
+
+// Memory activity metrics, as named in breaches of the memory check.
+const (
+	MemoryPageFaults  = "page_faults"
+	MemoryMajorFaults = "major_faults"
+	MemorySwap        = "swap"
+)
+
+// MemorySample is a reading of paging and swap activity over one sampling
+// interval.
+type MemorySample struct {
+	Timestamp time.Time
+	// PageFaults and MajorFaults are faults per second; major faults are
+	// the ones that had to read from disk.
+	PageFaults  float64
+	MajorFaults float64
+	// SwapIn and SwapOut are pages swapped per second.
+	SwapIn  float64
+	SwapOut float64
+	// MemUsed and SwapUsed are the percentage of memory and swap in use at
+	// the time of the sample, from /proc/meminfo.
+	MemUsed  float64
+	SwapUsed float64
+}
+
+// Swap returns the pages swapped in and out per second.
+func (s MemorySample) Swap() float64 {
+	return s.SwapIn + s.SwapOut
+}
+
+// MemoryLimits are the activity rates at or above which the memory signal
+// is busy. A zero limit is not checked.
+type MemoryLimits struct {
+	PageFaults  float64 `json:"page_faults,omitempty"`  // faults per second
+	MajorFaults float64 `json:"major_faults,omitempty"` // major faults per second
+	Swap        float64 `json:"swap,omitempty"`         // pages swapped in and out per second
+}
+
+// Enabled reports whether any limit is checked.
+func (l MemoryLimits) Enabled() bool {
+	return l.PageFaults > 0 || l.MajorFaults > 0 || l.Swap > 0
+}
+
+// Limit returns the limit on metric.
+func (l MemoryLimits) Limit(metric string) float64 {
+	switch metric {
+	case MemoryPageFaults:
+		return l.PageFaults
+	case MemoryMajorFaults:
+		return l.MajorFaults
+	case MemorySwap:
+		return l.Swap
+	}
+	return 0
+}
+
+// String lists the checked limits, e.g. "swap 100 pages/s, major faults
+// 50/s".
+func (l MemoryLimits) String() string {
+	var parts []string
+	if l.Swap > 0 {
+		parts = append(parts, fmt.Sprintf("swap %g pages/s", l.Swap))
+	}
+	if l.MajorFaults > 0 {
+		parts = append(parts, fmt.Sprintf("major faults %g/s", l.MajorFaults))
+	}
+	if l.PageFaults > 0 {
+		parts = append(parts, fmt.Sprintf("page faults %g/s", l.PageFaults))
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Exceeded returns the first metric of s at or above its limit, with its
+// value, or "" if s is within every limit. Swapping is checked first, as
+// the strongest sign of memory pressure.
+func (l MemoryLimits) Exceeded(s MemorySample) (metric string, value float64) {
+	switch {
+	case l.Swap > 0 && s.Swap() >= l.Swap:
+		return MemorySwap, s.Swap()
+	case l.MajorFaults > 0 && s.MajorFaults >= l.MajorFaults:
+		return MemoryMajorFaults, s.MajorFaults
+	case l.PageFaults > 0 && s.PageFaults >= l.PageFaults:
+		return MemoryPageFaults, s.PageFaults
+	}
+	return "", 0
+}
+
+// vmstatCounters are the /proc/vmstat counters the memory monitor uses.
+type vmstatCounters struct {
+	pgfault, pgmajfault, pswpin, pswpout uint64
+}
+
+// MemoryMonitor tracks paging and swap activity from /proc/vmstat, which
+// shows a workload actively using memory even while its CPU usage looks
+// idle, and memory and swap usage from /proc/meminfo.
+type MemoryMonitor struct {
+	mu       sync.RWMutex
+	samples  []MemorySample
+	interval time.Duration
+	limits   MemoryLimits
+
+	// prev holds the counters of the previous Sample, taken at prevAt.
+	// Only Sample uses them.
+	prev   *vmstatCounters
+	prevAt time.Time
+
+	// Clock and ProcRoot may be replaced before Start, e.g. in tests.
+	Clock    clock.Clock
+	ProcRoot string
+}
+
+// NewMemoryMonitor creates a new memory monitor with the specified
+// sampling interval and no limits.
+func NewMemoryMonitor(samplingInterval time.Duration) *MemoryMonitor {
+	return &MemoryMonitor{
+		samples:  make([]MemorySample, 0, 256),
+		interval: samplingInterval,
+		Clock:    clock.Real,
+		ProcRoot: DefaultProcRoot,
+	}
+}
+
+// SetLimits sets the activity rates the idle check compares samples with.
+func (m *MemoryMonitor) SetLimits(limits MemoryLimits) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.limits = limits
+}
+
+// Limits returns the activity rates the idle check compares samples with.
+func (m *MemoryMonitor) Limits() MemoryLimits {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.limits
+}
+
+// Start begins memory monitoring in a background goroutine.
+func (m *MemoryMonitor) Start(stopCh <-chan struct{}) {
+	go func() {
+		ticker := m.Clock.NewTicker(m.interval)
+		defer ticker.Stop()
+
+		m.Sample() // primes the counters; the first sample follows a tick later
+
+		for {
+			select {
+			case <-ticker.C():
+				m.Sample()
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Sample reads /proc/vmstat and /proc/meminfo and appends the activity
+// since the previous call to the rolling buffer. The first call, and the
+// first after a gap such as a suspend, only records the counters.
+func (m *MemoryMonitor) Sample() {
+	now := m.Clock.Now()
+	sample, ok, err := m.measure(now)
+	if err != nil {
+		slog.Error("Reading memory activity failed", logging.EventKey, logging.Sampling, "error", err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sample.Timestamp = now
+	m.samples = append(m.samples, sample)
+
+	cutoff := now.Add(-maxSampleRetention)
+	start := 0
+	for start < len(m.samples) && !m.samples[start].Timestamp.After(cutoff) {
+		start++
+	}
+	if start > 0 {
+		m.samples = m.samples[start:]
+	}
+}
+
+// measure reads the counters at now and returns the rates since the
+// previous reading. ok is false when there is no usable previous reading:
+// on the first call, after a gap of more than two intervals, or when a
+// counter went backwards.
+func (m *MemoryMonitor) measure(now time.Time) (sample MemorySample, ok bool, err error) {
+	counters, err := readVMStat(filepath.Join(m.ProcRoot, "vmstat"))
+	if err != nil {
+		return MemorySample{}, false, err
+	}
+	sample.MemUsed, sample.SwapUsed, err = readMemInfo(filepath.Join(m.ProcRoot, "meminfo"))
+	if err != nil {
+		return MemorySample{}, false, err
+	}
+
+	prev, prevAt := m.prev, m.prevAt
+	m.prev, m.prevAt = &counters, now
+	if prev == nil {
+		return MemorySample{}, false, nil
+	}
+	// As for CPU samples, a gap in wall-clock time means the VM was
+	// suspended and the counters stood still.
+	elapsed := now.Round(0).Sub(prevAt.Round(0))
+	if elapsed <= 0 || m.interval > 0 && elapsed > 2*m.interval {
+		return MemorySample{}, false, nil
+	}
+	if counters.pgfault < prev.pgfault || counters.pgmajfault < prev.pgmajfault ||
+		counters.pswpin < prev.pswpin || counters.pswpout < prev.pswpout {
+		slog.Warn("vmstat counters went backwards, restarting from current counters", logging.EventKey, logging.Sampling)
+		return MemorySample{}, false, nil
+	}
+
+	seconds := elapsed.Seconds()
+	sample.PageFaults = float64(counters.pgfault-prev.pgfault) / seconds
+	sample.MajorFaults = float64(counters.pgmajfault-prev.pgmajfault) / seconds
+	sample.SwapIn = float64(counters.pswpin-prev.pswpin) / seconds
+	sample.SwapOut = float64(counters.pswpout-prev.pswpout) / seconds
+	return sample, true, nil
+}
+
+// readVMStat reads the fault and swap counters from a vmstat file of
+// "name value" lines. Counters the kernel does not report, such as the
+// swap ones without swap support, read as zero.
+func readVMStat(path string) (vmstatCounters, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return vmstatCounters{}, err
+	}
+	defer f.Close()
+
+	var c vmstatCounters
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, val, _ := strings.Cut(scanner.Text(), " ")
+		var dst *uint64
+		switch name {
+		case "pgfault":
+			dst = &c.pgfault
+		case "pgmajfault":
+			dst = &c.pgmajfault
+		case "pswpin":
+			dst = &c.pswpin
+		case "pswpout":
+			dst = &c.pswpout
+		default:
+			continue
+		}
+		if *dst, err = strconv.ParseUint(strings.TrimSpace(val), 10, 64); err != nil {
+			return vmstatCounters{}, fmt.Errorf("parse %s: %w", path, err)
+		}
+	}
+	return c, scanner.Err()
+}
+
+// readMemInfo returns the percentage of memory and swap in use from a
+// meminfo file. Memory in use is what MemAvailable leaves of MemTotal.
+func readMemInfo(path string) (memUsed, swapUsed float64, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	kb := make(map[string]float64, 4)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, val, _ := strings.Cut(scanner.Text(), ":")
+		switch name {
+		case "MemTotal", "MemAvailable", "SwapTotal", "SwapFree":
+		default:
+			continue
+		}
+		fields := strings.Fields(val)
+		if len(fields) == 0 {
+			continue
+		}
+		v, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("parse %s: %w", path, err)
+		}
+		kb[name] = v
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	if total := kb["MemTotal"]; total > 0 {
+		memUsed = round2((total - kb["MemAvailable"]) / total * 100)
+	}
+	if total := kb["SwapTotal"]; total > 0 {
+		swapUsed = round2((total - kb["SwapFree"]) / total * 100)
+	}
+	return memUsed, swapUsed, nil
+}
+
+// IsIdle checks if paging and swapping stayed below the limits for the
+// specified duration.
+func (m *MemoryMonitor) IsIdle(minutes int) bool {
+	return m.IsIdleAt(m.Clock.Now(), minutes)
+}
+
+// IsIdleAt is IsIdle evaluated as if the current time were now.
+func (m *MemoryMonitor) IsIdleAt(now time.Time, minutes int) bool {
+	check := m.CheckAt(now, minutes)
+
+	if check.Samples < check.MinSamples {
+		slog.Info("Memory check: insufficient samples", logging.EventKey, logging.MemoryCheck,
+			"idle", false, "samples", check.Samples, "min_samples", check.MinSamples, "minutes", minutes)
+		return false
+	}
+
+	if b := check.FirstBreak; b != nil {
+		slog.Info("Memory check: not idle", logging.EventKey, logging.MemoryCheck,
+			"idle", false, "metric", b.Metric, "rate", round2(b.Value), "at", b.Time, "minutes", minutes)
+		return false
+	}
+
+	slog.Info("Memory check: idle", logging.EventKey, logging.MemoryCheck,
+		"idle", true, "samples", check.Samples, "minutes", minutes)
+	return true
+}
+
+// Check returns the detailed result of the IsIdle check.
+func (m *MemoryMonitor) Check(minutes int) WindowCheck {
+	return m.CheckAt(m.Clock.Now(), minutes)
+}
+
+// CheckAt is Check evaluated as if the current time were now.
+func (m *MemoryMonitor) CheckAt(now time.Time, minutes int) WindowCheck {
+	return checkWindow(now, minutes, m.interval, m.checkPoints(windowCutoff(now, minutes)))
+}
+
+// checkPoints returns the samples taken after since, breaking idleness
+// when a rate reaches its limit.
+func (m *MemoryMonitor) checkPoints(since time.Time) []checkPoint {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	samples := m.samples[windowStart(len(m.samples), since, func(i int) time.Time { return m.samples[i].Timestamp }):]
+	points := make([]checkPoint, len(samples))
+	for i, s := range samples {
+		metric, v := m.limits.Exceeded(s)
+		points[i] = checkPoint{time: s.Timestamp, value: v, metric: metric, breaks: metric != ""}
+	}
+	return points
+}
+
+// Current returns the most recent sample, or the zero sample if there is
+// none yet.
+func (m *MemoryMonitor) Current() MemorySample {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if len(m.samples) == 0 {
+		return MemorySample{}
+	}
+	return m.samples[len(m.samples)-1]
+}
+
+// GetSamples returns a snapshot of all retained memory samples.
+func (m *MemoryMonitor) GetSamples() []MemorySample {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]MemorySample(nil), m.samples...)
+}
+
+// WindowSamples returns the samples in the last minutes, the window IsIdle
+// checks.
+func (m *MemoryMonitor) WindowSamples(minutes int) []MemorySample {
+	return m.WindowSamplesAt(m.Clock.Now(), minutes)
+}
+
+// WindowSamplesAt is WindowSamples evaluated as if the current time were now.
+func (m *MemoryMonitor) WindowSamplesAt(now time.Time, minutes int) []MemorySample {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	cutoff := now.Add(-time.Duration(minutes) * time.Minute)
+	var result []MemorySample
+	for _, s := range m.samples {
+		if s.Timestamp.After(cutoff) && !s.Timestamp.After(now) {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// AddSamples appends previously recorded samples, e.g. loaded from a sample
+// file for offline replay. Samples must be in chronological order.
+func (m *MemoryMonitor) AddSamples(samples []MemorySample) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.samples = append(m.samples, samples...)
+}
@@ -0,0 +1,94 @@
+package monitor
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"idleshutdown/internal/clock"
+)
+
+// writeMemory writes vmstat and meminfo files under root with the given
+// fault and swap counters, 4 GiB of memory with 1 GiB available and 1 GiB
+// of swap, half of it used.
+func writeMemory(t *testing.T, root string, pgfault, pgmajfault, pswpin, pswpout uint64) {
+	t.Helper()
+	writeFile(t, filepath.Join(root, "vmstat"), fmt.Sprintf(
+		"nr_free_pages 12345\npgfault %d\npgmajfault %d\npswpin %d\npswpout %d\n",
+		pgfault, pgmajfault, pswpin, pswpout))
+	writeFile(t, filepath.Join(root, "meminfo"),
+		"MemTotal:        4194304 kB\nMemFree:          524288 kB\nMemAvailable:    1048576 kB\n"+
+			"SwapTotal:       1048576 kB\nSwapFree:         524288 kB\n")
+}
+
+func TestMemorySample(t *testing.T) {
+	root := t.TempDir()
+	writeMemory(t, root, 1000, 10, 0, 0)
+	fake := clock.NewFake(testStart)
+	m := NewMemoryMonitor(30 * time.Second)
+	m.ProcRoot = root
+	m.Clock = fake
+	m.SetLimits(MemoryLimits{MajorFaults: 50, Swap: 100})
+
+	m.Sample()
+	if n := len(m.GetSamples()); n != 0 {
+		t.Fatalf("first reading recorded %d samples, want none", n)
+	}
+
+	// quiet: 30 minor faults in 30 s
+	writeMemory(t, root, 1030, 10, 0, 0)
+	fake.Advance(30 * time.Second)
+	m.Sample()
+
+	s := m.Current()
+	if s.PageFaults != 1 || s.MajorFaults != 0 || s.Swap() != 0 || s.MemUsed != 75 || s.SwapUsed != 50 {
+		t.Errorf("sample = %+v, want 1 fault/s, 75%% memory and 50%% swap used", s)
+	}
+	if !m.IsIdle(1) {
+		t.Error("quiet memory is not idle")
+	}
+
+	// swapping: 3000 pages in and 1500 out in 30 s
+	writeMemory(t, root, 1060, 40, 3000, 1500)
+	fake.Advance(30 * time.Second)
+	m.Sample()
+
+	check := m.Check(1)
+	if check.Idle || check.FirstBreak == nil || check.FirstBreak.Metric != MemorySwap || check.FirstBreak.Value != 150 {
+		t.Errorf("check while swapping = %+v, want a swap breach of 150 pages/s", check)
+	}
+	if m.IsIdle(1) {
+		t.Error("swapping memory is idle")
+	}
+
+	// without limits nothing is busy
+	m.SetLimits(MemoryLimits{})
+	if !m.IsIdle(1) {
+		t.Error("memory without limits is not idle")
+	}
+}
+
+func TestMemoryCountersBackwards(t *testing.T) {
+	root := t.TempDir()
+	writeMemory(t, root, 5000, 10, 0, 0)
+	fake := clock.NewFake(testStart)
+	m := NewMemoryMonitor(30 * time.Second)
+	m.ProcRoot = root
+	m.Clock = fake
+
+	m.Sample()
+	writeMemory(t, root, 100, 10, 0, 0)
+	fake.Advance(30 * time.Second)
+	m.Sample()
+	if n := len(m.GetSamples()); n != 0 {
+		t.Fatalf("sample after counters went backwards recorded, got %d", n)
+	}
+
+	writeMemory(t, root, 130, 10, 0, 0)
+	fake.Advance(30 * time.Second)
+	m.Sample()
+	if s := m.Current(); s.PageFaults != 1 {
+		t.Errorf("sample after restart = %+v, want 1 fault/s", s)
+	}
+}
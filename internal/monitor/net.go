@@ -0,0 +1,379 @@
+package monitor
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"idleshutdown/internal/clock"
+	"idleshutdown/internal/logging"
+)
+
+// Network traffic metrics, as named in breaches of the net check.
+const (
+	NetBytes   = "bytes"
+	NetPackets = "packets"
+)
+
+// NetSample is the network traffic of the monitored interfaces over one
+// sampling interval, in bytes and packets per second.
+type NetSample struct {
+	Timestamp time.Time
+	RxBytes   float64
+	TxBytes   float64
+	RxPackets float64
+	TxPackets float64
+}
+
+// Bytes returns the bytes received and sent per second.
+func (s NetSample) Bytes() float64 {
+	return s.RxBytes + s.TxBytes
+}
+
+// Packets returns the packets received and sent per second.
+func (s NetSample) Packets() float64 {
+	return s.RxPackets + s.TxPackets
+}
+
+// NetLimits are the traffic rates at or above which the net signal is
+// busy. A zero limit is not checked.
+type NetLimits struct {
+	Bytes   float64 `json:"bytes,omitempty"`   // bytes received and sent per second
+	Packets float64 `json:"packets,omitempty"` // packets received and sent per second
+}
+
+// Enabled reports whether any limit is checked.
+func (l NetLimits) Enabled() bool {
+	return l.Bytes > 0 || l.Packets > 0
+}
+
+// Limit returns the limit on metric.
+func (l NetLimits) Limit(metric string) float64 {
+	switch metric {
+	case NetBytes:
+		return l.Bytes
+	case NetPackets:
+		return l.Packets
+	}
+	return 0
+}
+
+// String lists the checked limits, e.g. "20000 bytes/s, 100 packets/s".
+func (l NetLimits) String() string {
+	var parts []string
+	if l.Bytes > 0 {
+		parts = append(parts, fmt.Sprintf("%g bytes/s", l.Bytes))
+	}
+	if l.Packets > 0 {
+		parts = append(parts, fmt.Sprintf("%g packets/s", l.Packets))
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Exceeded returns the first metric of s at or above its limit, with its
+// value, or "" if s is within every limit.
+func (l NetLimits) Exceeded(s NetSample) (metric string, value float64) {
+	switch {
+	case l.Bytes > 0 && s.Bytes() >= l.Bytes:
+		return NetBytes, s.Bytes()
+	case l.Packets > 0 && s.Packets() >= l.Packets:
+		return NetPackets, s.Packets()
+	}
+	return "", 0
+}
+
+// netCounters are the /proc/net/dev counters of one interface.
+type netCounters struct {
+	rxBytes, rxPackets, txBytes, txPackets uint64
+}
+
+// NetMonitor tracks network traffic from /proc/net/dev, which shows a VM
+// serving or transferring data while its CPU looks idle.
+type NetMonitor struct {
+	mu         sync.RWMutex
+	samples    []NetSample
+	interval   time.Duration
+	limits     NetLimits
+	interfaces []string
+
+	// prev holds the counters of the previous Sample by interface, taken
+	// at prevAt. Only Sample uses them.
+	prev   map[string]netCounters
+	prevAt time.Time
+
+	// Clock and ProcRoot may be replaced before Start, e.g. in tests.
+	Clock    clock.Clock
+	ProcRoot string
+}
+
+// NewNetMonitor creates a new network monitor with the specified sampling
+// interval, no limits and every interface but loopback.
+func NewNetMonitor(samplingInterval time.Duration) *NetMonitor {
+	return &NetMonitor{
+		samples:  make([]NetSample, 0, 256),
+		interval: samplingInterval,
+		Clock:    clock.Real,
+		ProcRoot: DefaultProcRoot,
+	}
+}
+
+// SetLimits sets the traffic rates the idle check compares samples with.
+func (m *NetMonitor) SetLimits(limits NetLimits) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.limits = limits
+}
+
+// Limits returns the traffic rates the idle check compares samples with.
+func (m *NetMonitor) Limits() NetLimits {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.limits
+}
+
+// SetInterfaces selects the interfaces whose traffic is measured; empty
+// means every interface but loopback. The change applies from the next
+// sample on.
+func (m *NetMonitor) SetInterfaces(interfaces []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.interfaces = interfaces
+}
+
+// Interfaces returns the interfaces whose traffic is measured.
+func (m *NetMonitor) Interfaces() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.interfaces
+}
+
+// Start begins network monitoring in a background goroutine.
+func (m *NetMonitor) Start(stopCh <-chan struct{}) {
+	go func() {
+		ticker := m.Clock.NewTicker(m.interval)
+		defer ticker.Stop()
+
+		m.Sample() // primes the counters; the first sample follows a tick later
+
+		for {
+			select {
+			case <-ticker.C():
+				m.Sample()
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Sample reads /proc/net/dev and appends the traffic since the previous
+// call to the rolling buffer. The first call, and the first after a gap
+// such as a suspend, only records the counters.
+func (m *NetMonitor) Sample() {
+	now := m.Clock.Now()
+	sample, ok, err := m.measure(now)
+	if err != nil {
+		slog.Error("Reading network traffic failed", logging.EventKey, logging.Sampling, "error", err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sample.Timestamp = now
+	m.samples = append(m.samples, sample)
+
+	cutoff := now.Add(-maxSampleRetention)
+	start := 0
+	for start < len(m.samples) && !m.samples[start].Timestamp.After(cutoff) {
+		start++
+	}
+	if start > 0 {
+		m.samples = m.samples[start:]
+	}
+}
+
+// measure reads the counters at now and returns the rates of the selected
+// interfaces since the previous reading. ok is false when there is no
+// usable previous reading: on the first call or after a gap of more than
+// two intervals. An interface that is new, or whose counters went
+// backwards because it was recreated, counts from the next reading.
+func (m *NetMonitor) measure(now time.Time) (sample NetSample, ok bool, err error) {
+	counters, err := readNetDev(filepath.Join(m.ProcRoot, "net", "dev"))
+	if err != nil {
+		return NetSample{}, false, err
+	}
+
+	prev, prevAt := m.prev, m.prevAt
+	m.prev, m.prevAt = counters, now
+	if prev == nil {
+		return NetSample{}, false, nil
+	}
+	// As for CPU samples, a gap in wall-clock time means the VM was
+	// suspended and the counters stood still.
+	elapsed := now.Round(0).Sub(prevAt.Round(0))
+	if elapsed <= 0 || m.interval > 0 && elapsed > 2*m.interval {
+		return NetSample{}, false, nil
+	}
+
+	interfaces := m.Interfaces()
+	seconds := elapsed.Seconds()
+	for name, c := range counters {
+		if len(interfaces) > 0 && !slices.Contains(interfaces, name) || len(interfaces) == 0 && name == "lo" {
+			continue
+		}
+		p, seen := prev[name]
+		if !seen || c.rxBytes < p.rxBytes || c.txBytes < p.txBytes || c.rxPackets < p.rxPackets || c.txPackets < p.txPackets {
+			continue
+		}
+		sample.RxBytes += float64(c.rxBytes-p.rxBytes) / seconds
+		sample.TxBytes += float64(c.txBytes-p.txBytes) / seconds
+		sample.RxPackets += float64(c.rxPackets-p.rxPackets) / seconds
+		sample.TxPackets += float64(c.txPackets-p.txPackets) / seconds
+	}
+	return sample, true, nil
+}
+
+// readNetDev reads the byte and packet counters of each interface from a
+// /proc/net/dev file: two header lines, then "name: <8 receive counters>
+// <8 transmit counters>" per interface.
+func readNetDev(path string) (map[string]netCounters, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	counters := map[string]netCounters{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, rest, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue // header
+		}
+		fields := strings.Fields(rest)
+		if len(fields) < 10 {
+			return nil, fmt.Errorf("parse %s: short line for %s", path, strings.TrimSpace(name))
+		}
+		var vals [4]uint64
+		for i, idx := range []int{0, 1, 8, 9} {
+			if vals[i], err = strconv.ParseUint(fields[idx], 10, 64); err != nil {
+				return nil, fmt.Errorf("parse %s: %w", path, err)
+			}
+		}
+		counters[strings.TrimSpace(name)] = netCounters{rxBytes: vals[0], rxPackets: vals[1], txBytes: vals[2], txPackets: vals[3]}
+	}
+	return counters, scanner.Err()
+}
+
+// IsIdle checks if network traffic stayed below the limits for the
+// specified duration.
+func (m *NetMonitor) IsIdle(minutes int) bool {
+	return m.IsIdleAt(m.Clock.Now(), minutes)
+}
+
+// IsIdleAt is IsIdle evaluated as if the current time were now.
+func (m *NetMonitor) IsIdleAt(now time.Time, minutes int) bool {
+	check := m.CheckAt(now, minutes)
+
+	if check.Samples < check.MinSamples {
+		slog.Info("Network check: insufficient samples", logging.EventKey, logging.NetCheck,
+			"idle", false, "samples", check.Samples, "min_samples", check.MinSamples, "minutes", minutes)
+		return false
+	}
+
+	if b := check.FirstBreak; b != nil {
+		slog.Info("Network check: not idle", logging.EventKey, logging.NetCheck,
+			"idle", false, "metric", b.Metric, "rate", round2(b.Value), "at", b.Time, "minutes", minutes)
+		return false
+	}
+
+	slog.Info("Network check: idle", logging.EventKey, logging.NetCheck,
+		"idle", true, "samples", check.Samples, "minutes", minutes)
+	return true
+}
+
+// Check returns the detailed result of the IsIdle check.
+func (m *NetMonitor) Check(minutes int) WindowCheck {
+	return m.CheckAt(m.Clock.Now(), minutes)
+}
+
+// CheckAt is Check evaluated as if the current time were now.
+func (m *NetMonitor) CheckAt(now time.Time, minutes int) WindowCheck {
+	return checkWindow(now, minutes, m.interval, m.checkPoints(windowCutoff(now, minutes)))
+}
+
+// checkPoints returns the samples taken after since, breaking idleness
+// when a rate reaches its limit.
+func (m *NetMonitor) checkPoints(since time.Time) []checkPoint {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	samples := m.samples[windowStart(len(m.samples), since, func(i int) time.Time { return m.samples[i].Timestamp }):]
+	points := make([]checkPoint, len(samples))
+	for i, s := range samples {
+		metric, v := m.limits.Exceeded(s)
+		points[i] = checkPoint{time: s.Timestamp, value: v, metric: metric, breaks: metric != ""}
+	}
+	return points
+}
+
+// Current returns the most recent sample, or the zero sample if there is
+// none yet.
+func (m *NetMonitor) Current() NetSample {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if len(m.samples) == 0 {
+		return NetSample{}
+	}
+	return m.samples[len(m.samples)-1]
+}
+
+// GetSamples returns a snapshot of all retained network samples.
+func (m *NetMonitor) GetSamples() []NetSample {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]NetSample(nil), m.samples...)
+}
+
+// WindowSamples returns the samples in the last minutes, the window IsIdle
+// checks.
+func (m *NetMonitor) WindowSamples(minutes int) []NetSample {
+	return m.WindowSamplesAt(m.Clock.Now(), minutes)
+}
+
+// WindowSamplesAt is WindowSamples evaluated as if the current time were now.
+func (m *NetMonitor) WindowSamplesAt(now time.Time, minutes int) []NetSample {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	cutoff := now.Add(-time.Duration(minutes) * time.Minute)
+	var result []NetSample
+	for _, s := range m.samples {
+		if s.Timestamp.After(cutoff) && !s.Timestamp.After(now) {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// AddSamples appends previously recorded samples, e.g. loaded from a sample
+// file for offline replay. Samples must be in chronological order.
+func (m *NetMonitor) AddSamples(samples []NetSample) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.samples = append(m.samples, samples...)
+}
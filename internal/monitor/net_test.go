@@ -0,0 +1,127 @@
+package monitor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"idleshutdown/internal/clock"
+)
+
+// netDev holds the rx bytes, rx packets, tx bytes and tx packets of an
+// interface.
+type netDev struct {
+	rxBytes, rxPackets, txBytes, txPackets uint64
+}
+
+// writeNetDev writes a net/dev file under root with the given counters.
+func writeNetDev(t *testing.T, root string, devs map[string]netDev) {
+	t.Helper()
+	var b strings.Builder
+	b.WriteString("Inter-|   Receive                                                |  Transmit\n")
+	b.WriteString(" face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed\n")
+	for name, d := range devs {
+		fmt.Fprintf(&b, "%6s: %d %d 0 0 0 0 0 0 %d %d 0 0 0 0 0 0\n", name, d.rxBytes, d.rxPackets, d.txBytes, d.txPackets)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "net"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(root, "net", "dev"), b.String())
+}
+
+func TestNetSample(t *testing.T) {
+	root := t.TempDir()
+	writeNetDev(t, root, map[string]netDev{"lo": {0, 0, 0, 0}, "eth0": {1000, 10, 500, 5}})
+	fake := clock.NewFake(testStart)
+	m := NewNetMonitor(30 * time.Second)
+	m.ProcRoot = root
+	m.Clock = fake
+	m.SetLimits(NetLimits{Bytes: 10000})
+
+	m.Sample()
+	if n := len(m.GetSamples()); n != 0 {
+		t.Fatalf("first reading recorded %d samples, want none", n)
+	}
+
+	// quiet: 3000 bytes in and 1500 out in 30 s, heavy loopback traffic
+	writeNetDev(t, root, map[string]netDev{"lo": {9e9, 9e6, 9e9, 9e6}, "eth0": {4000, 40, 2000, 20}})
+	fake.Advance(30 * time.Second)
+	m.Sample()
+
+	s := m.Current()
+	if s.RxBytes != 100 || s.TxBytes != 50 || s.RxPackets != 1 || s.TxPackets != 0.5 {
+		t.Errorf("sample = %+v, want 100/50 bytes/s and 1/0.5 packets/s", s)
+	}
+	if !m.IsIdle(1) {
+		t.Error("quiet network is not idle")
+	}
+
+	// a download: 30 MB in 30 s
+	writeNetDev(t, root, map[string]netDev{"lo": {9e9, 9e6, 9e9, 9e6}, "eth0": {30_004_000, 20_040, 302_000, 4_020}})
+	fake.Advance(30 * time.Second)
+	m.Sample()
+
+	check := m.Check(1)
+	if check.Idle || check.FirstBreak == nil || check.FirstBreak.Metric != NetBytes || check.FirstBreak.Value != 1_010_000 {
+		t.Errorf("check while downloading = %+v, want a bytes breach of 1010000 bytes/s", check)
+	}
+
+	// the packet limit applies on its own
+	m.SetLimits(NetLimits{Packets: 500})
+	if check := m.Check(1); check.FirstBreak == nil || check.FirstBreak.Metric != NetPackets || check.FirstBreak.Value != 800 {
+		t.Errorf("check on packets = %+v, want a packets breach of 800 packets/s", check)
+	}
+
+	// without limits nothing is busy
+	m.SetLimits(NetLimits{})
+	if !m.IsIdle(1) {
+		t.Error("network without limits is not idle")
+	}
+}
+
+func TestNetInterfaces(t *testing.T) {
+	root := t.TempDir()
+	writeNetDev(t, root, map[string]netDev{"eth0": {0, 0, 0, 0}, "docker0": {0, 0, 0, 0}})
+	fake := clock.NewFake(testStart)
+	m := NewNetMonitor(30 * time.Second)
+	m.ProcRoot = root
+	m.Clock = fake
+	m.SetInterfaces([]string{"eth0"})
+
+	m.Sample()
+	writeNetDev(t, root, map[string]netDev{"eth0": {300, 3, 0, 0}, "docker0": {3e6, 3e3, 3e6, 3e3}})
+	fake.Advance(30 * time.Second)
+	m.Sample()
+	if s := m.Current(); s.Bytes() != 10 || s.Packets() != 0.1 {
+		t.Errorf("sample of eth0 = %+v, want 10 bytes/s and 0.1 packets/s", s)
+	}
+}
+
+func TestNetCountersBackwards(t *testing.T) {
+	root := t.TempDir()
+	writeNetDev(t, root, map[string]netDev{"eth0": {5000, 50, 0, 0}, "veth1": {5000, 50, 0, 0}})
+	fake := clock.NewFake(testStart)
+	m := NewNetMonitor(30 * time.Second)
+	m.ProcRoot = root
+	m.Clock = fake
+
+	m.Sample()
+	// veth1 was recreated and wlan0 appeared; only eth0 counts
+	writeNetDev(t, root, map[string]netDev{"eth0": {5300, 53, 0, 0}, "veth1": {100, 1, 0, 0}, "wlan0": {9e9, 9e6, 0, 0}})
+	fake.Advance(30 * time.Second)
+	m.Sample()
+	if s := m.Current(); s.RxBytes != 10 || s.RxPackets != 0.1 {
+		t.Errorf("sample = %+v, want 10 bytes/s and 0.1 packets/s from eth0", s)
+	}
+
+	// after a suspend the next reading only records the counters
+	writeNetDev(t, root, map[string]netDev{"eth0": {9e9, 9e6, 0, 0}})
+	fake.Advance(time.Hour)
+	m.Sample()
+	if n := len(m.GetSamples()); n != 1 {
+		t.Errorf("sample across a gap recorded, got %d samples", n)
+	}
+}
@@ -0,0 +1,403 @@
+package monitor
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"idleshutdown/internal/clock"
+	"idleshutdown/internal/logging"
+)
+
+// Resources /proc/pressure reports stall information on.
+const (
+	PSICPU    = "cpu"
+	PSIIO     = "io"
+	PSIMemory = "memory"
+)
+
+// PSIResources lists every PSI resource.
+var PSIResources = []string{PSICPU, PSIIO, PSIMemory}
+
+// ValidPSIResource reports whether name is a PSI resource.
+func ValidPSIResource(name string) bool {
+	switch name {
+	case PSICPU, PSIIO, PSIMemory:
+		return true
+	}
+	return false
+}
+
+// PSIPressure is the "some" line of one resource's pressure file: how much
+// of the time at least one task was stalled waiting for the resource, in
+// percent.
+type PSIPressure struct {
+	// Avg10 and Avg60 are the kernel's running averages over 10 and 60
+	// seconds at the time of the sample.
+	Avg10 float64
+	Avg60 float64
+	// Stall is the share of the whole sampling interval, from the total
+	// stall time counter.
+	Stall float64
+}
+
+// PSISample is a reading of the pressure of every resource.
+type PSISample struct {
+	Timestamp time.Time
+	CPU       PSIPressure
+	IO        PSIPressure
+	Memory    PSIPressure
+}
+
+// Pressure returns the reading for resource.
+func (s PSISample) Pressure(resource string) PSIPressure {
+	switch resource {
+	case PSIIO:
+		return s.IO
+	case PSIMemory:
+		return s.Memory
+	}
+	return s.CPU
+}
+
+// Value returns the highest stall of resources, the reading the PSI idle
+// check compares with its threshold.
+func (s PSISample) Value(resources []string) float64 {
+	v := 0.0
+	for _, r := range resources {
+		v = max(v, s.Pressure(r).Stall)
+	}
+	return v
+}
+
+// psiReading is the "some" line of a pressure file.
+type psiReading struct {
+	avg10, avg60 float64
+	total        uint64 // microseconds
+}
+
+// PSIMonitor tracks Pressure Stall Information from /proc/pressure: the
+// time tasks spent waiting for CPU, I/O or memory, which shows work being
+// held up even on a throttled VM whose utilization looks low. On kernels
+// without PSI the monitor disables itself after the first sample.
+type PSIMonitor struct {
+	mu        sync.RWMutex
+	samples   []PSISample
+	interval  time.Duration
+	resources []string
+	// unavailable is set once reading /proc/pressure showed the kernel
+	// has no PSI; sampling stops then.
+	unavailable bool
+
+	// prev holds the total stall counters of the previous Sample by
+	// resource, taken at prevAt. Only Sample uses them.
+	prev   map[string]uint64
+	prevAt time.Time
+
+	// Clock and ProcRoot may be replaced before Start, e.g. in tests.
+	Clock    clock.Clock
+	ProcRoot string
+}
+
+// NewPSIMonitor creates a new PSI monitor with the specified sampling
+// interval, checking every resource.
+func NewPSIMonitor(samplingInterval time.Duration) *PSIMonitor {
+	return &PSIMonitor{
+		samples:   make([]PSISample, 0, 256),
+		interval:  samplingInterval,
+		resources: PSIResources,
+		Clock:     clock.Real,
+		ProcRoot:  DefaultProcRoot,
+	}
+}
+
+// SetResources selects the resources whose stall the idle check compares
+// with the threshold; empty means all of them.
+func (m *PSIMonitor) SetResources(resources []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(resources) == 0 {
+		resources = PSIResources
+	}
+	m.resources = resources
+}
+
+// Resources returns the resources the idle check looks at.
+func (m *PSIMonitor) Resources() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.resources
+}
+
+// Available reports whether the kernel provides PSI. It is true until a
+// sample has shown otherwise.
+func (m *PSIMonitor) Available() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return !m.unavailable
+}
+
+// Start begins PSI monitoring in a background goroutine, which ends early
+// if the kernel has no PSI.
+func (m *PSIMonitor) Start(stopCh <-chan struct{}) {
+	go func() {
+		ticker := m.Clock.NewTicker(m.interval)
+		defer ticker.Stop()
+
+		m.Sample() // primes the counters; the first sample follows a tick later
+
+		for m.Available() {
+			select {
+			case <-ticker.C():
+				m.Sample()
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Sample reads the pressure files and appends the stall since the previous
+// call to the rolling buffer. The first call, and the first after a gap
+// such as a suspend, only records the counters.
+func (m *PSIMonitor) Sample() {
+	now := m.Clock.Now()
+	sample, ok, err := m.measure(now)
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.EOPNOTSUPP) {
+		slog.Info("Pressure stall information not available, psi signal disabled", logging.EventKey, logging.Sampling,
+			"error", err)
+		m.mu.Lock()
+		m.unavailable = true
+		m.mu.Unlock()
+		return
+	}
+	if err != nil {
+		slog.Error("Reading pressure stall information failed", logging.EventKey, logging.Sampling, "error", err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sample.Timestamp = now
+	m.samples = append(m.samples, sample)
+
+	// Kept as long as CPU samples, so PSI can be calibrated over the same
+	// lookback
+	cutoff := now.Add(-maxSampleRetention)
+	start := 0
+	for start < len(m.samples) && !m.samples[start].Timestamp.After(cutoff) {
+		start++
+	}
+	if start > 0 {
+		m.samples = m.samples[start:]
+	}
+}
+
+// measure reads every pressure file at now and returns the stall since the
+// previous reading. ok is false when there is no usable previous reading:
+// on the first call, after a gap of more than two intervals, or when a
+// counter went backwards.
+func (m *PSIMonitor) measure(now time.Time) (sample PSISample, ok bool, err error) {
+	readings := make(map[string]psiReading, len(PSIResources))
+	totals := make(map[string]uint64, len(PSIResources))
+	for _, r := range PSIResources {
+		reading, err := readPressure(filepath.Join(m.ProcRoot, "pressure", r))
+		if err != nil {
+			return PSISample{}, false, err
+		}
+		readings[r] = reading
+		totals[r] = reading.total
+	}
+
+	prev, prevAt := m.prev, m.prevAt
+	m.prev, m.prevAt = totals, now
+	if prev == nil {
+		return PSISample{}, false, nil
+	}
+	// As for CPU samples, a gap in wall-clock time means the VM was
+	// suspended and the counters stood still.
+	elapsed := now.Round(0).Sub(prevAt.Round(0))
+	if elapsed <= 0 || m.interval > 0 && elapsed > 2*m.interval {
+		return PSISample{}, false, nil
+	}
+
+	for _, r := range PSIResources {
+		reading := readings[r]
+		if reading.total < prev[r] {
+			slog.Warn("PSI counters went backwards, restarting from current counters", logging.EventKey, logging.Sampling,
+				"resource", r)
+			return PSISample{}, false, nil
+		}
+		stall := float64(reading.total-prev[r]) / float64(elapsed.Microseconds()) * 100
+		p := PSIPressure{Avg10: reading.avg10, Avg60: reading.avg60, Stall: min(stall, 100)}
+		switch r {
+		case PSICPU:
+			sample.CPU = p
+		case PSIIO:
+			sample.IO = p
+		case PSIMemory:
+			sample.Memory = p
+		}
+	}
+	return sample, true, nil
+}
+
+// readPressure reads the "some" line of a pressure file:
+//
+//	some avg10=0.12 avg60=0.05 avg300=0.01 total=123456
+func readPressure(path string) (psiReading, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return psiReading{}, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] != "some" {
+			continue
+		}
+		var r psiReading
+		for _, field := range fields[1:] {
+			key, val, _ := strings.Cut(field, "=")
+			switch key {
+			case "avg10":
+				r.avg10, err = strconv.ParseFloat(val, 64)
+			case "avg60":
+				r.avg60, err = strconv.ParseFloat(val, 64)
+			case "total":
+				r.total, err = strconv.ParseUint(val, 10, 64)
+			}
+			if err != nil {
+				return psiReading{}, fmt.Errorf("parse %s: %w", path, err)
+			}
+		}
+		return r, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return psiReading{}, err
+	}
+	return psiReading{}, fmt.Errorf("no \"some\" line in %s", path)
+}
+
+// IsIdle checks if the stall of the selected resources stayed below
+// threshold percent for the specified duration. Without PSI the check
+// always passes, so the psi signal never holds a shutdown back.
+func (m *PSIMonitor) IsIdle(threshold float64, minutes int) bool {
+	return m.IsIdleAt(m.Clock.Now(), threshold, minutes)
+}
+
+// IsIdleAt is IsIdle evaluated as if the current time were now.
+func (m *PSIMonitor) IsIdleAt(now time.Time, threshold float64, minutes int) bool {
+	if !m.Available() {
+		slog.Debug("PSI check: not available, counted as idle", logging.EventKey, logging.PSICheck,
+			"idle", true, "minutes", minutes)
+		return true
+	}
+	check := m.CheckAt(now, threshold, minutes)
+
+	if check.Samples < check.MinSamples {
+		slog.Info("PSI check: insufficient samples", logging.EventKey, logging.PSICheck,
+			"idle", false, "samples", check.Samples, "min_samples", check.MinSamples, "minutes", minutes)
+		return false
+	}
+
+	if b := check.FirstBreak; b != nil {
+		slog.Info("PSI check: not idle", logging.EventKey, logging.PSICheck,
+			"idle", false, "stall", round2(b.Value), "threshold", threshold, "at", b.Time, "minutes", minutes)
+		return false
+	}
+
+	slog.Info("PSI check: idle", logging.EventKey, logging.PSICheck,
+		"idle", true, "samples", check.Samples, "threshold", threshold, "minutes", minutes)
+	return true
+}
+
+// Check returns the detailed result of the IsIdle check.
+func (m *PSIMonitor) Check(threshold float64, minutes int) WindowCheck {
+	return m.CheckAt(m.Clock.Now(), threshold, minutes)
+}
+
+// CheckAt is Check evaluated as if the current time were now.
+func (m *PSIMonitor) CheckAt(now time.Time, threshold float64, minutes int) WindowCheck {
+	if !m.Available() {
+		return WindowCheck{Minutes: minutes, Idle: true, IdleAt: now}
+	}
+	return checkWindow(now, minutes, m.interval, m.checkPoints(windowCutoff(now, minutes), threshold))
+}
+
+// checkPoints returns the stall of the selected resources in the samples
+// taken after since, breaking idleness at or above threshold.
+func (m *PSIMonitor) checkPoints(since time.Time, threshold float64) []checkPoint {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	samples := m.samples[windowStart(len(m.samples), since, func(i int) time.Time { return m.samples[i].Timestamp }):]
+	points := make([]checkPoint, len(samples))
+	for i, s := range samples {
+		v := s.Value(m.resources)
+		points[i] = checkPoint{time: s.Timestamp, value: v, breaks: v >= threshold}
+	}
+	return points
+}
+
+// Current returns the most recent sample, or the zero sample if there is
+// none yet.
+func (m *PSIMonitor) Current() PSISample {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if len(m.samples) == 0 {
+		return PSISample{}
+	}
+	return m.samples[len(m.samples)-1]
+}
+
+// GetSamples returns a snapshot of all retained PSI samples.
+func (m *PSIMonitor) GetSamples() []PSISample {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]PSISample(nil), m.samples...)
+}
+
+// WindowSamples returns the samples in the last minutes, the window IsIdle
+// checks.
+func (m *PSIMonitor) WindowSamples(minutes int) []PSISample {
+	return m.WindowSamplesAt(m.Clock.Now(), minutes)
+}
+
+// WindowSamplesAt is WindowSamples evaluated as if the current time were now.
+func (m *PSIMonitor) WindowSamplesAt(now time.Time, minutes int) []PSISample {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	cutoff := now.Add(-time.Duration(minutes) * time.Minute)
+	var result []PSISample
+	for _, s := range m.samples {
+		if s.Timestamp.After(cutoff) && !s.Timestamp.After(now) {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// AddSamples appends previously recorded samples, e.g. loaded from a sample
+// file for offline replay. Samples must be in chronological order.
+func (m *PSIMonitor) AddSamples(samples []PSISample) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.samples = append(m.samples, samples...)
+}
@@ -0,0 +1,79 @@
+package monitor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"idleshutdown/internal/clock"
+)
+
+// writePressure writes a pressure file under root/pressure with the given
+// "some" total in microseconds.
+func writePressure(t *testing.T, root, resource string, avg10 float64, total uint64) {
+	t.Helper()
+	dir := filepath.Join(root, "pressure")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	content := fmt.Sprintf("some avg10=%.2f avg60=0.00 avg300=0.00 total=%d\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=0\n",
+		avg10, total)
+	writeFile(t, filepath.Join(dir, resource), content)
+}
+
+func TestPSISample(t *testing.T) {
+	root := t.TempDir()
+	for _, r := range PSIResources {
+		writePressure(t, root, r, 0, 1000)
+	}
+	fake := clock.NewFake(testStart)
+	m := NewPSIMonitor(30 * time.Second)
+	m.ProcRoot = root
+	m.Clock = fake
+
+	m.Sample()
+	if n := len(m.GetSamples()); n != 0 {
+		t.Fatalf("first reading recorded %d samples, want none", n)
+	}
+
+	// 3 s of I/O stall in 30 s
+	writePressure(t, root, PSIIO, 8.5, 1000+3_000_000)
+	fake.Advance(30 * time.Second)
+	m.Sample()
+
+	s := m.Current()
+	if s.IO.Stall != 10 || s.IO.Avg10 != 8.5 || s.CPU.Stall != 0 {
+		t.Errorf("sample = %+v, want 10%% I/O stall", s)
+	}
+	if got := s.Value([]string{PSICPU, PSIMemory}); got != 0 {
+		t.Errorf("cpu and memory stall = %.2f, want 0", got)
+	}
+
+	m.SetResources([]string{PSIIO})
+	if m.IsIdle(5, 1) {
+		t.Error("I/O stall of 10% is idle with a 5% threshold")
+	}
+	m.SetResources([]string{PSICPU, PSIMemory})
+	if !m.IsIdle(5, 1) {
+		t.Error("cpu and memory without stall are not idle")
+	}
+}
+
+func TestPSIUnavailable(t *testing.T) {
+	m := NewPSIMonitor(30 * time.Second)
+	m.ProcRoot = t.TempDir()
+	m.Clock = clock.NewFake(testStart)
+
+	m.Sample()
+	if m.Available() {
+		t.Fatal("PSI available without /proc/pressure")
+	}
+	if check := m.Check(5, 30); !check.Idle || check.Samples != 0 {
+		t.Errorf("check without PSI = %+v, want idle", check)
+	}
+	if !m.IsIdle(5, 30) {
+		t.Error("psi signal blocks shutdown without PSI")
+	}
+}
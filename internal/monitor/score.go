@@ -0,0 +1,93 @@
+package monitor
+
+import (
+	"math"
+	"time"
+)
+
+// Scoring methods: how the samples of a window are averaged.
+const (
+	// ScoreFraction weighs every sample in the window equally.
+	ScoreFraction = "fraction"
+	// ScoreEWMA weighs recent samples more, halving the weight every
+	// HalfLife.
+	ScoreEWMA = "ewma"
+)
+
+// ScoreOptions selects how a window of samples becomes an idleness score.
+type ScoreOptions struct {
+	Method   string
+	HalfLife time.Duration
+	// SpikeTolerance forgives runs of consecutive breaking samples
+	// spanning at most this long; 0 forgives none.
+	SpikeTolerance time.Duration
+}
+
+// Score is the idleness of one signal over a window, from 0 (busy
+// throughout) to 1 (idle throughout). Unlike WindowCheck, a breaking
+// sample lowers the score rather than resetting it.
+type Score struct {
+	Minutes    int `json:"minutes"`
+	Samples    int `json:"samples"`
+	MinSamples int `json:"min_samples"`
+	// IdleSamples counts samples scored idle, including Tolerated ones:
+	// breaking samples forgiven as short spikes.
+	IdleSamples int     `json:"idle_samples"`
+	Tolerated   int     `json:"tolerated"`
+	Value       float64 `json:"value"`
+}
+
+// scoreWindow scores the points in the window (now-minutes, now], which
+// must be in chronological order. A window with fewer than the minimum
+// samples scores proportionally lower, so a fresh start is not idle.
+func scoreWindow(now time.Time, minutes int, interval time.Duration, points []checkPoint, opts ScoreOptions) Score {
+	cutoff := now.Add(-time.Duration(minutes) * time.Minute)
+	score := Score{Minutes: minutes, MinSamples: minWindowSamples(minutes)}
+
+	var window []checkPoint
+	for _, p := range points {
+		if p.time.After(cutoff) && !p.time.After(now) {
+			window = append(window, p)
+		}
+	}
+	score.Samples = len(window)
+	if len(window) == 0 {
+		return score
+	}
+
+	idle := make([]bool, len(window))
+	for i := 0; i < len(window); {
+		if !window[i].breaks {
+			idle[i] = true
+			i++
+			continue
+		}
+		run := i
+		for run < len(window) && window[run].breaks {
+			run++
+		}
+		if opts.SpikeTolerance > 0 && time.Duration(run-i)*interval <= opts.SpikeTolerance {
+			for j := i; j < run; j++ {
+				idle[j] = true
+			}
+			score.Tolerated += run - i
+		}
+		i = run
+	}
+
+	var sum, total float64
+	for i, p := range window {
+		weight := 1.0
+		if opts.Method == ScoreEWMA && opts.HalfLife > 0 {
+			weight = math.Exp2(-float64(now.Sub(p.time)) / float64(opts.HalfLife))
+		}
+		total += weight
+		if idle[i] {
+			sum += weight
+			score.IdleSamples++
+		}
+	}
+	coverage := min(float64(score.Samples)/float64(score.MinSamples), 1)
+	score.Value = sum / total * coverage
+	return score
+}
@@ -0,0 +1,318 @@
+package monitor
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"idleshutdown/internal/clock"
+	"idleshutdown/internal/logging"
+)
+
+// maxTCPSampleRetention is how far back connection samples are kept.
+const maxTCPSampleRetention = 24 * time.Hour
+
+// tcpEstablished is the ESTABLISHED state in /proc/net/tcp.
+const tcpEstablished = "01"
+
+// TCPFilter selects the connections that count as a service in use: those
+// on one of Ports locally, from a peer outside ExcludePeers.
+type TCPFilter struct {
+	Ports        []uint16
+	ExcludePeers []netip.Prefix
+}
+
+// Qualifies reports whether a connection between local and remote counts.
+func (f TCPFilter) Qualifies(local, remote netip.AddrPort) bool {
+	if !slices.Contains(f.Ports, local.Port()) {
+		return false
+	}
+	peer := remote.Addr().Unmap()
+	for _, p := range f.ExcludePeers {
+		if p.Contains(peer) {
+			return false
+		}
+	}
+	return true
+}
+
+// TCPSample is a count of the qualifying connections at one point in time.
+type TCPSample struct {
+	Timestamp time.Time
+	Count     int
+	// Peers lists the connected clients as "address → :port", when known.
+	Peers []string
+}
+
+// TCPMonitor tracks established inbound TCP connections to selected local
+// ports from /proc/net/tcp and /proc/net/tcp6, which show a web app or
+// database in use even while its CPU usage is low.
+type TCPMonitor struct {
+	mu       sync.RWMutex
+	samples  []TCPSample
+	interval time.Duration
+	filter   TCPFilter
+
+	// Clock and ProcRoot may be replaced before Start, e.g. in tests.
+	Clock    clock.Clock
+	ProcRoot string
+}
+
+// NewTCPMonitor creates a new connection monitor with the specified
+// sampling interval and no ports selected.
+func NewTCPMonitor(samplingInterval time.Duration) *TCPMonitor {
+	return &TCPMonitor{
+		samples:  make([]TCPSample, 0, 128),
+		interval: samplingInterval,
+		Clock:    clock.Real,
+		ProcRoot: DefaultProcRoot,
+	}
+}
+
+// SetFilter selects the connections that are counted.
+func (m *TCPMonitor) SetFilter(filter TCPFilter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.filter = filter
+}
+
+// Filter returns the selection of connections that are counted.
+func (m *TCPMonitor) Filter() TCPFilter {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.filter
+}
+
+// Start begins connection monitoring in a background goroutine.
+func (m *TCPMonitor) Start(stopCh <-chan struct{}) {
+	go func() {
+		ticker := m.Clock.NewTicker(m.interval)
+		defer ticker.Stop()
+
+		m.Sample()
+
+		for {
+			select {
+			case <-ticker.C():
+				m.Sample()
+			case <-stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Sample counts the qualifying connections and appends them to the rolling
+// buffer.
+func (m *TCPMonitor) Sample() {
+	filter := m.Filter()
+	var peers []string
+	count := 0
+	for _, name := range []string{"tcp", "tcp6"} {
+		conns, err := readTCPConnections(filepath.Join(m.ProcRoot, "net", name))
+		if name == "tcp6" && errors.Is(err, fs.ErrNotExist) {
+			continue // IPv6 disabled
+		}
+		if err != nil {
+			slog.Error("Reading TCP connections failed", logging.EventKey, logging.Sampling, "error", err)
+			return
+		}
+		for _, c := range conns {
+			if !filter.Qualifies(c.local, c.remote) {
+				continue
+			}
+			count++
+			peer := fmt.Sprintf("%s → :%d", c.remote.Addr().Unmap(), c.local.Port())
+			if !slices.Contains(peers, peer) {
+				peers = append(peers, peer)
+			}
+		}
+	}
+	slices.Sort(peers)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.Clock.Now()
+	m.samples = append(m.samples, TCPSample{Timestamp: now, Count: count, Peers: peers})
+
+	cutoff := now.Add(-maxTCPSampleRetention)
+	start := 0
+	for start < len(m.samples) && !m.samples[start].Timestamp.After(cutoff) {
+		start++
+	}
+	if start > 0 {
+		m.samples = m.samples[start:]
+	}
+}
+
+// tcpConn is an established connection from /proc/net/tcp.
+type tcpConn struct {
+	local, remote netip.AddrPort
+}
+
+// readTCPConnections returns the established connections listed in a
+// /proc/net/tcp or tcp6 file:
+//
+//	sl  local_address rem_address   st ...
+//	 0: 0100007F:1538 0100007F:C2A4 01 ...
+func readTCPConnections(path string) ([]tcpConn, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var conns []tcpConn
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[3] != tcpEstablished {
+			continue
+		}
+		local, err := parseProcAddrPort(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+		remote, err := parseProcAddrPort(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+		conns = append(conns, tcpConn{local: local, remote: remote})
+	}
+	return conns, scanner.Err()
+}
+
+// parseProcAddrPort parses an address as /proc/net/tcp shows it: the
+// address in hex as 32-bit words in host (little-endian) byte order, a
+// colon and the port in hex.
+func parseProcAddrPort(s string) (netip.AddrPort, error) {
+	addrHex, portHex, ok := strings.Cut(s, ":")
+	if !ok {
+		return netip.AddrPort{}, fmt.Errorf("invalid address %q", s)
+	}
+	b, err := hex.DecodeString(addrHex)
+	if err != nil || len(b) != 4 && len(b) != 16 {
+		return netip.AddrPort{}, fmt.Errorf("invalid address %q", s)
+	}
+	for i := 0; i < len(b); i += 4 {
+		b[i], b[i+1], b[i+2], b[i+3] = b[i+3], b[i+2], b[i+1], b[i]
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	port, err := strconv.ParseUint(portHex, 16, 16)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("invalid port in %q", s)
+	}
+	return netip.AddrPortFrom(addr, uint16(port)), nil
+}
+
+// IsIdle checks if there have been no qualifying connections for the
+// specified duration.
+func (m *TCPMonitor) IsIdle(minutes int) bool {
+	return m.IsIdleAt(m.Clock.Now(), minutes)
+}
+
+// IsIdleAt is IsIdle evaluated as if the current time were now.
+func (m *TCPMonitor) IsIdleAt(now time.Time, minutes int) bool {
+	check := m.CheckAt(now, minutes)
+
+	if check.Samples < check.MinSamples {
+		slog.Info("TCP check: insufficient samples", logging.EventKey, logging.TCPCheck,
+			"idle", false, "samples", check.Samples, "min_samples", check.MinSamples, "minutes", minutes)
+		return false
+	}
+
+	if b := check.FirstBreak; b != nil {
+		slog.Info("TCP check: not idle", logging.EventKey, logging.TCPCheck,
+			"idle", false, "connections", int(b.Value), "peers", b.Users, "at", b.Time, "minutes", minutes)
+		return false
+	}
+
+	slog.Info("TCP check: idle", logging.EventKey, logging.TCPCheck,
+		"idle", true, "samples", check.Samples, "minutes", minutes)
+	return true
+}
+
+// Check returns the detailed result of the IsIdle check. Breaches list
+// the connected peers in Users.
+func (m *TCPMonitor) Check(minutes int) WindowCheck {
+	return m.CheckAt(m.Clock.Now(), minutes)
+}
+
+// CheckAt is Check evaluated as if the current time were now.
+func (m *TCPMonitor) CheckAt(now time.Time, minutes int) WindowCheck {
+	return checkWindow(now, minutes, m.interval, m.checkPoints(windowCutoff(now, minutes)))
+}
+
+// checkPoints returns the samples taken after since, breaking idleness
+// when a qualifying connection exists.
+func (m *TCPMonitor) checkPoints(since time.Time) []checkPoint {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	samples := m.samples[windowStart(len(m.samples), since, func(i int) time.Time { return m.samples[i].Timestamp }):]
+	points := make([]checkPoint, len(samples))
+	for i, s := range samples {
+		points[i] = checkPoint{time: s.Timestamp, value: float64(s.Count), users: s.Peers, breaks: s.Count > 0}
+	}
+	return points
+}
+
+// Current returns the most recent sample, or the zero sample if there is
+// none yet.
+func (m *TCPMonitor) Current() TCPSample {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if len(m.samples) == 0 {
+		return TCPSample{}
+	}
+	return m.samples[len(m.samples)-1]
+}
+
+// GetSamples returns a snapshot of all retained connection samples.
+func (m *TCPMonitor) GetSamples() []TCPSample {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]TCPSample(nil), m.samples...)
+}
+
+// WindowSamples returns the samples in the last minutes, the window IsIdle
+// checks.
+func (m *TCPMonitor) WindowSamples(minutes int) []TCPSample {
+	return m.WindowSamplesAt(m.Clock.Now(), minutes)
+}
+
+// WindowSamplesAt is WindowSamples evaluated as if the current time were now.
+func (m *TCPMonitor) WindowSamplesAt(now time.Time, minutes int) []TCPSample {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	cutoff := now.Add(-time.Duration(minutes) * time.Minute)
+	var result []TCPSample
+	for _, s := range m.samples {
+		if s.Timestamp.After(cutoff) && !s.Timestamp.After(now) {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// AddSamples appends previously recorded samples, e.g. loaded from a sample
+// file for offline replay. Samples must be in chronological order.
+func (m *TCPMonitor) AddSamples(samples []TCPSample) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.samples = append(m.samples, samples...)
+}
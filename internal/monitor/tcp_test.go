@@ -0,0 +1,87 @@
+package monitor
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"idleshutdown/internal/clock"
+)
+
+const tcpHeader = "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"
+
+// writeTCP writes /proc/net/tcp and, unless tcp6 is empty, tcp6 under root.
+func writeTCP(t *testing.T, root, tcp, tcp6 string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Join(root, "net"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(root, "net", "tcp"), tcpHeader+tcp)
+	if tcp6 != "" {
+		writeFile(t, filepath.Join(root, "net", "tcp6"), tcpHeader+tcp6)
+	}
+}
+
+func TestParseProcAddrPort(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"0500000A:1538", "10.0.0.5:5432"},
+		{"0000000000000000FFFF00001E01A8C0:D000", "[::ffff:192.168.1.30]:53248"},
+		{"B80D0120000000000000000001000000:01BB", "[2001:db8::1]:443"},
+	}
+	for _, tt := range tests {
+		got, err := parseProcAddrPort(tt.in)
+		if err != nil || got.String() != tt.want {
+			t.Errorf("parseProcAddrPort(%q) = %v, %v, want %s", tt.in, got, err, tt.want)
+		}
+	}
+	if _, err := parseProcAddrPort("0500000A"); err == nil {
+		t.Error("address without port parsed")
+	}
+}
+
+func TestTCPSample(t *testing.T) {
+	root := t.TempDir()
+	writeTCP(t, root,
+		// listening on 5432, a client, a monitoring scraper and an outbound
+		// connection from an ephemeral port to 443
+		"   0: 00000000:1538 00000000:0000 0A 00000000:00000000 00:00000000 00000000   113        0 1\n"+
+			"   1: 0500000A:1538 1401A8C0:C822 01 00000000:00000000 00:00000000 00000000   113        0 2\n"+
+			"   2: 0500000A:1538 0700090A:C823 01 00000000:00000000 00:00000000 00000000   113        0 3\n"+
+			"   3: 0500000A:D431 0800080A:01BB 01 00000000:00000000 00:00000000 00000000  1000        0 4\n",
+		// an IPv4 client through an IPv6 socket on 8888
+		"   0: 0000000000000000FFFF00000500000A:22B8 0000000000000000FFFF00001E01A8C0:D000 01 00000000:00000000 00:00000000 00000000  1000        0 5\n")
+	fake := clock.NewFake(testStart)
+	m := NewTCPMonitor(30 * time.Second)
+	m.ProcRoot = root
+	m.Clock = fake
+	m.SetFilter(TCPFilter{
+		Ports:        []uint16{5432, 8888, 443},
+		ExcludePeers: []netip.Prefix{netip.MustParsePrefix("10.9.0.0/16")},
+	})
+
+	m.Sample()
+	s := m.Current()
+	want := []string{"192.168.1.20 → :5432", "192.168.1.30 → :8888"}
+	if s.Count != 2 || !slices.Equal(s.Peers, want) {
+		t.Errorf("sample = %+v, want peers %v", s, want)
+	}
+	if check := m.Check(1); check.Idle || check.FirstBreak == nil || !slices.Equal(check.FirstBreak.Users, want) {
+		t.Errorf("check with clients = %+v", check)
+	}
+
+	// clients gone; IPv6 disabled
+	writeTCP(t, root, "", "")
+	os.Remove(filepath.Join(root, "net", "tcp6"))
+	for i := 0; i < 2; i++ {
+		fake.Advance(30 * time.Second)
+		m.Sample()
+	}
+	if !m.IsIdle(1) {
+		t.Error("no client connections is not idle")
+	}
+}
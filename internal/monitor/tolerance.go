@@ -0,0 +1,112 @@
+package monitor
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CPUTolerance relaxes the CPU idle check so that short bursts above the
+// threshold, such as a metrics agent running every few minutes, do not
+// reset the idle window. The zero value tolerates nothing.
+type CPUTolerance struct {
+	// Percent of the window's samples that may be at or above the
+	// threshold.
+	Percent float64
+	// MaxConsecutive tolerates runs of up to this many high samples.
+	// With Percent also set, the tolerated runs must fit within it.
+	MaxConsecutive int
+	// RollingAverage compares the average usage over this span, ending at
+	// each sample, with the threshold instead of the sample itself.
+	RollingAverage time.Duration
+}
+
+// String describes the tolerance for logs, e.g. "5% of samples, runs of 2".
+func (t CPUTolerance) String() string {
+	var parts []string
+	if t.Percent > 0 {
+		parts = append(parts, fmt.Sprintf("%g%% of samples", t.Percent))
+	}
+	if t.MaxConsecutive > 0 {
+		parts = append(parts, fmt.Sprintf("runs of %d", t.MaxConsecutive))
+	}
+	if t.RollingAverage > 0 {
+		parts = append(parts, fmt.Sprintf("%s rolling average", t.RollingAverage))
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// SetTolerance sets the tolerance of the CPU idle check.
+func (m *CPUMonitor) SetTolerance(t CPUTolerance) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tolerance = t
+}
+
+// Tolerance returns the tolerance of the CPU idle check.
+func (m *CPUMonitor) Tolerance() CPUTolerance {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.tolerance
+}
+
+// rollingAverage replaces each point's value with the average over span
+// ending at it and recomputes whether it breaks threshold. Points must be
+// in chronological order.
+func rollingAverage(points []checkPoint, span time.Duration, threshold int) {
+	values := make([]float64, len(points))
+	for i, p := range points {
+		values[i] = p.value
+	}
+	sum, first := 0.0, 0
+	for i, p := range points {
+		sum += values[i]
+		for !points[first].time.After(p.time.Add(-span)) {
+			sum -= values[first]
+			first++
+		}
+		points[i].value = sum / float64(i-first+1)
+		points[i].breaks = points[i].value >= float64(threshold)
+	}
+}
+
+// tolerateSpikes clears the breaks of the points in the window
+// (now-minutes, now] that t tolerates and returns how many it cleared.
+func tolerateSpikes(now time.Time, minutes int, points []checkPoint, t CPUTolerance) int {
+	if t.Percent <= 0 && t.MaxConsecutive <= 0 {
+		return 0
+	}
+	cutoff := now.Add(-time.Duration(minutes) * time.Minute)
+	var window []int
+	for i, p := range points {
+		if p.time.After(cutoff) && !p.time.After(now) {
+			window = append(window, i)
+		}
+	}
+
+	var spikes []int
+	for start := 0; start < len(window); {
+		if !points[window[start]].breaks {
+			start++
+			continue
+		}
+		end := start
+		for end < len(window) && points[window[end]].breaks {
+			end++
+		}
+		if t.MaxConsecutive <= 0 || end-start <= t.MaxConsecutive {
+			spikes = append(spikes, window[start:end]...)
+		}
+		start = end
+	}
+	if t.Percent > 0 && float64(len(spikes)) > t.Percent/100*float64(len(window)) {
+		return 0
+	}
+	for _, i := range spikes {
+		points[i].breaks = false
+	}
+	return len(spikes)
+}
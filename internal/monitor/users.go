@@ -1,22 +1,42 @@
 package monitor
 
 import (
-	"bytes"
-	"log"
-	"os/exec"
+	"log/slog"
 	"strings"
 	"sync"
 	"time"
-)
 
-// maxUserSampleRetention is how far back user samples are kept.
-const maxUserSampleRetention = 2 * time.Hour
+	"idleshutdown/internal/clock"
+	"idleshutdown/internal/command"
+	"idleshutdown/internal/logging"
+)
 
 // UserMonitor tracks the number of logged-in users over time.
 type UserMonitor struct {
 	mu       sync.RWMutex
 	samples  []userSample
 	interval time.Duration
+
+	// Clock and Sessions may be replaced before Start, e.g. in tests.
+	Clock    clock.Clock
+	Sessions SessionProvider
+}
+
+// SessionProvider lists the users currently logged in.
+type SessionProvider interface {
+	LoggedInUsers() ([]string, error)
+}
+
+// WhoSessions is the SessionProvider backed by the who command.
+type WhoSessions struct {
+	Runner command.Runner
+}
+
+// UserSample is the exported form of a user count reading.
+type UserSample struct {
+	Timestamp time.Time
+	Count     int
+	Users     []string // logged-in user names, when known
 }
 
 // userSample represents a single user count measurement.
@@ -31,21 +51,23 @@ func NewUserMonitor(samplingInterval time.Duration) *UserMonitor {
 	return &UserMonitor{
 		samples:  make([]userSample, 0, 128),
 		interval: samplingInterval,
+		Clock:    clock.Real,
+		Sessions: WhoSessions{Runner: command.Exec},
 	}
 }
 
 // Start begins user monitoring in a background goroutine.
 func (m *UserMonitor) Start(stopCh <-chan struct{}) {
 	go func() {
-		ticker := time.NewTicker(m.interval)
+		ticker := m.Clock.NewTicker(m.interval)
 		defer ticker.Stop()
 
-		m.takeSample()
+		m.Sample()
 
 		for {
 			select {
-			case <-ticker.C:
-				m.takeSample()
+			case <-ticker.C():
+				m.Sample()
 			case <-stopCh:
 				return
 			}
@@ -53,18 +75,18 @@ func (m *UserMonitor) Start(stopCh <-chan struct{}) {
 	}()
 }
 
-// takeSample reads current user count and appends to the rolling buffer.
-func (m *UserMonitor) takeSample() {
-	users, err := getLoggedInUsers()
+// Sample reads current user count and appends to the rolling buffer.
+func (m *UserMonitor) Sample() {
+	users, err := m.Sessions.LoggedInUsers()
 	if err != nil {
-		log.Printf("Error reading logged-in users: %v", err)
+		slog.Error("Reading logged-in users failed", logging.EventKey, logging.Sampling, "error", err)
 		return
 	}
 
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	now := time.Now()
+	now := m.Clock.Now()
 	m.samples = append(m.samples, userSample{
 		timestamp: now,
 		userCount: len(users),
@@ -72,7 +94,7 @@ func (m *UserMonitor) takeSample() {
 	})
 
 	// Prune old samples
-	cutoff := now.Add(-maxUserSampleRetention)
+	cutoff := now.Add(-maxSampleRetention)
 	start := 0
 	for start < len(m.samples) && !m.samples[start].timestamp.After(cutoff) {
 		start++
@@ -82,18 +104,14 @@ func (m *UserMonitor) takeSample() {
 	}
 }
 
-// getLoggedInUsers returns a deduplicated list of currently logged-in users.
-func getLoggedInUsers() ([]string, error) {
-	cmd := exec.Command("who")
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	if err := cmd.Run(); err != nil {
+// LoggedInUsers returns a deduplicated list of currently logged-in users.
+func (w WhoSessions) LoggedInUsers() ([]string, error) {
+	stdout, err := w.Runner.Run("who")
+	if err != nil {
 		return nil, err
 	}
 
-	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	lines := strings.Split(strings.TrimSpace(string(stdout)), "\n")
 	seen := make(map[string]struct{})
 
 	for _, line := range lines {
@@ -116,39 +134,102 @@ func getLoggedInUsers() ([]string, error) {
 // NoUsersLoggedIn checks if there have been zero logged-in users
 // for the specified duration.
 func (m *UserMonitor) NoUsersLoggedIn(minutes int) bool {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+	return m.NoUsersLoggedInAt(m.Clock.Now(), minutes)
+}
 
-	cutoff := time.Now().Add(-time.Duration(minutes) * time.Minute)
+// NoUsersLoggedInAt is NoUsersLoggedIn evaluated as if the current time
+// were now. Samples after now are ignored.
+func (m *UserMonitor) NoUsersLoggedInAt(now time.Time, minutes int) bool {
+	check := m.CheckAt(now, minutes)
 
-	var samplesInWindow []userSample
-	for _, s := range m.samples {
-		if s.timestamp.After(cutoff) {
-			samplesInWindow = append(samplesInWindow, s)
-		}
+	if check.Samples < check.MinSamples {
+		slog.Info("User check: insufficient samples", logging.EventKey, logging.UserCheck,
+			"idle", false, "samples", check.Samples, "min_samples", check.MinSamples, "minutes", minutes)
+		return false
 	}
 
-	minSamples := minutes / 2
-	if minSamples < 1 {
-		minSamples = 1
-	}
-	if len(samplesInWindow) < minSamples {
-		log.Printf("User check: insufficient samples (%d/%d) for %d-min window",
-			len(samplesInWindow), minSamples, minutes)
+	if b := check.FirstBreak; b != nil {
+		slog.Info("User check: not idle", logging.EventKey, logging.UserCheck,
+			"idle", false, "users", b.Users, "at", b.Time, "minutes", minutes)
 		return false
 	}
 
-	for _, s := range samplesInWindow {
+	slog.Info("User check: idle", logging.EventKey, logging.UserCheck,
+		"idle", true, "samples", check.Samples, "minutes", minutes)
+	return true
+}
+
+// Check returns the detailed result of the NoUsersLoggedIn check.
+func (m *UserMonitor) Check(minutes int) WindowCheck {
+	return m.CheckAt(m.Clock.Now(), minutes)
+}
+
+// CheckAt is Check evaluated as if the current time were now.
+func (m *UserMonitor) CheckAt(now time.Time, minutes int) WindowCheck {
+	return checkWindow(now, minutes, m.interval, m.checkPoints(windowCutoff(now, minutes)))
+}
+
+// Score returns how free of logged-in users the last minutes were.
+func (m *UserMonitor) Score(minutes int, opts ScoreOptions) Score {
+	return m.ScoreAt(m.Clock.Now(), minutes, opts)
+}
+
+// ScoreAt is Score evaluated as if the current time were now.
+func (m *UserMonitor) ScoreAt(now time.Time, minutes int, opts ScoreOptions) Score {
+	return scoreWindow(now, minutes, m.interval, m.checkPoints(windowCutoff(now, minutes)), opts)
+}
+
+// checkPoints returns the samples taken after since, breaking idleness
+// when a user is logged in.
+func (m *UserMonitor) checkPoints(since time.Time) []checkPoint {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	samples := m.samples[windowStart(len(m.samples), since, func(i int) time.Time { return m.samples[i].timestamp }):]
+	points := make([]checkPoint, len(samples))
+	for i, s := range samples {
+		points[i] = checkPoint{time: s.timestamp, value: float64(s.userCount), users: s.users, breaks: s.userCount > 0}
+	}
+	return points
+}
+
+// UserWindowStats summarizes the user samples in an idle check window.
+type UserWindowStats struct {
+	Minutes  int
+	Samples  int
+	MaxUsers int
+	// LastSeen is the newest retained sample with a user logged in, zero
+	// if there is none.
+	LastSeen time.Time
+}
+
+// WindowStats returns statistics over the last minutes, the same window
+// NoUsersLoggedIn checks.
+func (m *UserMonitor) WindowStats(minutes int) UserWindowStats {
+	return m.WindowStatsAt(m.Clock.Now(), minutes)
+}
+
+// WindowStatsAt is WindowStats evaluated as if the current time were now.
+func (m *UserMonitor) WindowStatsAt(now time.Time, minutes int) UserWindowStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	cutoff := now.Add(-time.Duration(minutes) * time.Minute)
+	stats := UserWindowStats{Minutes: minutes}
+	for _, s := range m.samples {
+		if s.timestamp.After(now) {
+			continue
+		}
 		if s.userCount > 0 {
-			log.Printf("User check: %d users at %s: %v — not idle",
-				s.userCount, s.timestamp.Format(time.RFC3339), s.users)
-			return false
+			stats.LastSeen = s.timestamp
+		}
+		if s.timestamp.After(cutoff) {
+			stats.Samples++
+			if s.userCount > stats.MaxUsers {
+				stats.MaxUsers = s.userCount
+			}
 		}
 	}
-
-	log.Printf("User check: 0 users for all %d samples over last %d min ✓",
-		len(samplesInWindow), minutes)
-	return true
+	return stats
 }
 
 // GetCurrentUserCount returns the most recent user count.
@@ -161,3 +242,47 @@ func (m *UserMonitor) GetCurrentUserCount() int {
 	}
 	return m.samples[len(m.samples)-1].userCount
 }
+
+// GetSamples returns a snapshot of all retained user samples as exported types.
+func (m *UserMonitor) GetSamples() []UserSample {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := make([]UserSample, len(m.samples))
+	for i, s := range m.samples {
+		result[i] = UserSample{Timestamp: s.timestamp, Count: s.userCount, Users: s.users}
+	}
+	return result
+}
+
+// WindowSamples returns the samples in the last minutes, the window
+// NoUsersLoggedIn checks.
+func (m *UserMonitor) WindowSamples(minutes int) []UserSample {
+	return m.WindowSamplesAt(m.Clock.Now(), minutes)
+}
+
+// WindowSamplesAt is WindowSamples evaluated as if the current time were now.
+func (m *UserMonitor) WindowSamplesAt(now time.Time, minutes int) []UserSample {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	cutoff := now.Add(-time.Duration(minutes) * time.Minute)
+	var result []UserSample
+	for _, s := range m.samples {
+		if s.timestamp.After(cutoff) && !s.timestamp.After(now) {
+			result = append(result, UserSample{Timestamp: s.timestamp, Count: s.userCount, Users: s.users})
+		}
+	}
+	return result
+}
+
+// AddSamples appends previously recorded samples, e.g. loaded from a sample
+// file for offline replay. Samples must be in chronological order.
+func (m *UserMonitor) AddSamples(samples []UserSample) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range samples {
+		m.samples = append(m.samples, userSample{timestamp: s.Timestamp, userCount: s.Count, users: s.Users})
+	}
+}
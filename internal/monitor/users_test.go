@@ -0,0 +1,110 @@
+package monitor
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"idleshutdown/internal/clock"
+)
+
+// fakeRunner returns canned output for every command.
+type fakeRunner struct {
+	output string
+	err    error
+	calls  []string
+}
+
+func (r *fakeRunner) Run(name string, args ...string) ([]byte, error) {
+	r.calls = append(r.calls, strings.Join(append([]string{name}, args...), " "))
+	return []byte(r.output), r.err
+}
+
+// staticSessions is a SessionProvider returning a fixed user list.
+type staticSessions []string
+
+func (s staticSessions) LoggedInUsers() ([]string, error) { return s, nil }
+
+func TestWhoSessionsDeduplicatesUsers(t *testing.T) {
+	runner := &fakeRunner{output: "alice pts/0 2026-02-19 09:00 (10.0.0.1)\n" +
+		"bob   pts/1 2026-02-19 09:05 (10.0.0.2)\n" +
+		"alice pts/2 2026-02-19 09:10 (10.0.0.1)\n"}
+
+	users, err := WhoSessions{Runner: runner}.LoggedInUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(users)
+	if strings.Join(users, ",") != "alice,bob" {
+		t.Errorf("users = %v, want [alice bob]", users)
+	}
+	if len(runner.calls) != 1 || runner.calls[0] != "who" {
+		t.Errorf("calls = %v, want [who]", runner.calls)
+	}
+}
+
+func TestWhoSessionsError(t *testing.T) {
+	runner := &fakeRunner{err: errors.New("exit status 1")}
+	if _, err := (WhoSessions{Runner: runner}).LoggedInUsers(); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestNoUsersLoggedIn(t *testing.T) {
+	fake := clock.NewFake(testStart)
+	sessions := staticSessions{"alice"}
+	m := NewUserMonitor(30 * time.Second)
+	m.Clock = fake
+	m.Sessions = &sessions
+
+	// alice is logged in for 10 minutes, then leaves
+	for i := 0; i < 140; i++ {
+		if i == 20 {
+			sessions = nil
+		}
+		m.Sample()
+		fake.Advance(30 * time.Second)
+	}
+
+	if !m.NoUsersLoggedIn(60) {
+		t.Error("expected no users over the last 60 minutes")
+	}
+	if m.NoUsersLoggedIn(65) {
+		t.Error("expected alice's session to be inside a 65-minute window")
+	}
+	if got := m.GetCurrentUserCount(); got != 0 {
+		t.Errorf("current user count = %d, want 0", got)
+	}
+
+	stats := m.WindowStats(60)
+	if stats.Samples != 119 || stats.MaxUsers != 0 {
+		t.Errorf("60-minute stats = %+v", stats)
+	}
+	if want := testStart.Add(19 * 30 * time.Second); !stats.LastSeen.Equal(want) {
+		t.Errorf("last seen = %v, want %v", stats.LastSeen, want)
+	}
+	if m.WindowStats(65).MaxUsers != 1 {
+		t.Error("expected alice inside a 65-minute window")
+	}
+}
+
+func TestUserSamplesKeptAsLongAsCPUSamples(t *testing.T) {
+	fake := clock.NewFake(testStart)
+	m := NewUserMonitor(30 * time.Second)
+	m.Clock = fake
+	m.Sessions = staticSessions{"alice"}
+
+	m.Sample()
+	fake.Advance(maxSampleRetention - time.Minute)
+	m.Sample()
+	if got := len(m.GetSamples()); got != 2 {
+		t.Fatalf("samples = %d, want both kept", got)
+	}
+	fake.Advance(time.Minute)
+	m.Sample()
+	if got := len(m.GetSamples()); got != 2 {
+		t.Errorf("samples = %d, want the first one pruned", got)
+	}
+}
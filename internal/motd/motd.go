@@ -0,0 +1,170 @@
+// Package motd maintains a login message (MOTD snippet) that tells users
+// the VM shuts itself down when idle.
+package motd
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"idleshutdown/internal/clock"
+	"idleshutdown/internal/fileutil"
+	"idleshutdown/internal/logging"
+	"idleshutdown/internal/monitor"
+	"idleshutdown/internal/rules"
+)
+
+// Status is the agent state shown in the snippet.
+type Status struct {
+	// AutoMode is true when the threshold is self-calibrated.
+	AutoMode bool
+	// Learning is true while shutdown evaluation is paused for the initial
+	// calibration; LearningRemaining is how long that lasts.
+	Learning          bool
+	LearningRemaining time.Duration
+
+	CPUThreshold     int
+	CPUCheckMinutes  int
+	UserCheckMinutes int
+	// CPUMetric is the CPU reading compared with the threshold; empty
+	// means the aggregate.
+	CPUMetric string
+	// Rule is the shutdown_when rule, empty when the default condition
+	// above applies.
+	Rule string
+	// GraceMinutes is the notice the agent gives once the VM is idle
+	// before it shuts down (shutdown_grace_minutes).
+	GraceMinutes int
+	// ShutdownAt is when a pending shutdown runs, zero if none is pending.
+	ShutdownAt time.Time
+	// Vetoes lists the signals added to every rule (memory, tcp) that
+	// currently hold off a shutdown.
+	Vetoes []string
+}
+
+// Render returns the snippet text for status.
+func Render(status Status) string {
+	cpu := cpuLabel(status.CPUMetric)
+	var b strings.Builder
+	b.WriteString("──────────────────────────────────────────────────────────────\n")
+	b.WriteString(" IdleShutdown: this VM shuts itself down when idle.\n")
+
+	switch {
+	case status.Learning:
+		fmt.Fprintf(&b, " Mode:     auto — learning this VM's idle CPU level (%s left);\n", clock.FormatDuration(status.LearningRemaining))
+		b.WriteString("           no shutdowns until learning completes.\n")
+	case status.AutoMode:
+		fmt.Fprintf(&b, " Mode:     auto — calibrated threshold %d%% %s\n", status.CPUThreshold, cpu)
+	default:
+		fmt.Fprintf(&b, " Mode:     manual — threshold %d%% %s\n", status.CPUThreshold, cpu)
+	}
+
+	switch {
+	case status.Rule != "":
+		fmt.Fprintf(&b, " Idle:     when %s\n", status.Rule)
+		writeLegend(&b, status.Rule)
+	case status.Learning:
+		fmt.Fprintf(&b, " Idle:     %s below the learned threshold for %d min and no users logged in for %d min\n",
+			cpu, status.CPUCheckMinutes, status.UserCheckMinutes)
+	default:
+		fmt.Fprintf(&b, " Idle:     %s below %d%% for %d min and no users logged in for %d min\n",
+			cpu, status.CPUThreshold, status.CPUCheckMinutes, status.UserCheckMinutes)
+	}
+	if status.Rule == "" {
+		fmt.Fprintf(&b, " This VM will shut down after %d min idle once you log out",
+			max(status.CPUCheckMinutes, status.UserCheckMinutes))
+		if status.GraceMinutes > 0 {
+			fmt.Fprintf(&b, ", plus %d min notice", status.GraceMinutes)
+		}
+		b.WriteString(".\n")
+	}
+	for _, veto := range status.Vetoes {
+		fmt.Fprintf(&b, " Vetoed:   by %s, waiting for %s\n", veto, rules.Signals[veto])
+	}
+	if !status.ShutdownAt.IsZero() {
+		fmt.Fprintf(&b, " Pending:  shutdown at %s unless the VM becomes busy.\n", status.ShutdownAt.UTC().Format("15:04 UTC"))
+	}
+	b.WriteString("──────────────────────────────────────────────────────────────\n")
+	return b.String()
+}
+
+// writeLegend explains each signal the rule checks, one per line.
+func writeLegend(b *strings.Builder, rule string) {
+	x, err := rules.Parse(rule)
+	if err != nil {
+		return
+	}
+	windows := rules.Windows(x)
+	signals := make([]string, 0, len(windows))
+	for signal := range windows {
+		signals = append(signals, signal)
+	}
+	sort.Strings(signals)
+	for _, signal := range signals {
+		fmt.Fprintf(b, "           %s: %s\n", signal, rules.Signals[signal])
+	}
+}
+
+// cpuLabel names the CPU reading of metric.
+func cpuLabel(metric string) string {
+	switch metric {
+	case monitor.MetricMaxCore:
+		return "busiest-core CPU"
+	case monitor.MetricLoad:
+		return "load per core"
+	case monitor.MetricCgroup:
+		return "CPU of selected services"
+	}
+	return "CPU"
+}
+
+// Writer keeps the snippet at Path up to date, rewriting it only when the
+// text changes.
+type Writer struct {
+	Path string
+
+	last    string
+	lastErr string
+}
+
+// NewWriter creates a Writer for the snippet at path.
+func NewWriter(path string) *Writer {
+	return &Writer{Path: path}
+}
+
+// Update renders status and writes it if it differs from the last write.
+// Failures are logged once until the error changes.
+func (w *Writer) Update(status Status) {
+	text := Render(status)
+	if text == w.last {
+		return
+	}
+
+	err := os.MkdirAll(filepath.Dir(w.Path), 0755)
+	if err == nil {
+		err = fileutil.WriteAtomic(w.Path, []byte(text), 0644)
+	}
+	if err != nil {
+		if err.Error() != w.lastErr {
+			slog.Warn("Could not write MOTD snippet", logging.EventKey, logging.Startup, "path", w.Path, "error", err)
+			w.lastErr = err.Error()
+		}
+		return
+	}
+	w.last = text
+	w.lastErr = ""
+}
+
+// Remove deletes the snippet, so logins no longer announce a policy that
+// is not being enforced.
+func (w *Writer) Remove() {
+	if err := os.Remove(w.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("Could not remove MOTD snippet", logging.EventKey, logging.Startup, "path", w.Path, "error", err)
+	}
+	w.last = ""
+}
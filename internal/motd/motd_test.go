@@ -0,0 +1,94 @@
+package motd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"idleshutdown/internal/monitor"
+)
+
+func TestRender(t *testing.T) {
+	cases := []struct {
+		name   string
+		status Status
+		want   []string
+	}{
+		{
+			name:   "learning",
+			status: Status{AutoMode: true, Learning: true, LearningRemaining: 3*time.Hour + 12*time.Minute, CPUThreshold: 25, CPUCheckMinutes: 60, UserCheckMinutes: 60},
+			want:   []string{"learning", "3h 12m left", "no shutdowns", "below the learned threshold for 60 min"},
+		},
+		{
+			name:   "calibrated",
+			status: Status{AutoMode: true, CPUThreshold: 5, CPUCheckMinutes: 60, UserCheckMinutes: 30},
+			want:   []string{"calibrated threshold 5%", "CPU below 5% for 60 min", "no users logged in for 30 min", "shut down after 60 min idle"},
+		},
+		{
+			name:   "manual",
+			status: Status{CPUThreshold: 20, CPUCheckMinutes: 30, UserCheckMinutes: 90},
+			want:   []string{"manual — threshold 20%", "shut down after 90 min idle once you log out.\n"},
+		},
+		{
+			name:   "grace",
+			status: Status{CPUThreshold: 20, CPUCheckMinutes: 30, UserCheckMinutes: 90, GraceMinutes: 10},
+			want:   []string{"shut down after 90 min idle once you log out, plus 10 min notice."},
+		},
+		{
+			name:   "max core",
+			status: Status{CPUThreshold: 20, CPUCheckMinutes: 30, UserCheckMinutes: 90, CPUMetric: monitor.MetricMaxCore},
+			want:   []string{"manual — threshold 20% busiest-core CPU", "busiest-core CPU below 20% for 30 min"},
+		},
+		{
+			name:   "rule",
+			status: Status{CPUThreshold: 20, CPUCheckMinutes: 30, UserCheckMinutes: 90, Rule: "users.idle(30m) || cpu.idle(2h)"},
+			want: []string{"manual — threshold 20%", "Idle:     when users.idle(30m) || cpu.idle(2h)",
+				"cpu: CPU usage below the threshold", "users: no users logged in"},
+		},
+		{
+			name: "pending with veto",
+			status: Status{CPUThreshold: 20, Rule: "cpu.idle(30m) && tcp.idle(5m)", Vetoes: []string{"tcp"},
+				ShutdownAt: time.Date(2026, 2, 19, 14, 35, 0, 0, time.UTC)},
+			want: []string{"tcp: no client connections on the [tcp] ports",
+				"Vetoed:   by tcp, waiting for no client connections", "Pending:  shutdown at 14:35 UTC"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			text := Render(tc.status)
+			for _, want := range tc.want {
+				if !strings.Contains(text, want) {
+					t.Errorf("missing %q in:\n%s", want, text)
+				}
+			}
+		})
+	}
+}
+
+func TestWriterUpdateAndRemove(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "motd.d", "idleshutdown")
+	w := NewWriter(path)
+	status := Status{CPUThreshold: 20, CPUCheckMinutes: 30, UserCheckMinutes: 30}
+
+	w.Update(status)
+	content, err := os.ReadFile(path)
+	if err != nil || string(content) != Render(status) {
+		t.Fatalf("snippet = %q, %v", content, err)
+	}
+
+	// An unchanged status must not rewrite the file
+	if err := os.WriteFile(path, []byte("edited"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w.Update(status)
+	if content, _ := os.ReadFile(path); string(content) != "edited" {
+		t.Error("unchanged status rewrote the snippet")
+	}
+
+	w.Remove()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("snippet still present after Remove: %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+// Package notify delivers agent lifecycle events (shutdowns, calibrations,
+// config problems) to external systems.
+package notify
+
+import (
+	"os"
+	"time"
+)
+
+// Event types.
+const (
+	// ShutdownPending is sent when the idle condition is first met and the
+	// shutdown grace period starts.
+	ShutdownPending = "shutdown_pending"
+	// ShutdownExecuted is sent right before the shutdown command runs.
+	ShutdownExecuted = "shutdown_executed"
+	// ShutdownCancelled is sent when the VM becomes busy during the grace
+	// period.
+	ShutdownCancelled = "shutdown_cancelled"
+	// CalibrationApplied is sent when a calibration sets a new threshold.
+	CalibrationApplied = "calibration_applied"
+	// CalibrationFailed is sent when a due calibration fails.
+	CalibrationFailed = "calibration_failed"
+	// LearningCompleted is sent when the initial calibration ends the
+	// learning phase.
+	LearningCompleted = "learning_completed"
+	// ConfigReloadFailed is sent when config.ini can no longer be loaded.
+	ConfigReloadFailed = "config_reload_failed"
+)
+
+// Event is a single notification, serialized as JSON by the webhook.
+type Event struct {
+	Type    string         `json:"type"`
+	Time    time.Time      `json:"time"`
+	Host    string         `json:"host"`
+	Message string         `json:"message"`
+	Fields  map[string]any `json:"fields,omitempty"`
+}
+
+// NewEvent creates an event for this host.
+func NewEvent(eventType string, at time.Time, message string, fields map[string]any) Event {
+	return Event{Type: eventType, Time: at.UTC(), Host: hostname(), Message: message, Fields: fields}
+}
+
+// Notifier delivers events. Delivery is synchronous, bounded in time and
+// best effort: failures are logged, never returned, so a broken endpoint
+// cannot stop or long delay a shutdown.
+type Notifier interface {
+	Notify(event Event)
+}
+
+// Discard is a Notifier that drops every event.
+var Discard Notifier = discard{}
+
+type discard struct{}
+
+func (discard) Notify(Event) {}
+
+// Multi returns a Notifier that delivers each event to every notifier in
+// turn.
+func Multi(notifiers ...Notifier) Notifier {
+	return multi(notifiers)
+}
+
+type multi []Notifier
+
+func (m multi) Notify(event Event) {
+	for _, n := range m {
+		n.Notify(event)
+	}
+}
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return name
+}
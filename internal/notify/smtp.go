@@ -0,0 +1,216 @@
+package notify
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"sort"
+	"strings"
+	"time"
+
+	"idleshutdown/internal/logging"
+	"idleshutdown/internal/rules"
+)
+
+// smtpTimeout bounds a whole delivery, from dial to QUIT.
+const smtpTimeout = 30 * time.Second
+
+// detailLabels gives readable names to the event fields the agent attaches
+// to shutdown events; other fields are listed under their own names.
+var detailLabels = map[string]string{
+	"shutdown_at":     "Shutdown at",
+	"cpu_threshold":   "CPU threshold (%)",
+	"cpu_minutes":     "CPU window (min)",
+	"cpu_samples":     "CPU samples",
+	"cpu_average":     "CPU average (%)",
+	"cpu_peak":        "CPU peak (%)",
+	"user_minutes":    "User window (min)",
+	"user_samples":    "User samples",
+	"users_last_seen": "Last user seen",
+	"dry_run":         "Dry run",
+}
+
+// SMTP emails events to the VM owners through a mail relay. Only event
+// types listed in Events are sent.
+type SMTP struct {
+	// Addr is the relay as host:port.
+	Addr string
+	From string
+	To   []string
+	// Username and Password enable PLAIN authentication, which net/smtp
+	// only allows over TLS or to localhost.
+	Username string
+	Password string
+	// StartTLS requires the relay to support STARTTLS and upgrades the
+	// connection before authenticating.
+	StartTLS  bool
+	TLSConfig *tls.Config
+	Events    map[string]bool
+}
+
+// NewSMTP creates an SMTP notifier that emails owners about pending and
+// executed shutdowns.
+func NewSMTP(addr, from string, owners []string, username, password string, startTLS bool) *SMTP {
+	host, _, _ := net.SplitHostPort(addr)
+	if from == "" {
+		from = "idleshutdown@" + hostname()
+	}
+	return &SMTP{
+		Addr:      addr,
+		From:      from,
+		To:        owners,
+		Username:  username,
+		Password:  password,
+		StartTLS:  startTLS,
+		TLSConfig: &tls.Config{ServerName: host},
+		Events:    map[string]bool{ShutdownPending: true, ShutdownExecuted: true},
+	}
+}
+
+// Notify emails event to the owners if its type is selected.
+func (s *SMTP) Notify(event Event) {
+	if !s.Events[event.Type] {
+		return
+	}
+	if err := s.send(event); err != nil {
+		slog.Warn("Email not delivered", logging.EventKey, logging.Notify,
+			"to", s.To, "type", event.Type, "error", err)
+	}
+}
+
+func (s *SMTP) send(event Event) error {
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return fmt.Errorf("invalid relay address: %w", err)
+	}
+	conn, err := net.DialTimeout("tcp", s.Addr, smtpTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(smtpTimeout))
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := client.Hello(hostname()); err != nil {
+		return err
+	}
+	if s.StartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return errors.New("relay does not support STARTTLS")
+		}
+		if err := client.StartTLS(s.TLSConfig); err != nil {
+			return fmt.Errorf("STARTTLS: %w", err)
+		}
+	}
+	if s.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.Username, s.Password, host)); err != nil {
+			return fmt.Errorf("authenticate: %w", err)
+		}
+	}
+
+	if err := client.Mail(s.From); err != nil {
+		return err
+	}
+	for _, to := range s.To {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("recipient %s: %w", to, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(s.message(event)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// message renders event as a plain-text email with CRLF line endings.
+func (s *SMTP) message(event Event) []byte {
+	var subject, intro, help string
+	login := loginBlocksShutdown(event)
+	switch {
+	case event.Type == ShutdownPending && login:
+		help = "To keep it running, log in (an active session blocks the shutdown),\n" +
+			"or stop the agent with: sudo systemctl stop IdleShutdown"
+	case event.Type == ShutdownPending:
+		help = fmt.Sprintf("Logging in does not keep it running under the shutdown rule\n%v. ", event.Fields["rule"]) +
+			"To keep it running, stop the agent with:\nsudo systemctl stop IdleShutdown"
+	case event.Type == ShutdownExecuted && login:
+		help = "Start it again from your cloud console. To prevent future idle shutdowns,\n" +
+			"stay logged in while you need it, or stop the agent with:\n" +
+			"sudo systemctl stop IdleShutdown"
+	case event.Type == ShutdownExecuted:
+		help = "Start it again from your cloud console. To prevent future idle shutdowns,\n" +
+			"stop the agent with: sudo systemctl stop IdleShutdown"
+	}
+	switch event.Type {
+	case ShutdownPending:
+		subject = fmt.Sprintf("[IdleShutdown] %s will shut down soon", event.Host)
+		intro = fmt.Sprintf("%s is idle and will shut down at %v unless it becomes busy.", event.Host, event.Fields["shutdown_at"])
+	case ShutdownExecuted:
+		subject = fmt.Sprintf("[IdleShutdown] %s is shutting down", event.Host)
+		intro = fmt.Sprintf("%s was idle and is shutting down now.", event.Host)
+	default:
+		subject = fmt.Sprintf("[IdleShutdown] %s: %s", event.Host, event.Type)
+		intro = fmt.Sprintf("%s reported %s.", event.Host, event.Type)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\n", s.From)
+	fmt.Fprintf(&b, "To: %s\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&b, "Subject: %s\n", subject)
+	fmt.Fprintf(&b, "Date: %s\n", event.Time.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\n\n")
+
+	fmt.Fprintf(&b, "%s\n\nReason: %s\n", intro, event.Message)
+	if len(event.Fields) > 0 {
+		b.WriteString("\nIdle statistics:\n")
+		keys := make([]string, 0, len(event.Fields))
+		for k := range event.Fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			label := detailLabels[k]
+			if label == "" {
+				label = k
+			}
+			fmt.Fprintf(&b, "  %-18s %v\n", label+":", event.Fields[k])
+		}
+	}
+	if help != "" {
+		fmt.Fprintf(&b, "\n%s\n", help)
+	}
+
+	return []byte(strings.ReplaceAll(b.String(), "\n", "\r\n"))
+}
+
+// loginBlocksShutdown reports whether a user session keeps the VM up
+// under the shutdown rule in the event's "rule" field. Without one, the
+// built-in rule applies, which waits for users to log out.
+func loginBlocksShutdown(event Event) bool {
+	src, ok := event.Fields["rule"].(string)
+	if !ok {
+		return true
+	}
+	rule, err := rules.Parse(src)
+	if err != nil {
+		return true
+	}
+	return rules.BlockedBy(rule, "users")
+}
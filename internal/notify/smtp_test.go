@@ -0,0 +1,211 @@
+package notify
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// smtpStandIn is a minimal SMTP relay that records what it receives.
+type smtpStandIn struct {
+	listener net.Listener
+	tls      *tls.Config // offer STARTTLS when set
+
+	mu       sync.Mutex
+	auth     string
+	from     string
+	rcpt     []string
+	data     string
+	upgraded bool
+	done     chan struct{}
+}
+
+func newSMTPStandIn(t *testing.T, tlsConfig *tls.Config) *smtpStandIn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpStandIn{listener: listener, tls: tlsConfig, done: make(chan struct{})}
+	t.Cleanup(func() { listener.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *smtpStandIn) serve() {
+	defer close(s.done)
+	conn, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	reply("220 stand-in ESMTP")
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.SplitN(cmd, " ", 2)[0])
+
+		s.mu.Lock()
+		switch verb {
+		case "EHLO":
+			reply("250-stand-in")
+			if s.tls != nil && !s.upgraded {
+				reply("250-STARTTLS")
+			}
+			reply("250 AUTH PLAIN")
+		case "STARTTLS":
+			reply("220 ready")
+			tlsConn := tls.Server(conn, s.tls)
+			if err := tlsConn.Handshake(); err != nil {
+				s.mu.Unlock()
+				return
+			}
+			conn, r, s.upgraded = tlsConn, bufio.NewReader(tlsConn), true
+		case "AUTH":
+			s.auth = cmd
+			reply("235 ok")
+		case "MAIL":
+			s.from = cmd
+			reply("250 ok")
+		case "RCPT":
+			s.rcpt = append(s.rcpt, cmd)
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil || l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			s.data = data.String()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			s.mu.Unlock()
+			return
+		default:
+			reply("502 unknown")
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *smtpStandIn) wait(t *testing.T) {
+	select {
+	case <-s.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SMTP session did not finish")
+	}
+}
+
+var pendingEvent = NewEvent(ShutdownPending, time.Date(2026, 2, 19, 2, 13, 0, 0, time.UTC),
+	"VM idle — CPU below threshold and no users logged in", map[string]any{
+		"shutdown_at": "2026-02-19T02:18:00Z",
+		"cpu_average": 2.1,
+		"cpu_peak":    3.4,
+	})
+
+func TestSMTPSendsPendingShutdown(t *testing.T) {
+	relay := newSMTPStandIn(t, nil)
+	n := NewSMTP(relay.listener.Addr().String(), "agent@vm", []string{"alice@example.com", "bob@example.com"}, "", "", false)
+
+	n.Notify(pendingEvent)
+	relay.wait(t)
+
+	if relay.from != "MAIL FROM:<agent@vm>" || len(relay.rcpt) != 2 {
+		t.Errorf("envelope = %q %q", relay.from, relay.rcpt)
+	}
+	for _, want := range []string{
+		"Subject: [IdleShutdown] " + pendingEvent.Host + " will shut down soon",
+		"shut down at 2026-02-19T02:18:00Z",
+		"CPU peak (%):",
+		"3.4",
+		"sudo systemctl stop IdleShutdown",
+	} {
+		if !strings.Contains(relay.data, want) {
+			t.Errorf("message missing %q:\n%s", want, relay.data)
+		}
+	}
+}
+
+func TestSMTPHintFollowsRule(t *testing.T) {
+	n := NewSMTP("relay:25", "agent@vm", []string{"alice@example.com"}, "", "", false)
+	for _, tc := range []struct {
+		rule string
+		want string
+	}{
+		{"cpu.idle(1h) && users.idle(1h)", "an active session blocks the shutdown"},
+		{"cpu.idle(1h) || users.idle(1h)", "Logging in does not keep it running under the shutdown rule\r\n" +
+			"cpu.idle(1h) || users.idle(1h). To keep it running, stop the agent with:\r\nsudo systemctl stop IdleShutdown\r\n"},
+	} {
+		event := pendingEvent
+		event.Fields = map[string]any{"shutdown_at": "2026-02-19T02:18:00Z", "rule": tc.rule}
+		if msg := string(n.message(event)); !strings.Contains(msg, tc.want) {
+			t.Errorf("rule %s: message missing %q:\n%s", tc.rule, tc.want, msg)
+		}
+	}
+}
+
+func TestSMTPStartTLSAndAuth(t *testing.T) {
+	// Borrow httptest's self-signed certificate for 127.0.0.1
+	certSrv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer certSrv.Close()
+	relay := newSMTPStandIn(t, &tls.Config{Certificates: certSrv.TLS.Certificates})
+
+	n := NewSMTP(relay.listener.Addr().String(), "agent@vm", []string{"alice@example.com"}, "agent", "pw", true)
+	n.TLSConfig = certSrv.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	n.TLSConfig.ServerName = "127.0.0.1"
+
+	n.Notify(NewEvent(ShutdownExecuted, pendingEvent.Time, "VM idle", nil))
+	relay.wait(t)
+
+	if !relay.upgraded {
+		t.Error("connection was not upgraded with STARTTLS")
+	}
+	want := "AUTH PLAIN " + base64.StdEncoding.EncodeToString([]byte("\x00agent\x00pw"))
+	if relay.auth != want {
+		t.Errorf("auth = %q, want %q", relay.auth, want)
+	}
+	if !strings.Contains(relay.data, "is shutting down now") {
+		t.Errorf("message:\n%s", relay.data)
+	}
+}
+
+func TestSMTPRequiresStartTLS(t *testing.T) {
+	relay := newSMTPStandIn(t, nil)
+	n := NewSMTP(relay.listener.Addr().String(), "agent@vm", []string{"alice@example.com"}, "", "", true)
+
+	if err := n.send(pendingEvent); err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Errorf("err = %v, want STARTTLS required", err)
+	}
+}
+
+func TestSMTPIgnoresOtherEvents(t *testing.T) {
+	relay := newSMTPStandIn(t, nil)
+	n := NewSMTP(relay.listener.Addr().String(), "agent@vm", []string{"alice@example.com"}, "", "", false)
+
+	// The stand-in accepts one session, so only the shutdown mail may use it
+	n.Notify(NewEvent(CalibrationApplied, pendingEvent.Time, "calibrated", nil))
+	n.Notify(pendingEvent)
+	relay.wait(t)
+
+	if !strings.Contains(relay.data, "will shut down soon") {
+		t.Errorf("message:\n%s", relay.data)
+	}
+}
@@ -0,0 +1,145 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"idleshutdown/internal/clock"
+	"idleshutdown/internal/logging"
+)
+
+// Webhook request headers.
+const (
+	EventHeader     = "X-IdleShutdown-Event"
+	SignatureHeader = "X-IdleShutdown-Signature"
+)
+
+// Webhook POSTs each event as JSON to every configured URL. When Secret is
+// set, the body is signed with HMAC-SHA256 and the signature sent as
+// "sha256=<hex>" in the X-IdleShutdown-Signature header.
+type Webhook struct {
+	URLs   []string
+	Secret string
+	// Retries is how many times a failed delivery is retried, waiting
+	// Backoff, then twice as long, and so on.
+	Retries int
+	Backoff time.Duration
+	// Timeout bounds the delivery of an event to one URL, retries and
+	// backoff included, so an unreachable endpoint holds up the agent for
+	// at most Timeout per event.
+	Timeout time.Duration
+	Client  *http.Client
+	// Clock may be replaced, e.g. in tests; backoff sleeps go through it.
+	Clock clock.Clock
+}
+
+// NewWebhook creates a webhook notifier that spends at most timeout on
+// each event.
+func NewWebhook(urls []string, secret string, timeout time.Duration, retries int) *Webhook {
+	return &Webhook{
+		URLs:    urls,
+		Secret:  secret,
+		Retries: retries,
+		Backoff: time.Second,
+		Timeout: timeout,
+		Client:  &http.Client{},
+		Clock:   clock.Real,
+	}
+}
+
+// Notify delivers event to all URLs at once and returns when every
+// delivery has succeeded or given up.
+func (w *Webhook) Notify(event Event) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("Could not encode event", logging.EventKey, logging.Notify, "type", event.Type, "error", err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, url := range w.URLs {
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+			if err := w.deliver(url, event.Type, body); err != nil {
+				slog.Warn("Webhook not delivered", logging.EventKey, logging.Notify,
+					"url", url, "type", event.Type, "error", err)
+			}
+		}(url)
+	}
+	wg.Wait()
+}
+
+// deliver POSTs body to url, retrying network errors and 5xx/429 responses
+// until Timeout has been used up.
+func (w *Webhook) deliver(url, eventType string, body []byte) error {
+	deadline := w.Clock.Now().Add(w.Timeout)
+	backoff := w.Backoff
+	var err error
+	attempt := 0
+	for ; attempt <= w.Retries; attempt++ {
+		if attempt > 0 {
+			if !w.Clock.Now().Add(backoff).Before(deadline) {
+				break
+			}
+			w.Clock.Sleep(backoff)
+			backoff *= 2
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), deadline.Sub(w.Clock.Now()))
+		var retry bool
+		retry, err = w.post(ctx, url, eventType, body)
+		cancel()
+		if err == nil || !retry {
+			return err
+		}
+	}
+	return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+}
+
+// post sends one request and reports whether a failure is worth retrying.
+func (w *Webhook) post(ctx context.Context, url, eventType string, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "idleshutdown")
+	req.Header.Set(EventHeader, eventType)
+	if w.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(w.Secret, body))
+	}
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("server returned %s", resp.Status)
+	default:
+		return false, fmt.Errorf("server returned %s", resp.Status)
+	}
+}
+
+// Sign returns the X-IdleShutdown-Signature value for body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
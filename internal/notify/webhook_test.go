@@ -0,0 +1,171 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"idleshutdown/internal/clock"
+)
+
+// receiver records webhook requests and answers with the queued statuses,
+// then 200.
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	events   []Event
+	bodies   [][]byte
+	headers  []http.Header
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.bodies = append(r.bodies, body)
+	r.headers = append(r.headers, req.Header.Clone())
+	var event Event
+	json.Unmarshal(body, &event)
+	r.events = append(r.events, event)
+
+	if len(r.statuses) > 0 {
+		w.WriteHeader(r.statuses[0])
+		r.statuses = r.statuses[1:]
+	}
+}
+
+func newTestWebhook(urls ...string) *Webhook {
+	w := NewWebhook(urls, "s3cret", time.Second, 2)
+	w.Backoff = time.Millisecond
+	return w
+}
+
+var testEvent = NewEvent(ShutdownExecuted, time.Date(2026, 2, 19, 2, 13, 0, 0, time.UTC),
+	"VM idle", map[string]any{"cpu_threshold": 5})
+
+func TestWebhookDeliversSignedJSON(t *testing.T) {
+	rec := &receiver{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	newTestWebhook(srv.URL).Notify(testEvent)
+
+	if len(rec.events) != 1 {
+		t.Fatalf("got %d requests, want 1", len(rec.events))
+	}
+	got := rec.events[0]
+	if got.Type != ShutdownExecuted || got.Message != "VM idle" || got.Host == "" || !got.Time.Equal(testEvent.Time) {
+		t.Errorf("event = %+v", got)
+	}
+	if got.Fields["cpu_threshold"] != float64(5) {
+		t.Errorf("fields = %v", got.Fields)
+	}
+
+	header := rec.headers[0]
+	if header.Get(EventHeader) != ShutdownExecuted || header.Get("Content-Type") != "application/json" {
+		t.Errorf("headers = %v", header)
+	}
+	if sig := header.Get(SignatureHeader); sig != Sign("s3cret", rec.bodies[0]) {
+		t.Errorf("signature = %q, want %q", sig, Sign("s3cret", rec.bodies[0]))
+	}
+}
+
+func TestWebhookRetriesServerErrors(t *testing.T) {
+	rec := &receiver{statuses: []int{http.StatusBadGateway, http.StatusTooManyRequests}}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	newTestWebhook(srv.URL).Notify(testEvent)
+	if len(rec.events) != 3 {
+		t.Errorf("got %d attempts, want 3 (two failures, then success)", len(rec.events))
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	rec := &receiver{statuses: []int{500, 500, 500, 500}}
+	failing := httptest.NewServer(rec)
+	defer failing.Close()
+	badRequest := &receiver{statuses: []int{http.StatusBadRequest}}
+	rejecting := httptest.NewServer(badRequest)
+	defer rejecting.Close()
+	ok := &receiver{}
+	healthy := httptest.NewServer(ok)
+	defer healthy.Close()
+
+	newTestWebhook(failing.URL, rejecting.URL, healthy.URL).Notify(testEvent)
+
+	if len(rec.events) != 3 {
+		t.Errorf("failing endpoint got %d attempts, want 1 + 2 retries", len(rec.events))
+	}
+	if len(badRequest.events) != 1 {
+		t.Errorf("4xx endpoint got %d attempts, want no retries", len(badRequest.events))
+	}
+	if len(ok.events) != 1 {
+		t.Error("a failing endpoint must not block delivery to the others")
+	}
+}
+
+func TestWebhookTimeout(t *testing.T) {
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer srv.Close()
+	defer close(block)
+
+	w := NewWebhook([]string{srv.URL}, "", 50*time.Millisecond, 0)
+	start := time.Now()
+	w.Notify(testEvent)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Notify took %v despite a 50ms timeout", elapsed)
+	}
+}
+
+func TestWebhookTimeoutCoversRetries(t *testing.T) {
+	block := make(chan struct{})
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-block
+	}))
+	defer hanging.Close()
+	defer close(block)
+	ok := &receiver{}
+	healthy := httptest.NewServer(ok)
+	defer healthy.Close()
+
+	w := NewWebhook([]string{hanging.URL, healthy.URL}, "", 100*time.Millisecond, 5)
+	w.Backoff = 10 * time.Millisecond
+	start := time.Now()
+	w.Notify(testEvent)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Notify took %v, want at most about the 100ms timeout for all retries", elapsed)
+	}
+	if len(ok.events) != 1 {
+		t.Error("a hanging endpoint must not hold up delivery to the others")
+	}
+}
+
+func TestWebhookBackoffUsesClock(t *testing.T) {
+	rec := &receiver{statuses: []int{500, 500, 500, 500, 500, 500}}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	start := time.Date(2026, 2, 19, 2, 13, 0, 0, time.UTC)
+	fake := clock.NewFake(start)
+	w := NewWebhook([]string{srv.URL}, "", 10*time.Second, 5)
+	w.Clock = fake
+	w.Notify(testEvent)
+
+	// Attempts at 0s, 1s, 3s and 7s; waiting another 8s would pass the
+	// 10s timeout.
+	if len(rec.events) != 4 {
+		t.Errorf("got %d attempts, want 4 within the timeout", len(rec.events))
+	}
+	if got := fake.Since(start); got != 7*time.Second {
+		t.Errorf("slept %v on the clock, want 7s", got)
+	}
+}
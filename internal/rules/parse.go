@@ -0,0 +1,222 @@
+package rules
+
+import (
+	"fmt"
+	"time"
+	"unicode"
+)
+
+// Parse parses a shutdown_when expression and checks that every atom
+// refers to a known signal with a window of at least a minute.
+func Parse(src string) (Expr, error) {
+	p := &parser{src: src}
+	p.next()
+	x, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %s", p.tok)
+	}
+	return x, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokDuration
+	tokOr
+	tokAnd
+	tokNot
+	tokLParen
+	tokRParen
+	tokDot
+	tokInvalid
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+type parser struct {
+	src string
+	pos int
+	tok token
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("shutdown_when: column %d: %s", p.tok.pos+1, fmt.Sprintf(format, args...))
+}
+
+// next scans the next token into p.tok.
+func (p *parser) next() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+	start := p.pos
+	if p.pos >= len(p.src) {
+		p.tok = token{kind: tokEOF, pos: start}
+		return
+	}
+
+	two := p.src[p.pos:min(p.pos+2, len(p.src))]
+	switch {
+	case two == "||":
+		p.pos += 2
+		p.tok = token{tokOr, two, start}
+		return
+	case two == "&&":
+		p.pos += 2
+		p.tok = token{tokAnd, two, start}
+		return
+	}
+
+	c := p.src[p.pos]
+	switch {
+	case c == '!':
+		p.pos++
+		p.tok = token{tokNot, "!", start}
+	case c == '(':
+		p.pos++
+		p.tok = token{tokLParen, "(", start}
+	case c == ')':
+		p.pos++
+		p.tok = token{tokRParen, ")", start}
+	case c == '.':
+		p.pos++
+		p.tok = token{tokDot, ".", start}
+	case isLetter(c):
+		for p.pos < len(p.src) && (isLetter(p.src[p.pos]) || isDigit(p.src[p.pos])) {
+			p.pos++
+		}
+		p.tok = token{tokIdent, p.src[start:p.pos], start}
+	case isDigit(c):
+		for p.pos < len(p.src) && (isLetter(p.src[p.pos]) || isDigit(p.src[p.pos]) || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		p.tok = token{tokDuration, p.src[start:p.pos], start}
+	default:
+		p.pos++
+		p.tok = token{tokInvalid, string(c), start}
+	}
+}
+
+func isLetter(c byte) bool { return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') }
+func isDigit(c byte) bool  { return c >= '0' && c <= '9' }
+
+// parseOr parses and-expressions separated by ||.
+func (p *parser) parseOr() (Expr, error) {
+	x, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	or := Or{x}
+	for p.tok.kind == tokOr {
+		p.next()
+		y, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		or = append(or, y)
+	}
+	if len(or) == 1 {
+		return x, nil
+	}
+	return or, nil
+}
+
+// parseAnd parses unary expressions separated by &&.
+func (p *parser) parseAnd() (Expr, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	and := And{x}
+	for p.tok.kind == tokAnd {
+		p.next()
+		y, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		and = append(and, y)
+	}
+	if len(and) == 1 {
+		return x, nil
+	}
+	return and, nil
+}
+
+// parseUnary parses a negation, a parenthesized expression or an atom.
+func (p *parser) parseUnary() (Expr, error) {
+	switch p.tok.kind {
+	case tokNot:
+		p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return Not{x}, nil
+	case tokLParen:
+		p.next()
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokRParen {
+			return nil, p.errorf("expected ), found %s", p.tok)
+		}
+		p.next()
+		return x, nil
+	case tokIdent:
+		return p.parseAtom()
+	}
+	return nil, p.errorf("expected a condition such as cpu.idle(60m), found %s", p.tok)
+}
+
+// parseAtom parses <signal>.idle(<duration>).
+func (p *parser) parseAtom() (Expr, error) {
+	signal := p.tok
+	if _, ok := Signals[signal.text]; !ok {
+		return nil, p.errorf("unknown signal %q (known: %s)", signal.text, signalNames())
+	}
+	p.next()
+	if p.tok.kind != tokDot {
+		return nil, p.errorf("expected .idle(...) after %q, found %s", signal.text, p.tok)
+	}
+	p.next()
+	if p.tok.kind != tokIdent || p.tok.text != "idle" {
+		return nil, p.errorf("expected idle after %q, found %s", signal.text+".", p.tok)
+	}
+	p.next()
+	if p.tok.kind != tokLParen {
+		return nil, p.errorf("expected ( after %s.idle, found %s", signal.text, p.tok)
+	}
+	p.next()
+	if p.tok.kind != tokDuration {
+		return nil, p.errorf("expected a duration such as 30m or 2h, found %s", p.tok)
+	}
+	window, err := time.ParseDuration(p.tok.text)
+	if err != nil {
+		return nil, p.errorf("invalid duration %q", p.tok.text)
+	}
+	if window < time.Minute || window%time.Minute != 0 {
+		return nil, p.errorf("duration %q must be a whole number of minutes", p.tok.text)
+	}
+	p.next()
+	if p.tok.kind != tokRParen {
+		return nil, p.errorf("expected ) after the duration, found %s", p.tok)
+	}
+	p.next()
+	return Atom{Signal: signal.text, Window: window}, nil
+}
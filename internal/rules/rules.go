@@ -0,0 +1,223 @@
+// Package rules parses and evaluates shutdown_when expressions, which
+// combine idle checks with boolean operators:
+//
+//	users.idle(30m) || (cpu.idle(2h) && !users.idle(5m))
+//
+// An atom is <signal>.idle(<duration>) and is true when the signal has been
+// idle for the whole duration. Operators, by increasing precedence, are
+// ||, && and !; parentheses group.
+package rules
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Signals lists the signals atoms may refer to.
+var Signals = map[string]string{
+	"cpu":    "CPU usage below the threshold",
+	"users":  "no users logged in",
+	"psi":    "pressure stall below the [psi] threshold",
+	"memory": "paging and swapping below the [memory] limits",
+	"net":    "network traffic below the [net] limits",
+	"tcp":    "no client connections on the [tcp] ports",
+	"score":  "weighted idle score at or above the [scoring] target",
+}
+
+// Env answers the idle checks atoms refer to.
+type Env interface {
+	Idle(signal string, window time.Duration) bool
+}
+
+// EnvFunc adapts a function to Env.
+type EnvFunc func(signal string, window time.Duration) bool
+
+// Idle calls f.
+func (f EnvFunc) Idle(signal string, window time.Duration) bool {
+	return f(signal, window)
+}
+
+// Expr is a parsed expression.
+type Expr interface {
+	// Eval evaluates the expression and returns, along with the result,
+	// the sub-expression that decided it: for a true result the parts
+	// that fired, for a false one every part that blocked.
+	Eval(env Env) (bool, Expr)
+	String() string
+}
+
+// Atom is an idle check on one signal over a window.
+type Atom struct {
+	Signal string
+	Window time.Duration
+}
+
+// Minutes returns the window in whole minutes.
+func (a Atom) Minutes() int {
+	return int(a.Window / time.Minute)
+}
+
+func (a Atom) Eval(env Env) (bool, Expr) {
+	return env.Idle(a.Signal, a.Window), a
+}
+
+func (a Atom) String() string {
+	return fmt.Sprintf("%s.idle(%s)", a.Signal, formatWindow(a.Window))
+}
+
+// Not negates an expression.
+type Not struct {
+	X Expr
+}
+
+func (n Not) Eval(env Env) (bool, Expr) {
+	val, why := n.X.Eval(env)
+	return !val, Not{why}
+}
+
+func (n Not) String() string {
+	return "!" + operand(n.X)
+}
+
+// And is true when all its operands are.
+type And []Expr
+
+func (a And) Eval(env Env) (bool, Expr) {
+	var met, blocked And
+	for _, x := range a {
+		if val, w := x.Eval(env); val {
+			met = append(met, w)
+		} else {
+			blocked = append(blocked, w)
+		}
+	}
+	if len(blocked) > 0 {
+		return false, blocked.simplify()
+	}
+	return true, met.simplify()
+}
+
+func (a And) String() string {
+	return join(a, " && ")
+}
+
+func (a And) simplify() Expr {
+	if len(a) == 1 {
+		return a[0]
+	}
+	return a
+}
+
+// Or is true when any of its operands is.
+type Or []Expr
+
+func (o Or) Eval(env Env) (bool, Expr) {
+	var why Or
+	for _, x := range o {
+		val, w := x.Eval(env)
+		if val {
+			return true, w
+		}
+		why = append(why, w)
+	}
+	if len(why) == 1 {
+		return false, why[0]
+	}
+	return false, why
+}
+
+func (o Or) String() string {
+	return join(o, " || ")
+}
+
+func join(xs []Expr, sep string) string {
+	parts := make([]string, len(xs))
+	for i, x := range xs {
+		parts[i] = operand(x)
+	}
+	return strings.Join(parts, sep)
+}
+
+// operand renders x, parenthesized if it is a binary expression.
+func operand(x Expr) string {
+	switch x.(type) {
+	case And, Or:
+		return "(" + x.String() + ")"
+	}
+	return x.String()
+}
+
+// formatWindow renders a whole-minute window the way it is usually
+// written: 30m, 2h or 1h30m.
+func formatWindow(d time.Duration) string {
+	hours, mins := int(d.Hours()), int(d.Minutes())%60
+	switch {
+	case hours == 0:
+		return fmt.Sprintf("%dm", mins)
+	case mins == 0:
+		return fmt.Sprintf("%dh", hours)
+	default:
+		return fmt.Sprintf("%dh%dm", hours, mins)
+	}
+}
+
+// Default returns the built-in rule: CPU idle for cpuMinutes and no users
+// for userMinutes.
+func Default(cpuMinutes, userMinutes int) Expr {
+	return And{
+		Atom{Signal: "cpu", Window: time.Duration(cpuMinutes) * time.Minute},
+		Atom{Signal: "users", Window: time.Duration(userMinutes) * time.Minute},
+	}
+}
+
+// Atoms returns every atom in x, in order of appearance.
+func Atoms(x Expr) []Atom {
+	switch x := x.(type) {
+	case Atom:
+		return []Atom{x}
+	case Not:
+		return Atoms(x.X)
+	case And:
+		return atomsOf(x)
+	case Or:
+		return atomsOf(x)
+	}
+	return nil
+}
+
+func atomsOf(xs []Expr) []Atom {
+	var atoms []Atom
+	for _, x := range xs {
+		atoms = append(atoms, Atoms(x)...)
+	}
+	return atoms
+}
+
+// BlockedBy reports whether activity on signal alone keeps x from
+// holding, i.e. whether x is false when signal is busy and every other
+// signal idle.
+func BlockedBy(x Expr, signal string) bool {
+	val, _ := x.Eval(EnvFunc(func(s string, _ time.Duration) bool { return s != signal }))
+	return !val
+}
+
+// Windows returns the longest window x checks for each signal.
+func Windows(x Expr) map[string]time.Duration {
+	windows := map[string]time.Duration{}
+	for _, a := range Atoms(x) {
+		windows[a.Signal] = max(windows[a.Signal], a.Window)
+	}
+	return windows
+}
+
+// signalNames returns the known signals, sorted, for error messages.
+func signalNames() string {
+	names := make([]string, 0, len(Signals))
+	for name := range Signals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
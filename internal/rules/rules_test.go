@@ -0,0 +1,146 @@
+package rules
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"cpu.idle(60m)", "cpu.idle(1h)"},
+		{"cpu.idle(90m) && users.idle(30m)", "cpu.idle(1h30m) && users.idle(30m)"},
+		// && binds tighter than ||
+		{"users.idle(30m) || cpu.idle(2h) && users.idle(5m)", "users.idle(30m) || (cpu.idle(2h) && users.idle(5m))"},
+		{"(users.idle(30m) || cpu.idle(2h)) && users.idle(5m)", "(users.idle(30m) || cpu.idle(2h)) && users.idle(5m)"},
+		{"!!users.idle(1h)", "!!users.idle(1h)"},
+		{"  cpu.idle(2h)||!(users.idle(10m)&&cpu.idle(1m))  ", "cpu.idle(2h) || !(users.idle(10m) && cpu.idle(1m))"},
+	}
+	for _, tt := range tests {
+		x, err := Parse(tt.src)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.src, err)
+			continue
+		}
+		if got := x.String(); got != tt.want {
+			t.Errorf("Parse(%q) = %s, want %s", tt.src, got, tt.want)
+		}
+		// The canonical form parses to the same expression
+		if y, err := Parse(x.String()); err != nil || y.String() != x.String() {
+			t.Errorf("round trip of %s = %v, %v", x, y, err)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"", "column 1: expected a condition"},
+		{"disk.idle(2h)", `column 1: unknown signal "disk" (known: cpu, memory, net, psi, score, tcp, users)`},
+		{"cpu.busy(2h)", "column 5: expected idle"},
+		{"cpu.idle(2 h)", "column 10: invalid duration"},
+		{"cpu.idle(30s)", "must be a whole number of minutes"},
+		{"cpu.idle(90s)", "must be a whole number of minutes"},
+		{"cpu.idle(1h) &&", "column 16: expected a condition"},
+		{"(cpu.idle(1h)", "column 14: expected ), found end of expression"},
+		{"cpu.idle(1h) users.idle(1h)", `column 14: unexpected "users"`},
+		{"cpu.idle(1h) & users.idle(1h)", `unexpected "&"`},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.src)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Parse(%q) error = %v, want %q", tt.src, err, tt.want)
+		}
+	}
+}
+
+// idleEnv reports the listed atoms as idle.
+func idleEnv(idle ...string) Env {
+	return EnvFunc(func(signal string, window time.Duration) bool {
+		a := Atom{Signal: signal, Window: window}.String()
+		for _, s := range idle {
+			if s == a {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func TestEvalReportsDecidingExpression(t *testing.T) {
+	x, err := Parse("users.idle(30m) || (cpu.idle(2h) && !users.idle(5m))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		idle []string
+		want bool
+		why  string
+	}{
+		{[]string{"users.idle(30m)"}, true, "users.idle(30m)"},
+		{[]string{"cpu.idle(2h)"}, true, "cpu.idle(2h) && !users.idle(5m)"},
+		{[]string{"cpu.idle(2h)", "users.idle(5m)"}, false, "users.idle(30m) || !users.idle(5m)"},
+		{nil, false, "users.idle(30m) || cpu.idle(2h)"},
+	}
+	for _, tt := range tests {
+		got, why := x.Eval(idleEnv(tt.idle...))
+		if got != tt.want || why.String() != tt.why {
+			t.Errorf("idle %v: Eval = %v, %s; want %v, %s", tt.idle, got, why, tt.want, tt.why)
+		}
+	}
+}
+
+func TestEvalAndReportsEveryBlocker(t *testing.T) {
+	x := And{Default(60, 60), Atom{Signal: "users", Window: 5 * time.Minute}}
+	if got, why := x.Eval(idleEnv("users.idle(5m)")); got || why.String() != "cpu.idle(1h) && users.idle(1h)" {
+		t.Errorf("Eval = %v, %s", got, why)
+	}
+	if got, why := x.Eval(idleEnv("cpu.idle(1h)")); got || why.String() != "users.idle(1h) && users.idle(5m)" {
+		t.Errorf("Eval = %v, %s", got, why)
+	}
+}
+
+func TestDefaultAndWindows(t *testing.T) {
+	x := Default(60, 45)
+	if got := x.String(); got != "cpu.idle(1h) && users.idle(45m)" {
+		t.Errorf("Default = %s", got)
+	}
+
+	y, err := Parse("users.idle(30m) || (cpu.idle(2h) && users.idle(1h))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	windows := Windows(y)
+	if windows["cpu"] != 2*time.Hour || windows["users"] != time.Hour || len(windows) != 2 {
+		t.Errorf("Windows = %v", windows)
+	}
+	if atoms := Atoms(y); len(atoms) != 3 || atoms[0].Minutes() != 30 {
+		t.Errorf("Atoms = %v", atoms)
+	}
+}
+
+func TestBlockedBy(t *testing.T) {
+	for _, tc := range []struct {
+		rule, signal string
+		want         bool
+	}{
+		{"cpu.idle(1h) && users.idle(1h)", "users", true},
+		{"users.idle(30m) || (cpu.idle(2h) && users.idle(10m))", "users", true},
+		{"users.idle(30m) || cpu.idle(2h)", "users", false},
+		{"cpu.idle(2h)", "users", false},
+		{"cpu.idle(2h) && !users.idle(5m)", "users", false},
+	} {
+		x, err := Parse(tc.rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := BlockedBy(x, tc.signal); got != tc.want {
+			t.Errorf("BlockedBy(%s, %s) = %v, want %v", tc.rule, tc.signal, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,430 @@
+// Package samplefile reads and writes recorded CPU, user, PSI, memory,
+// network and TCP samples, used for exports and for offline calibration
+// and backtesting.
+//
+// The CSV format has one sample per row:
+//
+//	timestamp,metric,value
+//	2026-02-19T02:13:00Z,cpu,3.21
+//	2026-02-19T02:13:00Z,cpu_max_core,11.5
+//	2026-02-19T02:13:00Z,load_per_core,6.25
+//	2026-02-19T02:13:00Z,cpu_steal,1.2
+//	2026-02-19T02:13:00Z,users,0
+//	2026-02-19T02:13:00Z,psi_io,0.42
+//
+// cpu_max_core, load_per_core, cpu_cgroup, cpu_steal, cpu_iowait and
+// cpu_guest belong to the cpu sample with the same timestamp and are
+// omitted when 0, as in files recorded before they were measured.
+// psi_cpu, psi_io and psi_memory are the stall percentages of one PSI
+// sample; the kernel's running averages are not recorded.
+// mem_page_faults, mem_major_faults, mem_swap_in and mem_swap_out are the
+// per-second rates of one memory sample, mem_used and swap_used its
+// percentages of memory and swap in use.
+// net_rx_bytes, net_tx_bytes, net_rx_packets and net_tx_packets are the
+// per-second traffic rates of one network sample.
+// tcp is the number of qualifying client connections; their peers are not
+// recorded.
+// The JSON Lines format carries the same fields, one object per line:
+//
+//	{"timestamp":"2026-02-19T02:13:00Z","metric":"cpu","value":3.21}
+//
+// Timestamps are RFC 3339. Rows with unknown metrics are ignored.
+package samplefile
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"idleshutdown/internal/monitor"
+)
+
+// Metric names used in sample files.
+const (
+	MetricCPU         = "cpu"
+	MetricCPUMaxCore  = "cpu_max_core"
+	MetricLoadPerCore = "load_per_core"
+	MetricCPUCgroup   = "cpu_cgroup"
+	MetricCPUSteal    = "cpu_steal"
+	MetricCPUIOWait   = "cpu_iowait"
+	MetricCPUGuest    = "cpu_guest"
+	MetricUsers       = "users"
+	MetricPSICPU      = "psi_cpu"
+	MetricPSIIO       = "psi_io"
+	MetricPSIMemory   = "psi_memory"
+
+	MetricMemPageFaults  = "mem_page_faults"
+	MetricMemMajorFaults = "mem_major_faults"
+	MetricMemSwapIn      = "mem_swap_in"
+	MetricMemSwapOut     = "mem_swap_out"
+	MetricMemUsed        = "mem_used"
+	MetricSwapUsed       = "swap_used"
+	MetricNetRxBytes     = "net_rx_bytes"
+	MetricNetTxBytes     = "net_tx_bytes"
+	MetricNetRxPackets   = "net_rx_packets"
+	MetricNetTxPackets   = "net_tx_packets"
+	MetricTCP            = "tcp"
+)
+
+// Supported file formats.
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+)
+
+// record is a single row in either format.
+type record struct {
+	Timestamp time.Time `json:"timestamp"`
+	Metric    string    `json:"metric"`
+	Value     float64   `json:"value"`
+}
+
+// Samples holds the CPU, user, PSI, memory, network and TCP samples read
+// from a file, each sorted chronologically.
+type Samples struct {
+	CPU    []monitor.CPUSample
+	Users  []monitor.UserSample
+	PSI    []monitor.PSISample
+	Memory []monitor.MemorySample
+	Net    []monitor.NetSample
+	TCP    []monitor.TCPSample
+}
+
+// ReadFile reads samples from the file at path. Files ending in .jsonl or
+// .json are read as JSON Lines, anything else as CSV.
+func ReadFile(path string) (*Samples, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open sample file: %w", err)
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jsonl", ".json":
+		return ReadJSONL(file)
+	default:
+		return ReadCSV(file)
+	}
+}
+
+// ReadCSV reads samples in CSV format from r. A header row is optional.
+func ReadCSV(r io.Reader) (*Samples, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 3
+	reader.TrimLeadingSpace = true
+
+	result := &Samples{}
+	for line := 1; ; line++ {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read samples: %w", err)
+		}
+		if line == 1 && strings.EqualFold(row[0], "timestamp") {
+			continue
+		}
+
+		ts, err := time.Parse(time.RFC3339, row[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid timestamp %q: %w", line, row[0], err)
+		}
+		value, err := strconv.ParseFloat(row[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid value %q: %w", line, row[2], err)
+		}
+
+		result.add(row[1], ts, value)
+	}
+
+	result.sortByTime()
+	return result, nil
+}
+
+// ReadJSONL reads samples in JSON Lines format from r.
+func ReadJSONL(r io.Reader) (*Samples, error) {
+	result := &Samples{}
+	decoder := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		var rec record
+		err := decoder.Decode(&rec)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %w", line, err)
+		}
+		result.add(rec.Metric, rec.Timestamp, rec.Value)
+	}
+
+	result.sortByTime()
+	return result, nil
+}
+
+// Between returns the samples with timestamps in [since, until]. A zero
+// since or until leaves that end of the range open.
+func (s *Samples) Between(since, until time.Time) *Samples {
+	inRange := func(ts time.Time) bool {
+		return (since.IsZero() || !ts.Before(since)) && (until.IsZero() || !ts.After(until))
+	}
+
+	result := &Samples{}
+	for _, c := range s.CPU {
+		if inRange(c.Timestamp) {
+			result.CPU = append(result.CPU, c)
+		}
+	}
+	for _, u := range s.Users {
+		if inRange(u.Timestamp) {
+			result.Users = append(result.Users, u)
+		}
+	}
+	for _, p := range s.PSI {
+		if inRange(p.Timestamp) {
+			result.PSI = append(result.PSI, p)
+		}
+	}
+	for _, m := range s.Memory {
+		if inRange(m.Timestamp) {
+			result.Memory = append(result.Memory, m)
+		}
+	}
+	for _, n := range s.Net {
+		if inRange(n.Timestamp) {
+			result.Net = append(result.Net, n)
+		}
+	}
+	for _, c := range s.TCP {
+		if inRange(c.Timestamp) {
+			result.TCP = append(result.TCP, c)
+		}
+	}
+	return result
+}
+
+// Write writes the samples to w in the given format, in chronological order.
+func (s *Samples) Write(w io.Writer, format string) error {
+	switch format {
+	case FormatCSV:
+		return s.WriteCSV(w)
+	case FormatJSONL:
+		return s.WriteJSONL(w)
+	default:
+		return fmt.Errorf("unknown format %q (want %s or %s)", format, FormatCSV, FormatJSONL)
+	}
+}
+
+// WriteCSV writes the samples as CSV with a header row.
+func (s *Samples) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"timestamp", "metric", "value"}); err != nil {
+		return err
+	}
+	for _, rec := range s.records() {
+		row := []string{
+			rec.Timestamp.Format(time.RFC3339),
+			rec.Metric,
+			strconv.FormatFloat(rec.Value, 'f', -1, 64),
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteJSONL writes the samples as JSON Lines.
+func (s *Samples) WriteJSONL(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	for _, rec := range s.records() {
+		if err := encoder.Encode(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// records merges all samples into one chronological sequence.
+func (s *Samples) records() []record {
+	result := make([]record, 0, len(s.CPU)+len(s.Users)+3*len(s.PSI)+6*len(s.Memory)+4*len(s.Net)+len(s.TCP))
+	for _, c := range s.CPU {
+		result = append(result, record{Timestamp: c.Timestamp, Metric: MetricCPU, Value: math.Round(c.Usage*100) / 100})
+		for _, extra := range []struct {
+			metric string
+			value  float64
+		}{
+			{MetricCPUMaxCore, c.MaxCore},
+			{MetricLoadPerCore, c.LoadPerCore},
+			{MetricCPUCgroup, c.Cgroup},
+			{MetricCPUSteal, c.Steal},
+			{MetricCPUIOWait, c.IOWait},
+			{MetricCPUGuest, c.Guest},
+		} {
+			if extra.value != 0 {
+				result = append(result, record{Timestamp: c.Timestamp, Metric: extra.metric, Value: math.Round(extra.value*100) / 100})
+			}
+		}
+	}
+	for _, u := range s.Users {
+		result = append(result, record{Timestamp: u.Timestamp, Metric: MetricUsers, Value: float64(u.Count)})
+	}
+	for _, p := range s.PSI {
+		result = append(result,
+			record{Timestamp: p.Timestamp, Metric: MetricPSICPU, Value: math.Round(p.CPU.Stall*100) / 100},
+			record{Timestamp: p.Timestamp, Metric: MetricPSIIO, Value: math.Round(p.IO.Stall*100) / 100},
+			record{Timestamp: p.Timestamp, Metric: MetricPSIMemory, Value: math.Round(p.Memory.Stall*100) / 100})
+	}
+	for _, m := range s.Memory {
+		result = append(result,
+			record{Timestamp: m.Timestamp, Metric: MetricMemPageFaults, Value: math.Round(m.PageFaults*100) / 100},
+			record{Timestamp: m.Timestamp, Metric: MetricMemMajorFaults, Value: math.Round(m.MajorFaults*100) / 100},
+			record{Timestamp: m.Timestamp, Metric: MetricMemSwapIn, Value: math.Round(m.SwapIn*100) / 100},
+			record{Timestamp: m.Timestamp, Metric: MetricMemSwapOut, Value: math.Round(m.SwapOut*100) / 100},
+			record{Timestamp: m.Timestamp, Metric: MetricMemUsed, Value: m.MemUsed},
+			record{Timestamp: m.Timestamp, Metric: MetricSwapUsed, Value: m.SwapUsed})
+	}
+	for _, n := range s.Net {
+		result = append(result,
+			record{Timestamp: n.Timestamp, Metric: MetricNetRxBytes, Value: math.Round(n.RxBytes*100) / 100},
+			record{Timestamp: n.Timestamp, Metric: MetricNetTxBytes, Value: math.Round(n.TxBytes*100) / 100},
+			record{Timestamp: n.Timestamp, Metric: MetricNetRxPackets, Value: math.Round(n.RxPackets*100) / 100},
+			record{Timestamp: n.Timestamp, Metric: MetricNetTxPackets, Value: math.Round(n.TxPackets*100) / 100})
+	}
+	for _, c := range s.TCP {
+		result = append(result, record{Timestamp: c.Timestamp, Metric: MetricTCP, Value: float64(c.Count)})
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Timestamp.Before(result[j].Timestamp)
+	})
+	return result
+}
+
+func (s *Samples) add(metric string, ts time.Time, value float64) {
+	switch strings.ToLower(metric) {
+	case MetricCPU:
+		s.CPU = append(s.CPU, monitor.CPUSample{Timestamp: ts, Usage: value})
+	case MetricCPUMaxCore:
+		s.cpuAt(ts).MaxCore = value
+	case MetricLoadPerCore:
+		s.cpuAt(ts).LoadPerCore = value
+	case MetricCPUCgroup:
+		s.cpuAt(ts).Cgroup = value
+	case MetricCPUSteal:
+		s.cpuAt(ts).Steal = value
+	case MetricCPUIOWait:
+		s.cpuAt(ts).IOWait = value
+	case MetricCPUGuest:
+		s.cpuAt(ts).Guest = value
+	case MetricUsers:
+		s.Users = append(s.Users, monitor.UserSample{Timestamp: ts, Count: int(value)})
+	case MetricPSICPU:
+		s.psiAt(ts).CPU.Stall = value
+	case MetricPSIIO:
+		s.psiAt(ts).IO.Stall = value
+	case MetricPSIMemory:
+		s.psiAt(ts).Memory.Stall = value
+	case MetricMemPageFaults:
+		s.memoryAt(ts).PageFaults = value
+	case MetricMemMajorFaults:
+		s.memoryAt(ts).MajorFaults = value
+	case MetricMemSwapIn:
+		s.memoryAt(ts).SwapIn = value
+	case MetricMemSwapOut:
+		s.memoryAt(ts).SwapOut = value
+	case MetricMemUsed:
+		s.memoryAt(ts).MemUsed = value
+	case MetricSwapUsed:
+		s.memoryAt(ts).SwapUsed = value
+	case MetricNetRxBytes:
+		s.netAt(ts).RxBytes = value
+	case MetricNetTxBytes:
+		s.netAt(ts).TxBytes = value
+	case MetricNetRxPackets:
+		s.netAt(ts).RxPackets = value
+	case MetricNetTxPackets:
+		s.netAt(ts).TxPackets = value
+	case MetricTCP:
+		s.TCP = append(s.TCP, monitor.TCPSample{Timestamp: ts, Count: int(value)})
+	}
+}
+
+// cpuAt returns the CPU sample at ts, adding one if there is none. Rows
+// are expected in chronological order, so only the latest samples are
+// searched.
+func (s *Samples) cpuAt(ts time.Time) *monitor.CPUSample {
+	for i := len(s.CPU) - 1; i >= 0 && !s.CPU[i].Timestamp.Before(ts); i-- {
+		if s.CPU[i].Timestamp.Equal(ts) {
+			return &s.CPU[i]
+		}
+	}
+	s.CPU = append(s.CPU, monitor.CPUSample{Timestamp: ts})
+	return &s.CPU[len(s.CPU)-1]
+}
+
+// psiAt returns the PSI sample at ts, adding one if there is none, like
+// cpuAt.
+func (s *Samples) psiAt(ts time.Time) *monitor.PSISample {
+	for i := len(s.PSI) - 1; i >= 0 && !s.PSI[i].Timestamp.Before(ts); i-- {
+		if s.PSI[i].Timestamp.Equal(ts) {
+			return &s.PSI[i]
+		}
+	}
+	s.PSI = append(s.PSI, monitor.PSISample{Timestamp: ts})
+	return &s.PSI[len(s.PSI)-1]
+}
+
+// memoryAt returns the memory sample at ts, adding one if there is none,
+// like cpuAt.
+func (s *Samples) memoryAt(ts time.Time) *monitor.MemorySample {
+	for i := len(s.Memory) - 1; i >= 0 && !s.Memory[i].Timestamp.Before(ts); i-- {
+		if s.Memory[i].Timestamp.Equal(ts) {
+			return &s.Memory[i]
+		}
+	}
+	s.Memory = append(s.Memory, monitor.MemorySample{Timestamp: ts})
+	return &s.Memory[len(s.Memory)-1]
+}
+
+// netAt returns the network sample at ts, adding one if there is none,
+// like cpuAt.
+func (s *Samples) netAt(ts time.Time) *monitor.NetSample {
+	for i := len(s.Net) - 1; i >= 0 && !s.Net[i].Timestamp.Before(ts); i-- {
+		if s.Net[i].Timestamp.Equal(ts) {
+			return &s.Net[i]
+		}
+	}
+	s.Net = append(s.Net, monitor.NetSample{Timestamp: ts})
+	return &s.Net[len(s.Net)-1]
+}
+
+func (s *Samples) sortByTime() {
+	sort.SliceStable(s.CPU, func(i, j int) bool {
+		return s.CPU[i].Timestamp.Before(s.CPU[j].Timestamp)
+	})
+	sort.SliceStable(s.Users, func(i, j int) bool {
+		return s.Users[i].Timestamp.Before(s.Users[j].Timestamp)
+	})
+	sort.SliceStable(s.PSI, func(i, j int) bool {
+		return s.PSI[i].Timestamp.Before(s.PSI[j].Timestamp)
+	})
+	sort.SliceStable(s.Memory, func(i, j int) bool {
+		return s.Memory[i].Timestamp.Before(s.Memory[j].Timestamp)
+	})
+	sort.SliceStable(s.Net, func(i, j int) bool {
+		return s.Net[i].Timestamp.Before(s.Net[j].Timestamp)
+	})
+	sort.SliceStable(s.TCP, func(i, j int) bool {
+		return s.TCP[i].Timestamp.Before(s.TCP[j].Timestamp)
+	})
+}
@@ -0,0 +1,83 @@
+package samplefile
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"idleshutdown/internal/monitor"
+)
+
+func testSamples() *Samples {
+	t0 := time.Date(2026, 2, 19, 2, 13, 0, 0, time.UTC)
+	return &Samples{
+		CPU: []monitor.CPUSample{
+			{Timestamp: t0, Usage: 3.21},
+			{Timestamp: t0.Add(30 * time.Second), Usage: 4.5, MaxCore: 17.25, LoadPerCore: 12.5, Steal: 1.5, IOWait: 0.25},
+		},
+		Users: []monitor.UserSample{
+			{Timestamp: t0, Count: 0},
+			{Timestamp: t0.Add(30 * time.Second), Count: 2},
+		},
+		PSI: []monitor.PSISample{
+			{Timestamp: t0.Add(30 * time.Second), IO: monitor.PSIPressure{Stall: 0.42}},
+		},
+		Memory: []monitor.MemorySample{
+			{Timestamp: t0, PageFaults: 120.5, MajorFaults: 2, SwapIn: 40, MemUsed: 61.25},
+		},
+		Net: []monitor.NetSample{
+			{Timestamp: t0.Add(30 * time.Second), RxBytes: 2048.5, TxBytes: 512, RxPackets: 12, TxPackets: 4.25},
+		},
+		TCP: []monitor.TCPSample{
+			{Timestamp: t0.Add(30 * time.Second), Count: 3},
+		},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range []string{FormatCSV, FormatJSONL} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := testSamples().Write(&buf, format); err != nil {
+				t.Fatal(err)
+			}
+
+			read := ReadCSV
+			if format == FormatJSONL {
+				read = ReadJSONL
+			}
+			got, err := read(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := testSamples()
+			if len(got.CPU) != 2 || len(got.Users) != 2 || len(got.PSI) != 1 || len(got.Memory) != 1 || got.Memory[0] != want.Memory[0] ||
+				len(got.Net) != 1 || got.Net[0] != want.Net[0] ||
+				len(got.TCP) != 1 || got.TCP[0].Count != 3 ||
+				got.CPU[1] != want.CPU[1] || got.CPU[0].MaxCore != 0 || got.Users[1].Count != 2 ||
+				got.PSI[0] != want.PSI[0] || !got.CPU[0].Timestamp.Equal(want.CPU[0].Timestamp) {
+				t.Errorf("round trip = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestReadCSVErrors(t *testing.T) {
+	if _, err := ReadCSV(strings.NewReader("yesterday,cpu,1\n")); err == nil {
+		t.Error("expected error for invalid timestamp")
+	}
+	if _, err := ReadCSV(strings.NewReader("2026-02-19T02:13:00Z,cpu,high\n")); err == nil {
+		t.Error("expected error for invalid value")
+	}
+}
+
+func TestBetween(t *testing.T) {
+	s := testSamples()
+	since := s.CPU[1].Timestamp
+	got := s.Between(since, time.Time{})
+	if len(got.CPU) != 1 || len(got.Users) != 1 || !got.CPU[0].Timestamp.Equal(since) {
+		t.Errorf("Between = %+v", got)
+	}
+}
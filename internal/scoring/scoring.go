@@ -0,0 +1,57 @@
+// Package scoring computes the composite idle score behind score.idle
+// atoms: each signal's idleness over the window, from 0 to 1, averaged by
+// the weights in [scoring]. A short burst of activity lowers the score
+// instead of resetting the idle clock.
+package scoring
+
+import (
+	"time"
+
+	"idleshutdown/internal/config"
+	"idleshutdown/internal/monitor"
+)
+
+// Result is the composite score over one window.
+type Result struct {
+	Minutes int     `json:"minutes"`
+	Value   float64 `json:"value"`
+	Target  float64 `json:"target"`
+	Idle    bool    `json:"idle"`
+
+	Signals []Signal `json:"signals"`
+}
+
+// Signal is one signal's part of the score.
+type Signal struct {
+	Name   string  `json:"name"`
+	Weight float64 `json:"weight"`
+	Method string  `json:"method"`
+	monitor.Score
+}
+
+// Evaluate scores the window of minutes before now; CPU samples count as
+// idle below threshold. Signals weighted 0 are left out, and with no
+// weighted signal at all the score is 0.
+func Evaluate(now time.Time, cfg config.ScoringConfig, threshold, minutes int,
+	cpuMon *monitor.CPUMonitor, userMon *monitor.UserMonitor) Result {
+	r := Result{Minutes: minutes, Target: cfg.Target}
+
+	var sum, total float64
+	add := func(name string, s config.SignalScoring, score monitor.Score) {
+		r.Signals = append(r.Signals, Signal{Name: name, Weight: s.Weight, Method: s.Method, Score: score})
+		sum += s.Weight * score.Value
+		total += s.Weight
+	}
+	if cfg.CPU.Weight > 0 {
+		add("cpu", cfg.CPU, cpuMon.ScoreAt(now, threshold, minutes, cfg.CPU.Options()))
+	}
+	if cfg.Users.Weight > 0 {
+		add("users", cfg.Users, userMon.ScoreAt(now, minutes, cfg.Users.Options()))
+	}
+
+	if total > 0 {
+		r.Value = sum / total
+	}
+	r.Idle = total > 0 && r.Value >= r.Target
+	return r
+}
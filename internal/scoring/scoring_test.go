@@ -0,0 +1,79 @@
+package scoring
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"idleshutdown/internal/clock"
+	"idleshutdown/internal/config"
+	"idleshutdown/internal/monitor"
+)
+
+var testNow = time.Date(2026, 2, 19, 14, 30, 0, 0, time.UTC)
+
+// monitors returns monitors holding an hour of samples every 30s up to
+// testNow, a 30s CPU spike every 5 minutes and a user logged in for the
+// first 6 minutes.
+func monitors() (*monitor.CPUMonitor, *monitor.UserMonitor) {
+	cpuMon := monitor.NewCPUMonitor(30 * time.Second)
+	cpuMon.Clock = clock.NewFake(testNow)
+	userMon := monitor.NewUserMonitor(30 * time.Second)
+	userMon.Clock = clock.NewFake(testNow)
+
+	var cpuSamples []monitor.CPUSample
+	var userSamples []monitor.UserSample
+	for i := 0; i < 120; i++ {
+		ts := testNow.Add(-time.Duration(119-i) * 30 * time.Second)
+		usage := 2.0
+		if i%10 == 0 {
+			usage = 40
+		}
+		cpuSamples = append(cpuSamples, monitor.CPUSample{Timestamp: ts, Usage: usage})
+		count := 0
+		if i < 12 {
+			count = 1
+		}
+		userSamples = append(userSamples, monitor.UserSample{Timestamp: ts, Count: count})
+	}
+	cpuMon.AddSamples(cpuSamples)
+	userMon.AddSamples(userSamples)
+	return cpuMon, userMon
+}
+
+func TestEvaluate(t *testing.T) {
+	cpuMon, userMon := monitors()
+	cfg := config.ScoringConfig{
+		Target: 0.9,
+		CPU:    config.SignalScoring{Weight: 1, Method: monitor.ScoreFraction},
+		Users:  config.SignalScoring{Weight: 1, Method: monitor.ScoreFraction},
+	}
+
+	// CPU 108/120 idle, users 108/120
+	r := Evaluate(testNow, cfg, 5, 60, cpuMon, userMon)
+	if len(r.Signals) != 2 || math.Abs(r.Value-0.9) > 1e-9 || !r.Idle {
+		t.Errorf("result = %+v", r)
+	}
+
+	// Forgiving the 30s spikes makes CPU fully idle; weighting it three
+	// times as much as users gives (3*1 + 0.9) / 4
+	cfg.CPU = config.SignalScoring{Weight: 3, Method: monitor.ScoreFraction, SpikeSeconds: 30}
+	r = Evaluate(testNow, cfg, 5, 60, cpuMon, userMon)
+	if r.Signals[0].Tolerated != 12 || math.Abs(r.Value-0.975) > 1e-9 {
+		t.Errorf("result = %+v", r)
+	}
+
+	// Ignoring users, with a stricter target
+	cfg.Users.Weight = 0
+	cfg.CPU.SpikeSeconds = 0
+	cfg.Target = 0.95
+	r = Evaluate(testNow, cfg, 5, 60, cpuMon, userMon)
+	if len(r.Signals) != 1 || r.Idle {
+		t.Errorf("result = %+v", r)
+	}
+
+	cfg.CPU.Weight = 0
+	if r := Evaluate(testNow, cfg, 5, 60, cpuMon, userMon); r.Idle || r.Value != 0 {
+		t.Errorf("no weighted signal: %+v", r)
+	}
+}
@@ -3,36 +3,114 @@ package shutdown
 
 import (
 	"fmt"
-	"log"
-	"os/exec"
+	"log/slog"
 	"time"
+
+	"idleshutdown/internal/clock"
+	"idleshutdown/internal/command"
+	"idleshutdown/internal/logging"
+	"idleshutdown/internal/notify"
 )
 
 // Executor handles system shutdown operations.
 type Executor struct {
 	DryRun bool
+	Runner command.Runner
+	Clock  clock.Clock
+	// Grace delays the shutdown after the idle condition is first met. If
+	// Cancel is called before it elapses, the shutdown is called off.
+	Grace    time.Duration
+	Notifier notify.Notifier
+
+	pendingSince time.Time
 }
 
 // NewExecutor creates a new shutdown executor.
 func NewExecutor(dryRun bool) *Executor {
-	return &Executor{DryRun: dryRun}
+	return &Executor{DryRun: dryRun, Runner: command.Exec, Clock: clock.Real, Notifier: notify.Discard}
+}
+
+// Pending reports whether a shutdown is waiting out its grace period.
+func (e *Executor) Pending() bool {
+	return !e.pendingSince.IsZero()
+}
+
+// PendingSince returns when the pending shutdown's grace period started,
+// or the zero time if none is pending.
+func (e *Executor) PendingSince() time.Time {
+	return e.pendingSince
+}
+
+// Due reports whether the next Shutdown call executes the shutdown rather
+// than starting or waiting out the grace period.
+func (e *Executor) Due() bool {
+	if e.Grace <= 0 {
+		return true
+	}
+	return e.Pending() && e.Clock.Now().Sub(e.pendingSince) >= e.Grace
 }
 
 // Shutdown initiates a system shutdown with a reason logged to the journal.
-func (e *Executor) Shutdown(reason string) error {
-	log.Println("=== SHUTDOWN INITIATED ===")
-	log.Printf("Time:   %s", time.Now().Format(time.RFC3339))
-	log.Printf("Reason: %s", reason)
+// With a grace period, the first call only marks the shutdown pending;
+// calls after the grace period has elapsed execute it. details (e.g. idle
+// statistics) are passed on to notifications.
+func (e *Executor) Shutdown(reason string, details map[string]any) error {
+	now := e.Clock.Now()
+
+	if e.Grace > 0 {
+		if !e.Pending() {
+			e.pendingSince = now
+			slog.Warn("Shutdown pending", logging.EventKey, logging.Shutdown,
+				"state", "pending", "grace", e.Grace.String(), "shutdown_at", now.Add(e.Grace), "reason", reason)
+			e.Notifier.Notify(notify.NewEvent(notify.ShutdownPending, now, reason, withField(details,
+				"shutdown_at", now.Add(e.Grace).UTC().Format(time.RFC3339))))
+			return nil
+		}
+		if remaining := e.Grace - now.Sub(e.pendingSince); remaining > 0 {
+			slog.Info("Shutdown pending", logging.EventKey, logging.Shutdown,
+				"state", "pending", "remaining", remaining.Round(time.Second).String())
+			return nil
+		}
+	}
+	e.pendingSince = time.Time{}
+
+	slog.Warn("Shutdown initiated", logging.EventKey, logging.Shutdown,
+		"state", "executed", "dry_run", e.DryRun, "reason", reason)
+
+	// Sent first: once the command runs there may be no network left.
+	e.Notifier.Notify(notify.NewEvent(notify.ShutdownExecuted, now, reason, withField(details,
+		"dry_run", e.DryRun)))
 
 	if e.DryRun {
-		log.Println("[DRY RUN] Would execute: shutdown -h now")
+		slog.Info("Dry run: would execute shutdown -h now", logging.EventKey, logging.Shutdown)
 		return nil
 	}
 
-	cmd := exec.Command("shutdown", "-h", "now")
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("shutdown command failed: %w — %s", err, string(output))
+	if _, err := e.Runner.Run("shutdown", "-h", "now"); err != nil {
+		return fmt.Errorf("shutdown command failed: %w", err)
 	}
 
 	return nil
 }
+
+// withField returns a copy of fields with key set to val.
+func withField(fields map[string]any, key string, val any) map[string]any {
+	result := make(map[string]any, len(fields)+1)
+	for k, v := range fields {
+		result[k] = v
+	}
+	result[key] = val
+	return result
+}
+
+// Cancel calls off a pending shutdown because the idle condition no longer
+// holds. It does nothing when no shutdown is pending.
+func (e *Executor) Cancel(reason string) {
+	if !e.Pending() {
+		return
+	}
+	e.pendingSince = time.Time{}
+
+	slog.Info("Shutdown cancelled", logging.EventKey, logging.Shutdown, "state", "cancelled", "reason", reason)
+	e.Notifier.Notify(notify.NewEvent(notify.ShutdownCancelled, e.Clock.Now(), reason, nil))
+}
@@ -0,0 +1,134 @@
+package shutdown
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"idleshutdown/internal/clock"
+	"idleshutdown/internal/notify"
+)
+
+type fakeRunner struct {
+	err   error
+	calls []string
+}
+
+func (r *fakeRunner) Run(name string, args ...string) ([]byte, error) {
+	r.calls = append(r.calls, strings.Join(append([]string{name}, args...), " "))
+	return nil, r.err
+}
+
+func newTestExecutor(dryRun bool, runner *fakeRunner) *Executor {
+	e := NewExecutor(dryRun)
+	e.Runner = runner
+	e.Clock = clock.NewFake(time.Date(2026, 2, 19, 2, 13, 0, 0, time.UTC))
+	return e
+}
+
+func TestShutdownRunsCommand(t *testing.T) {
+	runner := &fakeRunner{}
+	if err := newTestExecutor(false, runner).Shutdown("idle", nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(runner.calls) != 1 || runner.calls[0] != "shutdown -h now" {
+		t.Errorf("calls = %v, want [shutdown -h now]", runner.calls)
+	}
+}
+
+func TestShutdownDryRun(t *testing.T) {
+	runner := &fakeRunner{}
+	if err := newTestExecutor(true, runner).Shutdown("idle", nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(runner.calls) != 0 {
+		t.Errorf("dry run executed %v", runner.calls)
+	}
+}
+
+func TestShutdownCommandFailure(t *testing.T) {
+	runner := &fakeRunner{err: errors.New("permission denied")}
+	err := newTestExecutor(false, runner).Shutdown("idle", nil)
+	if err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("err = %v, want wrapped command error", err)
+	}
+}
+
+type recordingNotifier struct {
+	events []notify.Event
+}
+
+func (n *recordingNotifier) Notify(event notify.Event) {
+	n.events = append(n.events, event)
+}
+
+func (n *recordingNotifier) types() string {
+	var types []string
+	for _, e := range n.events {
+		types = append(types, e.Type)
+	}
+	return strings.Join(types, ",")
+}
+
+func TestShutdownGracePeriod(t *testing.T) {
+	runner := &fakeRunner{}
+	notifier := &recordingNotifier{}
+	e := newTestExecutor(false, runner)
+	e.Grace = 5 * time.Minute
+	e.Notifier = notifier
+	fake := e.Clock.(*clock.Fake)
+
+	// Pending, then cancelled when the VM gets busy
+	e.Shutdown("idle", nil)
+	fake.Advance(2 * time.Minute)
+	e.Cancel("user logged in")
+	e.Cancel("user logged in")
+	if len(runner.calls) != 0 || e.Pending() {
+		t.Fatalf("calls = %v, pending = %v after cancel", runner.calls, e.Pending())
+	}
+
+	// Pending again, executed once the grace period has passed
+	if e.Due() {
+		t.Error("due before the grace period started")
+	}
+	e.Shutdown("idle", nil)
+	fake.Advance(4 * time.Minute)
+	e.Shutdown("idle", nil)
+	if len(runner.calls) != 0 || e.Due() {
+		t.Fatal("shutdown ran (or was due) before the grace period elapsed")
+	}
+	fake.Advance(time.Minute)
+	if !e.Due() {
+		t.Error("not due after the grace period")
+	}
+	if err := e.Shutdown("idle", nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(runner.calls) != 1 {
+		t.Errorf("calls = %v, want one shutdown", runner.calls)
+	}
+
+	want := "shutdown_pending,shutdown_cancelled,shutdown_pending,shutdown_executed"
+	if got := notifier.types(); got != want {
+		t.Errorf("events = %s, want %s", got, want)
+	}
+	if notifier.events[0].Fields["shutdown_at"] != "2026-02-19T02:18:00Z" {
+		t.Errorf("pending fields = %v", notifier.events[0].Fields)
+	}
+}
+
+func TestShutdownWithoutGraceNotifiesExecuted(t *testing.T) {
+	notifier := &recordingNotifier{}
+	e := newTestExecutor(true, &fakeRunner{})
+	e.Notifier = notifier
+	e.Shutdown("idle", map[string]any{"cpu_peak": 3.4})
+	e.Cancel("busy")
+
+	if got := notifier.types(); got != notify.ShutdownExecuted {
+		t.Errorf("events = %s, want only %s", got, notify.ShutdownExecuted)
+	}
+	if fields := notifier.events[0].Fields; fields["dry_run"] != true || fields["cpu_peak"] != 3.4 {
+		t.Errorf("fields = %v", notifier.events[0].Fields)
+	}
+}
@@ -11,6 +11,7 @@ set -e
 # Installation paths
 BINARY_PATH="/usr/local/bin/idleshutdown"
 CONFIG_DIR="/etc/idleshutdown"
+STATE_DIR="/var/lib/idleshutdown"
 SERVICE_NAME="IdleShutdown"
 SERVICE_FILE="/etc/systemd/system/${SERVICE_NAME}.service"
 
@@ -46,7 +47,7 @@ echo -e "${GREEN}      ✓ Files removed${NC}"
 
 # Remove config (optional)
 echo -e "${CYAN}[3/3]${NC} Removing configuration..."
-rm -rf "${CONFIG_DIR}"
+rm -rf "${CONFIG_DIR}" "${STATE_DIR}"
 echo -e "${GREEN}      ✓ Configuration removed${NC}"
 
 echo ""
@@ -10,7 +10,7 @@
 #   2. Disables the service from starting on boot
 #   3. Removes the systemd service file
 #   4. Removes the binary from /usr/local/bin/
-#   5. Optionally removes the config directory
+#   5. Optionally removes the config and state directories
 #
 
 set -e
@@ -25,6 +25,7 @@ NC='\033[0m' # No Color
 # Installation paths
 BINARY_PATH="/usr/local/bin/idleshutdown"
 CONFIG_DIR="/etc/idleshutdown"
+STATE_DIR="/var/lib/idleshutdown"
 SERVICE_NAME="IdleShutdown"
 SERVICE_FILE="/etc/systemd/system/${SERVICE_NAME}.service"
 
@@ -89,6 +90,17 @@ if [[ -d "${CONFIG_DIR}" ]]; then
         echo -e "${CYAN}→ Configuration preserved at ${CONFIG_DIR}${NC}"
     fi
 fi
+if [[ -d "${STATE_DIR}" ]]; then
+    echo -e "${YELLOW}State directory exists: ${STATE_DIR}${NC}"
+    read -p "Remove calibration state? (y/N): " -n 1 -r
+    echo
+    if [[ $REPLY =~ ^[Yy]$ ]]; then
+        rm -rf "${STATE_DIR}"
+        echo -e "${GREEN}✓ State directory removed${NC}"
+    else
+        echo -e "${CYAN}→ State preserved at ${STATE_DIR}${NC}"
+    fi
+fi
 
 echo ""
 echo -e "${GREEN}============================================${NC}"